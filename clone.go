@@ -0,0 +1,164 @@
+package mmdbwriter
+
+import (
+	"github.com/pkg/errors"
+)
+
+// Begin starts a transaction. The inserts and other changes made to the tree
+// after Begin may be undone with Rollback, e.g., if a source turns out to be
+// corrupt partway through, or kept with Commit. Begin copies the tree, as
+// with Clone, so it takes time and memory proportional to the size of the
+// tree.
+//
+// Only one transaction may be in progress at a time.
+//
+// This is not safe to call from multiple threads unless Options.ThreadSafe
+// is set.
+func (t *Tree) Begin() error {
+	t.lock()
+	defer t.unlock()
+
+	if t.snapshot != nil {
+		return errors.New("a transaction is already in progress")
+	}
+	t.snapshot = t.clone()
+	return nil
+}
+
+// Commit ends the current transaction and keeps its changes.
+//
+// This is not safe to call from multiple threads unless Options.ThreadSafe
+// is set.
+func (t *Tree) Commit() error {
+	t.lock()
+	defer t.unlock()
+
+	if t.snapshot == nil {
+		return errors.New("no transaction is in progress")
+	}
+	t.snapshot = nil
+	return nil
+}
+
+// Rollback ends the current transaction and restores the tree to its state
+// when Begin was called.
+//
+// This is not safe to call from multiple threads unless Options.ThreadSafe
+// is set.
+func (t *Tree) Rollback() error {
+	t.lock()
+	defer t.unlock()
+
+	if t.snapshot == nil {
+		return errors.New("no transaction is in progress")
+	}
+	*t = *t.snapshot
+	return nil
+}
+
+// Clone returns an independent copy of the tree. Changes to either tree,
+// including its metadata, do not affect the other. The search tree is
+// copied, but the values are shared, as values are never modified once
+// inserted.
+//
+// This is not safe to call from multiple threads unless Options.ThreadSafe
+// is set.
+func (t *Tree) Clone() *Tree {
+	t.rlock()
+	defer t.runlock()
+
+	c := t.clone()
+	if t.mu != nil {
+		c.mu = newTreeLock()
+	}
+	return c
+}
+
+// clone returns a copy of the tree that shares the mutex of the tree.
+func (t *Tree) clone() *Tree {
+	return t.cloneSubtree(t.root)
+}
+
+// cloneSubtree is the same as clone except that only the subtree under n,
+// which becomes the root of the copy, is copied. The copy has all of the
+// tree's values, so their reference counts are only correct if n is the
+// root.
+func (t *Tree) cloneSubtree(n *node) *Tree {
+	c := *t
+	c.snapshot = nil
+
+	c.description = make(map[string]string, len(t.description))
+	for k, v := range t.description {
+		c.description[k] = v
+	}
+	if t.languages != nil {
+		c.languages = append([]string(nil), t.languages...)
+	}
+
+	c.dataMap = newDataMap()
+	c.dataMap.validate = t.dataMap.validate
+	c.dataMap.schema = t.dataMap.schema
+	c.dataMap.metrics = t.dataMap.metrics
+	if t.dataMap.keyCache != nil {
+		c.dataMap.keyCache = newKeyCache(t.dataMap.keyCache.size)
+	}
+	for key, value := range t.dataMap.data {
+		c.dataMap.data[key] = &dataMapValue{
+			data:       value.data,
+			key:        value.key,
+			mapKey:     value.mapKey,
+			provenance: value.provenance,
+			refCount:   value.refCount,
+		}
+	}
+
+	if t.provenance != nil {
+		c.provenance = t.provenance.clone()
+	}
+
+	c.allocator = &nodeAllocator{}
+	cl := cloner{
+		tree:       &c,
+		fixedNodes: map[*node]*node{},
+	}
+	c.root = cl.cloneNode(n)
+
+	// The aliases are updated after the whole tree has been copied as an
+	// alias may come before its target.
+	for _, r := range cl.aliases {
+		r.node = cl.fixedNodes[r.node]
+	}
+	return &c
+}
+
+type cloner struct {
+	tree *Tree
+	// fixedNodes maps the fixed nodes of the original tree to their
+	// copies.
+	fixedNodes map[*node]*node
+	// aliases are the alias records in the copy. They still point to the
+	// original fixed nodes.
+	aliases []*record
+}
+
+func (cl *cloner) cloneNode(n *node) *node {
+	c := cl.tree.allocator.new()
+	*c = *n
+	for i := range c.children {
+		r := &c.children[i]
+		switch r.recordType {
+		case recordTypeNode:
+			r.node = cl.cloneNode(r.node)
+		case recordTypeFixedNode:
+			fixed := cl.cloneNode(r.node)
+			cl.fixedNodes[r.node] = fixed
+			r.node = fixed
+		case recordTypeAlias:
+			cl.aliases = append(cl.aliases, r)
+		case recordTypeData:
+			r.value = cl.tree.dataMap.data[r.value.mapKey]
+		default:
+		}
+	}
+	return c
+}
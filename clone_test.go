@@ -0,0 +1,78 @@
+package mmdbwriter
+
+import (
+	"bytes"
+	"net"
+	"testing"
+
+	"github.com/maxmind/mmdbwriter/mmdbtype"
+	"github.com/oschwald/maxminddb-golang"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestClone(t *testing.T) {
+	tree, err := New(Options{
+		DatabaseType: "mmdbwriter-test",
+		Description:  map[string]string{"en": "Test database"},
+	})
+	require.NoError(t, err)
+	require.NoError(t, tree.Insert(mustParseNetwork(t, "1.1.0.0/16"), mmdbtype.String("base")))
+
+	clone := tree.Clone()
+	require.NoError(t, clone.Insert(mustParseNetwork(t, "1.1.1.0/24"), mmdbtype.String("clone")))
+	require.NoError(t, tree.Remove(mustParseNetwork(t, "1.1.2.0/24")))
+	clone.description["en"] = "Clone"
+
+	_, value := tree.Get(net.ParseIP("1.1.1.1"))
+	assert.Equal(t, mmdbtype.String("base"), value)
+	_, value = tree.Get(net.ParseIP("1.1.2.1"))
+	assert.Nil(t, value)
+	assert.Equal(t, "Test database", tree.description["en"])
+
+	_, value = clone.Get(net.ParseIP("1.1.1.1"))
+	assert.Equal(t, mmdbtype.String("clone"), value)
+	_, value = clone.Get(net.ParseIP("1.1.2.1"))
+	assert.Equal(t, mmdbtype.String("base"), value)
+
+	for tr, expected := range map[*Tree]string{tree: "base", clone: "clone"} {
+		buf := &bytes.Buffer{}
+		_, err := tr.WriteTo(buf)
+		require.NoError(t, err)
+
+		reader, err := maxminddb.FromBytes(buf.Bytes())
+		require.NoError(t, err)
+		require.NoError(t, reader.Verify())
+
+		var v interface{}
+		require.NoError(t, reader.Lookup(net.ParseIP("::ffff:1.1.1.1"), &v))
+		assert.Equal(t, expected, v, "the aliases point to the tree's own IPv4 subtree")
+	}
+}
+
+func TestTransactions(t *testing.T) {
+	tree, err := New(Options{ThreadSafe: true})
+	require.NoError(t, err)
+	require.NoError(t, tree.Insert(mustParseNetwork(t, "1.1.0.0/16"), mmdbtype.String("base")))
+
+	require.NoError(t, tree.Begin())
+	assert.EqualError(t, tree.Begin(), "a transaction is already in progress")
+	require.NoError(t, tree.Insert(mustParseNetwork(t, "1.1.1.0/24"), mmdbtype.String("abandoned")))
+	require.NoError(t, tree.Remove(mustParseNetwork(t, "1.1.2.0/24")))
+	require.NoError(t, tree.Rollback())
+
+	for _, ip := range []string{"1.1.1.1", "1.1.2.1"} {
+		_, value := tree.Get(net.ParseIP(ip))
+		assert.Equal(t, mmdbtype.String("base"), value, ip)
+	}
+
+	require.NoError(t, tree.Begin())
+	require.NoError(t, tree.Insert(mustParseNetwork(t, "1.1.1.0/24"), mmdbtype.String("kept")))
+	require.NoError(t, tree.Commit())
+
+	_, value := tree.Get(net.ParseIP("1.1.1.1"))
+	assert.Equal(t, mmdbtype.String("kept"), value)
+
+	assert.EqualError(t, tree.Commit(), "no transaction is in progress")
+	assert.EqualError(t, tree.Rollback(), "no transaction is in progress")
+}
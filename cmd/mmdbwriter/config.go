@@ -0,0 +1,320 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"io"
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"unicode/utf8"
+
+	"github.com/maxmind/mmdbwriter"
+	"github.com/maxmind/mmdbwriter/importer/genericcsv"
+	"github.com/maxmind/mmdbwriter/importer/geolite2csv"
+	"github.com/maxmind/mmdbwriter/importer/mrt"
+	"github.com/maxmind/mmdbwriter/importer/rirstats"
+	"github.com/maxmind/mmdbwriter/inserter"
+	"github.com/maxmind/mmdbwriter/mmdbtype"
+	"github.com/pkg/errors"
+)
+
+// config is the configuration file for the build subcommand.
+type config struct {
+	Options treeOptions `json:"options"`
+	// Sources are inserted in order, so later sources take precedence
+	// over earlier ones where they overlap.
+	Sources []source `json:"sources"`
+}
+
+type treeOptions struct {
+	BuildEpoch              int64             `json:"build_epoch"`
+	DatabaseType            string            `json:"database_type"`
+	Description             map[string]string `json:"description"`
+	DisableIPv4Aliasing     bool              `json:"disable_ipv4_aliasing"`
+	IncludeReservedNetworks bool              `json:"include_reserved_networks"`
+	IPVersion               int               `json:"ip_version"`
+	Languages               []string          `json:"languages"`
+	RecordSize              int               `json:"record_size"`
+	SkipReservedInserts     bool              `json:"skip_reserved_inserts"`
+}
+
+type source struct {
+	// Type is one of geolite2-city, geolite2-country, geolite2-asn, csv,
+	// jsonl, rirstats, mrt, or mmdb.
+	Type string `json:"type"`
+	// Paths are the data files for the source, e.g., the blocks files for
+	// the GeoLite2 sources.
+	Paths []string `json:"paths"`
+	// Locations are the locations files for the GeoLite2 City and Country
+	// sources.
+	Locations []string `json:"locations"`
+	// Merge is how the records are combined with existing data: replace, the
+	// default, keep_existing, top_level, or deep. It is not supported by the
+	// GeoLite2 and rirstats sources.
+	Merge string `json:"merge"`
+
+	// The following are only used by the csv source.
+	Network    string     `json:"network"`
+	Fields     []csvField `json:"fields"`
+	Comma      string     `json:"comma"`
+	SkipErrors bool       `json:"skip_errors"`
+}
+
+type csvField struct {
+	Column string `json:"column"`
+	Field  string `json:"field"`
+	// Type is one of string, the default, bool, float32, float64, int32,
+	// uint16, uint32, or uint64.
+	Type string `json:"type"`
+}
+
+var csvTypes = map[string]genericcsv.Type{
+	"":        genericcsv.String,
+	"bool":    genericcsv.Bool,
+	"float32": genericcsv.Float32,
+	"float64": genericcsv.Float64,
+	"int32":   genericcsv.Int32,
+	"string":  genericcsv.String,
+	"uint16":  genericcsv.Uint16,
+	"uint32":  genericcsv.Uint32,
+	"uint64":  genericcsv.Uint64,
+}
+
+func mergeStrategy(name string) (inserter.FuncGenerator, error) {
+	switch name {
+	case "", "replace":
+		return inserter.ReplaceWith, nil
+	case "keep_existing":
+		return inserter.KeepExistingWith, nil
+	case "top_level":
+		return inserter.TopLevelMergeWith, nil
+	case "deep":
+		return inserter.DeepMergeWith, nil
+	default:
+		return nil, errors.Errorf("unknown merge strategy: %s", name)
+	}
+}
+
+// readConfig reads the configuration file. Relative paths in the sources
+// are resolved relative to the directory of the file.
+func readConfig(path string) (*config, error) {
+	b, err := ioutil.ReadFile(path) //nolint:gosec // the path is provided by the user
+	if err != nil {
+		return nil, errors.Wrap(err, "error reading config")
+	}
+
+	var c config
+	if err := json.Unmarshal(b, &c); err != nil {
+		return nil, errors.Wrapf(err, "error parsing config %s", path)
+	}
+
+	dir := filepath.Dir(path)
+	resolve := func(paths []string) {
+		for i, p := range paths {
+			if !filepath.IsAbs(p) {
+				paths[i] = filepath.Join(dir, p)
+			}
+		}
+	}
+	for i := range c.Sources {
+		resolve(c.Sources[i].Paths)
+		resolve(c.Sources[i].Locations)
+	}
+	return &c, nil
+}
+
+// build creates a tree from the configuration.
+func build(c *config) (*mmdbwriter.Tree, error) {
+	opts := mmdbwriter.Options{
+		BuildEpoch:              c.Options.BuildEpoch,
+		DatabaseType:            c.Options.DatabaseType,
+		Description:             c.Options.Description,
+		DisableIPv4Aliasing:     c.Options.DisableIPv4Aliasing,
+		IncludeReservedNetworks: c.Options.IncludeReservedNetworks,
+		IPVersion:               c.Options.IPVersion,
+		Languages:               c.Options.Languages,
+		RecordSize:              c.Options.RecordSize,
+	}
+	if c.Options.SkipReservedInserts {
+		opts.OnReservedInsert = mmdbwriter.ReservedInsertSkip
+	}
+
+	tree, err := mmdbwriter.New(opts)
+	if err != nil {
+		return nil, err
+	}
+
+	for i, s := range c.Sources {
+		if err := s.insert(tree); err != nil {
+			return nil, errors.WithMessagef(err, "error inserting source %d (%s)", i, s.Type)
+		}
+	}
+
+	if skipped := tree.SkippedInserts(); skipped > 0 {
+		logf("skipped %d inserts into reserved or aliased networks", skipped)
+	}
+	return tree, nil
+}
+
+func (s *source) insert(tree *mmdbwriter.Tree) error {
+	strategy, err := mergeStrategy(s.Merge)
+	if err != nil {
+		return err
+	}
+
+	switch s.Type {
+	case "geolite2-city", "geolite2-country", "geolite2-asn", "rirstats":
+		if s.Merge != "" && s.Merge != "replace" {
+			return errors.Errorf("the %s source does not support merging", s.Type)
+		}
+	}
+
+	switch s.Type {
+	case "geolite2-city", "geolite2-country":
+		locations := geolite2csv.NewLocations()
+		err := eachFile(s.Locations, func(r io.Reader) error {
+			return locations.Read(r)
+		})
+		if err != nil {
+			return err
+		}
+		insert := geolite2csv.InsertCityBlocks
+		if s.Type == "geolite2-country" {
+			insert = geolite2csv.InsertCountryBlocks
+		}
+		return eachFile(s.Paths, func(r io.Reader) error {
+			return insert(tree, r, locations)
+		})
+	case "geolite2-asn":
+		return eachFile(s.Paths, func(r io.Reader) error {
+			return geolite2csv.InsertASNBlocks(tree, r)
+		})
+	case "rirstats":
+		return eachFile(s.Paths, func(r io.Reader) error {
+			return rirstats.Insert(tree, r)
+		})
+	case "mrt":
+		return eachFile(s.Paths, func(r io.Reader) error {
+			return mrt.Insert(tree, r, mrt.Options{Inserter: strategy})
+		})
+	case "csv":
+		opts, err := s.csvOptions(strategy)
+		if err != nil {
+			return err
+		}
+		return eachFile(s.Paths, func(r io.Reader) error {
+			return genericcsv.Insert(tree, r, opts)
+		})
+	case "jsonl":
+		return eachFile(s.Paths, func(r io.Reader) error {
+			return insertJSONL(tree, r, strategy)
+		})
+	case "mmdb":
+		for _, path := range s.Paths {
+			other, err := mmdbwriter.Load(path, mmdbwriter.Options{})
+			if err != nil {
+				return errors.WithMessagef(err, "error loading %s", path)
+			}
+			if err := tree.MergeTree(other, strategy); err != nil {
+				return errors.WithMessagef(err, "error merging %s", path)
+			}
+		}
+		return nil
+	default:
+		return errors.Errorf("unknown source type: %q", s.Type)
+	}
+}
+
+func (s *source) csvOptions(strategy inserter.FuncGenerator) (genericcsv.Options, error) {
+	if s.Network == "" {
+		return genericcsv.Options{}, errors.New("the csv source requires a network column")
+	}
+
+	opts := genericcsv.Options{
+		Network:  genericcsv.Column{Name: s.Network},
+		Header:   genericcsv.HeaderPresent,
+		Inserter: strategy,
+	}
+
+	if s.Comma != "" {
+		comma, size := utf8.DecodeRuneInString(s.Comma)
+		if size != len(s.Comma) {
+			return genericcsv.Options{}, errors.Errorf("invalid comma: %q", s.Comma)
+		}
+		opts.Comma = comma
+	}
+
+	for _, f := range s.Fields {
+		typ, ok := csvTypes[f.Type]
+		if !ok {
+			return genericcsv.Options{}, errors.Errorf("unknown type for %s: %s", f.Column, f.Type)
+		}
+		field := f.Field
+		if field == "" {
+			field = f.Column
+		}
+		opts.Fields = append(opts.Fields, genericcsv.Column{Name: f.Column, Field: field, Type: typ})
+	}
+
+	if s.SkipErrors {
+		opts.OnError = func(line int, err error) error {
+			logf("skipping line %d: %v", line, err)
+			return nil
+		}
+	}
+	return opts, nil
+}
+
+type jsonlRecord struct {
+	Network string          `json:"network"`
+	Data    json.RawMessage `json:"data"`
+}
+
+// insertJSONL inserts JSON Lines in the format written by
+// Tree.ExportJSONL.
+func insertJSONL(tree *mmdbwriter.Tree, r io.Reader, strategy inserter.FuncGenerator) error {
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(nil, 64*1024*1024)
+	for line := 1; scanner.Scan(); line++ {
+		if len(scanner.Bytes()) == 0 {
+			continue
+		}
+
+		var record jsonlRecord
+		if err := json.Unmarshal(scanner.Bytes(), &record); err != nil {
+			return errors.Wrapf(err, "error parsing line %d", line)
+		}
+		_, network, err := net.ParseCIDR(record.Network)
+		if err != nil {
+			return errors.Wrapf(err, "error parsing network on line %d", line)
+		}
+		value, err := mmdbtype.FromJSON(record.Data)
+		if err != nil {
+			return errors.WithMessagef(err, "error converting data on line %d", line)
+		}
+		if err := tree.InsertWith(network, value, strategy); err != nil {
+			return errors.WithMessagef(err, "error inserting line %d", line)
+		}
+	}
+	return errors.Wrap(scanner.Err(), "error reading JSON Lines")
+}
+
+// eachFile opens each file and calls fn with its contents.
+func eachFile(paths []string, fn func(r io.Reader) error) error {
+	for _, path := range paths {
+		err := func() error {
+			f, err := os.Open(path) //nolint:gosec // the path is provided by the user
+			if err != nil {
+				return errors.Wrap(err, "error opening file")
+			}
+			defer f.Close()
+			return fn(f)
+		}()
+		if err != nil {
+			return errors.WithMessagef(err, "error processing %s", path)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,70 @@
+package main
+
+import (
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/maxmind/mmdbwriter/mmdbtype"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestBuild(t *testing.T) {
+	dir, err := ioutil.TempDir("", "mmdbwriter")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	files := map[string]string{
+		"asn.csv": "network,asn,org\n1.1.1.0/24,13335,Cloudflare\n",
+		"extra.jsonl": `{"network":"1.1.1.0/24","data":{"is_anycast":true}}` + "\n" +
+			`{"network":"8.8.8.0/24","data":{"org":"Google"}}` + "\n",
+		"config.json": `{
+			"options": {"database_type": "Test"},
+			"sources": [
+				{
+					"type": "csv",
+					"paths": ["asn.csv"],
+					"network": "network",
+					"fields": [
+						{"column": "asn", "field": "autonomous_system_number", "type": "uint32"},
+						{"column": "org"}
+					]
+				},
+				{"type": "jsonl", "paths": ["extra.jsonl"], "merge": "top_level"}
+			]
+		}`,
+	}
+	for name, contents := range files {
+		require.NoError(t, ioutil.WriteFile(filepath.Join(dir, name), []byte(contents), 0o600))
+	}
+
+	c, err := readConfig(filepath.Join(dir, "config.json"))
+	require.NoError(t, err)
+
+	tree, err := build(c)
+	require.NoError(t, err)
+
+	_, value := tree.Get(net.ParseIP("1.1.1.1"))
+	assert.Equal(
+		t,
+		mmdbtype.Map{
+			"autonomous_system_number": mmdbtype.Uint32(13335),
+			"is_anycast":               mmdbtype.Bool(true),
+			"org":                      mmdbtype.String("Cloudflare"),
+		},
+		value,
+	)
+
+	_, value = tree.Get(net.ParseIP("8.8.8.8"))
+	assert.Equal(t, mmdbtype.Map{"org": mmdbtype.String("Google")}, value)
+
+	_, err = build(&config{Sources: []source{{Type: "geolite2-asn", Merge: "deep"}}})
+	assert.EqualError(
+		t,
+		err,
+		"error inserting source 0 (geolite2-asn): the geolite2-asn source does not support merging",
+	)
+}
@@ -0,0 +1,300 @@
+// mmdbwriter builds, merges, compares, and inspects MaxMind DB files.
+//
+// Usage:
+//
+//	mmdbwriter build -config config.json -o out.mmdb
+//	mmdbwriter merge [-strategy replace|keep_existing|top_level|deep] -o out.mmdb a.mmdb b.mmdb...
+//	mmdbwriter diff a.mmdb b.mmdb
+//	mmdbwriter inspect [-export jsonl|csv] [-fields a,b.c] db.mmdb [ip...]
+//
+// The build subcommand creates a database from the sources in a JSON
+// configuration file, e.g.:
+//
+//	{
+//	  "options": {
+//	    "database_type": "My-City-DB",
+//	    "description": {"en": "My City database"}
+//	  },
+//	  "sources": [
+//	    {
+//	      "type": "geolite2-city",
+//	      "locations": ["GeoLite2-City-Locations-en.csv"],
+//	      "paths": ["GeoLite2-City-Blocks-IPv4.csv", "GeoLite2-City-Blocks-IPv6.csv"]
+//	    },
+//	    {
+//	      "type": "csv",
+//	      "paths": ["extra.csv"],
+//	      "merge": "deep",
+//	      "network": "network",
+//	      "fields": [{"column": "asn", "field": "autonomous_system_number", "type": "uint32"}]
+//	    }
+//	  ]
+//	}
+//
+// The source types are geolite2-city, geolite2-country, geolite2-asn, csv,
+// jsonl (in the format written by the inspect -export jsonl subcommand),
+// rirstats, mrt, and mmdb. Relative paths are resolved relative to the
+// directory of the configuration file.
+//
+// The diff subcommand writes one JSON object per line for each network
+// whose data differs between the databases.
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"net"
+	"os"
+	"strings"
+
+	"github.com/maxmind/mmdbwriter"
+	"github.com/maxmind/mmdbwriter/mmdbtype"
+	"github.com/oschwald/maxminddb-golang"
+	"github.com/pkg/errors"
+)
+
+var commands = map[string]func(args []string) error{
+	"build":   runBuild,
+	"merge":   runMerge,
+	"diff":    runDiff,
+	"inspect": runInspect,
+}
+
+func main() {
+	if len(os.Args) < 2 {
+		usage()
+		os.Exit(2)
+	}
+
+	cmd, ok := commands[os.Args[1]]
+	if !ok {
+		usage()
+		os.Exit(2)
+	}
+
+	if err := cmd(os.Args[2:]); err != nil {
+		fmt.Fprintf(os.Stderr, "mmdbwriter %s: %+v\n", os.Args[1], err)
+		os.Exit(1)
+	}
+}
+
+func usage() {
+	fmt.Fprint(os.Stderr, `Usage:
+  mmdbwriter build -config config.json -o out.mmdb
+  mmdbwriter merge [-strategy replace|keep_existing|top_level|deep] -o out.mmdb a.mmdb b.mmdb...
+  mmdbwriter diff a.mmdb b.mmdb
+  mmdbwriter inspect [-export jsonl|csv] [-fields a,b.c] db.mmdb [ip...]
+`)
+}
+
+func logf(format string, args ...interface{}) {
+	fmt.Fprintf(os.Stderr, format+"\n", args...)
+}
+
+func runBuild(args []string) error {
+	fs := flag.NewFlagSet("build", flag.ExitOnError)
+	configPath := fs.String("config", "", "path to the JSON configuration file")
+	out := fs.String("o", "", "path to write the database to")
+	_ = fs.Parse(args)
+
+	if *configPath == "" || *out == "" || fs.NArg() != 0 {
+		return errors.New("-config and -o are required")
+	}
+
+	c, err := readConfig(*configPath)
+	if err != nil {
+		return err
+	}
+
+	tree, err := build(c)
+	if err != nil {
+		return err
+	}
+	return writeTree(tree, *out)
+}
+
+func runMerge(args []string) error {
+	fs := flag.NewFlagSet("merge", flag.ExitOnError)
+	out := fs.String("o", "", "path to write the database to")
+	strategyName := fs.String(
+		"strategy",
+		"replace",
+		"how to merge the databases: replace, keep_existing, top_level, or deep",
+	)
+	_ = fs.Parse(args)
+
+	if *out == "" || fs.NArg() < 2 {
+		return errors.New("-o and at least two databases are required")
+	}
+
+	strategy, err := mergeStrategy(*strategyName)
+	if err != nil {
+		return err
+	}
+
+	// The metadata, other than the build epoch, is taken from the first
+	// database.
+	tree, err := mmdbwriter.Load(fs.Arg(0), mmdbwriter.Options{})
+	if err != nil {
+		return errors.WithMessagef(err, "error loading %s", fs.Arg(0))
+	}
+	for _, path := range fs.Args()[1:] {
+		other, err := mmdbwriter.Load(path, mmdbwriter.Options{})
+		if err != nil {
+			return errors.WithMessagef(err, "error loading %s", path)
+		}
+		if err := tree.MergeTree(other, strategy); err != nil {
+			return errors.WithMessagef(err, "error merging %s", path)
+		}
+	}
+	return writeTree(tree, *out)
+}
+
+var differenceTypes = map[mmdbwriter.DifferenceType]string{
+	mmdbwriter.DifferenceAdded:   "added",
+	mmdbwriter.DifferenceRemoved: "removed",
+	mmdbwriter.DifferenceChanged: "changed",
+}
+
+type jsonDifference struct {
+	Network string      `json:"network"`
+	Type    string      `json:"type"`
+	Before  interface{} `json:"before,omitempty"`
+	After   interface{} `json:"after,omitempty"`
+}
+
+func runDiff(args []string) error {
+	fs := flag.NewFlagSet("diff", flag.ExitOnError)
+	_ = fs.Parse(args)
+
+	if fs.NArg() != 2 {
+		return errors.New("two databases are required")
+	}
+
+	var trees [2]*mmdbwriter.Tree
+	for i, path := range fs.Args() {
+		tree, err := mmdbwriter.Load(path, mmdbwriter.Options{})
+		if err != nil {
+			return errors.WithMessagef(err, "error loading %s", path)
+		}
+		trees[i] = tree
+	}
+
+	diffs, err := mmdbwriter.Diff(trees[0], trees[1])
+	if err != nil {
+		return err
+	}
+
+	enc := json.NewEncoder(os.Stdout)
+	for _, d := range diffs {
+		jd := jsonDifference{Network: d.Network.String(), Type: differenceTypes[d.Type]}
+		if err := mmdbtype.Unmarshal(d.Before, &jd.Before); err != nil {
+			return err
+		}
+		if err := mmdbtype.Unmarshal(d.After, &jd.After); err != nil {
+			return err
+		}
+		if err := enc.Encode(jd); err != nil {
+			return errors.Wrap(err, "error writing difference")
+		}
+	}
+	return nil
+}
+
+type inspectLookup struct {
+	IP      string      `json:"ip"`
+	Network string      `json:"network"`
+	Data    interface{} `json:"data"`
+}
+
+func runInspect(args []string) error {
+	fs := flag.NewFlagSet("inspect", flag.ExitOnError)
+	export := fs.String("export", "", "write every network as jsonl or csv instead of the metadata")
+	fields := fs.String("fields", "", "comma-separated fields to include with -export csv")
+	_ = fs.Parse(args)
+
+	if fs.NArg() < 1 {
+		return errors.New("a database is required")
+	}
+	path := fs.Arg(0)
+
+	switch *export {
+	case "":
+	case "jsonl", "csv":
+		tree, err := mmdbwriter.Load(path, mmdbwriter.Options{})
+		if err != nil {
+			return errors.WithMessagef(err, "error loading %s", path)
+		}
+		if *export == "jsonl" {
+			return tree.ExportJSONL(os.Stdout)
+		}
+		if *fields == "" {
+			return errors.New("-fields is required with -export csv")
+		}
+		return tree.ExportCSV(os.Stdout, strings.Split(*fields, ","))
+	default:
+		return errors.Errorf("unknown export format: %s", *export)
+	}
+
+	reader, err := maxminddb.Open(path)
+	if err != nil {
+		return errors.Wrapf(err, "error opening %s", path)
+	}
+	defer reader.Close()
+
+	enc := json.NewEncoder(os.Stdout)
+	enc.SetIndent("", "  ")
+
+	if fs.NArg() == 1 {
+		return inspectMetadata(enc, reader)
+	}
+
+	for _, s := range fs.Args()[1:] {
+		ip := net.ParseIP(s)
+		if ip == nil {
+			return errors.Errorf("invalid IP address: %s", s)
+		}
+		lookup := inspectLookup{IP: s}
+		network, _, err := reader.LookupNetwork(ip, &lookup.Data)
+		if err != nil {
+			return errors.Wrapf(err, "error looking up %s", s)
+		}
+		lookup.Network = network.String()
+		if err := enc.Encode(lookup); err != nil {
+			return errors.Wrap(err, "error writing lookup")
+		}
+	}
+	return nil
+}
+
+func inspectMetadata(enc *json.Encoder, reader *maxminddb.Reader) error {
+	count := 0
+	networks := reader.Networks(maxminddb.SkipAliasedNetworks)
+	for networks.Next() {
+		count++
+	}
+	if err := networks.Err(); err != nil {
+		return errors.Wrap(err, "error iterating over networks")
+	}
+
+	m := reader.Metadata
+	return errors.Wrap(enc.Encode(map[string]interface{}{
+		"binary_format_major_version": m.BinaryFormatMajorVersion,
+		"binary_format_minor_version": m.BinaryFormatMinorVersion,
+		"build_epoch":                 m.BuildEpoch,
+		"database_type":               m.DatabaseType,
+		"description":                 m.Description,
+		"ip_version":                  m.IPVersion,
+		"languages":                   m.Languages,
+		"node_count":                  m.NodeCount,
+		"record_size":                 m.RecordSize,
+		"network_count":               count,
+	}), "error writing metadata")
+}
+
+// writeTree writes the tree to the file at path.
+func writeTree(tree *mmdbwriter.Tree, path string) error {
+	_, err := tree.WriteToFile(path)
+	return err
+}
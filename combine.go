@@ -0,0 +1,72 @@
+package mmdbwriter
+
+import (
+	"net"
+
+	"github.com/maxmind/mmdbwriter/inserter"
+	"github.com/maxmind/mmdbwriter/mmdbtype"
+	"github.com/oschwald/maxminddb-golang"
+	"github.com/pkg/errors"
+)
+
+// CombineSource is a database to combine with others with Combine.
+type CombineSource struct {
+	// Path is the path to the database.
+	Path string
+	// Key is the key that the values from the database are stored under,
+	// e.g., "asn".
+	Key string
+}
+
+// Combine returns a new tree with the options that combines the databases,
+// e.g., to merge separate geolocation, ASN, and threat databases into one.
+// The value for each network is a Map with the value from each database
+// that has data for the network stored under the database's key:
+//
+//	{"geo": {...}, "asn": {...}, "threat": {...}}
+//
+// The networks of the databases need not line up. Where a network in one
+// database spans several networks in another, it is split so that each part
+// has the values from both.
+//
+// The databases are read one at a time rather than loaded into trees of
+// their own. Aliased networks in IPv6 databases are skipped as with Load.
+func Combine(opts Options, sources []CombineSource) (*Tree, error) {
+	tree, err := New(opts)
+	if err != nil {
+		return nil, err
+	}
+
+	keys := map[string]bool{}
+	for _, source := range sources {
+		if source.Key == "" {
+			return nil, errors.Errorf("the key for %s is empty", source.Path)
+		}
+		if keys[source.Key] {
+			return nil, errors.Errorf("the key %q is used for more than one database", source.Key)
+		}
+		keys[source.Key] = true
+
+		if err := tree.combine(source); err != nil {
+			return nil, errors.WithMessagef(err, "error combining %s", source.Path)
+		}
+	}
+	return tree, nil
+}
+
+func (t *Tree) combine(source CombineSource) error {
+	db, err := maxminddb.Open(source.Path)
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+
+	key := mmdbtype.String(source.Key)
+	l := newLoader(t, func(network *net.IPNet, value mmdbtype.DataType) error {
+		return t.InsertFunc(network, inserter.TopLevelMergeWith(mmdbtype.Map{key: value}))
+	})
+	// The IP version in the options may be 0 for the default.
+	opts := t.options
+	opts.IPVersion = t.ipVersion
+	return l.load(db.Networks(loadNetworksOptions(opts)...), nil)
+}
@@ -0,0 +1,61 @@
+package mmdbwriter
+
+import (
+	"net"
+	"os"
+	"testing"
+
+	"github.com/maxmind/mmdbwriter/mmdbtype"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestCombine(t *testing.T) {
+	geo, err := New(Options{})
+	require.NoError(t, err)
+	require.NoError(t, geo.Insert(mustParseNetwork(t, "1.1.0.0/16"), mmdbtype.Map{"country": mmdbtype.String("DE")}))
+	require.NoError(t, geo.Insert(mustParseNetwork(t, "2003::/16"), mmdbtype.Map{"country": mmdbtype.String("DE")}))
+	geoPath := writeTempDatabase(t, geo)
+	defer func() { require.NoError(t, os.Remove(geoPath)) }()
+
+	asn, err := New(Options{IPVersion: 4})
+	require.NoError(t, err)
+	require.NoError(t, asn.Insert(mustParseNetwork(t, "1.1.1.0/24"), mmdbtype.Uint32(13335)))
+	require.NoError(t, asn.Insert(mustParseNetwork(t, "2.2.2.0/24"), mmdbtype.Uint32(15169)))
+	asnPath := writeTempDatabase(t, asn)
+	defer func() { require.NoError(t, os.Remove(asnPath)) }()
+
+	tree, err := Combine(Options{}, []CombineSource{{Path: geoPath, Key: "geo"}, {Path: asnPath, Key: "asn"}})
+	require.NoError(t, err)
+
+	values := map[string]mmdbtype.DataType{}
+	require.NoError(t, tree.Walk(func(network *net.IPNet, value mmdbtype.DataType) error {
+		values[network.String()] = value
+		return nil
+	}))
+	geoValue := mmdbtype.Map{"country": mmdbtype.String("DE")}
+	assert.Equal(
+		t,
+		map[string]mmdbtype.DataType{
+			"1.1.0.0/24":   mmdbtype.Map{"geo": geoValue},
+			"1.1.1.0/24":   mmdbtype.Map{"geo": geoValue, "asn": mmdbtype.Uint32(13335)},
+			"1.1.2.0/23":   mmdbtype.Map{"geo": geoValue},
+			"1.1.4.0/22":   mmdbtype.Map{"geo": geoValue},
+			"1.1.8.0/21":   mmdbtype.Map{"geo": geoValue},
+			"1.1.16.0/20":  mmdbtype.Map{"geo": geoValue},
+			"1.1.32.0/19":  mmdbtype.Map{"geo": geoValue},
+			"1.1.64.0/18":  mmdbtype.Map{"geo": geoValue},
+			"1.1.128.0/17": mmdbtype.Map{"geo": geoValue},
+			"2.2.2.0/24":   mmdbtype.Map{"asn": mmdbtype.Uint32(15169)},
+			"2003::/16":    mmdbtype.Map{"geo": geoValue},
+		},
+		values,
+	)
+
+	_, err = Combine(Options{}, []CombineSource{{Path: geoPath, Key: "geo"}, {Path: asnPath, Key: "geo"}})
+	assert.EqualError(t, err, `the key "geo" is used for more than one database`)
+	_, err = Combine(Options{}, []CombineSource{{Path: geoPath}})
+	assert.EqualError(t, err, "the key for "+geoPath+" is empty")
+	_, err = Combine(Options{IPVersion: 4}, []CombineSource{{Path: geoPath, Key: "geo"}})
+	assert.Contains(t, err.Error(), "error combining "+geoPath+": ")
+}
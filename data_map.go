@@ -1,6 +1,10 @@
 package mmdbwriter
 
-import "github.com/maxmind/mmdbwriter/mmdbtype"
+import (
+	"reflect"
+
+	"github.com/maxmind/mmdbwriter/mmdbtype"
+)
 
 type dataMapKey string
 
@@ -8,7 +12,14 @@ type dataMapKey string
 // alignment as we end up storing quite a few in memory.
 type dataMapValue struct {
 	data mmdbtype.DataType
-	key  dataMapKey
+	// key is generated from the data alone. It is used to compare values
+	// and to deduplicate them when writing the data section.
+	key dataMapKey
+	// mapKey is the key in the dataMap. It is the same as key unless the
+	// value has a provenance, in which case it also includes the
+	// provenance.
+	mapKey     dataMapKey
+	provenance *Provenance
 
 	// Alternatively, we could use a weak map for the data map, but I
 	// don't see any very good options at the moment. We should revist
@@ -21,6 +32,14 @@ type dataMapValue struct {
 type dataMap struct {
 	data      map[dataMapKey]*dataMapValue
 	keyWriter *keyWriter
+	// validate is set if the values should be checked with
+	// mmdbtype.Validate before they are stored.
+	validate bool
+	// schema, if set, is used to check the values before they are stored.
+	schema Schema
+	// keyCache is only set if Options.KeyCacheSize is positive.
+	keyCache *keyCache
+	metrics  Metrics
 }
 
 func newDataMap() *dataMap {
@@ -31,22 +50,29 @@ func newDataMap() *dataMap {
 }
 
 // store stores the value in the dataMap and returns the dataMapValue for it.
-// If the value is already in the dataMap, the reference count for it is
-// incremented.
-func (dm *dataMap) store(v mmdbtype.DataType) (*dataMapValue, error) {
-	key, err := dm.keyWriter.key(v)
+// If the value is already in the dataMap with the same provenance, the
+// reference count for it is incremented. prov may be nil.
+func (dm *dataMap) store(v mmdbtype.DataType, prov *Provenance) (*dataMapValue, error) {
+	dmKey, err := dm.key(v)
 	if err != nil {
 		return nil, err
 	}
+	mapKey := dmKey
+	if prov != nil {
+		mapKey += dataMapKey(prov.key())
+	}
 
-	dmv, ok := dm.data[dataMapKey(key)]
+	dmv, ok := dm.data[mapKey]
 	if !ok {
-		dmKey := dataMapKey(key)
 		dmv = &dataMapValue{
-			key:  dmKey,
-			data: v,
+			key:        dmKey,
+			mapKey:     mapKey,
+			data:       v,
+			provenance: prov,
 		}
-		dm.data[dmKey] = dmv
+		dm.data[mapKey] = dmv
+	} else {
+		addMetric(dm.metrics, CounterDedupHits, 1)
 	}
 
 	dmv.refCount++
@@ -60,6 +86,87 @@ func (dm *dataMap) remove(v *dataMapValue) {
 	v.refCount--
 
 	if v.refCount == 0 {
-		delete(dm.data, v.key)
+		delete(dm.data, v.mapKey)
+	}
+}
+
+// key validates the value, if required, and returns its key. Values found in
+// the key cache were validated when they were first stored.
+func (dm *dataMap) key(v mmdbtype.DataType) (dataMapKey, error) {
+	id, cacheable := valueIdentityOf(v)
+	if cacheable && dm.keyCache != nil {
+		if entry, ok := dm.keyCache.entries[id]; ok {
+			return entry.key, nil
+		}
+	}
+
+	if dm.validate {
+		if err := mmdbtype.Validate(v); err != nil {
+			return "", err
+		}
+	}
+	if dm.schema != nil {
+		if err := dm.schema.Validate(v); err != nil {
+			return "", err
+		}
+	}
+
+	key, err := dm.keyWriter.key(v)
+	if err != nil {
+		return "", err
+	}
+	dmKey := dataMapKey(key)
+
+	if cacheable && dm.keyCache != nil {
+		dm.keyCache.add(id, v, dmKey)
+	}
+	return dmKey, nil
+}
+
+// valueIdentity identifies a Map or Slice by the memory it refers to rather
+// than its contents.
+type valueIdentity struct {
+	isMap  bool
+	ptr    uintptr
+	length int
+}
+
+func valueIdentityOf(v mmdbtype.DataType) (valueIdentity, bool) {
+	switch v := v.(type) {
+	case mmdbtype.Map:
+		return valueIdentity{isMap: true, ptr: reflect.ValueOf(v).Pointer(), length: len(v)}, v != nil
+	case mmdbtype.Slice:
+		return valueIdentity{ptr: reflect.ValueOf(v).Pointer(), length: len(v)}, len(v) > 0
+	default:
+		return valueIdentity{}, false
+	}
+}
+
+// keyCache caches the keys of Map and Slice values by their identity, so
+// that inserting the same value many times, e.g., the Map for a country,
+// only encodes it once. As values must not be modified once inserted, a
+// value with the same identity has the same key. The entries keep a
+// reference to their value so that its memory cannot be reused by a
+// different value while the entry exists.
+type keyCache struct {
+	entries map[valueIdentity]keyCacheEntry
+	size    int
+}
+
+type keyCacheEntry struct {
+	value mmdbtype.DataType
+	key   dataMapKey
+}
+
+func newKeyCache(size int) *keyCache {
+	return &keyCache{entries: map[valueIdentity]keyCacheEntry{}, size: size}
+}
+
+func (kc *keyCache) add(id valueIdentity, v mmdbtype.DataType, key dataMapKey) {
+	if len(kc.entries) >= kc.size {
+		// Rather than tracking which entries were used least recently, we
+		// start over. Frequently inserted values are quickly added back.
+		kc.entries = make(map[valueIdentity]keyCacheEntry, kc.size)
 	}
+	kc.entries[id] = keyCacheEntry{value: v, key: key}
 }
@@ -1,6 +1,7 @@
 package mmdbwriter
 
 import (
+	"net"
 	"testing"
 
 	"github.com/maxmind/mmdbwriter/mmdbtype"
@@ -13,15 +14,18 @@ func TestDataMap(t *testing.T) {
 
 	dm := newDataMap()
 
-	dmv, err := dm.store(v)
+	dmv, err := dm.store(v, nil)
 	require.NoError(t, err)
 
+	key := dataMapKey("\x87\x02\xf53\x8b\x96\xfdǻQ\x97\x9c\xe2\xcc\\\xda\xf2\xb1\xd7" +
+		"\xc1L\xc5l\xfd\x83\xfc\x97\xd6\x03\xf5\xedr")
+
 	assert.Equal(
 		t,
 		&dataMapValue{
-			data: v,
-			key: "\x87\x02\xf53\x8b\x96\xfdǻQ\x97\x9c\xe2\xcc\\\xda\xf2\xb1\xd7" +
-				"\xc1L\xc5l\xfd\x83\xfc\x97\xd6\x03\xf5\xedr",
+			data:     v,
+			key:      key,
+			mapKey:   key,
 			refCount: 1,
 		},
 		dmv,
@@ -31,7 +35,7 @@ func TestDataMap(t *testing.T) {
 
 	assert.Equal(t, dmv, mapDMV)
 
-	dmv, err = dm.store(v)
+	dmv, err = dm.store(v, nil)
 	require.NoError(t, err)
 
 	assert.Equal(t, uint32(2), dmv.refCount, "refCount incremented on store")
@@ -46,3 +50,110 @@ func TestDataMap(t *testing.T) {
 	_, ok := dm.data[dmv.key]
 	assert.False(t, ok, "map value removed when refCount drops to 0")
 }
+
+func TestDataMapKeyCache(t *testing.T) {
+	dm := newDataMap()
+	dm.keyCache = newKeyCache(2)
+
+	m := mmdbtype.Map{"a": mmdbtype.String("b")}
+	dmv, err := dm.store(m, nil)
+	require.NoError(t, err)
+	require.Len(t, dm.keyCache.entries, 1)
+
+	other := mmdbtype.Slice{mmdbtype.Bool(true)}
+	_, err = dm.store(other, nil)
+	require.NoError(t, err)
+	require.Len(t, dm.keyCache.entries, 2)
+
+	// The cached key is used for the same Map...
+	dm.keyWriter = nil
+	again, err := dm.store(m, nil)
+	require.NoError(t, err)
+	assert.Same(t, dmv, again)
+	assert.Equal(t, uint32(2), again.refCount)
+
+	// ...but equal copies and scalars are encoded.
+	dm.keyWriter = newKeyWriter()
+	again, err = dm.store(m.Copy(), nil)
+	require.NoError(t, err)
+	assert.Same(t, dmv, again)
+	_, err = dm.store(mmdbtype.String("b"), nil)
+	require.NoError(t, err)
+
+	assert.Len(t, dm.keyCache.entries, 1, "the cache is reset when it is full")
+}
+
+// assertRefCounts checks that the reference count of each value in the
+// tree's data map is the number of data records in the tree with the value.
+func assertRefCounts(t *testing.T, tree *Tree) {
+	counts := map[*dataMapValue]uint32{}
+	countValueRefs(tree.root, counts)
+
+	for key, value := range tree.dataMap.data {
+		assert.Equal(t, counts[value], value.refCount, "reference count for %v", value.data)
+		delete(counts, value)
+		assert.Equal(t, value.mapKey, key)
+	}
+	for value := range counts {
+		assert.Fail(t, "value in the tree is not in the data map", "%v", value.data)
+	}
+}
+
+func countValueRefs(n *node, counts map[*dataMapValue]uint32) {
+	for i := 0; i < 2; i++ {
+		r := n.children[i]
+		switch r.recordType {
+		case recordTypeData:
+			counts[r.value]++
+		case recordTypeNode, recordTypeFixedNode:
+			countValueRefs(r.node, counts)
+		default:
+		}
+	}
+}
+
+func TestDataMapRefCountsAfterSplit(t *testing.T) {
+	tree, err := New(Options{})
+	require.NoError(t, err)
+
+	a := mmdbtype.String("a")
+	require.NoError(t, tree.Insert(mustParseNetwork(t, "1.1.0.0/16"), a))
+	// This splits the record for 1.1.0.0/16 into one record for each bit
+	// down to 1.1.1.0/24, which all reference a.
+	require.NoError(t, tree.Insert(mustParseNetwork(t, "1.1.1.0/24"), mmdbtype.String("b")))
+	assertRefCounts(t, tree)
+
+	// Overwriting some of the records with a must not remove a from the
+	// data map while other records still reference it.
+	require.NoError(t, tree.Insert(mustParseNetwork(t, "1.1.128.0/17"), mmdbtype.String("c")))
+	assertRefCounts(t, tree)
+	_, value := tree.Get(net.ParseIP("1.1.0.1"))
+	assert.Equal(t, a, value)
+
+	require.NoError(t, tree.Insert(mustParseNetwork(t, "1.1.0.0/16"), mmdbtype.String("d")))
+	assertRefCounts(t, tree)
+	assert.Len(t, tree.dataMap.data, 1)
+}
+
+func TestDataMapRefCountsAfterMerge(t *testing.T) {
+	tree, err := New(Options{})
+	require.NoError(t, err)
+
+	a := mmdbtype.String("a")
+	require.NoError(t, tree.Insert(mustParseNetwork(t, "1.1.0.0/16"), a))
+	require.NoError(t, tree.Insert(mustParseNetwork(t, "1.1.1.0/24"), mmdbtype.String("b")))
+	// This makes the records under 1.1.0.0/16 the same again, so they are
+	// merged when the tree is finalized.
+	require.NoError(t, tree.Insert(mustParseNetwork(t, "1.1.1.0/24"), a))
+	tree.finalize()
+	assertRefCounts(t, tree)
+	network, _ := tree.Get(net.ParseIP("1.1.1.1"))
+	assert.Equal(t, "1.1.0.0/16", network.String())
+
+	require.NoError(t, tree.Insert(mustParseNetwork(t, "1.1.0.0/16"), mmdbtype.String("c")))
+	assertRefCounts(t, tree)
+	assert.Len(t, tree.dataMap.data, 1, "a is removed from the data map")
+	assert.Equal(t, []mmdbtype.DataType{mmdbtype.String("c")}, tree.FindValues(func(mmdbtype.DataType) bool {
+		return true
+	}))
+}
@@ -4,6 +4,7 @@ import (
 	"bytes"
 
 	"github.com/maxmind/mmdbwriter/mmdbtype"
+	"github.com/pkg/errors"
 )
 
 type writtenType struct {
@@ -51,6 +52,19 @@ func (dw *dataWriter) maybeWrite(value *dataMapValue) (int, error) {
 	return int(written.pointer), nil
 }
 
+// offset returns the offset of a value that has already been written with
+// maybeWrite. It does not modify the dataWriter and is safe to call
+// concurrently as long as nothing is being written.
+func (dw *dataWriter) offset(value *dataMapValue) (int, error) {
+	written, ok := dw.offsets[value.key]
+	if !ok {
+		// This should only happen if there is a programming bug
+		// in this library.
+		return 0, errors.New("attempted to write a node before its data")
+	}
+	return int(written.pointer), nil
+}
+
 func (dw *dataWriter) WriteOrWritePointer(t mmdbtype.DataType) (int64, error) {
 	keyBytes, err := dw.keyWriter.key(t)
 	if err != nil {
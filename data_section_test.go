@@ -17,7 +17,7 @@ func TestDisablingPointers(t *testing.T) {
 	}
 	dm := newDataMap()
 
-	key, err := dm.store(v)
+	key, err := dm.store(v, nil)
 	require.NoError(t, err)
 
 	usePointers := true
@@ -33,3 +33,36 @@ func TestDisablingPointers(t *testing.T) {
 
 	assert.Less(t, pointerWriter.Len(), noPointerWriter.Len())
 }
+
+func TestNestedValuesArePointers(t *testing.T) {
+	names := mmdbtype.Map{
+		"de": mmdbtype.String("Vereinigte Staaten"),
+		"en": mmdbtype.String("United States"),
+		"es": mmdbtype.String("Estados Unidos"),
+		"fr": mmdbtype.String("États Unis"),
+	}
+	records := []mmdbtype.Map{
+		{"city": mmdbtype.String("Seattle"), "country": mmdbtype.Map{"names": names}},
+		{"city": mmdbtype.String("Portland"), "country": mmdbtype.Map{"names": names}},
+	}
+
+	dm := newDataMap()
+	var values []*dataMapValue
+	for _, r := range records {
+		v, err := dm.store(r, nil)
+		require.NoError(t, err)
+		values = append(values, v)
+	}
+
+	usePointers := true
+	pointerWriter := newDataWriter(dm, usePointers)
+	for _, v := range values {
+		_, err := pointerWriter.maybeWrite(v)
+		require.NoError(t, err)
+	}
+
+	// The second record should only add its city and a pointer to the
+	// country map of the first record.
+	firstSize := int(pointerWriter.offsets[values[1].key].pointer)
+	assert.Less(t, pointerWriter.Len()-firstSize, firstSize/2)
+}
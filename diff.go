@@ -0,0 +1,155 @@
+package mmdbwriter
+
+import (
+	"net"
+
+	"github.com/maxmind/mmdbwriter/mmdbtype"
+	"github.com/pkg/errors"
+)
+
+// DifferenceType is the type of change between two trees for a network.
+type DifferenceType int
+
+const (
+	// DifferenceAdded means the network only has data in the second tree.
+	DifferenceAdded DifferenceType = iota + 1
+	// DifferenceRemoved means the network only has data in the first tree.
+	DifferenceRemoved
+	// DifferenceChanged means the network has different data in the trees.
+	DifferenceChanged
+)
+
+// Difference is a network whose data differs between two trees.
+type Difference struct {
+	Network *net.IPNet
+	Type    DifferenceType
+	// Before is the value in the first tree. It is nil if the network was
+	// added.
+	Before mmdbtype.DataType
+	// After is the value in the second tree. It is nil if the network was
+	// removed.
+	After mmdbtype.DataType
+}
+
+// Diff compares the trees and returns the networks whose data differs, in
+// address order. The trees are traversed together, so each network in the
+// result is as large as possible without overlapping a network with
+// different data in either tree. As with Walk, the trees are finalized
+// first, aliased networks are skipped, and networks in the IPv4 subtree of
+// an IPv6 tree are returned as IPv4 networks.
+//
+// Values are compared by their encoded form, and the values in the result
+// are shared with the trees. They must not be modified.
+//
+// Both trees must have the same IP version.
+func Diff(a, b *Tree) ([]Difference, error) {
+	if a.treeDepth != b.treeDepth {
+		return nil, errors.Errorf("cannot diff an IPv%d tree with an IPv%d tree", a.ipVersion, b.ipVersion)
+	}
+	if a == b {
+		return nil, nil
+	}
+
+	lockTrees(a, b)
+	defer unlockTrees(a, b)
+
+	for _, t := range []*Tree{a, b} {
+		if t.nodeCount == 0 {
+			t.finalize()
+		}
+	}
+
+	var diffs []Difference
+	ip := make(net.IP, a.treeDepth/8)
+	diffRecords(
+		&record{recordType: recordTypeNode, node: a.root},
+		&record{recordType: recordTypeNode, node: b.root},
+		ip,
+		0,
+		func(prefixLen int, before, after *dataMapValue) {
+			d := Difference{Network: a.network(ip, prefixLen)}
+			switch {
+			case before == nil:
+				d.Type = DifferenceAdded
+				d.After = after.data
+			case after == nil:
+				d.Type = DifferenceRemoved
+				d.Before = before.data
+			default:
+				d.Type = DifferenceChanged
+				d.Before = before.data
+				d.After = after.data
+			}
+			diffs = append(diffs, d)
+		},
+	)
+	return diffs, nil
+}
+
+// diffRecords traverses the two records together and calls fn for every
+// network where their data differs. If one record is a node and the other
+// is not, the other record is compared with each of the node's children.
+// ip is used as a buffer for the path to the current records.
+func diffRecords(
+	a, b *record,
+	ip net.IP,
+	depth int,
+	fn func(prefixLen int, before, after *dataMapValue),
+) {
+	if a.recordType == recordTypeAlias || b.recordType == recordTypeAlias {
+		return
+	}
+
+	aIsNode := a.recordType == recordTypeNode || a.recordType == recordTypeFixedNode
+	bIsNode := b.recordType == recordTypeNode || b.recordType == recordTypeFixedNode
+	if !aIsNode && !bIsNode {
+		before, after := recordData(a), recordData(b)
+		switch {
+		case before == nil && after == nil:
+		case before != nil && after != nil && before.key == after.key:
+		default:
+			fn(depth, before, after)
+		}
+		return
+	}
+
+	for i := 0; i < 2; i++ {
+		setBit(ip, depth, byte(i))
+		ca, cb := a, b
+		if aIsNode {
+			ca = &a.node.children[i]
+		}
+		if bIsNode {
+			cb = &b.node.children[i]
+		}
+		diffRecords(ca, cb, ip, depth+1, fn)
+	}
+	setBit(ip, depth, 0)
+}
+
+// recordData returns the value of the record if it is a data record and nil
+// otherwise.
+func recordData(r *record) *dataMapValue {
+	if r.recordType != recordTypeData {
+		return nil
+	}
+	return r.value
+}
+
+// lockTrees locks two different trees. They are locked in the order that
+// their locks were created rather than that of the arguments, so that
+// functions that lock the same trees concurrently, e.g., Diff(a, b) and
+// Diff(b, a), do not deadlock.
+func lockTrees(a, b *Tree) {
+	if a.mu != nil && b.mu != nil && a.mu.order > b.mu.order {
+		a, b = b, a
+	}
+	a.lock()
+	b.lock()
+}
+
+// unlockTrees unlocks the trees locked by lockTrees.
+func unlockTrees(a, b *Tree) {
+	a.unlock()
+	b.unlock()
+}
@@ -0,0 +1,112 @@
+package mmdbwriter
+
+import (
+	"net"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/maxmind/mmdbwriter/mmdbtype"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestDiff(t *testing.T) {
+	newTree := func(inserts []testInsert) *Tree {
+		tree, err := New(Options{})
+		require.NoError(t, err)
+		for _, insert := range inserts {
+			_, network, err := net.ParseCIDR(insert.network)
+			require.NoError(t, err)
+			require.NoError(t, tree.Insert(network, insert.value))
+		}
+		return tree
+	}
+
+	a := newTree([]testInsert{
+		{network: "1.1.0.0/23", value: mmdbtype.String("x")},
+		{network: "1.2.0.0/16", value: mmdbtype.String("removed")},
+		{network: "2003::/16", value: mmdbtype.Map{"a": mmdbtype.Uint32(1)}},
+	})
+	b := newTree([]testInsert{
+		{network: "1.1.0.0/24", value: mmdbtype.String("y")},
+		{network: "1.1.1.0/24", value: mmdbtype.String("x")},
+		{network: "2003::/16", value: mmdbtype.Map{"a": mmdbtype.Uint32(1)}},
+		{network: "2004::/16", value: mmdbtype.String("added")},
+	})
+
+	diffs, err := Diff(a, b)
+	require.NoError(t, err)
+
+	assert.Equal(
+		t,
+		[]Difference{
+			{
+				Network: mustParseNetwork(t, "1.1.0.0/24"),
+				Type:    DifferenceChanged,
+				Before:  mmdbtype.String("x"),
+				After:   mmdbtype.String("y"),
+			},
+			{
+				Network: mustParseNetwork(t, "1.2.0.0/16"),
+				Type:    DifferenceRemoved,
+				Before:  mmdbtype.String("removed"),
+			},
+			{
+				Network: mustParseNetwork(t, "2004::/16"),
+				Type:    DifferenceAdded,
+				After:   mmdbtype.String("added"),
+			},
+		},
+		diffs,
+	)
+
+	diffs, err = Diff(a, a)
+	require.NoError(t, err)
+	assert.Empty(t, diffs)
+
+	v4, err := New(Options{IPVersion: 4})
+	require.NoError(t, err)
+	_, err = Diff(a, v4)
+	assert.EqualError(t, err, "cannot diff an IPv6 tree with an IPv4 tree")
+}
+
+func TestDiffConcurrently(t *testing.T) {
+	a, err := New(Options{ThreadSafe: true})
+	require.NoError(t, err)
+	b, err := New(Options{ThreadSafe: true})
+	require.NoError(t, err)
+	require.NoError(t, a.Insert(mustParseNetwork(t, "1.1.0.0/16"), mmdbtype.Uint32(1)))
+	require.NoError(t, b.Insert(mustParseNetwork(t, "2.2.0.0/16"), mmdbtype.Uint32(2)))
+
+	// Diff(a, b) and Diff(b, a) lock the same trees, which must not
+	// deadlock. The inserts make each diff finalize the trees again.
+	var wg sync.WaitGroup
+	for _, trees := range [][2]*Tree{{a, b}, {b, a}} {
+		wg.Add(1)
+		go func(x, y *Tree) {
+			defer wg.Done()
+			for i := 0; i < 2000; i++ {
+				ip := net.IPv4(3, byte(i>>8), byte(i), 0).To4()
+				_ = x.Insert(&net.IPNet{IP: ip, Mask: net.CIDRMask(24, 32)}, mmdbtype.Uint32(i))
+				_, _ = Diff(x, y)
+			}
+		}(trees[0], trees[1])
+	}
+	done := make(chan struct{})
+	go func() {
+		wg.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(30 * time.Second):
+		require.FailNow(t, "Diff deadlocked")
+	}
+}
+
+func mustParseNetwork(t *testing.T, s string) *net.IPNet {
+	_, network, err := net.ParseCIDR(s)
+	require.NoError(t, err)
+	return network
+}
@@ -0,0 +1,178 @@
+package mmdbwriter
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"net"
+
+	"github.com/pkg/errors"
+)
+
+// maxDOTLabelLen is the maximum length of the value shown in the label of a
+// data record in ExportDOT.
+const maxDOTLabelLen = 40
+
+// ExportDOT writes the search tree, or the part of it for prefix, in the
+// Graphviz DOT language, e.g., to diagnose unexpected node counts or
+// aliasing. If prefix is nil, the whole tree is written. An IPv4 prefix
+// refers to the IPv4 subtree of an IPv6 tree. Only the nodes within
+// maxDepth levels of the first node are written, and records for the nodes
+// below them are shown as "...". If maxDepth is 0, there is no limit, which
+// is only practical for small trees.
+//
+// Each node is labeled with its network and each edge with the bit that it
+// represents. Records with data point to a box for the value, which is
+// shared by all of the records that have the value, so that the sharing in
+// the data section is visible. Aliases are shown as dashed edges to the
+// node they refer to, if it is written.
+//
+// The tree is finalized first, so the nodes are those that would be
+// written.
+//
+// This is not safe to call from multiple threads unless Options.ThreadSafe
+// is set.
+func (t *Tree) ExportDOT(w io.Writer, prefix *net.IPNet, maxDepth int) error {
+	t.lock()
+	defer t.unlock()
+
+	if t.nodeCount == 0 {
+		t.finalize()
+	}
+
+	ip := make(net.IP, t.treeDepth/8)
+	prefixLen := 0
+	if prefix != nil {
+		var err error
+		ip, prefixLen, err = t.treeNetwork(prefix)
+		if err != nil {
+			return err
+		}
+	}
+
+	e := &dotExporter{
+		tree:     t,
+		w:        bufio.NewWriter(w),
+		maxDepth: maxDepth,
+		nodes:    map[*node]int{},
+		values:   map[*dataMapValue]int{},
+	}
+	e.printf("digraph mmdb {\n")
+	e.printf("\tnode [shape=ellipse];\n")
+
+	r, depth := t.prefixRecord(ip, prefixLen)
+	switch r.recordType {
+	case recordTypeNode, recordTypeFixedNode:
+		e.writeNode(r.node, ip, depth, 0)
+	default:
+		// The prefix is within a record that is not a node, so that record
+		// is the only one to show.
+		e.printf("\troot [label=%q, shape=plaintext];\n", t.network(ip, depth).String())
+		e.writeRecord("root", r, 0)
+	}
+
+	for _, alias := range e.aliases {
+		if id, ok := e.nodes[alias.target]; ok {
+			e.printf("\t%s -> n%d [style=dashed];\n", alias.from, id)
+		}
+	}
+	e.printf("}\n")
+
+	if e.err != nil {
+		return errors.Wrap(e.err, "error writing DOT")
+	}
+	return errors.Wrap(e.w.Flush(), "error writing DOT")
+}
+
+type dotAlias struct {
+	from   string
+	target *node
+}
+
+type dotExporter struct {
+	tree     *Tree
+	w        *bufio.Writer
+	err      error
+	maxDepth int
+	// nodes and values map the nodes and values written to their IDs.
+	nodes   map[*node]int
+	values  map[*dataMapValue]int
+	leaves  int
+	aliases []dotAlias
+}
+
+func (e *dotExporter) printf(format string, args ...interface{}) {
+	if e.err != nil {
+		return
+	}
+	_, e.err = fmt.Fprintf(e.w, format, args...)
+}
+
+// writeNode writes the node, whose network is the first depth bits of ip,
+// and its records. level is the number of nodes above it that were
+// written.
+func (e *dotExporter) writeNode(n *node, ip net.IP, depth, level int) {
+	id := len(e.nodes)
+	e.nodes[n] = id
+	e.printf("\tn%d [label=%q];\n", id, e.tree.network(ip, depth).String())
+
+	for i := 0; i < 2; i++ {
+		setBit(ip, depth, byte(i))
+		r := n.children[i]
+		from := fmt.Sprintf("n%d", id)
+		if r.recordType == recordTypeNode || r.recordType == recordTypeFixedNode {
+			if e.maxDepth != 0 && level+1 >= e.maxDepth {
+				e.writeLeaf(from, i, "...", "plaintext")
+				continue
+			}
+			if r.recordType == recordTypeFixedNode {
+				e.printf("\t%s -> n%d [label=\"%d\", penwidth=2];\n", from, len(e.nodes), i)
+			} else {
+				e.printf("\t%s -> n%d [label=\"%d\"];\n", from, len(e.nodes), i)
+			}
+			e.writeNode(r.node, ip, depth+1, level+1)
+			continue
+		}
+		e.writeRecord(from, r, i)
+	}
+	setBit(ip, depth, 0)
+}
+
+// writeRecord writes a record that is not a node.
+func (e *dotExporter) writeRecord(from string, r record, bit int) {
+	switch r.recordType {
+	case recordTypeEmpty:
+		e.writeLeaf(from, bit, "", "point")
+	case recordTypeData:
+		id, ok := e.values[r.value]
+		if !ok {
+			id = len(e.values)
+			e.values[r.value] = id
+			value, err := csvValue(r.value.data)
+			if err != nil {
+				value = fmt.Sprintf("%T", r.value.data)
+			}
+			if runes := []rune(value); len(runes) > maxDOTLabelLen {
+				value = string(runes[:maxDOTLabelLen-3]) + "..."
+			}
+			e.printf("\td%d [label=%q, shape=box];\n", id, fmt.Sprintf("data %d\n%s", id, value))
+		}
+		e.printf("\t%s -> d%d [label=\"%d\"];\n", from, id, bit)
+	case recordTypeAlias:
+		leaf := e.writeLeaf(from, bit, "alias", "diamond")
+		e.aliases = append(e.aliases, dotAlias{from: leaf, target: r.node})
+	case recordTypeReserved:
+		e.writeLeaf(from, bit, "reserved", "octagon")
+	default:
+	}
+}
+
+// writeLeaf writes a node for a record without a node or value of its own
+// and returns its ID.
+func (e *dotExporter) writeLeaf(from string, bit int, label, shape string) string {
+	id := fmt.Sprintf("l%d", e.leaves)
+	e.leaves++
+	e.printf("\t%s [label=%q, shape=%s];\n", id, label, shape)
+	e.printf("\t%s -> %s [label=\"%d\"];\n", from, id, bit)
+	return id
+}
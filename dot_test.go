@@ -0,0 +1,98 @@
+package mmdbwriter
+
+import (
+	"bytes"
+	"net"
+	"regexp"
+	"strings"
+	"testing"
+
+	"github.com/maxmind/mmdbwriter/mmdbtype"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestExportDOT(t *testing.T) {
+	tree, err := New(Options{IPVersion: 4, IncludeReservedNetworks: true})
+	require.NoError(t, err)
+	require.NoError(t, tree.Insert(mustParseNetwork(t, "0.0.0.0/2"), mmdbtype.String("a")))
+	require.NoError(t, tree.Insert(mustParseNetwork(t, "128.0.0.0/2"), mmdbtype.String("a")))
+	require.NoError(t, tree.Insert(mustParseNetwork(t, "192.0.0.0/2"), mmdbtype.Map{"b": mmdbtype.String("x")}))
+
+	buf := &bytes.Buffer{}
+	require.NoError(t, tree.ExportDOT(buf, nil, 0))
+	assert.Equal(t, `digraph mmdb {
+	node [shape=ellipse];
+	n0 [label="0.0.0.0/0"];
+	n0 -> n1 [label="0"];
+	n1 [label="0.0.0.0/1"];
+	d0 [label="data 0\na", shape=box];
+	n1 -> d0 [label="0"];
+	l0 [label="", shape=point];
+	n1 -> l0 [label="1"];
+	n0 -> n2 [label="1"];
+	n2 [label="128.0.0.0/1"];
+	n2 -> d0 [label="0"];
+	d1 [label="data 1\n{\"b\":\"x\"}", shape=box];
+	n2 -> d1 [label="1"];
+}
+`, buf.String())
+
+	buf.Reset()
+	require.NoError(t, tree.ExportDOT(buf, nil, 1))
+	assert.Equal(t, `digraph mmdb {
+	node [shape=ellipse];
+	n0 [label="0.0.0.0/0"];
+	l0 [label="...", shape=plaintext];
+	n0 -> l0 [label="0"];
+	l1 [label="...", shape=plaintext];
+	n0 -> l1 [label="1"];
+}
+`, buf.String())
+
+	// The prefix is within a network with data.
+	buf.Reset()
+	require.NoError(t, tree.ExportDOT(buf, mustParseNetwork(t, "1.0.0.0/8"), 0))
+	assert.Equal(t, `digraph mmdb {
+	node [shape=ellipse];
+	root [label="0.0.0.0/2", shape=plaintext];
+	d0 [label="data 0\na", shape=box];
+	root -> d0 [label="0"];
+}
+`, buf.String())
+
+	assert.EqualError(
+		t,
+		tree.ExportDOT(buf, mustParseNetwork(t, "2001::/16"), 0),
+		"cannot use the IPv6 network 2001::/16 with an IPv4 tree",
+	)
+}
+
+func TestExportDOTAliases(t *testing.T) {
+	tree, err := New(Options{ReservedNetworks: []*net.IPNet{}})
+	require.NoError(t, err)
+	require.NoError(t, tree.Insert(mustParseNetwork(t, "1.1.1.0/24"), mmdbtype.String("a")))
+
+	buf := &bytes.Buffer{}
+	require.NoError(t, tree.ExportDOT(buf, nil, 0))
+	dot := buf.String()
+
+	// Each of the default aliases points to the root of the IPv4 subtree.
+	ipv4Root := regexp.MustCompile(`(?m)^\t(n\d+) \[label="0\.0\.0\.0/0"\];$`).FindStringSubmatch(dot)
+	require.NotNil(t, ipv4Root, dot)
+	assert.Equal(t, 3, strings.Count(dot, " -> "+ipv4Root[1]+" [style=dashed];"))
+	assert.Equal(t, 3, strings.Count(dot, `[label="alias", shape=diamond]`))
+	assert.Contains(t, dot, `n119 [label="1.1.0.0/23"];`)
+	assert.Contains(t, dot, `n119 -> d0 [label="1"];`)
+
+	// An IPv4 prefix refers to the IPv4 subtree.
+	buf.Reset()
+	require.NoError(t, tree.ExportDOT(buf, mustParseNetwork(t, "1.1.1.0/24"), 0))
+	assert.Equal(t, `digraph mmdb {
+	node [shape=ellipse];
+	root [label="1.1.1.0/24", shape=plaintext];
+	d0 [label="data 0\na", shape=box];
+	root -> d0 [label="0"];
+}
+`, buf.String())
+}
@@ -0,0 +1,40 @@
+package mmdbwriter
+
+import (
+	"github.com/pkg/errors"
+)
+
+var (
+	// ErrReservedNetwork is wrapped by the error returned when a network
+	// within a reserved network is inserted. See
+	// Options.IncludeReservedNetworks.
+	ErrReservedNetwork = errors.New("reserved network")
+
+	// ErrAliasedNetwork is wrapped by the error returned when a network
+	// within an aliased network is inserted. See Options.Aliases.
+	ErrAliasedNetwork = errors.New("aliased network")
+
+	// ErrRecordSizeOverflow matches a *RecordSizeError with errors.Is, i.e.,
+	// when a tree is too large for its record size.
+	ErrRecordSizeOverflow = errors.New("the record size cannot address the database")
+
+	// ErrNodeLimitExceeded is wrapped by the error returned when an insert
+	// would exceed Options.MaxNodes.
+	ErrNodeLimitExceeded = errors.New("node limit exceeded")
+)
+
+// sentinelError is an error with its own message that wraps one of the
+// sentinel errors, so that errors.Is matches the sentinel error without
+// its message being added to the error's.
+type sentinelError struct {
+	msg      string
+	sentinel error
+}
+
+func (e *sentinelError) Error() string {
+	return e.msg
+}
+
+func (e *sentinelError) Unwrap() error {
+	return e.sentinel
+}
@@ -0,0 +1,32 @@
+package mmdbwriter
+
+import (
+	"testing"
+
+	"github.com/maxmind/mmdbwriter/mmdbtype"
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSentinelErrors(t *testing.T) {
+	tree, err := New(Options{})
+	require.NoError(t, err)
+
+	err = tree.Insert(mustParseNetwork(t, "10.0.0.0/8"), mmdbtype.String("a"))
+	assert.EqualError(t, err, "attempt to insert 10.0.0.0/8 (::a00:0/104 in the tree), which is in a reserved network")
+	assert.True(t, errors.Is(err, ErrReservedNetwork))
+	assert.False(t, errors.Is(err, ErrAliasedNetwork))
+
+	err = tree.Insert(mustParseNetwork(t, "2002:100::/24"), mmdbtype.String("a"))
+	assert.EqualError(t, err, "attempt to insert 2002:100::/24, which is in an aliased network")
+	assert.True(t, errors.Is(err, ErrAliasedNetwork))
+	assert.False(t, errors.Is(err, ErrReservedNetwork))
+
+	tree, err = New(Options{RecordSize: 24})
+	require.NoError(t, err)
+	tree.nodeCount = 1 << 24
+	_, err = tree.resolveRecordSize(1)
+	assert.True(t, errors.Is(err, ErrRecordSizeOverflow))
+	assert.False(t, errors.Is(errors.New("other"), ErrRecordSizeOverflow))
+}
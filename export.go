@@ -0,0 +1,140 @@
+package mmdbwriter
+
+import (
+	"encoding/csv"
+	"encoding/hex"
+	"encoding/json"
+	"io"
+	"math/big"
+	"net"
+	"strconv"
+	"strings"
+
+	"github.com/maxmind/mmdbwriter/mmdbtype"
+	"github.com/pkg/errors"
+)
+
+// ExportCSV writes a CSV row for every network in the tree that has data,
+// in the same order as Walk. The first column is the network and the
+// remaining columns are the values of the fields in fieldOrder. The first
+// row is a header with "network" followed by the field names.
+//
+// Fields are paths into the record with the keys separated by a ".", e.g.,
+// "country.iso_code". A path element may also be an index into a Slice,
+// e.g., "subdivisions.0.iso_code". The column is empty if the record does
+// not have the field. Maps and Slices are written as JSON and Bytes are
+// written as hex.
+//
+// This is not safe to call from multiple threads unless Options.ThreadSafe
+// is set.
+func (t *Tree) ExportCSV(w io.Writer, fieldOrder []string) error {
+	cw := csv.NewWriter(w)
+
+	header := append([]string{"network"}, fieldOrder...)
+	if err := cw.Write(header); err != nil {
+		return errors.Wrap(err, "error writing CSV header")
+	}
+
+	paths := make([][]string, len(fieldOrder))
+	for i, field := range fieldOrder {
+		paths[i] = strings.Split(field, ".")
+	}
+
+	row := make([]string, len(header))
+	err := t.Walk(func(network *net.IPNet, value mmdbtype.DataType) error {
+		row[0] = network.String()
+		for i, path := range paths {
+			s, err := csvValue(lookupPath(value, path))
+			if err != nil {
+				return errors.WithMessagef(err, "error formatting %s for %s", fieldOrder[i], network)
+			}
+			row[i+1] = s
+		}
+		return errors.Wrap(cw.Write(row), "error writing CSV row")
+	})
+	if err != nil {
+		return err
+	}
+
+	cw.Flush()
+	return errors.Wrap(cw.Error(), "error writing CSV")
+}
+
+type jsonlRecord struct {
+	Network string      `json:"network"`
+	Data    interface{} `json:"data"`
+}
+
+// ExportJSONL writes a JSON object for every network in the tree that has
+// data, one per line and in the same order as Walk. Each object has the
+// form {"network": "1.1.1.0/24", "data": {...}}. The data is encoded using
+// the Go types that mmdbtype.Unmarshal uses for an interface{}, e.g., Bytes
+// are encoded as base64 strings and Uint128 values as JSON numbers.
+//
+// This is not safe to call from multiple threads unless Options.ThreadSafe
+// is set.
+func (t *Tree) ExportJSONL(w io.Writer) error {
+	enc := json.NewEncoder(w)
+	return t.Walk(func(network *net.IPNet, value mmdbtype.DataType) error {
+		record := jsonlRecord{Network: network.String()}
+		if err := mmdbtype.Unmarshal(value, &record.Data); err != nil {
+			return errors.WithMessagef(err, "error converting the data for %s", network)
+		}
+		// Encode adds the newline.
+		return errors.Wrapf(enc.Encode(record), "error writing JSON for %s", network)
+	})
+}
+
+// lookupPath returns the value at the path in the DataType or nil if there
+// is no such value.
+func lookupPath(value mmdbtype.DataType, path []string) mmdbtype.DataType {
+	for _, key := range path {
+		switch v := value.(type) {
+		case mmdbtype.Map:
+			value = v[mmdbtype.String(key)]
+		case mmdbtype.Slice:
+			i, err := strconv.Atoi(key)
+			if err != nil || i < 0 || i >= len(v) {
+				return nil
+			}
+			value = v[i]
+		default:
+			return nil
+		}
+	}
+	return value
+}
+
+func csvValue(value mmdbtype.DataType) (string, error) {
+	switch v := value.(type) {
+	case nil:
+		return "", nil
+	case mmdbtype.Bool:
+		return strconv.FormatBool(bool(v)), nil
+	case mmdbtype.Bytes:
+		return hex.EncodeToString(v), nil
+	case mmdbtype.Float32:
+		return strconv.FormatFloat(float64(v), 'f', -1, 32), nil
+	case mmdbtype.Float64:
+		return strconv.FormatFloat(float64(v), 'f', -1, 64), nil
+	case mmdbtype.Int32:
+		return strconv.FormatInt(int64(v), 10), nil
+	case mmdbtype.String:
+		return string(v), nil
+	case mmdbtype.Uint16:
+		return strconv.FormatUint(uint64(v), 10), nil
+	case mmdbtype.Uint32:
+		return strconv.FormatUint(uint64(v), 10), nil
+	case mmdbtype.Uint64:
+		return strconv.FormatUint(uint64(v), 10), nil
+	case *mmdbtype.Uint128:
+		return (*big.Int)(v).String(), nil
+	default:
+		var i interface{}
+		if err := mmdbtype.Unmarshal(value, &i); err != nil {
+			return "", err
+		}
+		b, err := json.Marshal(i)
+		return string(b), errors.Wrap(err, "error encoding value as JSON")
+	}
+}
@@ -0,0 +1,80 @@
+package mmdbwriter
+
+import (
+	"bytes"
+	"net"
+	"testing"
+
+	"github.com/maxmind/mmdbwriter/mmdbtype"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func newExportTestTree(t *testing.T) *Tree {
+	tree, err := New(Options{})
+	require.NoError(t, err)
+
+	inserts := []testInsert{
+		{
+			network: "1.1.1.0/24",
+			value: mmdbtype.Map{
+				"country": mmdbtype.Map{"iso_code": mmdbtype.String("AU")},
+				"subdivisions": mmdbtype.Slice{
+					mmdbtype.Map{"iso_code": mmdbtype.String("NSW")},
+				},
+				"location": mmdbtype.Map{
+					"latitude":  mmdbtype.Float64(-33.494),
+					"longitude": mmdbtype.Float64(143.2104),
+				},
+				"is_anycast": mmdbtype.Bool(true),
+			},
+		},
+		{
+			network: "2003::/16",
+			value: mmdbtype.Map{
+				"country": mmdbtype.Map{"iso_code": mmdbtype.String("DE")},
+				"hash":    mmdbtype.Bytes{0xde, 0xad},
+			},
+		},
+	}
+	for _, insert := range inserts {
+		_, network, err := net.ParseCIDR(insert.network)
+		require.NoError(t, err)
+		require.NoError(t, tree.Insert(network, insert.value))
+	}
+	return tree
+}
+
+func TestExportCSV(t *testing.T) {
+	tree := newExportTestTree(t)
+
+	buf := &bytes.Buffer{}
+	err := tree.ExportCSV(
+		buf,
+		[]string{"country.iso_code", "subdivisions.0.iso_code", "location", "is_anycast", "hash", "missing.key"},
+	)
+	require.NoError(t, err)
+
+	assert.Equal(
+		t,
+		"network,country.iso_code,subdivisions.0.iso_code,location,is_anycast,hash,missing.key\n"+
+			`1.1.1.0/24,AU,NSW,"{""latitude"":-33.494,""longitude"":143.2104}",true,,`+"\n"+
+			"2003::/16,DE,,,,dead,\n",
+		buf.String(),
+	)
+}
+
+func TestExportJSONL(t *testing.T) {
+	tree := newExportTestTree(t)
+
+	buf := &bytes.Buffer{}
+	require.NoError(t, tree.ExportJSONL(buf))
+
+	assert.Equal(
+		t,
+		`{"network":"1.1.1.0/24","data":{"country":{"iso_code":"AU"},"is_anycast":true,`+
+			`"location":{"latitude":-33.494,"longitude":143.2104},"subdivisions":[{"iso_code":"NSW"}]}}`+"\n"+
+			`{"network":"2003::/16","data":{"country":{"iso_code":"DE"},"hash":"3q0="}}`+"\n",
+		buf.String(),
+	)
+}
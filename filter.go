@@ -0,0 +1,56 @@
+package mmdbwriter
+
+import (
+	"io"
+	"net"
+
+	"github.com/maxmind/mmdbwriter/mmdbtype"
+)
+
+// WriteFilteredTo writes the tree to the provided Writer as WriteTo does, but
+// only with the networks for which keep returns true, e.g., so that one tree
+// may be used to write databases for different audiences. keep is called
+// for each network with data, as with Walk, and must not modify the value
+// passed to it.
+//
+// The tree itself is not changed. The search tree is copied and filtered
+// before it is written, but the values are shared with the copy.
+//
+// This is not safe to call from multiple threads unless Options.ThreadSafe
+// is set.
+func (t *Tree) WriteFilteredTo(
+	w io.Writer,
+	keep func(network *net.IPNet, value mmdbtype.DataType) bool,
+) (int64, error) {
+	filtered := t.filteredCopy(keep)
+	return filtered.WriteTo(w)
+}
+
+// filteredCopy returns a copy of the tree with only the networks for which
+// keep returns true.
+func (t *Tree) filteredCopy(keep func(network *net.IPNet, value mmdbtype.DataType) bool) *Tree {
+	t.lock()
+	// Finalizing first means that there are fewer nodes to copy and that
+	// keep is called for the merged networks.
+	if t.nodeCount == 0 {
+		t.finalize()
+	}
+	filtered := t.clone()
+	t.unlock()
+	filtered.mu = nil
+
+	ip := make(net.IP, filtered.treeDepth/8)
+	// Removing a value never fails.
+	_ = filtered.root.walk(ip, 0, func(ip net.IP, prefixLen int, r *record) error {
+		if keep(filtered.network(ip, prefixLen), r.value.data) {
+			return nil
+		}
+		return filtered.setRecordValue(r, nil)
+	})
+
+	// Removing networks may allow others to be merged anywhere in the
+	// tree.
+	filtered.nodeCount = 0
+	filtered.root.invalidate()
+	return filtered
+}
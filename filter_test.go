@@ -0,0 +1,55 @@
+package mmdbwriter
+
+import (
+	"bytes"
+	"net"
+	"testing"
+
+	"github.com/maxmind/mmdbwriter/mmdbtype"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestWriteFilteredTo(t *testing.T) {
+	opts := Options{BuildEpoch: 1, DatabaseType: "mmdbwriter-test"}
+	tree, err := New(opts)
+	require.NoError(t, err)
+	expected, err := New(opts)
+	require.NoError(t, err)
+
+	free := mmdbtype.Map{"plan": mmdbtype.String("free")}
+	paid := mmdbtype.Map{"plan": mmdbtype.String("paid")}
+	for network, value := range map[string]mmdbtype.Map{
+		"1.1.0.0/24": free,
+		"1.1.1.0/24": paid,
+		"2.2.0.0/16": free,
+		"2003::/16":  paid,
+	} {
+		n := mustParseNetwork(t, network)
+		require.NoError(t, tree.Insert(n, value))
+		if value.Equal(free) {
+			require.NoError(t, expected.Insert(n, value))
+		}
+	}
+
+	write := func(tree *Tree) []byte {
+		buf := &bytes.Buffer{}
+		_, err := tree.WriteTo(buf)
+		require.NoError(t, err)
+		return buf.Bytes()
+	}
+	before := write(tree)
+
+	var networks []string
+	buf := &bytes.Buffer{}
+	n, err := tree.WriteFilteredTo(buf, func(network *net.IPNet, value mmdbtype.DataType) bool {
+		networks = append(networks, network.String())
+		return value.Equal(free)
+	})
+	require.NoError(t, err)
+	assert.Equal(t, int64(buf.Len()), n)
+	assert.Equal(t, write(expected), buf.Bytes())
+	assert.Equal(t, []string{"1.1.0.0/24", "1.1.1.0/24", "2.2.0.0/16", "2003::/16"}, networks)
+
+	assert.Equal(t, before, write(tree), "the tree is not changed")
+}
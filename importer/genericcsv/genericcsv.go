@@ -0,0 +1,306 @@
+// Package genericcsv inserts the rows of an arbitrary CSV file into a
+// mmdbwriter.Tree. The caller maps the CSV columns to fields in the record
+// and chooses the MaxMind DB type for each field.
+package genericcsv
+
+import (
+	"encoding/csv"
+	"io"
+	"net"
+	"strconv"
+	"strings"
+
+	"github.com/maxmind/mmdbwriter"
+	"github.com/maxmind/mmdbwriter/inserter"
+	"github.com/maxmind/mmdbwriter/mmdbtype"
+	"github.com/pkg/errors"
+)
+
+// Type converts the value of a CSV column to a mmdbtype.DataType.
+type Type func(value string) (mmdbtype.DataType, error)
+
+// The types for the scalar MaxMind DB data types. The integer and float
+// types use strconv to parse the value. Bool accepts the values accepted by
+// strconv.ParseBool, e.g., "1", "0", "true", and "false".
+var (
+	Bool Type = func(v string) (mmdbtype.DataType, error) {
+		b, err := strconv.ParseBool(v)
+		return mmdbtype.Bool(b), err
+	}
+	Float32 Type = func(v string) (mmdbtype.DataType, error) {
+		f, err := strconv.ParseFloat(v, 32)
+		return mmdbtype.Float32(f), err
+	}
+	Float64 Type = func(v string) (mmdbtype.DataType, error) {
+		f, err := strconv.ParseFloat(v, 64)
+		return mmdbtype.Float64(f), err
+	}
+	Int32 Type = func(v string) (mmdbtype.DataType, error) {
+		i, err := strconv.ParseInt(v, 10, 32)
+		return mmdbtype.Int32(i), err
+	}
+	String Type = func(v string) (mmdbtype.DataType, error) {
+		return mmdbtype.String(v), nil
+	}
+	Uint16 Type = func(v string) (mmdbtype.DataType, error) {
+		i, err := strconv.ParseUint(v, 10, 16)
+		return mmdbtype.Uint16(i), err
+	}
+	Uint32 Type = func(v string) (mmdbtype.DataType, error) {
+		i, err := strconv.ParseUint(v, 10, 32)
+		return mmdbtype.Uint32(i), err
+	}
+	Uint64 Type = func(v string) (mmdbtype.DataType, error) {
+		i, err := strconv.ParseUint(v, 10, 64)
+		return mmdbtype.Uint64(i), err
+	}
+)
+
+// Column maps a CSV column to a field in the record.
+type Column struct {
+	// Name is the name of the column in the header. If it is empty, Index is
+	// used instead.
+	Name string
+
+	// Index is the zero-based index of the column. It is only used if Name
+	// is empty.
+	Index int
+
+	// Field is the key of the field in the record. Nested fields are
+	// separated by a ".", e.g., "location.latitude" is the "latitude" key of
+	// the "location" map. This is ignored for Options.Network.
+	Field string
+
+	// Type converts the value to a DataType. If it is nil, String is used.
+	// This is ignored for Options.Network.
+	Type Type
+}
+
+// Header determines how the first row of the CSV is treated.
+type Header int
+
+const (
+	// HeaderDetect treats the first row as a header if the network column
+	// of the row does not contain a valid network. This is the default.
+	HeaderDetect Header = iota
+	// HeaderPresent treats the first row as a header.
+	HeaderPresent
+	// HeaderAbsent treats the first row as data.
+	HeaderAbsent
+)
+
+// Options configures Insert.
+type Options struct {
+	// Network is the column containing the network in CIDR notation. A
+	// single IP address is treated as a network containing only that
+	// address.
+	Network Column
+
+	// Fields are the columns to add to each record. Empty values are not
+	// added.
+	Fields []Column
+
+	// Header determines whether the CSV has a header row.
+	Header Header
+
+	// Comma is the field delimiter. It defaults to ','.
+	Comma rune
+
+	// Inserter is used to generate the inserter function for each record.
+	// If it is nil, inserter.ReplaceWith is used.
+	Inserter inserter.FuncGenerator
+
+	// OnError is called with the line number and error when a row cannot
+	// be parsed or inserted. If it returns nil, the row is skipped and the
+	// import continues. If it returns an error, the import stops and that
+	// error is returned. If OnError is nil, the import stops at the first
+	// error.
+	OnError func(line int, err error) error
+}
+
+type column struct {
+	index int
+	path  []mmdbtype.String
+	typ   Type
+}
+
+// Insert inserts a record for each row of the CSV into the tree.
+func Insert(tree *mmdbwriter.Tree, r io.Reader, opts Options) error {
+	cr := csv.NewReader(r)
+	cr.FieldsPerRecord = -1
+	if opts.Comma != 0 {
+		cr.Comma = opts.Comma
+	}
+
+	strategy := opts.Inserter
+	if strategy == nil {
+		strategy = inserter.ReplaceWith
+	}
+
+	onError := opts.OnError
+	if onError == nil {
+		onError = func(line int, err error) error {
+			return errors.WithMessagef(err, "error on line %d", line)
+		}
+	}
+
+	first, err := cr.Read()
+	if errors.Is(err, io.EOF) {
+		return nil
+	}
+	if err != nil {
+		return errors.Wrap(err, "error reading CSV")
+	}
+
+	var header []string
+	switch opts.Header {
+	case HeaderPresent:
+		header = first
+	case HeaderAbsent:
+	case HeaderDetect:
+		// If the network column is referenced by name, the CSV must have a
+		// header.
+		if opts.Network.Name != "" || !hasNetwork(first, opts.Network.Index) {
+			header = first
+		}
+	default:
+		return errors.Errorf("unknown Header value: %d", opts.Header)
+	}
+
+	networkIndex, err := columnIndex(opts.Network, header)
+	if err != nil {
+		return err
+	}
+
+	columns := make([]column, len(opts.Fields))
+	for i, c := range opts.Fields {
+		index, err := columnIndex(c, header)
+		if err != nil {
+			return err
+		}
+		if c.Field == "" {
+			return errors.Errorf("no field set for column %d", index)
+		}
+		typ := c.Type
+		if typ == nil {
+			typ = String
+		}
+		var path []mmdbtype.String
+		for _, key := range strings.Split(c.Field, ".") {
+			path = append(path, mmdbtype.String(key))
+		}
+		columns[i] = column{index: index, path: path, typ: typ}
+	}
+
+	line := 1
+	row := first
+	if header != nil {
+		line++
+		row, err = cr.Read()
+	}
+	for ; ; line++ {
+		if errors.Is(err, io.EOF) {
+			return nil
+		}
+		if err != nil {
+			return errors.Wrap(err, "error reading CSV")
+		}
+
+		if err := insertRow(tree, row, networkIndex, columns, strategy); err != nil {
+			if err = onError(line, err); err != nil {
+				return err
+			}
+		}
+
+		row, err = cr.Read()
+	}
+}
+
+func insertRow(
+	tree *mmdbwriter.Tree,
+	row []string,
+	networkIndex int,
+	columns []column,
+	strategy inserter.FuncGenerator,
+) error {
+	if networkIndex >= len(row) {
+		return errors.Errorf("row has %d columns; the network column is %d", len(row), networkIndex)
+	}
+	network, err := parseNetwork(row[networkIndex])
+	if err != nil {
+		return err
+	}
+
+	record := mmdbtype.Map{}
+	for _, c := range columns {
+		if c.index >= len(row) || row[c.index] == "" {
+			continue
+		}
+		value, err := c.typ(row[c.index])
+		if err != nil {
+			return errors.Wrapf(err, "error parsing column %d", c.index)
+		}
+		if err := set(record, c.path, value); err != nil {
+			return err
+		}
+	}
+
+	return tree.InsertFunc(network, strategy(record))
+}
+
+func set(m mmdbtype.Map, path []mmdbtype.String, value mmdbtype.DataType) error {
+	for _, key := range path[:len(path)-1] {
+		v, ok := m[key]
+		if !ok {
+			nm := mmdbtype.Map{}
+			m[key] = nm
+			m = nm
+			continue
+		}
+		nm, ok := v.(mmdbtype.Map)
+		if !ok {
+			return errors.Errorf("cannot set a field inside %s as it is not a map", key)
+		}
+		m = nm
+	}
+	m[path[len(path)-1]] = value
+	return nil
+}
+
+func columnIndex(c Column, header []string) (int, error) {
+	if c.Name == "" {
+		return c.Index, nil
+	}
+	if header == nil {
+		return 0, errors.Errorf("column %s is referenced by name but the CSV does not have a header", c.Name)
+	}
+	for i, name := range header {
+		if name == c.Name {
+			return i, nil
+		}
+	}
+	return 0, errors.Errorf("CSV header is missing the %s column", c.Name)
+}
+
+func hasNetwork(row []string, index int) bool {
+	if index >= len(row) {
+		return false
+	}
+	_, err := parseNetwork(row[index])
+	return err == nil
+}
+
+func parseNetwork(s string) (*net.IPNet, error) {
+	if _, network, err := net.ParseCIDR(s); err == nil {
+		return network, nil
+	}
+	ip := net.ParseIP(s)
+	if ip == nil {
+		return nil, errors.Errorf("invalid network: %q", s)
+	}
+	if ipv4 := ip.To4(); ipv4 != nil {
+		ip = ipv4
+	}
+	bits := len(ip) * 8
+	return &net.IPNet{IP: ip, Mask: net.CIDRMask(bits, bits)}, nil
+}
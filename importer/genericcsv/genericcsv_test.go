@@ -0,0 +1,120 @@
+package genericcsv
+
+import (
+	"net"
+	"strings"
+	"testing"
+
+	"github.com/maxmind/mmdbwriter"
+	"github.com/maxmind/mmdbwriter/mmdbtype"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestInsert(t *testing.T) {
+	tests := []struct {
+		name     string
+		csv      string
+		opts     Options
+		expected map[string]mmdbtype.DataType
+	}{
+		{
+			name: "header by name",
+			csv: "asn,org,net,lat\n" +
+				"13335,Cloudflare,1.1.1.0/24,37.7\n" +
+				"15169,,8.8.8.8,\n",
+			opts: Options{
+				Network: Column{Name: "net"},
+				Fields: []Column{
+					{Name: "asn", Field: "autonomous_system_number", Type: Uint32},
+					{Name: "org", Field: "autonomous_system_organization"},
+					{Name: "lat", Field: "location.latitude", Type: Float64},
+				},
+			},
+			expected: map[string]mmdbtype.DataType{
+				"1.1.1.1": mmdbtype.Map{
+					"autonomous_system_number":       mmdbtype.Uint32(13335),
+					"autonomous_system_organization": mmdbtype.String("Cloudflare"),
+					"location":                       mmdbtype.Map{"latitude": mmdbtype.Float64(37.7)},
+				},
+				"8.8.8.8": mmdbtype.Map{"autonomous_system_number": mmdbtype.Uint32(15169)},
+				"8.8.8.9": nil,
+			},
+		},
+		{
+			name: "detected header by index",
+			csv: "network;anycast\n" +
+				"1.1.1.0/24;true\n",
+			opts: Options{
+				Comma:  ';',
+				Fields: []Column{{Index: 1, Field: "is_anycast", Type: Bool}},
+			},
+			expected: map[string]mmdbtype.DataType{
+				"1.1.1.1": mmdbtype.Map{"is_anycast": mmdbtype.Bool(true)},
+			},
+		},
+		{
+			name: "no header",
+			csv:  "2001:db8::/32,-1\n",
+			opts: Options{
+				Fields: []Column{{Index: 1, Field: "offset", Type: Int32}},
+			},
+			expected: map[string]mmdbtype.DataType{
+				"2001:db8::1": mmdbtype.Map{"offset": mmdbtype.Int32(-1)},
+			},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			tree, err := mmdbwriter.New(mmdbwriter.Options{IncludeReservedNetworks: true})
+			require.NoError(t, err)
+
+			require.NoError(t, Insert(tree, strings.NewReader(test.csv), test.opts))
+
+			for ip, expected := range test.expected {
+				_, value := tree.Get(net.ParseIP(ip))
+				assert.Equal(t, expected, value, ip)
+			}
+		})
+	}
+}
+
+func TestInsertErrors(t *testing.T) {
+	csv := "network,asn\n" +
+		"1.0.0.0/24,1\n" +
+		"not-a-network,2\n" +
+		"1.0.1.0/24,invalid\n" +
+		"1.0.2.0/24,3\n"
+	opts := Options{
+		Network: Column{Name: "network"},
+		Fields:  []Column{{Name: "asn", Field: "asn", Type: Uint32}},
+	}
+
+	tree, err := mmdbwriter.New(mmdbwriter.Options{})
+	require.NoError(t, err)
+
+	err = Insert(tree, strings.NewReader(csv), opts)
+	assert.EqualError(t, err, `error on line 3: invalid network: "not-a-network"`)
+
+	var lines []int
+	opts.OnError = func(line int, err error) error {
+		lines = append(lines, line)
+		return nil
+	}
+	require.NoError(t, Insert(tree, strings.NewReader(csv), opts))
+	assert.Equal(t, []int{3, 4}, lines)
+
+	_, value := tree.Get(net.ParseIP("1.0.2.1"))
+	assert.Equal(t, mmdbtype.Map{"asn": mmdbtype.Uint32(3)}, value)
+
+	err = Insert(tree, strings.NewReader(csv), Options{Network: Column{Name: "missing"}})
+	assert.EqualError(t, err, "CSV header is missing the missing column")
+
+	err = Insert(
+		tree,
+		strings.NewReader(csv),
+		Options{Network: Column{Name: "network"}, Header: HeaderAbsent},
+	)
+	assert.EqualError(t, err, "column network is referenced by name but the CSV does not have a header")
+}
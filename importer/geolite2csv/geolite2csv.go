@@ -0,0 +1,485 @@
+// Package geolite2csv inserts the data from the GeoLite2 City, Country, and
+// ASN CSV files into a mmdbwriter.Tree using the same record structure as
+// the GeoLite2 MaxMind DB files.
+//
+// The City and Country databases consist of blocks files, e.g.,
+// GeoLite2-City-Blocks-IPv4.csv, and locations files, one per locale, e.g.,
+// GeoLite2-City-Locations-en.csv. Read all of the locations files with
+// Locations.Read before inserting the blocks:
+//
+//	locations := geolite2csv.NewLocations()
+//	for _, file := range locationFiles {
+//		// open file...
+//		if err := locations.Read(f); err != nil {
+//			// handle error
+//		}
+//	}
+//	for _, file := range blockFiles {
+//		// open file...
+//		if err := geolite2csv.InsertCityBlocks(tree, f, locations); err != nil {
+//			// handle error
+//		}
+//	}
+package geolite2csv
+
+import (
+	"encoding/csv"
+	"io"
+	"net"
+	"strconv"
+
+	"github.com/maxmind/mmdbwriter"
+	"github.com/maxmind/mmdbwriter/mmdbtype"
+	"github.com/pkg/errors"
+)
+
+// continentGeoNameIDs are the GeoNames IDs of the continents. The locations
+// files only include the continent codes.
+var continentGeoNameIDs = map[string]uint32{
+	"AF": 6255146,
+	"AN": 6255152,
+	"AS": 6255147,
+	"EU": 6255148,
+	"NA": 6255149,
+	"OC": 6255151,
+	"SA": 6255150,
+}
+
+type place struct {
+	isoCode string
+	names   map[string]string
+}
+
+type location struct {
+	continent    place
+	country      place
+	subdivisions [2]place
+	cityNames    map[string]string
+	metroCode    string
+	timeZone     string
+	isInEU       bool
+}
+
+// Locations holds the contents of the GeoLite2 City or Country locations
+// files.
+type Locations struct {
+	locations map[uint32]*location
+	// countryIDs maps country ISO codes to their GeoNames IDs. It is built
+	// from the locations for the countries themselves when it is first
+	// needed.
+	countryIDs map[string]uint32
+}
+
+// NewLocations returns an empty Locations.
+func NewLocations() *Locations {
+	return &Locations{
+		locations: map[uint32]*location{},
+	}
+}
+
+var cityLocationColumns = []string{
+	"geoname_id",
+	"locale_code",
+	"continent_code",
+	"continent_name",
+	"country_iso_code",
+	"country_name",
+	"is_in_european_union",
+}
+
+// Read reads a City or Country locations file. Each locations file
+// contains the names for a single locale; reading the files for multiple
+// locales adds the names for each locale to the same locations.
+func (l *Locations) Read(r io.Reader) error {
+	l.countryIDs = nil
+	return readCSV(r, cityLocationColumns, func(row *row) error {
+		id, err := row.uint32("geoname_id")
+		if err != nil {
+			return err
+		}
+		locale := row.get("locale_code")
+
+		loc, ok := l.locations[id]
+		if !ok {
+			loc = &location{}
+			l.locations[id] = loc
+		}
+
+		loc.continent.set(row.get("continent_code"), locale, row.get("continent_name"))
+		loc.country.set(row.get("country_iso_code"), locale, row.get("country_name"))
+		loc.isInEU = row.get("is_in_european_union") == "1"
+
+		// The remaining columns are only in the City locations files.
+		loc.subdivisions[0].set(row.get("subdivision_1_iso_code"), locale, row.get("subdivision_1_name"))
+		loc.subdivisions[1].set(row.get("subdivision_2_iso_code"), locale, row.get("subdivision_2_name"))
+		setName(&loc.cityNames, locale, row.get("city_name"))
+		if v := row.get("metro_code"); v != "" {
+			loc.metroCode = v
+		}
+		if v := row.get("time_zone"); v != "" {
+			loc.timeZone = v
+		}
+		return nil
+	})
+}
+
+func (p *place) set(isoCode, locale, name string) {
+	if isoCode != "" {
+		p.isoCode = isoCode
+	}
+	setName(&p.names, locale, name)
+}
+
+func setName(names *map[string]string, locale, name string) {
+	if name == "" || locale == "" {
+		return
+	}
+	if *names == nil {
+		*names = map[string]string{}
+	}
+	(*names)[locale] = name
+}
+
+// countryID returns the GeoNames ID of the country with the ISO code or 0 if
+// there is no location for the country itself.
+func (l *Locations) countryID(isoCode string) uint32 {
+	if l.countryIDs == nil {
+		l.countryIDs = map[string]uint32{}
+		for id, loc := range l.locations {
+			if loc.country.isoCode == "" || loc.cityNames != nil ||
+				loc.subdivisions[0].isoCode != "" || loc.subdivisions[0].names != nil {
+				continue
+			}
+			// There should only be one location per country, but we use the
+			// lowest ID to be deterministic if there isn't.
+			if prev, ok := l.countryIDs[loc.country.isoCode]; !ok || id < prev {
+				l.countryIDs[loc.country.isoCode] = id
+			}
+		}
+	}
+	return l.countryIDs[isoCode]
+}
+
+func (l *Locations) location(id uint32) (*location, error) {
+	loc, ok := l.locations[id]
+	if !ok {
+		return nil, errors.Errorf("unknown geoname_id: %d", id)
+	}
+	return loc, nil
+}
+
+var countryBlockColumns = []string{
+	"network",
+	"geoname_id",
+	"registered_country_geoname_id",
+	"represented_country_geoname_id",
+	"is_anonymous_proxy",
+	"is_satellite_provider",
+}
+
+// InsertCountryBlocks inserts the networks from a GeoLite2 Country blocks
+// file, either IPv4 or IPv6, into the tree. The locations must have been
+// read from the Country locations files.
+func InsertCountryBlocks(tree *mmdbwriter.Tree, r io.Reader, locations *Locations) error {
+	return readCSV(r, countryBlockColumns, func(row *row) error {
+		network, err := row.network()
+		if err != nil {
+			return err
+		}
+
+		record := mmdbtype.Map{}
+		if err := locations.addCountryFields(record, row); err != nil {
+			return err
+		}
+
+		return tree.Insert(network, record)
+	})
+}
+
+var cityBlockColumns = append(
+	append([]string{}, countryBlockColumns...),
+	"postal_code",
+	"latitude",
+	"longitude",
+	"accuracy_radius",
+)
+
+// InsertCityBlocks inserts the networks from a GeoLite2 City blocks file,
+// either IPv4 or IPv6, into the tree. The locations must have been read from
+// the City locations files.
+func InsertCityBlocks(tree *mmdbwriter.Tree, r io.Reader, locations *Locations) error {
+	return readCSV(r, cityBlockColumns, func(row *row) error {
+		network, err := row.network()
+		if err != nil {
+			return err
+		}
+
+		record := mmdbtype.Map{}
+		if err := locations.addCountryFields(record, row); err != nil {
+			return err
+		}
+
+		if code := row.get("postal_code"); code != "" {
+			record["postal"] = mmdbtype.Map{"code": mmdbtype.String(code)}
+		}
+
+		loc := mmdbtype.Map{}
+		if row.get("latitude") != "" && row.get("longitude") != "" {
+			for _, key := range []string{"latitude", "longitude"} {
+				f, err := strconv.ParseFloat(row.get(key), 64)
+				if err != nil {
+					return errors.Wrapf(err, "error parsing %s", key)
+				}
+				loc[mmdbtype.String(key)] = mmdbtype.Float64(f)
+			}
+		}
+		if v := row.get("accuracy_radius"); v != "" {
+			radius, err := strconv.ParseUint(v, 10, 16)
+			if err != nil {
+				return errors.Wrap(err, "error parsing accuracy_radius")
+			}
+			loc["accuracy_radius"] = mmdbtype.Uint16(radius)
+		}
+
+		if row.get("geoname_id") != "" {
+			id, err := row.uint32("geoname_id")
+			if err != nil {
+				return err
+			}
+			l, err := locations.location(id)
+			if err != nil {
+				return err
+			}
+
+			if l.cityNames != nil {
+				record["city"] = mmdbtype.Map{
+					"geoname_id": mmdbtype.Uint32(id),
+					"names":      names(l.cityNames),
+				}
+			}
+
+			subdivisions := mmdbtype.Slice{}
+			for _, s := range l.subdivisions {
+				if s.isoCode == "" && s.names == nil {
+					continue
+				}
+				subdivision := mmdbtype.Map{}
+				addPlace(subdivision, s)
+				subdivisions = append(subdivisions, subdivision)
+			}
+			if len(subdivisions) > 0 {
+				record["subdivisions"] = subdivisions
+			}
+
+			if l.metroCode != "" {
+				metroCode, err := strconv.ParseUint(l.metroCode, 10, 16)
+				if err != nil {
+					return errors.Wrapf(err, "error parsing metro_code for geoname_id %d", id)
+				}
+				loc["metro_code"] = mmdbtype.Uint16(metroCode)
+			}
+			if l.timeZone != "" {
+				loc["time_zone"] = mmdbtype.String(l.timeZone)
+			}
+		}
+
+		if len(loc) > 0 {
+			record["location"] = loc
+		}
+
+		return tree.Insert(network, record)
+	})
+}
+
+// addCountryFields adds the continent, country, registered_country,
+// represented_country, and traits fields shared by the City and Country
+// records.
+func (l *Locations) addCountryFields(record mmdbtype.Map, row *row) error {
+	if row.get("geoname_id") != "" {
+		id, err := row.uint32("geoname_id")
+		if err != nil {
+			return err
+		}
+		loc, err := l.location(id)
+		if err != nil {
+			return err
+		}
+
+		if loc.continent.isoCode != "" {
+			continent := mmdbtype.Map{"code": mmdbtype.String(loc.continent.isoCode)}
+			if id, ok := continentGeoNameIDs[loc.continent.isoCode]; ok {
+				continent["geoname_id"] = mmdbtype.Uint32(id)
+			}
+			if loc.continent.names != nil {
+				continent["names"] = names(loc.continent.names)
+			}
+			record["continent"] = continent
+		}
+
+		if country := l.country(loc, l.countryID(loc.country.isoCode)); country != nil {
+			record["country"] = country
+		}
+	}
+
+	for _, key := range []string{"registered_country", "represented_country"} {
+		column := key + "_geoname_id"
+		if row.get(column) == "" {
+			continue
+		}
+		id, err := row.uint32(column)
+		if err != nil {
+			return err
+		}
+		loc, err := l.location(id)
+		if err != nil {
+			return err
+		}
+		if country := l.country(loc, id); country != nil {
+			record[mmdbtype.String(key)] = country
+		}
+	}
+
+	traits := mmdbtype.Map{}
+	for _, key := range []string{"is_anonymous_proxy", "is_satellite_provider", "is_anycast"} {
+		if row.get(key) == "1" {
+			traits[mmdbtype.String(key)] = mmdbtype.Bool(true)
+		}
+	}
+	if len(traits) > 0 {
+		record["traits"] = traits
+	}
+	return nil
+}
+
+// country returns the country map for the location or nil if the location
+// does not have a country. id is the GeoNames ID of the country, if known.
+func (l *Locations) country(loc *location, id uint32) mmdbtype.Map {
+	if loc.country.isoCode == "" && loc.country.names == nil {
+		return nil
+	}
+
+	country := mmdbtype.Map{}
+	if id != 0 {
+		country["geoname_id"] = mmdbtype.Uint32(id)
+	}
+	addPlace(country, loc.country)
+	if loc.isInEU {
+		country["is_in_european_union"] = mmdbtype.Bool(true)
+	}
+	return country
+}
+
+func addPlace(m mmdbtype.Map, p place) {
+	if p.isoCode != "" {
+		m["iso_code"] = mmdbtype.String(p.isoCode)
+	}
+	if p.names != nil {
+		m["names"] = names(p.names)
+	}
+}
+
+func names(n map[string]string) mmdbtype.Map {
+	m := make(mmdbtype.Map, len(n))
+	for locale, name := range n {
+		m[mmdbtype.String(locale)] = mmdbtype.String(name)
+	}
+	return m
+}
+
+var asnBlockColumns = []string{
+	"network",
+	"autonomous_system_number",
+	"autonomous_system_organization",
+}
+
+// InsertASNBlocks inserts the networks from a GeoLite2 ASN blocks file,
+// either IPv4 or IPv6, into the tree.
+func InsertASNBlocks(tree *mmdbwriter.Tree, r io.Reader) error {
+	return readCSV(r, asnBlockColumns, func(row *row) error {
+		network, err := row.network()
+		if err != nil {
+			return err
+		}
+
+		record := mmdbtype.Map{}
+		if row.get("autonomous_system_number") != "" {
+			asn, err := row.uint32("autonomous_system_number")
+			if err != nil {
+				return err
+			}
+			record["autonomous_system_number"] = mmdbtype.Uint32(asn)
+		}
+		if org := row.get("autonomous_system_organization"); org != "" {
+			record["autonomous_system_organization"] = mmdbtype.String(org)
+		}
+
+		return tree.Insert(network, record)
+	})
+}
+
+type row struct {
+	columns map[string]int
+	fields  []string
+}
+
+func (r *row) get(column string) string {
+	i, ok := r.columns[column]
+	if !ok || i >= len(r.fields) {
+		return ""
+	}
+	return r.fields[i]
+}
+
+func (r *row) uint32(column string) (uint32, error) {
+	v, err := strconv.ParseUint(r.get(column), 10, 32)
+	if err != nil {
+		return 0, errors.Wrapf(err, "error parsing %s", column)
+	}
+	return uint32(v), nil
+}
+
+func (r *row) network() (*net.IPNet, error) {
+	_, network, err := net.ParseCIDR(r.get("network"))
+	if err != nil {
+		return nil, errors.Wrap(err, "error parsing network")
+	}
+	return network, nil
+}
+
+// readCSV calls fn for each row in the CSV. The first row must be a header
+// that contains each of the required columns.
+func readCSV(r io.Reader, required []string, fn func(*row) error) error {
+	cr := csv.NewReader(r)
+	cr.ReuseRecord = true
+
+	header, err := cr.Read()
+	if err != nil {
+		return errors.Wrap(err, "error reading CSV header")
+	}
+
+	columns := make(map[string]int, len(header))
+	for i, name := range header {
+		columns[name] = i
+	}
+	for _, name := range required {
+		if _, ok := columns[name]; !ok {
+			return errors.Errorf("CSV header is missing the %s column", name)
+		}
+	}
+
+	row := &row{columns: columns}
+	for line := 2; ; line++ {
+		fields, err := cr.Read()
+		if errors.Is(err, io.EOF) {
+			return nil
+		}
+		if err != nil {
+			return errors.Wrap(err, "error reading CSV")
+		}
+
+		row.fields = fields
+		if err := fn(row); err != nil {
+			return errors.WithMessagef(err, "error on line %d", line)
+		}
+	}
+}
@@ -0,0 +1,177 @@
+package geolite2csv
+
+import (
+	"net"
+	"strings"
+	"testing"
+
+	"github.com/maxmind/mmdbwriter"
+	"github.com/maxmind/mmdbwriter/mmdbtype"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const cityLocationsHeader = "geoname_id,locale_code,continent_code,continent_name,country_iso_code," +
+	"country_name,subdivision_1_iso_code,subdivision_1_name,subdivision_2_iso_code,subdivision_2_name," +
+	"city_name,metro_code,time_zone,is_in_european_union\n"
+
+const cityLocationsEN = cityLocationsHeader +
+	"2921044,en,EU,Europe,DE,Germany,,,,,,,Europe/Berlin,1\n" +
+	"2950159,en,EU,Europe,DE,Germany,BE,\"Land Berlin\",,,Berlin,,Europe/Berlin,1\n" +
+	"6252001,en,NA,\"North America\",US,\"United States\",,,,,,,,0\n"
+
+const cityLocationsDE = cityLocationsHeader +
+	"2921044,de,EU,Europa,DE,Deutschland,,,,,,,Europe/Berlin,1\n" +
+	"2950159,de,EU,Europa,DE,Deutschland,BE,Berlin,,,Berlin,,Europe/Berlin,1\n" +
+	"6252001,de,NA,Nordamerika,US,USA,,,,,,,,0\n"
+
+const cityBlocks = "network,geoname_id,registered_country_geoname_id,represented_country_geoname_id," +
+	"is_anonymous_proxy,is_satellite_provider,postal_code,latitude,longitude,accuracy_radius,is_anycast\n" +
+	"2.16.20.0/24,2950159,2921044,6252001,0,0,10115,52.5200,13.4050,20,\n" +
+	"2.16.21.0/24,,6252001,,1,0,,,,,\n"
+
+func TestInsertCityBlocks(t *testing.T) {
+	locations := NewLocations()
+	require.NoError(t, locations.Read(strings.NewReader(cityLocationsEN)))
+	require.NoError(t, locations.Read(strings.NewReader(cityLocationsDE)))
+
+	tree, err := mmdbwriter.New(mmdbwriter.Options{})
+	require.NoError(t, err)
+	require.NoError(t, InsertCityBlocks(tree, strings.NewReader(cityBlocks), locations))
+
+	germany := mmdbtype.Map{
+		"geoname_id": mmdbtype.Uint32(2921044),
+		"iso_code":   mmdbtype.String("DE"),
+		"names": mmdbtype.Map{
+			"de": mmdbtype.String("Deutschland"),
+			"en": mmdbtype.String("Germany"),
+		},
+		"is_in_european_union": mmdbtype.Bool(true),
+	}
+	unitedStates := mmdbtype.Map{
+		"geoname_id": mmdbtype.Uint32(6252001),
+		"iso_code":   mmdbtype.String("US"),
+		"names": mmdbtype.Map{
+			"de": mmdbtype.String("USA"),
+			"en": mmdbtype.String("United States"),
+		},
+	}
+
+	network, value := tree.Get(net.ParseIP("2.16.20.1"))
+	assert.Equal(t, "2.16.20.0/24", network.String())
+	assert.Equal(
+		t,
+		mmdbtype.Map{
+			"city": mmdbtype.Map{
+				"geoname_id": mmdbtype.Uint32(2950159),
+				"names": mmdbtype.Map{
+					"de": mmdbtype.String("Berlin"),
+					"en": mmdbtype.String("Berlin"),
+				},
+			},
+			"continent": mmdbtype.Map{
+				"code":       mmdbtype.String("EU"),
+				"geoname_id": mmdbtype.Uint32(6255148),
+				"names": mmdbtype.Map{
+					"de": mmdbtype.String("Europa"),
+					"en": mmdbtype.String("Europe"),
+				},
+			},
+			"country": germany,
+			"location": mmdbtype.Map{
+				"accuracy_radius": mmdbtype.Uint16(20),
+				"latitude":        mmdbtype.Float64(52.52),
+				"longitude":       mmdbtype.Float64(13.405),
+				"time_zone":       mmdbtype.String("Europe/Berlin"),
+			},
+			"postal":              mmdbtype.Map{"code": mmdbtype.String("10115")},
+			"registered_country":  germany,
+			"represented_country": unitedStates,
+			"subdivisions": mmdbtype.Slice{
+				mmdbtype.Map{
+					"iso_code": mmdbtype.String("BE"),
+					"names": mmdbtype.Map{
+						"de": mmdbtype.String("Berlin"),
+						"en": mmdbtype.String("Land Berlin"),
+					},
+				},
+			},
+		},
+		value,
+	)
+
+	_, value = tree.Get(net.ParseIP("2.16.21.1"))
+	assert.Equal(
+		t,
+		mmdbtype.Map{
+			"registered_country": unitedStates,
+			"traits":             mmdbtype.Map{"is_anonymous_proxy": mmdbtype.Bool(true)},
+		},
+		value,
+	)
+}
+
+func TestInsertCountryBlocks(t *testing.T) {
+	locations := NewLocations()
+	require.NoError(t, locations.Read(strings.NewReader(
+		"geoname_id,locale_code,continent_code,continent_name,country_iso_code,country_name,is_in_european_union\n"+
+			"6252001,en,NA,\"North America\",US,\"United States\",0\n",
+	)))
+
+	tree, err := mmdbwriter.New(mmdbwriter.Options{})
+	require.NoError(t, err)
+	require.NoError(t, InsertCountryBlocks(tree, strings.NewReader(
+		"network,geoname_id,registered_country_geoname_id,represented_country_geoname_id,"+
+			"is_anonymous_proxy,is_satellite_provider\n"+
+			"2001:4860::/32,6252001,6252001,,0,0\n",
+	), locations))
+
+	us := mmdbtype.Map{
+		"geoname_id": mmdbtype.Uint32(6252001),
+		"iso_code":   mmdbtype.String("US"),
+		"names":      mmdbtype.Map{"en": mmdbtype.String("United States")},
+	}
+	_, value := tree.Get(net.ParseIP("2001:4860::1"))
+	assert.Equal(
+		t,
+		mmdbtype.Map{
+			"continent": mmdbtype.Map{
+				"code":       mmdbtype.String("NA"),
+				"geoname_id": mmdbtype.Uint32(6255149),
+				"names":      mmdbtype.Map{"en": mmdbtype.String("North America")},
+			},
+			"country":            us,
+			"registered_country": us,
+		},
+		value,
+	)
+
+	err = InsertCountryBlocks(tree, strings.NewReader(
+		"network,geoname_id,registered_country_geoname_id,represented_country_geoname_id,"+
+			"is_anonymous_proxy,is_satellite_provider\n"+
+			"2001:4860::/32,1,,,0,0\n",
+	), locations)
+	assert.EqualError(t, err, "error on line 2: unknown geoname_id: 1")
+}
+
+func TestInsertASNBlocks(t *testing.T) {
+	tree, err := mmdbwriter.New(mmdbwriter.Options{})
+	require.NoError(t, err)
+	require.NoError(t, InsertASNBlocks(tree, strings.NewReader(
+		"network,autonomous_system_number,autonomous_system_organization\n"+
+			"1.0.0.0/24,13335,\"CLOUDFLARENET\"\n",
+	)))
+
+	_, value := tree.Get(net.ParseIP("1.0.0.1"))
+	assert.Equal(
+		t,
+		mmdbtype.Map{
+			"autonomous_system_number":       mmdbtype.Uint32(13335),
+			"autonomous_system_organization": mmdbtype.String("CLOUDFLARENET"),
+		},
+		value,
+	)
+
+	err = InsertASNBlocks(tree, strings.NewReader("network,autonomous_system_number\n"))
+	assert.EqualError(t, err, "CSV header is missing the autonomous_system_organization column")
+}
@@ -0,0 +1,308 @@
+// Package mrt inserts the origin ASN of each prefix in a BGP routing table
+// dump into a mmdbwriter.Tree. The dump must be in the MRT TABLE_DUMP_V2
+// format described in RFC 6396, which is used by route collectors such as
+// RouteViews and RIPE RIS. These files are usually compressed; the caller
+// is responsible for decompressing them, e.g., with compress/bzip2 or
+// compress/gzip.
+//
+// Each prefix is inserted with a record like the following:
+//
+//	{"autonomous_system_number": 13335}
+package mrt
+
+import (
+	"bufio"
+	"encoding/binary"
+	"io"
+	"io/ioutil"
+	"net"
+	"sort"
+
+	"github.com/maxmind/mmdbwriter"
+	"github.com/maxmind/mmdbwriter/inserter"
+	"github.com/maxmind/mmdbwriter/mmdbtype"
+	"github.com/pkg/errors"
+)
+
+const (
+	typeTableDumpV2 = 13
+
+	subtypeRIBIPv4Unicast        = 2
+	subtypeRIBIPv6Unicast        = 4
+	subtypeRIBIPv4UnicastAddPath = 8
+	subtypeRIBIPv6UnicastAddPath = 10
+
+	attrTypeASPath = 2
+
+	attrFlagExtendedLength = 0x10
+
+	asPathSegmentSet      = 1
+	asPathSegmentSequence = 2
+
+	headerLength = 12
+
+	// maxRecordLength is the maximum length of the RIB records. The length
+	// in the header is not trusted as the dump may be corrupt. Even the
+	// RIB records of the most widely seen prefixes in the dumps of the
+	// large route collectors are well under this.
+	maxRecordLength = 16 << 20
+)
+
+// Options configures Insert.
+type Options struct {
+	// Inserter is used to generate the inserter function for each prefix.
+	// If it is nil, inserter.ReplaceWith is used.
+	Inserter inserter.FuncGenerator
+
+	// OnError is called when a prefix cannot be inserted into the tree,
+	// e.g., because it is in a reserved network. If it returns nil, the
+	// prefix is skipped and the import continues. If it returns an error,
+	// the import stops and that error is returned. If OnError is nil, the
+	// import stops at the first error. Errors parsing the dump always stop
+	// the import.
+	OnError func(network *net.IPNet, err error) error
+}
+
+// Insert reads the routing table dump and inserts the origin ASN of each
+// IPv4 and IPv6 unicast prefix into the tree.
+//
+// The origin ASN is the last ASN in the AS_PATH. If the path ends in an
+// AS_SET with more than one ASN, the path has no origin ASN. When the peers
+// disagree about the origin of a prefix, the ASN announced by the most
+// peers is used, with ties broken by choosing the lowest ASN. Prefixes
+// without an origin ASN are skipped. Records other than the TABLE_DUMP_V2
+// IPv4 and IPv6 unicast RIB records are skipped. RIB records longer than
+// 16 MiB are treated as corrupt and return an error.
+func Insert(tree *mmdbwriter.Tree, r io.Reader, opts Options) error {
+	strategy := opts.Inserter
+	if strategy == nil {
+		strategy = inserter.ReplaceWith
+	}
+
+	br := bufio.NewReader(r)
+	header := make([]byte, headerLength)
+	var message []byte
+	for record := 1; ; record++ {
+		_, err := io.ReadFull(br, header)
+		if errors.Is(err, io.EOF) {
+			return nil
+		}
+		if err != nil {
+			return errors.Wrapf(err, "error reading header of MRT record %d", record)
+		}
+
+		typ := binary.BigEndian.Uint16(header[4:])
+		subtype := binary.BigEndian.Uint16(header[6:])
+		length := binary.BigEndian.Uint32(header[8:])
+
+		var isIPv6, addPath, skip bool
+		switch subtype {
+		case subtypeRIBIPv4Unicast:
+		case subtypeRIBIPv6Unicast:
+			isIPv6 = true
+		case subtypeRIBIPv4UnicastAddPath:
+			addPath = true
+		case subtypeRIBIPv6UnicastAddPath:
+			isIPv6, addPath = true, true
+		default:
+			skip = true
+		}
+
+		// The skipped records are discarded as they are read so that their
+		// lengths, which are not checked, never determine an allocation.
+		if skip || typ != typeTableDumpV2 {
+			if _, err := io.CopyN(ioutil.Discard, br, int64(length)); err != nil {
+				if errors.Is(err, io.EOF) {
+					err = io.ErrUnexpectedEOF
+				}
+				return errors.Wrapf(err, "error reading MRT record %d", record)
+			}
+			continue
+		}
+
+		if length > maxRecordLength {
+			return errors.Errorf(
+				"MRT record %d has a length of %d bytes, which exceeds the maximum of %d bytes",
+				record,
+				length,
+				maxRecordLength,
+			)
+		}
+		if cap(message) < int(length) {
+			message = make([]byte, length)
+		}
+		message = message[:length]
+		if _, err := io.ReadFull(br, message); err != nil {
+			return errors.Wrapf(err, "error reading MRT record %d", record)
+		}
+
+		network, asn, err := parseRIB(message, isIPv6, addPath)
+		if err != nil {
+			return errors.WithMessagef(err, "error parsing MRT record %d", record)
+		}
+		if asn == 0 {
+			continue
+		}
+
+		value := mmdbtype.Map{"autonomous_system_number": mmdbtype.Uint32(asn)}
+		if err := tree.InsertFunc(network, strategy(value)); err != nil {
+			if opts.OnError == nil {
+				return errors.WithMessagef(err, "error inserting %s", network)
+			}
+			if err = opts.OnError(network, err); err != nil {
+				return err
+			}
+		}
+	}
+}
+
+// parseRIB parses a RIB_IPV4_UNICAST or RIB_IPV6_UNICAST message, or the
+// ADDPATH variant from RFC 8050, and returns the prefix and the origin ASN.
+// The ASN is 0 if there is no origin ASN.
+func parseRIB(m []byte, isIPv6, addPath bool) (*net.IPNet, uint32, error) {
+	d := &decoder{buf: m}
+
+	// sequence number
+	d.skip(4)
+
+	prefixLen := int(d.uint8())
+	bits := 32
+	if isIPv6 {
+		bits = 128
+	}
+	if prefixLen > bits {
+		return nil, 0, errors.Errorf("invalid prefix length: %d", prefixLen)
+	}
+	ip := make(net.IP, bits/8)
+	copy(ip, d.bytes((prefixLen+7)/8))
+	mask := net.CIDRMask(prefixLen, bits)
+	network := &net.IPNet{IP: ip.Mask(mask), Mask: mask}
+
+	counts := map[uint32]int{}
+	entries := int(d.uint16())
+	for i := 0; i < entries && d.err == nil; i++ {
+		// peer index and originated time
+		d.skip(6)
+		if addPath {
+			// path identifier
+			d.skip(4)
+		}
+		attrs := d.bytes(int(d.uint16()))
+		if d.err != nil {
+			break
+		}
+		asn, err := originASN(attrs)
+		if err != nil {
+			return nil, 0, err
+		}
+		if asn != 0 {
+			counts[asn]++
+		}
+	}
+	if d.err != nil {
+		return nil, 0, d.err
+	}
+
+	asns := make([]uint32, 0, len(counts))
+	for asn := range counts {
+		asns = append(asns, asn)
+	}
+	if len(asns) == 0 {
+		return network, 0, nil
+	}
+	sort.Slice(asns, func(i, j int) bool {
+		if counts[asns[i]] != counts[asns[j]] {
+			return counts[asns[i]] > counts[asns[j]]
+		}
+		return asns[i] < asns[j]
+	})
+	return network, asns[0], nil
+}
+
+// originASN returns the origin ASN from the AS_PATH in the BGP path
+// attributes or 0 if there is none. RFC 6396 requires the AS_PATH in
+// TABLE_DUMP_V2 records to use four-byte ASNs.
+func originASN(attrs []byte) (uint32, error) {
+	d := &decoder{buf: attrs}
+	for len(d.buf) > 0 && d.err == nil {
+		flags := d.uint8()
+		typ := d.uint8()
+		var length int
+		if flags&attrFlagExtendedLength != 0 {
+			length = int(d.uint16())
+		} else {
+			length = int(d.uint8())
+		}
+		value := d.bytes(length)
+		if d.err != nil || typ != attrTypeASPath {
+			continue
+		}
+
+		var origin uint32
+		p := &decoder{buf: value}
+		for len(p.buf) > 0 && p.err == nil {
+			segmentType := p.uint8()
+			count := int(p.uint8())
+			asns := p.bytes(4 * count)
+			if p.err != nil || count == 0 {
+				continue
+			}
+			switch {
+			case segmentType == asPathSegmentSequence,
+				segmentType == asPathSegmentSet && count == 1:
+				origin = binary.BigEndian.Uint32(asns[4*(count-1):])
+			default:
+				origin = 0
+			}
+		}
+		if p.err != nil {
+			return 0, errors.WithMessage(p.err, "error parsing AS_PATH")
+		}
+		return origin, nil
+	}
+	if d.err != nil {
+		return 0, errors.WithMessage(d.err, "error parsing path attributes")
+	}
+	return 0, nil
+}
+
+// decoder reads big-endian values from a buffer. After the first read past
+// the end of the buffer, err is set and all reads return zero values.
+type decoder struct {
+	buf []byte
+	err error
+}
+
+func (d *decoder) bytes(n int) []byte {
+	if d.err != nil {
+		return nil
+	}
+	if n > len(d.buf) {
+		d.err = errors.Errorf("unexpected end of data: need %d bytes but only %d remain", n, len(d.buf))
+		d.buf = nil
+		return nil
+	}
+	b := d.buf[:n]
+	d.buf = d.buf[n:]
+	return b
+}
+
+func (d *decoder) skip(n int) {
+	d.bytes(n)
+}
+
+func (d *decoder) uint8() uint8 {
+	b := d.bytes(1)
+	if b == nil {
+		return 0
+	}
+	return b[0]
+}
+
+func (d *decoder) uint16() uint16 {
+	b := d.bytes(2)
+	if b == nil {
+		return 0
+	}
+	return binary.BigEndian.Uint16(b)
+}
@@ -0,0 +1,194 @@
+package mrt
+
+import (
+	"bytes"
+	"net"
+	"testing"
+
+	"github.com/maxmind/mmdbwriter"
+	"github.com/maxmind/mmdbwriter/mmdbtype"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type testSegment struct {
+	typ  byte
+	asns []uint32
+}
+
+func asPathAttr(segments ...testSegment) []byte {
+	var value []byte
+	for _, s := range segments {
+		value = append(value, s.typ, byte(len(s.asns)))
+		for _, asn := range s.asns {
+			value = appendUint32(value, asn)
+		}
+	}
+	// ORIGIN attribute followed by an extended length AS_PATH attribute
+	attrs := []byte{0x40, 1, 1, 0}
+	attrs = append(attrs, 0x50, attrTypeASPath)
+	attrs = appendUint16(attrs, uint16(len(value)))
+	return append(attrs, value...)
+}
+
+func ribRecord(subtype uint16, prefix string, paths ...[]byte) []byte {
+	_, network, err := net.ParseCIDR(prefix)
+	if err != nil {
+		panic(err)
+	}
+	prefixLen, _ := network.Mask.Size()
+
+	m := []byte{0, 0, 0, 1, byte(prefixLen)}
+	m = append(m, network.IP[:(prefixLen+7)/8]...)
+	m = appendUint16(m, uint16(len(paths)))
+	for i, attrs := range paths {
+		m = appendUint16(m, uint16(i))
+		m = append(m, 0, 0, 0, 0)
+		if subtype == subtypeRIBIPv4UnicastAddPath || subtype == subtypeRIBIPv6UnicastAddPath {
+			m = append(m, 0, 0, 0, byte(i))
+		}
+		m = appendUint16(m, uint16(len(attrs)))
+		m = append(m, attrs...)
+	}
+	return mrtRecord(typeTableDumpV2, subtype, m)
+}
+
+func appendUint16(b []byte, v uint16) []byte {
+	return append(b, byte(v>>8), byte(v))
+}
+
+func appendUint32(b []byte, v uint32) []byte {
+	return append(b, byte(v>>24), byte(v>>16), byte(v>>8), byte(v))
+}
+
+func mrtRecord(typ, subtype uint16, message []byte) []byte {
+	r := []byte{0, 0, 0, 0}
+	r = appendUint16(r, typ)
+	r = appendUint16(r, subtype)
+	r = appendUint32(r, uint32(len(message)))
+	return append(r, message...)
+}
+
+func TestInsert(t *testing.T) {
+	var dump []byte
+	// PEER_INDEX_TABLE, which is skipped
+	dump = append(dump, mrtRecord(typeTableDumpV2, 1, []byte{1, 2, 3})...)
+	// A BGP4MP record, which is skipped
+	dump = append(dump, mrtRecord(16, 4, []byte{1})...)
+	dump = append(dump, ribRecord(
+		subtypeRIBIPv4Unicast,
+		"1.1.1.0/24",
+		asPathAttr(testSegment{asPathSegmentSequence, []uint32{174, 13335}}),
+		asPathAttr(testSegment{asPathSegmentSequence, []uint32{3356, 13335}}),
+		asPathAttr(testSegment{asPathSegmentSequence, []uint32{6939, 64512}}),
+	)...)
+	// Both origins are announced by two peers, so the lower ASN is used.
+	dump = append(dump, ribRecord(
+		subtypeRIBIPv4UnicastAddPath,
+		"8.8.8.0/24",
+		asPathAttr(testSegment{asPathSegmentSequence, []uint32{15169}}),
+		asPathAttr(
+			testSegment{asPathSegmentSequence, []uint32{174}},
+			testSegment{asPathSegmentSet, []uint32{15169}},
+		),
+		asPathAttr(testSegment{asPathSegmentSequence, []uint32{64513}}),
+		asPathAttr(testSegment{asPathSegmentSequence, []uint32{64513}}),
+	)...)
+	dump = append(dump, ribRecord(
+		subtypeRIBIPv6Unicast,
+		"2606:4700::/32",
+		asPathAttr(testSegment{asPathSegmentSequence, []uint32{13335}}),
+	)...)
+	// The origin is ambiguous, so this is skipped.
+	dump = append(dump, ribRecord(
+		subtypeRIBIPv6UnicastAddPath,
+		"2001:4860::/32",
+		asPathAttr(
+			testSegment{asPathSegmentSequence, []uint32{174}},
+			testSegment{asPathSegmentSet, []uint32{15169, 36040}},
+		),
+	)...)
+
+	tree, err := mmdbwriter.New(mmdbwriter.Options{})
+	require.NoError(t, err)
+	require.NoError(t, Insert(tree, bytes.NewReader(dump), Options{}))
+
+	tests := []struct {
+		ip              string
+		expectedNetwork string
+		expectedASN     uint32
+	}{
+		{"1.1.1.1", "1.1.1.0/24", 13335},
+		{"8.8.8.8", "8.8.8.0/24", 15169},
+		{"2606:4700::1", "2606:4700::/32", 13335},
+		{"2001:4860::1", "2001:4000::/18", 0},
+	}
+	for _, test := range tests {
+		network, value := tree.Get(net.ParseIP(test.ip))
+		assert.Equal(t, test.expectedNetwork, network.String(), test.ip)
+		if test.expectedASN == 0 {
+			assert.Nil(t, value, test.ip)
+			continue
+		}
+		assert.Equal(
+			t,
+			mmdbtype.Map{"autonomous_system_number": mmdbtype.Uint32(test.expectedASN)},
+			value,
+			test.ip,
+		)
+	}
+}
+
+func TestInsertErrors(t *testing.T) {
+	tree, err := mmdbwriter.New(mmdbwriter.Options{})
+	require.NoError(t, err)
+
+	reserved := ribRecord(
+		subtypeRIBIPv4Unicast,
+		"10.0.0.0/8",
+		asPathAttr(testSegment{asPathSegmentSequence, []uint32{64512}}),
+	)
+	err = Insert(tree, bytes.NewReader(reserved), Options{})
+	assert.EqualError(
+		t,
+		err,
+		"error inserting 10.0.0.0/8: attempt to insert 10.0.0.0/8 (::a00:0/104 in the tree), which is in a reserved network",
+	)
+
+	var skipped []string
+	err = Insert(tree, bytes.NewReader(reserved), Options{
+		OnError: func(network *net.IPNet, err error) error {
+			skipped = append(skipped, network.String())
+			return nil
+		},
+	})
+	require.NoError(t, err)
+	assert.Equal(t, []string{"10.0.0.0/8"}, skipped)
+
+	truncated := mrtRecord(typeTableDumpV2, subtypeRIBIPv4Unicast, []byte{0, 0, 0, 1, 24, 1, 1})
+	err = Insert(tree, bytes.NewReader(truncated), Options{})
+	assert.EqualError(
+		t,
+		err,
+		"error parsing MRT record 1: unexpected end of data: need 3 bytes but only 2 remain",
+	)
+
+	err = Insert(tree, bytes.NewReader(reserved[:20]), Options{})
+	assert.EqualError(t, err, "error reading MRT record 1: unexpected EOF")
+
+	// The lengths in the headers of corrupt dumps must not determine the
+	// memory used.
+	huge := mrtRecord(typeTableDumpV2, subtypeRIBIPv4Unicast, nil)
+	copy(huge[8:], []byte{0xFF, 0xFF, 0xFF, 0xFF})
+	err = Insert(tree, bytes.NewReader(huge), Options{})
+	assert.EqualError(
+		t,
+		err,
+		"MRT record 1 has a length of 4294967295 bytes, which exceeds the maximum of 16777216 bytes",
+	)
+
+	hugeSkipped := mrtRecord(typeTableDumpV2+1, subtypeRIBIPv4Unicast, []byte{1, 2, 3})
+	copy(hugeSkipped[8:], []byte{0xFF, 0xFF, 0xFF, 0xFF})
+	err = Insert(tree, bytes.NewReader(hugeSkipped), Options{})
+	assert.EqualError(t, err, "error reading MRT record 1: unexpected EOF")
+}
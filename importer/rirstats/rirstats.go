@@ -0,0 +1,120 @@
+// Package rirstats inserts the IP allocations from the Regional Internet
+// Registry (RIR) statistics exchange files, e.g., delegated-ripencc-extended
+// or delegated-apnic-latest, into a mmdbwriter.Tree.
+//
+// Each allocated or assigned IPv4 and IPv6 range is inserted with a record
+// like the following:
+//
+//	{
+//		"country": {"iso_code": "AU"},
+//		"registry": "apnic",
+//		"status": "assigned"
+//	}
+//
+// IPv4 allocations are given as a start address and number of addresses,
+// which are not necessarily a power of two. These are converted to the
+// CIDR networks that cover the range.
+package rirstats
+
+import (
+	"bufio"
+	"encoding/binary"
+	"io"
+	"math"
+	"net"
+	"strconv"
+	"strings"
+
+	"github.com/maxmind/mmdbwriter"
+	"github.com/maxmind/mmdbwriter/mmdbtype"
+	"github.com/pkg/errors"
+)
+
+// Insert inserts the allocated and assigned IPv4 and IPv6 networks from the
+// statistics file into the tree. The version line, summary lines, comments,
+// ASN records, and networks with other statuses, e.g., "available" or
+// "reserved", are skipped.
+func Insert(tree *mmdbwriter.Tree, r io.Reader) error {
+	scanner := bufio.NewScanner(r)
+	for line := 1; scanner.Scan(); line++ {
+		if err := insertLine(tree, scanner.Text()); err != nil {
+			return errors.WithMessagef(err, "error on line %d", line)
+		}
+	}
+	return errors.Wrap(scanner.Err(), "error reading statistics file")
+}
+
+func insertLine(tree *mmdbwriter.Tree, line string) error {
+	line = strings.TrimSpace(line)
+	if line == "" || strings.HasPrefix(line, "#") {
+		return nil
+	}
+
+	fields := strings.Split(line, "|")
+	if len(fields) < 7 {
+		// The version line has the format version|registry|serial|records|
+		// startdate|enddate|UTCoffset and the summary lines have the
+		// format registry|*|type|*|count|summary.
+		if len(fields) == 6 && fields[5] == "summary" {
+			return nil
+		}
+		return errors.Errorf("unexpected number of fields: %d", len(fields))
+	}
+	if _, err := strconv.ParseFloat(fields[0], 64); err == nil {
+		// the version line
+		return nil
+	}
+
+	registry, cc, typ, start, value, status := fields[0], fields[1], fields[2], fields[3], fields[4], fields[6]
+	if status != "allocated" && status != "assigned" {
+		return nil
+	}
+
+	if typ != "ipv4" && typ != "ipv6" {
+		return nil
+	}
+
+	record := mmdbtype.Map{
+		"registry": mmdbtype.String(registry),
+		"status":   mmdbtype.String(status),
+	}
+	// ZZ is used for networks without a country.
+	if cc != "" && cc != "ZZ" {
+		record["country"] = mmdbtype.Map{"iso_code": mmdbtype.String(strings.ToUpper(cc))}
+	}
+
+	if typ == "ipv6" {
+		_, network, err := net.ParseCIDR(start + "/" + value)
+		if err != nil {
+			return errors.Wrap(err, "invalid IPv6 network")
+		}
+		return tree.Insert(network, record)
+	}
+
+	startIP, endIP, err := ipv4Range(start, value)
+	if err != nil {
+		return err
+	}
+	return tree.InsertRange(startIP, endIP, record)
+}
+
+// ipv4Range returns the first and last address of the IPv4 range with the
+// start address and number of addresses.
+func ipv4Range(start, value string) (net.IP, net.IP, error) {
+	startIP := net.ParseIP(start).To4()
+	if startIP == nil {
+		return nil, nil, errors.Errorf("invalid IPv4 address: %s", start)
+	}
+	count, err := strconv.ParseUint(value, 10, 32)
+	if err != nil || count == 0 {
+		return nil, nil, errors.Errorf("invalid number of addresses: %s", value)
+	}
+
+	end := uint64(binary.BigEndian.Uint32(startIP)) + count - 1
+	if end > math.MaxUint32 {
+		return nil, nil, errors.Errorf("range of %s addresses starting at %s is too large", value, start)
+	}
+	endIP := make(net.IP, 4)
+	binary.BigEndian.PutUint32(endIP, uint32(end))
+	return startIP, endIP, nil
+}
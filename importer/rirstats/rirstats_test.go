@@ -0,0 +1,102 @@
+package rirstats
+
+import (
+	"net"
+	"strings"
+	"testing"
+
+	"github.com/maxmind/mmdbwriter"
+	"github.com/maxmind/mmdbwriter/mmdbtype"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const testStats = `# a comment
+2|apnic|20230101|5|19830613|20221230|+1000
+apnic|*|asn|*|1|summary
+apnic|*|ipv4|*|3|summary
+apnic|*|ipv6|*|1|summary
+apnic|JP|asn|173|1|20020801|allocated|A91A7381
+apnic|AU|ipv4|1.0.0.0|768|20110811|assigned|A91872ED
+apnic|ZZ|ipv4|1.0.4.0|1024|20110412|allocated|A92E1062
+apnic||ipv4|1.0.8.0|256||available|
+apnic|CN|ipv6|2001:250::|35|20000426|allocated|A915C0A6
+`
+
+func TestInsert(t *testing.T) {
+	tree, err := mmdbwriter.New(mmdbwriter.Options{})
+	require.NoError(t, err)
+
+	require.NoError(t, Insert(tree, strings.NewReader(testStats)))
+
+	tests := []struct {
+		ip              string
+		expectedNetwork string
+		expectedValue   mmdbtype.DataType
+	}{
+		{
+			ip:              "1.0.0.1",
+			expectedNetwork: "1.0.0.0/23",
+			expectedValue: mmdbtype.Map{
+				"country":  mmdbtype.Map{"iso_code": mmdbtype.String("AU")},
+				"registry": mmdbtype.String("apnic"),
+				"status":   mmdbtype.String("assigned"),
+			},
+		},
+		{
+			ip:              "1.0.2.1",
+			expectedNetwork: "1.0.2.0/24",
+			expectedValue: mmdbtype.Map{
+				"country":  mmdbtype.Map{"iso_code": mmdbtype.String("AU")},
+				"registry": mmdbtype.String("apnic"),
+				"status":   mmdbtype.String("assigned"),
+			},
+		},
+		{
+			ip:              "1.0.5.1",
+			expectedNetwork: "1.0.4.0/22",
+			expectedValue: mmdbtype.Map{
+				"registry": mmdbtype.String("apnic"),
+				"status":   mmdbtype.String("allocated"),
+			},
+		},
+		{
+			ip:              "1.0.8.1",
+			expectedNetwork: "1.0.8.0/21",
+			expectedValue:   nil,
+		},
+		{
+			ip:              "2001:250::1",
+			expectedNetwork: "2001:250::/35",
+			expectedValue: mmdbtype.Map{
+				"country":  mmdbtype.Map{"iso_code": mmdbtype.String("CN")},
+				"registry": mmdbtype.String("apnic"),
+				"status":   mmdbtype.String("allocated"),
+			},
+		},
+	}
+
+	for _, test := range tests {
+		network, value := tree.Get(net.ParseIP(test.ip))
+		assert.Equal(t, test.expectedNetwork, network.String(), test.ip)
+		assert.Equal(t, test.expectedValue, value, test.ip)
+	}
+}
+
+func TestInsertErrors(t *testing.T) {
+	tree, err := mmdbwriter.New(mmdbwriter.Options{})
+	require.NoError(t, err)
+
+	err = Insert(tree, strings.NewReader("apnic|AU|ipv4|1.0.0.0|0|20110811|assigned\n"))
+	assert.EqualError(t, err, "error on line 1: invalid number of addresses: 0")
+
+	err = Insert(tree, strings.NewReader("\napnic|AU|ipv4|255.255.255.0|512|20110811|assigned\n"))
+	assert.EqualError(
+		t,
+		err,
+		"error on line 2: range of 512 addresses starting at 255.255.255.0 is too large",
+	)
+
+	err = Insert(tree, strings.NewReader("apnic|AU|ipv4\n"))
+	assert.EqualError(t, err, "error on line 1: unexpected number of fields: 3")
+}
@@ -7,26 +7,105 @@ import (
 	"github.com/pkg/errors"
 )
 
+// Func is an inserter function. It is passed the existing value for a
+// record, which is nil if the record is empty, and returns the value to
+// store in the record. Returning nil removes any existing value.
+type Func = func(value mmdbtype.DataType) (mmdbtype.DataType, error)
+
+// FuncGenerator creates an inserter Func for a new value. ReplaceWith,
+// KeepExistingWith, TopLevelMergeWith, DeepMergeWith, and AppendUniqueWith
+// are FuncGenerators.
+type FuncGenerator = func(value mmdbtype.DataType) Func
+
+// Chain creates an inserter function that calls each function in turn,
+// passing the value returned by one function to the next as the existing
+// value, e.g., to deep merge a new value and then normalize the result.
+// The value returned by the last function is stored. If a function returns
+// an error, the remaining functions are not called.
+func Chain(funcs ...Func) Func {
+	return func(value mmdbtype.DataType) (mmdbtype.DataType, error) {
+		for _, f := range funcs {
+			var err error
+			value, err = f(value)
+			if err != nil {
+				return nil, err
+			}
+		}
+		return value, nil
+	}
+}
+
+// ChainWith creates a FuncGenerator that chains the inserter functions
+// created by each of the generators for the new value, as with Chain.
+// Generators that do not use the new value, e.g., one that clamps fields of
+// the merged value, may be combined with those that do, such as
+// DeepMergeWith, so that the whole policy may be used wherever a
+// FuncGenerator is accepted, e.g., by Tree.MergeTree.
+func ChainWith(generators ...FuncGenerator) FuncGenerator {
+	return func(value mmdbtype.DataType) Func {
+		funcs := make([]Func, len(generators))
+		for i, g := range generators {
+			funcs[i] = g(value)
+		}
+		return Chain(funcs...)
+	}
+}
+
 // Remove any records for the network being inserted.
 func Remove(value mmdbtype.DataType) (mmdbtype.DataType, error) {
 	return nil, nil
 }
 
+// RemoveIfNil creates a FuncGenerator that removes the existing value if the
+// new value is nil and otherwise uses the inserter function that generator
+// creates, e.g., so that an incremental feed with rows that delete networks
+// may be applied with a merge policy such as TopLevelMergeWith, which
+// returns an error for a nil value:
+//
+//	tree.InsertWith(network, value, inserter.RemoveIfNil(inserter.TopLevelMergeWith))
+//
+// If generator is nil, ReplaceWith is used, which already removes the
+// existing value for a nil value.
+func RemoveIfNil(generator FuncGenerator) FuncGenerator {
+	if generator == nil {
+		generator = ReplaceWith
+	}
+	return func(value mmdbtype.DataType) Func {
+		if value == nil {
+			return Remove
+		}
+		return generator(value)
+	}
+}
+
 // ReplaceWith generates an inserter function that replaces the existing
 // value with the new value.
-func ReplaceWith(value mmdbtype.DataType) func(mmdbtype.DataType) (mmdbtype.DataType, error) {
+func ReplaceWith(value mmdbtype.DataType) Func {
 	return func(_ mmdbtype.DataType) (mmdbtype.DataType, error) {
 		return value, nil
 	}
 }
 
+// KeepExistingWith generates an inserter function that only sets the new
+// value where there is no existing value. Records that already have data are
+// left untouched. This allows a lower-priority source to fill in the gaps
+// left by the sources inserted before it.
+func KeepExistingWith(value mmdbtype.DataType) Func {
+	return func(existingValue mmdbtype.DataType) (mmdbtype.DataType, error) {
+		if existingValue != nil {
+			return existingValue, nil
+		}
+		return value, nil
+	}
+}
+
 // TopLevelMergeWith creates an inserter for Map values that will update an
 // existing Map by adding the top-level keys and values from the new Map,
 // replacing any existing values for the keys.
 //
 // Both the new and existing value must be a Map. An error will be returned
 // otherwise.
-func TopLevelMergeWith(newValue mmdbtype.DataType) func(mmdbtype.DataType) (mmdbtype.DataType, error) {
+func TopLevelMergeWith(newValue mmdbtype.DataType) Func {
 	return func(existingValue mmdbtype.DataType) (mmdbtype.DataType, error) {
 		newMap, ok := newValue.(mmdbtype.Map)
 		if !ok {
@@ -60,10 +139,120 @@ func TopLevelMergeWith(newValue mmdbtype.DataType) func(mmdbtype.DataType) (mmdb
 	}
 }
 
+// AppendUniqueWith creates an inserter for Slice values that treats them as
+// sets, e.g., for lists of categories. The elements of the new Slice that
+// are not already in the existing Slice are appended to a copy of it, so
+// the existing elements keep their order. Elements are compared with
+// Equal.
+//
+// Both the new and existing value must be a Slice. An error will be
+// returned otherwise.
+func AppendUniqueWith(newValue mmdbtype.DataType) Func {
+	return func(existingValue mmdbtype.DataType) (mmdbtype.DataType, error) {
+		newSlice, ok := newValue.(mmdbtype.Slice)
+		if !ok {
+			return nil, errors.Errorf(
+				"the new value is a %T, not a Slice. AppendUniqueWith only works if both values are Slice values.",
+				newValue,
+			)
+		}
+
+		var existingSlice mmdbtype.Slice
+		if existingValue != nil {
+			existingSlice, ok = existingValue.(mmdbtype.Slice)
+			if !ok {
+				return nil, errors.Errorf(
+					"the existing value is a %T, not a Slice. "+
+						"AppendUniqueWith only works if both values are Slice values.",
+					existingValue,
+				)
+			}
+		}
+
+		returnSlice := make(mmdbtype.Slice, len(existingSlice), len(existingSlice)+len(newSlice))
+		copy(returnSlice, existingSlice)
+	NewElements:
+		for _, nv := range newSlice {
+			for _, v := range returnSlice {
+				if v.Equal(nv) {
+					continue NewElements
+				}
+			}
+			returnSlice = append(returnSlice, nv.Copy())
+		}
+		return returnSlice, nil
+	}
+}
+
+// KeyedMergeWith creates a FuncGenerator for Map values that merges each
+// top-level key of the new Map with the existing value for the key using the
+// policy for the key, e.g.,
+//
+//	KeyedMergeWith(map[mmdbtype.String]FuncGenerator{
+//		"traits":   DeepMergeWith,
+//		"location": ReplaceWith,
+//	}, KeepExistingWith)
+//
+// Keys without a policy use defaultPolicy, or ReplaceWith if it is nil, in
+// which case the result is the same as with TopLevelMergeWith. Keys only in
+// the existing Map are kept. If a policy returns nil, the key is removed.
+//
+// Both the new and existing value must be a Map. An error will be returned
+// otherwise.
+func KeyedMergeWith(policies map[mmdbtype.String]FuncGenerator, defaultPolicy FuncGenerator) FuncGenerator {
+	if defaultPolicy == nil {
+		defaultPolicy = ReplaceWith
+	}
+	return func(newValue mmdbtype.DataType) Func {
+		return func(existingValue mmdbtype.DataType) (mmdbtype.DataType, error) {
+			newMap, ok := newValue.(mmdbtype.Map)
+			if !ok {
+				return nil, errors.Errorf(
+					"the new value is a %T, not a Map. KeyedMergeWith only works if both values are Map values.",
+					newValue,
+				)
+			}
+
+			var existingMap mmdbtype.Map
+			if existingValue != nil {
+				existingMap, ok = existingValue.(mmdbtype.Map)
+				if !ok {
+					return nil, errors.Errorf(
+						"the existing value is a %T, not a Map. "+
+							"KeyedMergeWith only works if both values are Map values.",
+						existingValue,
+					)
+				}
+			}
+
+			returnMap := make(mmdbtype.Map, len(existingMap)+len(newMap))
+			for k, v := range existingMap {
+				returnMap[k] = v
+			}
+			for k, v := range newMap {
+				policy, ok := policies[k]
+				if !ok {
+					policy = defaultPolicy
+				}
+				merged, err := policy(v)(existingMap[k])
+				if err != nil {
+					return nil, errors.WithMessagef(err, "error merging %s", k)
+				}
+				if merged == nil {
+					delete(returnMap, k)
+					continue
+				}
+				returnMap[k] = merged
+			}
+			return returnMap, nil
+		}
+	}
+}
+
 // DeepMergeWith creates an inserter that will recursively update an existing
 // value. Map and Slice values will be merged recursively. Other values will
 // be replaced by the new value.
-func DeepMergeWith(newValue mmdbtype.DataType) func(mmdbtype.DataType) (mmdbtype.DataType, error) {
+func DeepMergeWith(newValue mmdbtype.DataType) Func {
 	return func(existingValue mmdbtype.DataType) (mmdbtype.DataType, error) {
 		return deepMerge(existingValue, newValue)
 	}
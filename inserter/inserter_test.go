@@ -4,6 +4,7 @@ import (
 	"testing"
 
 	"github.com/maxmind/mmdbwriter/mmdbtype"
+	"github.com/pkg/errors"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
 )
@@ -14,12 +15,58 @@ func TestRemove(t *testing.T) {
 	assert.Nil(t, v)
 }
 
+func TestRemoveIfNil(t *testing.T) {
+	existing := mmdbtype.Map{"a": mmdbtype.Bool(true)}
+
+	v, err := RemoveIfNil(TopLevelMergeWith)(nil)(existing)
+	require.NoError(t, err)
+	assert.Nil(t, v)
+
+	v, err = RemoveIfNil(TopLevelMergeWith)(mmdbtype.Map{"b": mmdbtype.Bool(true)})(existing)
+	require.NoError(t, err)
+	assert.Equal(t, mmdbtype.Map{"a": mmdbtype.Bool(true), "b": mmdbtype.Bool(true)}, v)
+
+	v, err = RemoveIfNil(nil)(nil)(existing)
+	require.NoError(t, err)
+	assert.Nil(t, v)
+
+	v, err = RemoveIfNil(nil)(mmdbtype.Uint32(1))(existing)
+	require.NoError(t, err)
+	assert.Equal(t, mmdbtype.Uint32(1), v)
+}
+
 func TestReplaceWith(t *testing.T) {
 	v, err := ReplaceWith(mmdbtype.Uint64(1))(mmdbtype.Bool(true))
 	require.NoError(t, err)
 	assert.Equal(t, mmdbtype.Uint64(1), v)
 }
 
+func TestGeneratorSignatures(t *testing.T) {
+	// The generators must remain assignable to their original, unnamed
+	// function types.
+	generators := []func(mmdbtype.DataType) func(mmdbtype.DataType) (mmdbtype.DataType, error){
+		ReplaceWith,
+		TopLevelMergeWith,
+		DeepMergeWith,
+	}
+	value := mmdbtype.Map{"a": mmdbtype.Uint64(1)}
+	for _, g := range generators {
+		v, err := g(value)(nil)
+		require.NoError(t, err)
+		assert.Equal(t, value, v)
+	}
+}
+
+func TestKeepExistingWith(t *testing.T) {
+	v, err := KeepExistingWith(mmdbtype.Uint64(1))(mmdbtype.Bool(true))
+	require.NoError(t, err)
+	assert.Equal(t, mmdbtype.Bool(true), v)
+
+	v, err = KeepExistingWith(mmdbtype.Uint64(1))(nil)
+	require.NoError(t, err)
+	assert.Equal(t, mmdbtype.Uint64(1), v)
+}
+
 func TestTopLevelMergeWith(t *testing.T) {
 	tests := []struct {
 		description string
@@ -180,3 +227,154 @@ func TestDeepMergeWith(t *testing.T) {
 		}
 	}
 }
+
+func TestAppendUniqueWith(t *testing.T) {
+	tests := []struct {
+		description string
+		existing    mmdbtype.DataType
+		new         mmdbtype.DataType
+		expected    mmdbtype.DataType
+		expectedErr string
+	}{
+		{
+			description: "existing nil",
+			new:         mmdbtype.Slice{mmdbtype.String("a"), mmdbtype.String("a")},
+			expected:    mmdbtype.Slice{mmdbtype.String("a")},
+		},
+		{
+			description: "append",
+			existing:    mmdbtype.Slice{mmdbtype.String("spam"), mmdbtype.Map{"a": mmdbtype.Uint32(1)}},
+			new: mmdbtype.Slice{
+				mmdbtype.String("malware"),
+				mmdbtype.Map{"a": mmdbtype.Uint32(1)},
+				mmdbtype.String("spam"),
+				mmdbtype.Uint32(1),
+			},
+			expected: mmdbtype.Slice{
+				mmdbtype.String("spam"),
+				mmdbtype.Map{"a": mmdbtype.Uint32(1)},
+				mmdbtype.String("malware"),
+				mmdbtype.Uint32(1),
+			},
+		},
+		{
+			description: "new not a slice",
+			existing:    mmdbtype.Slice{},
+			new:         mmdbtype.String("a"),
+			expectedErr: "the new value is a mmdbtype.String, not a Slice. " +
+				"AppendUniqueWith only works if both values are Slice values.",
+		},
+		{
+			description: "existing not a slice",
+			existing:    mmdbtype.String("a"),
+			new:         mmdbtype.Slice{},
+			expectedErr: "the existing value is a mmdbtype.String, not a Slice. " +
+				"AppendUniqueWith only works if both values are Slice values.",
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.description, func(t *testing.T) {
+			existing := test.existing
+			if existing != nil {
+				existing = existing.Copy()
+			}
+			v, err := AppendUniqueWith(test.new)(existing)
+			if test.expectedErr != "" {
+				assert.EqualError(t, err, test.expectedErr)
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, test.expected, v)
+			assert.Equal(t, test.existing, existing, "the existing value is not modified")
+		})
+	}
+}
+
+func TestChain(t *testing.T) {
+	clamp := func(value mmdbtype.DataType) (mmdbtype.DataType, error) {
+		m := value.(mmdbtype.Map).Copy().(mmdbtype.Map)
+		if m["score"].(mmdbtype.Uint32) > 100 {
+			m["score"] = mmdbtype.Uint32(100)
+		}
+		return m, nil
+	}
+
+	v, err := Chain(DeepMergeWith(mmdbtype.Map{"score": mmdbtype.Uint32(150)}), clamp)(
+		mmdbtype.Map{"name": mmdbtype.String("a"), "score": mmdbtype.Uint32(1)},
+	)
+	require.NoError(t, err)
+	assert.Equal(t, mmdbtype.Map{"name": mmdbtype.String("a"), "score": mmdbtype.Uint32(100)}, v)
+
+	fail := func(mmdbtype.DataType) (mmdbtype.DataType, error) {
+		return nil, errors.New("failed")
+	}
+	called := false
+	_, err = Chain(fail, func(value mmdbtype.DataType) (mmdbtype.DataType, error) {
+		called = true
+		return value, nil
+	})(nil)
+	assert.EqualError(t, err, "failed")
+	assert.False(t, called)
+
+	generator := ChainWith(TopLevelMergeWith, func(mmdbtype.DataType) Func { return clamp })
+	v, err = generator(mmdbtype.Map{"score": mmdbtype.Uint32(101)})(mmdbtype.Map{"name": mmdbtype.String("a")})
+	require.NoError(t, err)
+	assert.Equal(t, mmdbtype.Map{"name": mmdbtype.String("a"), "score": mmdbtype.Uint32(100)}, v)
+}
+
+func TestKeyedMergeWith(t *testing.T) {
+	generator := KeyedMergeWith(map[mmdbtype.String]FuncGenerator{
+		"traits":     DeepMergeWith,
+		"categories": AppendUniqueWith,
+		"name":       KeepExistingWith,
+		"removed":    func(mmdbtype.DataType) Func { return Remove },
+	}, nil)
+
+	existing := mmdbtype.Map{
+		"traits":     mmdbtype.Map{"a": mmdbtype.Bool(true)},
+		"location":   mmdbtype.Map{"latitude": mmdbtype.Float64(1), "longitude": mmdbtype.Float64(2)},
+		"categories": mmdbtype.Slice{mmdbtype.String("spam")},
+		"name":       mmdbtype.String("existing"),
+		"removed":    mmdbtype.Bool(true),
+		"untouched":  mmdbtype.Uint32(1),
+	}
+	v, err := generator(mmdbtype.Map{
+		"traits":     mmdbtype.Map{"b": mmdbtype.Bool(true)},
+		"location":   mmdbtype.Map{"latitude": mmdbtype.Float64(3)},
+		"categories": mmdbtype.Slice{mmdbtype.String("malware"), mmdbtype.String("spam")},
+		"name":       mmdbtype.String("new"),
+		"removed":    mmdbtype.Bool(false),
+	})(existing)
+	require.NoError(t, err)
+	assert.Equal(
+		t,
+		mmdbtype.Map{
+			"traits":     mmdbtype.Map{"a": mmdbtype.Bool(true), "b": mmdbtype.Bool(true)},
+			"location":   mmdbtype.Map{"latitude": mmdbtype.Float64(3)},
+			"categories": mmdbtype.Slice{mmdbtype.String("spam"), mmdbtype.String("malware")},
+			"name":       mmdbtype.String("existing"),
+			"untouched":  mmdbtype.Uint32(1),
+		},
+		v,
+	)
+
+	v, err = generator(mmdbtype.Map{"name": mmdbtype.String("new")})(nil)
+	require.NoError(t, err)
+	assert.Equal(t, mmdbtype.Map{"name": mmdbtype.String("new")}, v)
+
+	_, err = generator(mmdbtype.Map{"categories": mmdbtype.String("spam")})(nil)
+	assert.EqualError(
+		t,
+		err,
+		"error merging categories: the new value is a mmdbtype.String, not a Slice. "+
+			"AppendUniqueWith only works if both values are Slice values.",
+	)
+
+	_, err = generator(mmdbtype.Map{})(mmdbtype.Slice{})
+	assert.EqualError(
+		t,
+		err,
+		"the existing value is a mmdbtype.Slice, not a Map. KeyedMergeWith only works if both values are Map values.",
+	)
+}
@@ -0,0 +1,292 @@
+package mmdbwriter
+
+import (
+	"net"
+	"sort"
+	"strings"
+
+	"github.com/maxmind/mmdbwriter/mmdbtype"
+	"github.com/oschwald/maxminddb-golang"
+	"github.com/pkg/errors"
+)
+
+// LoadFilter restricts the data loaded by LoadFiltered.
+type LoadFilter struct {
+	// Networks, if set, are the only networks whose data is loaded. Only
+	// the parts of the database within them are read, and networks in the
+	// database that contain one of them are loaded as that network.
+	Networks []*net.IPNet
+
+	// Keys, if set, are the only keys that are loaded from each Map value.
+	// A key may be a path into nested values with the keys separated by a
+	// ".", as with ExportCSV, e.g., "country.iso_code". Networks whose
+	// values have none of the keys are not loaded. An error is returned for
+	// values that are not a Map.
+	Keys []string
+
+	// Rename maps keys to the keys that they are loaded as, e.g.,
+	// {"country.iso_code": "cc"} loads the iso_code of the country Map as
+	// the top-level cc key. The renamed keys are loaded in addition to
+	// those in Keys, and the keys are paths as with Keys. A new key may not
+	// be within another new key, e.g., "a" and "a.b" may not both be new
+	// keys.
+	Rename map[string]string
+}
+
+// Load an existing database into the writer.
+func Load(path string, opts Options) (*Tree, error) {
+	return LoadFiltered(path, opts, LoadFilter{})
+}
+
+// LoadFiltered loads the parts of an existing database selected by the
+// filter into the writer, e.g., to build a smaller database with only some
+// of the networks or fields of an existing one. The options are used as
+// with Load.
+func LoadFiltered(path string, opts Options, filter LoadFilter) (*Tree, error) {
+	db, err := maxminddb.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer db.Close()
+
+	metadata := db.Metadata
+	if opts.DatabaseType == "" {
+		opts.DatabaseType = metadata.DatabaseType
+	}
+
+	if opts.Description == nil {
+		opts.Description = metadata.Description
+	}
+
+	if opts.IPVersion == 0 {
+		opts.IPVersion = int(metadata.IPVersion)
+	}
+
+	if opts.Languages == nil {
+		opts.Languages = metadata.Languages
+	}
+
+	if opts.RecordSize == 0 {
+		opts.RecordSize = int(metadata.RecordSize)
+	}
+
+	tree, err := New(opts)
+	if err != nil {
+		return nil, err
+	}
+
+	l := newLoader(tree, tree.Insert)
+	if err := l.setKeys(filter.Keys, filter.Rename); err != nil {
+		return nil, err
+	}
+
+	networkOpts := loadNetworksOptions(opts)
+
+	if filter.Networks == nil {
+		if err := l.load(db.Networks(networkOpts...), nil); err != nil {
+			return nil, err
+		}
+		return tree, nil
+	}
+	for _, within := range filter.Networks {
+		if err := l.load(db.NetworksWithin(within, networkOpts...), within); err != nil {
+			return nil, err
+		}
+	}
+	return tree, nil
+}
+
+// loadNetworksOptions returns the options for iterating over the networks of
+// a database loaded into a tree with the options.
+func loadNetworksOptions(opts Options) []maxminddb.NetworksOption {
+	if opts.IPVersion == 6 && !opts.DisableIPv4Aliasing {
+		return []maxminddb.NetworksOption{maxminddb.SkipAliasedNetworks}
+	}
+	return nil
+}
+
+type loader struct {
+	tree *Tree
+	// insert inserts each loaded value into the tree.
+	insert func(network *net.IPNet, value mmdbtype.DataType) error
+	dser   *deserializer
+	// keys are the keys to select, if any.
+	keys []selectedKey
+	// selected caches the selected keys of the Map and Slice values, which
+	// the deserializer reuses for each record that points to them, so that
+	// they are only selected once.
+	selected map[valueIdentity]mmdbtype.DataType
+}
+
+func newLoader(tree *Tree, insert func(network *net.IPNet, value mmdbtype.DataType) error) *loader {
+	return &loader{
+		tree:     tree,
+		insert:   insert,
+		dser:     newDeserializer(),
+		selected: map[valueIdentity]mmdbtype.DataType{},
+	}
+}
+
+// selectedKey is a key to select from the values and the key to store it
+// as.
+type selectedKey struct {
+	from []string
+	to   []mmdbtype.String
+}
+
+// setKeys sets the keys to select from the values.
+func (l *loader) setKeys(keys []string, rename map[string]string) error {
+	for _, key := range keys {
+		if _, ok := rename[key]; !ok {
+			l.keys = append(l.keys, newSelectedKey(key, key))
+		}
+	}
+	// The keys are sorted so that the errors are deterministic.
+	from := make([]string, 0, len(rename))
+	for key := range rename {
+		from = append(from, key)
+	}
+	sort.Strings(from)
+	for _, key := range from {
+		l.keys = append(l.keys, newSelectedKey(key, rename[key]))
+	}
+
+	// The new keys are stored in Maps created for the selected values, so
+	// that the loaded values are never modified. This is only possible if
+	// no new key is within another.
+	for i, a := range l.keys {
+		for j, b := range l.keys {
+			if i == j || len(a.to) > len(b.to) || !pathHasPrefix(b.to, a.to) {
+				continue
+			}
+			if len(a.to) == len(b.to) {
+				return errors.Errorf("both %s and %s are loaded as %s", a.fromKey(), b.fromKey(), a.toKey())
+			}
+			return errors.Errorf("cannot load %s as %s; it is within %s", b.fromKey(), b.toKey(), a.toKey())
+		}
+	}
+	return nil
+}
+
+func newSelectedKey(from, to string) selectedKey {
+	k := selectedKey{from: strings.Split(from, ".")}
+	for _, key := range strings.Split(to, ".") {
+		k.to = append(k.to, mmdbtype.String(key))
+	}
+	return k
+}
+
+func (k selectedKey) fromKey() string {
+	return strings.Join(k.from, ".")
+}
+
+func (k selectedKey) toKey() string {
+	keys := make([]string, len(k.to))
+	for i, key := range k.to {
+		keys[i] = string(key)
+	}
+	return strings.Join(keys, ".")
+}
+
+func pathHasPrefix(path, prefix []mmdbtype.String) bool {
+	for i, key := range prefix {
+		if path[i] != key {
+			return false
+		}
+	}
+	return true
+}
+
+// load inserts the networks from the iterator. If within is set, it is the
+// network that the iterator is restricted to.
+func (l *loader) load(networks *maxminddb.Networks, within *net.IPNet) error {
+	for networks.Next() {
+		l.dser.clear()
+		network, err := networks.Network(l.dser)
+		if err != nil {
+			return err
+		}
+
+		if within != nil {
+			network, err = l.clip(network, within)
+			if err != nil {
+				return err
+			}
+		}
+
+		value := l.dser.rv
+		if l.keys != nil {
+			value, err = l.selectKeys(network, value)
+			if err != nil {
+				return err
+			}
+			if value == nil {
+				continue
+			}
+		}
+
+		if err := l.insert(network, value); err != nil {
+			return err
+		}
+	}
+	return networks.Err()
+}
+
+// clip returns within if the network contains it, as the iterator returns
+// the network containing within rather than within itself, or network
+// otherwise.
+func (l *loader) clip(network, within *net.IPNet) (*net.IPNet, error) {
+	_, prefixLen, err := l.tree.treeNetwork(network)
+	if err != nil {
+		return nil, err
+	}
+	_, withinPrefixLen, err := l.tree.treeNetwork(within)
+	if err != nil {
+		return nil, err
+	}
+	if prefixLen < withinPrefixLen {
+		return within, nil
+	}
+	return network, nil
+}
+
+// selectKeys returns a Map with only the selected keys of the value, stored
+// as their new keys, or nil if it has none of them.
+func (l *loader) selectKeys(network *net.IPNet, value mmdbtype.DataType) (mmdbtype.DataType, error) {
+	m, ok := value.(mmdbtype.Map)
+	if !ok {
+		return nil, errors.Errorf("cannot select keys from the value for %s; it is a %T, not a Map", network, value)
+	}
+
+	id, _ := valueIdentityOf(m)
+	if selected, ok := l.selected[id]; ok {
+		return selected, nil
+	}
+
+	result := mmdbtype.Map{}
+	for _, key := range l.keys {
+		if v := lookupPath(m, key.from); v != nil {
+			setPath(result, key.to, v)
+		}
+	}
+	var selected mmdbtype.DataType
+	if len(result) > 0 {
+		selected = result
+	}
+	l.selected[id] = selected
+	return selected, nil
+}
+
+// setPath sets the value at the path in m, creating the Maps along the path
+// as needed. The Maps along the path must have been created by setPath.
+func setPath(m mmdbtype.Map, path []mmdbtype.String, value mmdbtype.DataType) {
+	for _, key := range path[:len(path)-1] {
+		next, ok := m[key].(mmdbtype.Map)
+		if !ok {
+			next = mmdbtype.Map{}
+			m[key] = next
+		}
+		m = next
+	}
+	m[path[len(path)-1]] = value
+}
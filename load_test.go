@@ -0,0 +1,116 @@
+package mmdbwriter
+
+import (
+	"io/ioutil"
+	"net"
+	"os"
+	"testing"
+
+	"github.com/maxmind/mmdbwriter/mmdbtype"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestLoadFiltered(t *testing.T) {
+	tree, err := New(Options{})
+	require.NoError(t, err)
+	country := mmdbtype.Map{
+		"country": mmdbtype.Map{
+			"iso_code": mmdbtype.String("DE"),
+			"names":    mmdbtype.Map{"en": mmdbtype.String("Germany")},
+		},
+		"city": mmdbtype.Map{"names": mmdbtype.Map{"en": mmdbtype.String("Berlin")}},
+	}
+	for network, value := range map[string]mmdbtype.DataType{
+		"1.0.0.0/8":  country,
+		"2.2.0.0/16": country,
+		"3.3.3.0/24": mmdbtype.Map{"city": mmdbtype.Map{}},
+		"2003::/16":  country,
+	} {
+		require.NoError(t, tree.Insert(mustParseNetwork(t, network), value))
+	}
+
+	path := writeTempDatabase(t, tree)
+	defer func() { require.NoError(t, os.Remove(path)) }()
+
+	networks := func(tree *Tree) map[string]mmdbtype.DataType {
+		values := map[string]mmdbtype.DataType{}
+		require.NoError(t, tree.Walk(func(network *net.IPNet, value mmdbtype.DataType) error {
+			values[network.String()] = value
+			return nil
+		}))
+		return values
+	}
+
+	loaded, err := LoadFiltered(path, Options{}, LoadFilter{})
+	require.NoError(t, err)
+	assert.Equal(t, networks(tree), networks(loaded))
+
+	loaded, err = LoadFiltered(path, Options{}, LoadFilter{
+		// 1.1.1.0/24 is within 1.0.0.0/8.
+		Networks: []*net.IPNet{
+			mustParseNetwork(t, "1.1.1.0/24"),
+			mustParseNetwork(t, "3.0.0.0/8"),
+			mustParseNetwork(t, "2000::/4"),
+		},
+		Keys: []string{"country.iso_code", "city.names.de", "asn"},
+	})
+	require.NoError(t, err)
+	countryCode := mmdbtype.Map{"country": mmdbtype.Map{"iso_code": mmdbtype.String("DE")}}
+	assert.Equal(
+		t,
+		map[string]mmdbtype.DataType{
+			"1.1.1.0/24": countryCode,
+			"2003::/16":  countryCode,
+		},
+		networks(loaded),
+	)
+
+	loaded, err = LoadFiltered(path, Options{}, LoadFilter{
+		Keys:   []string{"city.names"},
+		Rename: map[string]string{"country.iso_code": "cc", "country.names.en": "names.country"},
+	})
+	require.NoError(t, err)
+	renamed := mmdbtype.Map{
+		"cc":    mmdbtype.String("DE"),
+		"city":  mmdbtype.Map{"names": mmdbtype.Map{"en": mmdbtype.String("Berlin")}},
+		"names": mmdbtype.Map{"country": mmdbtype.String("Germany")},
+	}
+	assert.Equal(
+		t,
+		map[string]mmdbtype.DataType{
+			"1.0.0.0/8":  renamed,
+			"2.2.0.0/16": renamed,
+			"2003::/16":  renamed,
+		},
+		networks(loaded),
+	)
+
+	_, err = LoadFiltered(path, Options{}, LoadFilter{
+		Keys:   []string{"city"},
+		Rename: map[string]string{"country.iso_code": "city.cc"},
+	})
+	assert.EqualError(t, err, "cannot load country.iso_code as city.cc; it is within city")
+	_, err = LoadFiltered(path, Options{}, LoadFilter{
+		Keys:   []string{"cc"},
+		Rename: map[string]string{"country.iso_code": "cc"},
+	})
+	assert.EqualError(t, err, "both cc and country.iso_code are loaded as cc")
+
+	stringTree, err := New(Options{})
+	require.NoError(t, err)
+	require.NoError(t, stringTree.Insert(mustParseNetwork(t, "1.1.1.0/24"), mmdbtype.String("a")))
+	stringsPath := writeTempDatabase(t, stringTree)
+	defer func() { require.NoError(t, os.Remove(stringsPath)) }()
+	_, err = LoadFiltered(stringsPath, Options{}, LoadFilter{Keys: []string{"a"}})
+	assert.EqualError(t, err, "cannot select keys from the value for 1.1.1.0/24; it is a mmdbtype.String, not a Map")
+}
+
+func writeTempDatabase(t *testing.T, tree *Tree) string {
+	f, err := ioutil.TempFile("", "mmdbwriter")
+	require.NoError(t, err)
+	_, err = tree.WriteTo(f)
+	require.NoError(t, err)
+	require.NoError(t, f.Close())
+	return f.Name()
+}
@@ -0,0 +1,15 @@
+package mmdbwriter
+
+// Logger receives messages about conditions that do not stop the build
+// but that may indicate a problem with the input, such as an insert that
+// was skipped. A *log.Logger satisfies this interface.
+type Logger interface {
+	Printf(format string, v ...interface{})
+}
+
+// logf logs the message if l is not nil.
+func logf(l Logger, format string, v ...interface{}) {
+	if l != nil {
+		l.Printf(format, v...)
+	}
+}
@@ -0,0 +1,79 @@
+package mmdbwriter
+
+import "time"
+
+// Counter is a count reported to Options.Metrics.
+type Counter int
+
+const (
+	// CounterInserts is the number of successful inserts, including those
+	// made by InsertFunc, Remove, and MergeTree. TransformAll, and the
+	// methods built on it, count one insert for each network transformed.
+	CounterInserts Counter = iota + 1
+	// CounterMerges is the number of records whose existing data was passed
+	// to an inserter function, e.g., to be merged with a new value.
+	CounterMerges
+	// CounterDedupHits is the number of values stored that were already in
+	// the tree and so share the existing copy.
+	CounterDedupHits
+	// CounterNodesCreated is the number of nodes created when inserts split
+	// records. Nodes pruned when the tree is finalized are not subtracted.
+	CounterNodesCreated
+)
+
+var counterNames = map[Counter]string{
+	CounterInserts:      "inserts",
+	CounterMerges:       "merges",
+	CounterDedupHits:    "dedup_hits",
+	CounterNodesCreated: "nodes_created",
+}
+
+// String returns the name of the counter, e.g., "inserts".
+func (c Counter) String() string {
+	return counterNames[c]
+}
+
+// Timer is a duration reported to Options.Metrics.
+type Timer int
+
+const (
+	// TimerFinalize is the time taken to finalize the tree.
+	TimerFinalize Timer = iota + 1
+	// TimerWrite is the time taken by WriteTo and WriteToAt, including
+	// finalizing the tree if necessary.
+	TimerWrite
+)
+
+var timerNames = map[Timer]string{
+	TimerFinalize: "finalize",
+	TimerWrite:    "write",
+}
+
+// String returns the name of the timer, e.g., "finalize".
+func (t Timer) String() string {
+	return timerNames[t]
+}
+
+// Metrics receives counts and durations from a tree, e.g., to export them to
+// a monitoring system. The methods are called while the tree's lock is
+// held, so they must be fast and must not call methods on the tree.
+type Metrics interface {
+	// Add adds delta to the counter.
+	Add(counter Counter, delta int)
+	// Observe records a duration for the timer.
+	Observe(timer Timer, d time.Duration)
+}
+
+// addMetric adds delta to the counter if m is not nil.
+func addMetric(m Metrics, counter Counter, delta int) {
+	if m != nil {
+		m.Add(counter, delta)
+	}
+}
+
+// observeSince records the time since start for the timer if m is not nil.
+func observeSince(m Metrics, timer Timer, start time.Time) {
+	if m != nil {
+		m.Observe(timer, time.Since(start))
+	}
+}
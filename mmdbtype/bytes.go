@@ -0,0 +1,52 @@
+package mmdbtype
+
+import (
+	"encoding/base64"
+	"encoding/hex"
+
+	"github.com/pkg/errors"
+)
+
+// BytesOf returns a Bytes value with a copy of b. Converting a []byte with
+// Bytes(b) shares the slice, so a later change to b would change the value
+// stored in the tree. An error is returned if b is too large to be encoded.
+func BytesOf(b []byte) (Bytes, error) {
+	if len(b) > maxSize {
+		return nil, errors.Errorf("the Bytes is %d bytes; the maximum is %d", len(b), maxSize)
+	}
+	nv := make(Bytes, len(b))
+	copy(nv, b)
+	return nv, nil
+}
+
+// BytesFromHex returns the Bytes value for the hex-encoded string, e.g.,
+// "0a1b". An error is returned if s is not valid hex or if the result is too
+// large to be encoded.
+func BytesFromHex(s string) (Bytes, error) {
+	b, err := hex.DecodeString(s)
+	if err != nil {
+		return nil, errors.Wrap(err, "error decoding hex")
+	}
+	return BytesOf(b)
+}
+
+// BytesFromBase64 returns the Bytes value for the string in the standard,
+// padded base64 encoding. An error is returned if s is not valid base64 or
+// if the result is too large to be encoded.
+func BytesFromBase64(s string) (Bytes, error) {
+	b, err := base64.StdEncoding.DecodeString(s)
+	if err != nil {
+		return nil, errors.Wrap(err, "error decoding base64")
+	}
+	return BytesOf(b)
+}
+
+// Hex returns the hex encoding of the value.
+func (t Bytes) Hex() string {
+	return hex.EncodeToString(t)
+}
+
+// Base64 returns the standard, padded base64 encoding of the value.
+func (t Bytes) Base64() string {
+	return base64.StdEncoding.EncodeToString(t)
+}
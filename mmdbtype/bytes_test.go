@@ -0,0 +1,37 @@
+package mmdbtype
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestBytesOf(t *testing.T) {
+	b := []byte{1, 2, 3}
+	v, err := BytesOf(b)
+	require.NoError(t, err)
+	b[0] = 9
+	assert.Equal(t, Bytes{1, 2, 3}, v)
+
+	_, err = BytesOf(make([]byte, maxSize+1))
+	assert.EqualError(t, err, "the Bytes is 16843038 bytes; the maximum is 16843037")
+}
+
+func TestBytesEncodings(t *testing.T) {
+	v, err := BytesFromHex("0a1bff")
+	require.NoError(t, err)
+	assert.Equal(t, Bytes{0x0a, 0x1b, 0xff}, v)
+	assert.Equal(t, "0a1bff", v.Hex())
+
+	v, err = BytesFromBase64("Chv/")
+	require.NoError(t, err)
+	assert.Equal(t, Bytes{0x0a, 0x1b, 0xff}, v)
+	assert.Equal(t, "Chv/", v.Base64())
+
+	_, err = BytesFromHex("0g")
+	assert.EqualError(t, err, "error decoding hex: encoding/hex: invalid byte: U+0067 'g'")
+
+	_, err = BytesFromBase64("C")
+	assert.EqualError(t, err, "error decoding base64: illegal base64 data at input byte 0")
+}
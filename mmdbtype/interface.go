@@ -0,0 +1,98 @@
+package mmdbtype
+
+import (
+	"math"
+	"math/big"
+
+	"github.com/pkg/errors"
+)
+
+// FromInterface converts a value decoded into an interface{} by
+// github.com/oschwald/maxminddb-golang to a DataType, e.g., to copy records
+// from an existing database into a tree. The Go types are converted as
+// follows:
+//
+//	bool                      Bool
+//	string                    String
+//	[]byte                    Bytes
+//	float32                   Float32
+//	float64                   Float64
+//	int                       Int32 (an error is returned if out of range)
+//	uint64                    Uint32 if it fits, otherwise Uint64
+//	*big.Int                  Uint128 (an error is returned if out of range)
+//	map[string]interface{}    Map
+//	[]interface{}             Slice
+//
+// As the reader decodes all of the unsigned integer types other than uint128
+// to uint64, the original type cannot be recovered. Uint32 is used where
+// possible as it is the type used for most integers in the GeoIP2 databases.
+// Use Marshal with a struct to control the types, or mmdbwriter.Load to
+// copy a whole database with its types intact.
+//
+// Values that already implement DataType are returned as is. Map members
+// with a nil value are omitted, and a nil element of a Slice results in an
+// error as a Slice may not contain nil values. A top-level nil value returns
+// a nil DataType, which represents an empty record.
+func FromInterface(v interface{}) (DataType, error) {
+	switch v := v.(type) {
+	case nil:
+		return nil, nil
+	case DataType:
+		return v, nil
+	case bool:
+		return Bool(v), nil
+	case string:
+		return String(v), nil
+	case []byte:
+		b := make(Bytes, len(v))
+		copy(b, v)
+		return b, nil
+	case float32:
+		return Float32(v), nil
+	case float64:
+		return Float64(v), nil
+	case int:
+		if v < math.MinInt32 || v > math.MaxInt32 {
+			return nil, errors.Errorf("cannot convert %d to an Int32; the value is out of range", v)
+		}
+		return Int32(v), nil
+	case uint64:
+		if v <= math.MaxUint32 {
+			return Uint32(v), nil
+		}
+		return Uint64(v), nil
+	case *big.Int:
+		if v == nil {
+			return nil, nil
+		}
+		return marshalBigInt(v)
+	case map[string]interface{}:
+		m := make(Map, len(v))
+		for k, e := range v {
+			dt, err := FromInterface(e)
+			if err != nil {
+				return nil, errors.WithMessagef(err, "error converting the value for key %q", k)
+			}
+			if dt == nil {
+				continue
+			}
+			m[String(k)] = dt
+		}
+		return m, nil
+	case []interface{}:
+		s := make(Slice, len(v))
+		for i, e := range v {
+			dt, err := FromInterface(e)
+			if err != nil {
+				return nil, errors.WithMessagef(err, "error converting element %d", i)
+			}
+			if dt == nil {
+				return nil, errors.Errorf("cannot convert a Slice; element %d is nil", i)
+			}
+			s[i] = dt
+		}
+		return s, nil
+	default:
+		return nil, errors.Errorf("cannot convert values of type %T; use Marshal for Go values", v)
+	}
+}
@@ -0,0 +1,85 @@
+package mmdbtype
+
+import (
+	"math"
+	"math/big"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestFromInterface(t *testing.T) {
+	bigInt, _ := new(big.Int).SetString("18446744073709551616", 10)
+	uint128 := Uint128(*bigInt)
+
+	tests := []struct {
+		name        string
+		value       interface{}
+		expected    DataType
+		expectedErr string
+	}{
+		{name: "nil", value: nil, expected: nil},
+		{name: "bool", value: true, expected: Bool(true)},
+		{name: "string", value: "a", expected: String("a")},
+		{name: "bytes", value: []byte{1, 2}, expected: Bytes{1, 2}},
+		{name: "float32", value: float32(1.5), expected: Float32(1.5)},
+		{name: "float64", value: 2.5, expected: Float64(2.5)},
+		{name: "int", value: -5, expected: Int32(-5)},
+		{
+			name:        "int out of range",
+			value:       math.MaxInt32 + 1,
+			expectedErr: "cannot convert 2147483648 to an Int32; the value is out of range",
+		},
+		{name: "small uint64", value: uint64(13335), expected: Uint32(13335)},
+		{name: "large uint64", value: uint64(math.MaxUint32 + 1), expected: Uint64(math.MaxUint32 + 1)},
+		{name: "big.Int", value: bigInt, expected: &uint128},
+		{
+			name:        "negative big.Int",
+			value:       big.NewInt(-1),
+			expectedErr: "cannot marshal -1 as a Uint128; the value is out of range",
+		},
+		{name: "DataType", value: Uint16(1), expected: Uint16(1)},
+		{
+			name: "map",
+			value: map[string]interface{}{
+				"country": map[string]interface{}{
+					"geoname_id": uint64(2921044),
+					"iso_code":   "DE",
+				},
+				"subdivisions": []interface{}{map[string]interface{}{"iso_code": "BE"}},
+				"missing":      nil,
+			},
+			expected: Map{
+				"country": Map{
+					"geoname_id": Uint32(2921044),
+					"iso_code":   String("DE"),
+				},
+				"subdivisions": Slice{Map{"iso_code": String("BE")}},
+			},
+		},
+		{
+			name:        "nil element",
+			value:       []interface{}{"a", nil},
+			expectedErr: "cannot convert a Slice; element 1 is nil",
+		},
+		{
+			name:  "nested error",
+			value: map[string]interface{}{"a": []interface{}{int8(1)}},
+			expectedErr: `error converting the value for key "a": error converting element 0: ` +
+				"cannot convert values of type int8; use Marshal for Go values",
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			dt, err := FromInterface(test.value)
+			if test.expectedErr != "" {
+				assert.EqualError(t, err, test.expectedErr)
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, test.expected, dt)
+		})
+	}
+}
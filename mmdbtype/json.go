@@ -0,0 +1,172 @@
+package mmdbtype
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+	"math"
+	"math/big"
+	"strconv"
+
+	"github.com/pkg/errors"
+)
+
+// JSONNumberType determines the DataType used for JSON numbers by FromJSON.
+type JSONNumberType int
+
+const (
+	// JSONNumberAuto converts integers to a Uint32 if they are non-negative
+	// and fit, an Int32 if they are negative and fit, and a Uint64 or
+	// Uint128 if they are too large for a Uint32. Numbers with a fractional
+	// part or an exponent are converted to a Float64. This is the default.
+	JSONNumberAuto JSONNumberType = iota
+	// JSONNumberFloat64 converts all numbers to a Float64.
+	JSONNumberFloat64
+	// JSONNumberInt32 converts all numbers to an Int32. An error is returned
+	// for numbers that are not integers or that are out of range.
+	JSONNumberInt32
+	// JSONNumberUint32 converts all numbers to a Uint32. An error is
+	// returned for numbers that are not integers or that are out of range.
+	JSONNumberUint32
+	// JSONNumberUint64 converts all numbers to a Uint64. An error is
+	// returned for numbers that are not integers or that are out of range.
+	JSONNumberUint64
+)
+
+type jsonOptions struct {
+	numberType JSONNumberType
+}
+
+// JSONOption is an option for FromJSON.
+type JSONOption func(*jsonOptions)
+
+// JSONNumbers sets the DataType that FromJSON uses for JSON numbers.
+func JSONNumbers(t JSONNumberType) JSONOption {
+	return func(o *jsonOptions) {
+		o.numberType = t
+	}
+}
+
+// FromJSON converts a single JSON value to a DataType. Objects are converted
+// to a Map, arrays to a Slice, strings to a String, and booleans to a Bool.
+// Numbers are converted according to the JSONNumbers option.
+//
+// Object members with a null value are omitted. A null value in an array
+// results in an error as a Slice may not contain nil values. A top-level
+// null value returns a nil DataType, which represents an empty record.
+//
+// To convert JSON Lines input, call FromJSON on each line.
+func FromJSON(data []byte, options ...JSONOption) (DataType, error) {
+	opts := &jsonOptions{}
+	for _, option := range options {
+		option(opts)
+	}
+
+	dec := json.NewDecoder(bytes.NewReader(data))
+	dec.UseNumber()
+
+	var v interface{}
+	if err := dec.Decode(&v); err != nil {
+		return nil, errors.Wrap(err, "error decoding JSON")
+	}
+	if _, err := dec.Token(); !errors.Is(err, io.EOF) {
+		return nil, errors.New("error decoding JSON: unexpected data after top-level value")
+	}
+
+	return opts.fromJSONValue(v)
+}
+
+func (o *jsonOptions) fromJSONValue(v interface{}) (DataType, error) {
+	switch v := v.(type) {
+	case nil:
+		return nil, nil
+	case bool:
+		return Bool(v), nil
+	case string:
+		return String(v), nil
+	case json.Number:
+		return o.fromJSONNumber(v)
+	case []interface{}:
+		s := make(Slice, len(v))
+		for i, e := range v {
+			dt, err := o.fromJSONValue(e)
+			if err != nil {
+				return nil, err
+			}
+			if dt == nil {
+				return nil, errors.Errorf("cannot convert JSON array; element %d is null", i)
+			}
+			s[i] = dt
+		}
+		return s, nil
+	case map[string]interface{}:
+		m := make(Map, len(v))
+		for k, e := range v {
+			dt, err := o.fromJSONValue(e)
+			if err != nil {
+				return nil, err
+			}
+			if dt == nil {
+				continue
+			}
+			m[String(k)] = dt
+		}
+		return m, nil
+	default:
+		// This shouldn't happen as encoding/json only produces the above.
+		return nil, errors.Errorf("unexpected JSON value of type %T", v)
+	}
+}
+
+func (o *jsonOptions) fromJSONNumber(n json.Number) (DataType, error) {
+	if o.numberType == JSONNumberFloat64 {
+		f, err := n.Float64()
+		if err != nil {
+			return nil, errors.Wrapf(err, "error converting JSON number %s to a Float64", n)
+		}
+		return Float64(f), nil
+	}
+
+	i, isInt := new(big.Int).SetString(string(n), 10)
+
+	switch o.numberType {
+	case JSONNumberInt32:
+		if !isInt || !i.IsInt64() || i.Int64() < math.MinInt32 || i.Int64() > math.MaxInt32 {
+			return nil, errors.Errorf("cannot convert JSON number %s to an Int32", n)
+		}
+		return Int32(i.Int64()), nil
+	case JSONNumberUint32:
+		if !isInt || !i.IsUint64() || i.Uint64() > math.MaxUint32 {
+			return nil, errors.Errorf("cannot convert JSON number %s to a Uint32", n)
+		}
+		return Uint32(i.Uint64()), nil
+	case JSONNumberUint64:
+		if !isInt || !i.IsUint64() {
+			return nil, errors.Errorf("cannot convert JSON number %s to a Uint64", n)
+		}
+		return Uint64(i.Uint64()), nil
+	case JSONNumberAuto:
+		if !isInt {
+			f, err := strconv.ParseFloat(string(n), 64)
+			if err != nil {
+				return nil, errors.Wrapf(err, "error converting JSON number %s to a Float64", n)
+			}
+			return Float64(f), nil
+		}
+		switch {
+		case i.IsUint64() && i.Uint64() <= math.MaxUint32:
+			return Uint32(i.Uint64()), nil
+		case i.IsInt64() && i.Int64() < 0 && i.Int64() >= math.MinInt32:
+			return Int32(i.Int64()), nil
+		case i.IsUint64():
+			return Uint64(i.Uint64()), nil
+		case i.Sign() > 0 && i.Cmp(maxUint128) <= 0:
+			u := Uint128(*i)
+			return &u, nil
+		default:
+			return nil, errors.Errorf("cannot convert JSON number %s; the integer is out of range", n)
+		}
+	default:
+		return nil, errors.Errorf("unknown JSONNumberType: %d", o.numberType)
+	}
+}
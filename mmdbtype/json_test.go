@@ -0,0 +1,120 @@
+package mmdbtype
+
+import (
+	"math/big"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestFromJSON(t *testing.T) {
+	bigInt, _ := new(big.Int).SetString("18446744073709551616", 10)
+	uint128 := Uint128(*bigInt)
+
+	tests := []struct {
+		name        string
+		json        string
+		numberType  JSONNumberType
+		expected    DataType
+		expectedErr string
+	}{
+		{
+			name: "object",
+			json: `{"country": {"iso_code": "DE", "names": ["Germany"]}, "eu": true, "missing": null}`,
+			expected: Map{
+				"country": Map{
+					"iso_code": String("DE"),
+					"names":    Slice{String("Germany")},
+				},
+				"eu": Bool(true),
+			},
+		},
+		{
+			name: "auto numbers",
+			json: `[0, 4294967295, 4294967296, -1, 1.5, 1e3, 18446744073709551616]`,
+			expected: Slice{
+				Uint32(0),
+				Uint32(4294967295),
+				Uint64(4294967296),
+				Int32(-1),
+				Float64(1.5),
+				Float64(1000),
+				&uint128,
+			},
+		},
+		{
+			name:        "auto numbers, out of range",
+			json:        `-2147483649`,
+			expectedErr: "cannot convert JSON number -2147483649; the integer is out of range",
+		},
+		{
+			name:       "float64 numbers",
+			json:       `[1, -1, 1.5]`,
+			numberType: JSONNumberFloat64,
+			expected:   Slice{Float64(1), Float64(-1), Float64(1.5)},
+		},
+		{
+			name:       "int32 numbers",
+			json:       `[1, -1]`,
+			numberType: JSONNumberInt32,
+			expected:   Slice{Int32(1), Int32(-1)},
+		},
+		{
+			name:        "int32 numbers, fraction",
+			json:        `1.5`,
+			numberType:  JSONNumberInt32,
+			expectedErr: "cannot convert JSON number 1.5 to an Int32",
+		},
+		{
+			name:       "uint32 numbers",
+			json:       `{"asn": 13335}`,
+			numberType: JSONNumberUint32,
+			expected:   Map{"asn": Uint32(13335)},
+		},
+		{
+			name:        "uint32 numbers, negative",
+			json:        `-1`,
+			numberType:  JSONNumberUint32,
+			expectedErr: "cannot convert JSON number -1 to a Uint32",
+		},
+		{
+			name:       "uint64 numbers",
+			json:       `4294967296`,
+			numberType: JSONNumberUint64,
+			expected:   Uint64(4294967296),
+		},
+		{
+			name:     "null",
+			json:     `null`,
+			expected: nil,
+		},
+		{
+			name:        "null in array",
+			json:        `["a", null]`,
+			expectedErr: "cannot convert JSON array; element 1 is null",
+		},
+		{
+			name:        "trailing data",
+			json:        `{} {}`,
+			expectedErr: "error decoding JSON: unexpected data after top-level value",
+		},
+		{
+			name:        "invalid",
+			json:        `{`,
+			expectedErr: "error decoding JSON: unexpected EOF",
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			dt, err := FromJSON([]byte(test.json), JSONNumbers(test.numberType))
+			if test.expectedErr != "" {
+				assert.EqualError(t, err, test.expectedErr)
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, test.expected, dt)
+		})
+	}
+}
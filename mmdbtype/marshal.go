@@ -0,0 +1,316 @@
+package mmdbtype
+
+import (
+	"math"
+	"math/big"
+	"reflect"
+	"strings"
+	"sync"
+
+	"github.com/pkg/errors"
+)
+
+var (
+	bigIntType    = reflect.TypeOf(big.Int{})
+	dataTypeType  = reflect.TypeOf((*DataType)(nil)).Elem()
+	marshalerType = reflect.TypeOf((*Marshaler)(nil)).Elem()
+	maxUint128    = new(big.Int).Sub(new(big.Int).Lsh(big.NewInt(1), 128), big.NewInt(1))
+)
+
+// Marshaler is implemented by types that convert themselves to a DataType,
+// e.g., an ASN type that is stored as a Map with specific keys. Marshal
+// calls MarshalMMDB rather than converting the value itself. Returning a nil
+// DataType is the same as a nil value; it is omitted from Map values.
+type Marshaler interface {
+	MarshalMMDB() (DataType, error)
+}
+
+// Marshal converts a Go value into a DataType.
+//
+// Structs are converted to a Map. By default, the key for a field is the
+// field name. This may be changed with an `mmdb:"name"` struct tag. If there
+// is no mmdb tag, the `maxminddb` tag used by
+// github.com/oschwald/maxminddb-golang is used instead. A tag of "-" skips
+// the field and the "omitempty" option, e.g., `mmdb:"name,omitempty"`, skips
+// the field if it has the zero value. Unexported fields are skipped and the
+// fields of embedded structs without a tag are added to the parent Map.
+//
+// Maps with string keys are converted to a Map, []byte to Bytes, and other
+// slices and arrays to a Slice. Pointers and interfaces are converted to the
+// value they refer to. nil pointers, interfaces, maps, and slices are
+// omitted from Map values. They may not be used as the top-level value or
+// as an element of a Slice.
+//
+// Scalars are converted as follows:
+//
+//	bool                      Bool
+//	string                    String
+//	float32                   Float32
+//	float64                   Float64
+//	int, int8, ..., int64     Int32 (an error is returned if out of range)
+//	uint8, uint16             Uint16
+//	uint32                    Uint32
+//	uint, uint64, uintptr     Uint64
+//	big.Int                   Uint128 (an error is returned if out of range)
+//
+// Values that already implement DataType are returned as is and values that
+// implement Marshaler are converted by their MarshalMMDB method. As with
+// encoding/json, MarshalMMDB methods with a pointer receiver are only used
+// for addressable values, e.g., fields of a struct passed by pointer.
+func Marshal(v interface{}) (DataType, error) {
+	dt, err := marshal(reflect.ValueOf(v))
+	if err != nil {
+		return nil, err
+	}
+	if dt == nil {
+		return nil, errors.New("cannot marshal a nil value")
+	}
+	return dt, nil
+}
+
+func marshal(v reflect.Value) (DataType, error) {
+	if !v.IsValid() {
+		return nil, nil
+	}
+
+	if v.Type().Implements(dataTypeType) {
+		if (v.Kind() == reflect.Ptr || v.Kind() == reflect.Interface) && v.IsNil() {
+			return nil, nil
+		}
+		return v.Interface().(DataType), nil
+	}
+
+	if v.Type().Implements(marshalerType) {
+		if (v.Kind() == reflect.Ptr || v.Kind() == reflect.Interface) && v.IsNil() {
+			return nil, nil
+		}
+		return marshalMarshaler(v)
+	}
+	if v.Kind() != reflect.Ptr && v.CanAddr() && reflect.PtrTo(v.Type()).Implements(marshalerType) {
+		return marshalMarshaler(v.Addr())
+	}
+
+	if v.Type() == bigIntType {
+		var i *big.Int
+		if v.CanAddr() {
+			i = v.Addr().Interface().(*big.Int)
+		} else {
+			bi := v.Interface().(big.Int)
+			i = &bi
+		}
+		return marshalBigInt(i)
+	}
+
+	// This is a common case, so we avoid going through reflection for
+	// each byte.
+	if v.Kind() == reflect.Slice && v.Type().Elem().Kind() == reflect.Uint8 {
+		if v.IsNil() {
+			return nil, nil
+		}
+		b := make(Bytes, v.Len())
+		copy(b, v.Bytes())
+		return b, nil
+	}
+
+	switch v.Kind() {
+	case reflect.Bool:
+		return Bool(v.Bool()), nil
+	case reflect.String:
+		return String(v.String()), nil
+	case reflect.Float32:
+		return Float32(v.Float()), nil
+	case reflect.Float64:
+		return Float64(v.Float()), nil
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		i := v.Int()
+		if i < math.MinInt32 || i > math.MaxInt32 {
+			return nil, errors.Errorf("cannot marshal %d as an Int32; the value is out of range", i)
+		}
+		return Int32(i), nil
+	case reflect.Uint8, reflect.Uint16:
+		return Uint16(v.Uint()), nil
+	case reflect.Uint32:
+		return Uint32(v.Uint()), nil
+	case reflect.Uint, reflect.Uint64, reflect.Uintptr:
+		return Uint64(v.Uint()), nil
+	case reflect.Ptr, reflect.Interface:
+		if v.IsNil() {
+			return nil, nil
+		}
+		return marshal(v.Elem())
+	case reflect.Map:
+		return marshalMap(v)
+	case reflect.Slice, reflect.Array:
+		if v.Kind() == reflect.Slice && v.IsNil() {
+			return nil, nil
+		}
+		return marshalSlice(v)
+	case reflect.Struct:
+		m := Map{}
+		if err := marshalStruct(v, m); err != nil {
+			return nil, err
+		}
+		return m, nil
+	default:
+		return nil, errors.Errorf("cannot marshal values of type %s", v.Type())
+	}
+}
+
+func marshalMarshaler(v reflect.Value) (DataType, error) {
+	dt, err := v.Interface().(Marshaler).MarshalMMDB()
+	if err != nil {
+		return nil, errors.WithMessagef(err, "error calling MarshalMMDB for %s", v.Type())
+	}
+	return dt, nil
+}
+
+func marshalBigInt(i *big.Int) (DataType, error) {
+	if i.Sign() < 0 || i.Cmp(maxUint128) > 0 {
+		return nil, errors.Errorf("cannot marshal %s as a Uint128; the value is out of range", i)
+	}
+	u := Uint128(*new(big.Int).Set(i))
+	return &u, nil
+}
+
+func marshalMap(v reflect.Value) (DataType, error) {
+	if v.Type().Key().Kind() != reflect.String {
+		return nil, errors.Errorf("cannot marshal %s; map keys must be strings", v.Type())
+	}
+	if v.IsNil() {
+		return nil, nil
+	}
+
+	m := make(Map, v.Len())
+	iter := v.MapRange()
+	for iter.Next() {
+		dt, err := marshal(iter.Value())
+		if err != nil {
+			return nil, err
+		}
+		if dt == nil {
+			continue
+		}
+		m[String(iter.Key().String())] = dt
+	}
+	return m, nil
+}
+
+func marshalSlice(v reflect.Value) (DataType, error) {
+	s := make(Slice, v.Len())
+	for i := range s {
+		dt, err := marshal(v.Index(i))
+		if err != nil {
+			return nil, err
+		}
+		if dt == nil {
+			return nil, errors.Errorf("cannot marshal %s; element %d is nil", v.Type(), i)
+		}
+		s[i] = dt
+	}
+	return s, nil
+}
+
+func marshalStruct(v reflect.Value, m Map) error {
+	for _, f := range cachedFields(v.Type()) {
+		fv := v.Field(f.index)
+		if f.embedded {
+			for fv.Kind() == reflect.Ptr {
+				if fv.IsNil() {
+					break
+				}
+				fv = fv.Elem()
+			}
+			if fv.Kind() == reflect.Struct {
+				if err := marshalStruct(fv, m); err != nil {
+					return err
+				}
+			}
+			continue
+		}
+
+		if f.omitEmpty && fv.IsZero() {
+			continue
+		}
+
+		dt, err := marshal(fv)
+		if err != nil {
+			return errors.WithMessagef(err, "error marshaling field %s", f.goName)
+		}
+		if dt == nil {
+			continue
+		}
+		m[String(f.name)] = dt
+	}
+	return nil
+}
+
+type structField struct {
+	name      string
+	goName    string
+	index     int
+	embedded  bool
+	omitEmpty bool
+}
+
+var fieldCache sync.Map
+
+// cachedFields returns the fields of the struct type that should be used
+// when converting to and from a Map.
+func cachedFields(t reflect.Type) []structField {
+	if fields, ok := fieldCache.Load(t); ok {
+		return fields.([]structField)
+	}
+
+	var fields []structField
+	for i := 0; i < t.NumField(); i++ {
+		sf := t.Field(i)
+
+		tag, ok := sf.Tag.Lookup("mmdb")
+		if !ok {
+			tag = sf.Tag.Get("maxminddb")
+		}
+		if tag == "-" {
+			continue
+		}
+
+		name := tag
+		var opts string
+		if idx := strings.Index(tag, ","); idx != -1 {
+			name, opts = tag[:idx], tag[idx+1:]
+		}
+
+		if sf.Anonymous && name == "" {
+			ft := sf.Type
+			isPtr := ft.Kind() == reflect.Ptr
+			if isPtr {
+				ft = ft.Elem()
+			}
+			// Similar to encoding/json, the exported fields of embedded
+			// structs with unexported types are still used. This doesn't
+			// work for pointers to such types.
+			if ft.Kind() == reflect.Struct && (!isPtr || sf.PkgPath == "") {
+				fields = append(fields, structField{goName: sf.Name, index: i, embedded: true})
+				continue
+			}
+		}
+
+		if sf.PkgPath != "" {
+			// unexported
+			continue
+		}
+
+		if name == "" {
+			name = sf.Name
+		}
+
+		fields = append(fields, structField{
+			name:      name,
+			goName:    sf.Name,
+			index:     i,
+			omitEmpty: opts == "omitempty",
+		})
+	}
+
+	fieldCache.Store(t, fields)
+	return fields
+}
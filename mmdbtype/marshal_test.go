@@ -0,0 +1,211 @@
+package mmdbtype
+
+import (
+	"math/big"
+	"testing"
+
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type testNames struct {
+	EN string `mmdb:"en"`
+	DE string `mmdb:"de,omitempty"`
+}
+
+type testBase struct {
+	GeoNameID uint32 `maxminddb:"geoname_id"`
+}
+
+type testCity struct {
+	testBase
+	Names       testNames         `mmdb:"names"`
+	Confidence  *uint16           `mmdb:"confidence"`
+	Population  uint64            `mmdb:"population"`
+	Elevation   int               `mmdb:"elevation"`
+	Latitude    float64           `mmdb:"latitude"`
+	Accuracy    float32           `mmdb:"accuracy"`
+	IsCapital   bool              `mmdb:"is_capital"`
+	Postcodes   []string          `mmdb:"postcodes"`
+	Hash        []byte            `mmdb:"hash"`
+	Extra       map[string]string `mmdb:"extra"`
+	Custom      DataType          `mmdb:"custom"`
+	Ignored     string            `mmdb:"-"`
+	Untagged    string
+	unexported  string
+	EmptySlice  []string `mmdb:"empty_slice"`
+	OmittedZero uint32   `mmdb:"omitted_zero,omitempty"`
+}
+
+func TestMarshal(t *testing.T) {
+	bigInt := new(big.Int).Lsh(big.NewInt(1), 100)
+	uint128 := Uint128(*bigInt)
+
+	tests := []struct {
+		name        string
+		value       interface{}
+		expected    DataType
+		expectedErr string
+	}{
+		{name: "bool", value: true, expected: Bool(true)},
+		{name: "string", value: "a", expected: String("a")},
+		{name: "float32", value: float32(1.5), expected: Float32(1.5)},
+		{name: "float64", value: 1.5, expected: Float64(1.5)},
+		{name: "int", value: -5, expected: Int32(-5)},
+		{name: "uint8", value: uint8(5), expected: Uint16(5)},
+		{name: "uint16", value: uint16(5), expected: Uint16(5)},
+		{name: "uint32", value: uint32(5), expected: Uint32(5)},
+		{name: "uint", value: uint(5), expected: Uint64(5)},
+		{name: "big.Int", value: bigInt, expected: &uint128},
+		{name: "bytes", value: []byte{1, 2}, expected: Bytes{1, 2}},
+		{name: "array", value: [2]string{"a", "b"}, expected: Slice{String("a"), String("b")}},
+		{name: "DataType", value: Map{"a": Bool(true)}, expected: Map{"a": Bool(true)}},
+		{
+			name:     "map",
+			value:    map[string]interface{}{"a": 1, "b": nil},
+			expected: Map{"a": Int32(1)},
+		},
+		{
+			name: "struct",
+			value: &testCity{
+				testBase:   testBase{GeoNameID: 2643743},
+				Names:      testNames{EN: "London"},
+				Population: 8982000,
+				Elevation:  -2,
+				Latitude:   51.5072,
+				Accuracy:   0.5,
+				IsCapital:  true,
+				Postcodes:  []string{"EC1A", "W1A"},
+				Hash:       []byte{0xAB},
+				Extra:      map[string]string{"k": "v"},
+				Custom:     Uint64(1),
+				Ignored:    "ignored",
+				Untagged:   "untagged",
+				unexported: "unexported",
+				EmptySlice: []string{},
+			},
+			expected: Map{
+				"geoname_id":  Uint32(2643743),
+				"names":       Map{"en": String("London")},
+				"population":  Uint64(8982000),
+				"elevation":   Int32(-2),
+				"latitude":    Float64(51.5072),
+				"accuracy":    Float32(0.5),
+				"is_capital":  Bool(true),
+				"postcodes":   Slice{String("EC1A"), String("W1A")},
+				"hash":        Bytes{0xAB},
+				"extra":       Map{"k": String("v")},
+				"custom":      Uint64(1),
+				"Untagged":    String("untagged"),
+				"empty_slice": Slice{},
+			},
+		},
+		{
+			name:        "nil",
+			value:       nil,
+			expectedErr: "cannot marshal a nil value",
+		},
+		{
+			name:        "int out of range",
+			value:       int64(1) << 40,
+			expectedErr: "cannot marshal 1099511627776 as an Int32; the value is out of range",
+		},
+		{
+			name:        "negative big.Int",
+			value:       big.NewInt(-1),
+			expectedErr: "cannot marshal -1 as a Uint128; the value is out of range",
+		},
+		{
+			name:        "non-string map key",
+			value:       map[int]string{1: "a"},
+			expectedErr: "cannot marshal map[int]string; map keys must be strings",
+		},
+		{
+			name:        "nil slice element",
+			value:       []*string{nil},
+			expectedErr: "cannot marshal []*string; element 0 is nil",
+		},
+		{
+			name:        "unsupported type",
+			value:       struct{ C chan int }{C: make(chan int)},
+			expectedErr: "error marshaling field C: cannot marshal values of type chan int",
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			dt, err := Marshal(test.value)
+			if test.expectedErr != "" {
+				assert.EqualError(t, err, test.expectedErr)
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, test.expected, dt)
+		})
+	}
+}
+
+type testASN struct {
+	Number uint32
+	Org    string
+}
+
+func (a testASN) MarshalMMDB() (DataType, error) {
+	if a.Number == 0 {
+		return nil, errors.New("the ASN is missing")
+	}
+	return Map{
+		"autonomous_system_number":       Uint32(a.Number),
+		"autonomous_system_organization": String(a.Org),
+	}, nil
+}
+
+type testPtrMarshaler struct{}
+
+func (*testPtrMarshaler) MarshalMMDB() (DataType, error) {
+	return String("pointer"), nil
+}
+
+func TestMarshaler(t *testing.T) {
+	type record struct {
+		ASN     testASN            `mmdb:"asn"`
+		Missing *testASN           `mmdb:"missing"`
+		Ptr     testPtrMarshaler   `mmdb:"ptr"`
+		Slice   []testPtrMarshaler `mmdb:"slice"`
+	}
+
+	dt, err := Marshal(&record{
+		ASN:   testASN{Number: 13335, Org: "Cloudflare"},
+		Slice: []testPtrMarshaler{{}},
+	})
+	require.NoError(t, err)
+	assert.Equal(
+		t,
+		Map{
+			"asn": Map{
+				"autonomous_system_number":       Uint32(13335),
+				"autonomous_system_organization": String("Cloudflare"),
+			},
+			"ptr":   String("pointer"),
+			"slice": Slice{String("pointer")},
+		},
+		dt,
+	)
+
+	_, err = Marshal(record{})
+	assert.EqualError(
+		t,
+		err,
+		"error marshaling field ASN: error calling MarshalMMDB for mmdbtype.testASN: the ASN is missing",
+	)
+}
+
+func TestMarshalDoesNotAliasBytes(t *testing.T) {
+	b := []byte{1, 2, 3}
+	dt, err := Marshal(b)
+	require.NoError(t, err)
+
+	b[0] = 9
+	assert.Equal(t, Bytes{1, 2, 3}, dt)
+}
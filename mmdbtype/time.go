@@ -0,0 +1,61 @@
+package mmdbtype
+
+import (
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// TimeFormat determines how Time encodes a timestamp.
+type TimeFormat int
+
+const (
+	// TimeEpoch encodes the timestamp as a Uint64 of the seconds since the
+	// Unix epoch, as is done for the build_epoch in the metadata. Fractional
+	// seconds are truncated. This is the default.
+	TimeEpoch TimeFormat = iota
+	// TimeRFC3339 encodes the timestamp as a String in the RFC 3339 format
+	// in UTC, e.g., "2021-03-04T05:06:07Z". Fractional seconds are
+	// truncated.
+	TimeRFC3339
+)
+
+// Time encodes the timestamp in the format. Using the same format
+// throughout a database allows readers to decode every timestamp the same
+// way. An error is returned for timestamps before the Unix epoch with
+// TimeEpoch and for unsupported formats.
+func Time(t time.Time, format TimeFormat) (DataType, error) {
+	switch format {
+	case TimeEpoch:
+		epoch := t.Unix()
+		if epoch < 0 {
+			return nil, errors.Errorf("the time %s is before the Unix epoch", t.Format(time.RFC3339))
+		}
+		return Uint64(epoch), nil
+	case TimeRFC3339:
+		return String(t.UTC().Format(time.RFC3339)), nil
+	default:
+		return nil, errors.Errorf("unsupported TimeFormat: %d", format)
+	}
+}
+
+// ParseTime decodes a timestamp encoded by Time in the format.
+func ParseTime(dt DataType, format TimeFormat) (time.Time, error) {
+	switch format {
+	case TimeEpoch:
+		epoch, ok := dt.(Uint64)
+		if !ok {
+			return time.Time{}, errors.Errorf("expected a Uint64 for a TimeEpoch timestamp but got a %T", dt)
+		}
+		return time.Unix(int64(epoch), 0).UTC(), nil
+	case TimeRFC3339:
+		s, ok := dt.(String)
+		if !ok {
+			return time.Time{}, errors.Errorf("expected a String for a TimeRFC3339 timestamp but got a %T", dt)
+		}
+		t, err := time.Parse(time.RFC3339, string(s))
+		return t, errors.Wrap(err, "error parsing timestamp")
+	default:
+		return time.Time{}, errors.Errorf("unsupported TimeFormat: %d", format)
+	}
+}
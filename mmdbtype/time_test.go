@@ -0,0 +1,39 @@
+package mmdbtype
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestTime(t *testing.T) {
+	ts := time.Date(2021, 3, 4, 5, 6, 7, 8, time.FixedZone("UTC+1", 3600))
+
+	tests := []struct {
+		format   TimeFormat
+		expected DataType
+	}{
+		{format: TimeEpoch, expected: Uint64(1614830767)},
+		{format: TimeRFC3339, expected: String("2021-03-04T04:06:07Z")},
+	}
+	for _, test := range tests {
+		dt, err := Time(ts, test.format)
+		require.NoError(t, err)
+		assert.Equal(t, test.expected, dt)
+
+		parsed, err := ParseTime(dt, test.format)
+		require.NoError(t, err)
+		assert.True(t, ts.Truncate(time.Second).Equal(parsed), "round trip for format %d", test.format)
+	}
+
+	_, err := Time(time.Date(1969, 12, 31, 0, 0, 0, 0, time.UTC), TimeEpoch)
+	assert.EqualError(t, err, "the time 1969-12-31T00:00:00Z is before the Unix epoch")
+
+	_, err = Time(ts, TimeFormat(5))
+	assert.EqualError(t, err, "unsupported TimeFormat: 5")
+
+	_, err = ParseTime(String("1"), TimeEpoch)
+	assert.EqualError(t, err, "expected a Uint64 for a TimeEpoch timestamp but got a mmdbtype.String")
+}
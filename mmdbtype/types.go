@@ -2,8 +2,10 @@
 package mmdbtype
 
 import (
+	"bytes"
 	"encoding/binary"
 	"io"
+	"math"
 	"math/big"
 	"math/bits"
 	"sort"
@@ -44,6 +46,7 @@ type writer interface {
 // DataType represents a MaxMind DB data type
 type DataType interface {
 	Copy() DataType
+	Equal(other DataType) bool
 	size() int
 	typeNum() typeNum
 	WriteTo(writer) (int64, error)
@@ -55,6 +58,12 @@ type Bool bool
 // Copy the value
 func (t Bool) Copy() DataType { return t }
 
+// Equal checks whether other is a Bool with the same value.
+func (t Bool) Equal(other DataType) bool {
+	o, ok := other.(Bool)
+	return ok && t == o
+}
+
 func (t Bool) size() int {
 	if t {
 		return 1
@@ -81,6 +90,13 @@ func (t Bytes) Copy() DataType {
 	return nv
 }
 
+// Equal checks whether other is a Bytes with the same contents. A nil Bytes
+// is equal to an empty one.
+func (t Bytes) Equal(other DataType) bool {
+	o, ok := other.(Bytes)
+	return ok && bytes.Equal(t, o)
+}
+
 func (t Bytes) size() int {
 	return len(t)
 }
@@ -110,6 +126,14 @@ type Float32 float32
 // Copy the value
 func (t Float32) Copy() DataType { return t }
 
+// Equal checks whether other is a Float32 with the same value. The values
+// are compared by their bits, as they are when the tree deduplicates values,
+// so NaN is equal to itself and 0 is not equal to -0.
+func (t Float32) Equal(other DataType) bool {
+	o, ok := other.(Float32)
+	return ok && math.Float32bits(float32(t)) == math.Float32bits(float32(o))
+}
+
 func (t Float32) size() int {
 	return 4
 }
@@ -138,6 +162,13 @@ type Float64 float64
 // Copy the value
 func (t Float64) Copy() DataType { return t }
 
+// Equal checks whether other is a Float64 with the same value. As with
+// Float32, the values are compared by their bits.
+func (t Float64) Equal(other DataType) bool {
+	o, ok := other.(Float64)
+	return ok && math.Float64bits(float64(t)) == math.Float64bits(float64(o))
+}
+
 func (t Float64) size() int {
 	return 8
 }
@@ -166,6 +197,12 @@ type Int32 int32
 // Copy the value
 func (t Int32) Copy() DataType { return t }
 
+// Equal checks whether other is a Int32 with the same value.
+func (t Int32) Equal(other DataType) bool {
+	o, ok := other.(Int32)
+	return ok && t == o
+}
+
 func (t Int32) size() int {
 	return 4 - bits.LeadingZeros32(uint32(t))/8
 }
@@ -192,7 +229,9 @@ func (t Int32) WriteTo(w writer) (int64, error) {
 	return numBytes + int64(size), nil
 }
 
-// Map is the MaxMind DB map type
+// Map is the MaxMind DB map type. Its entries are always written in order
+// by key, so the same Map is encoded identically regardless of Go's map
+// iteration order.
 type Map map[String]DataType
 
 // Copy makes a deep copy of the Map
@@ -204,6 +243,21 @@ func (t Map) Copy() DataType {
 	return newMap
 }
 
+// Equal checks whether other is a Map with the same keys and equal values.
+func (t Map) Equal(other DataType) bool {
+	o, ok := other.(Map)
+	if !ok || len(t) != len(o) {
+		return false
+	}
+	for k, v := range t {
+		ov, ok := o[k]
+		if !ok || !equal(v, ov) {
+			return false
+		}
+	}
+	return true
+}
+
 func (t Map) size() int {
 	return len(t)
 }
@@ -259,6 +313,12 @@ const (
 	pointerMaxSize2 = pointerMaxSize1 + (1 << 27)
 )
 
+// Equal checks whether other is a Pointer with the same value.
+func (t Pointer) Equal(other DataType) bool {
+	o, ok := other.(Pointer)
+	return ok && t == o
+}
+
 func (t Pointer) size() int {
 	switch {
 	case t < pointerMaxSize0:
@@ -364,6 +424,21 @@ func (t Slice) Copy() DataType {
 	return newSlice
 }
 
+// Equal checks whether other is a Slice with equal elements in the same
+// order.
+func (t Slice) Equal(other DataType) bool {
+	o, ok := other.(Slice)
+	if !ok || len(t) != len(o) {
+		return false
+	}
+	for i, v := range t {
+		if !equal(v, o[i]) {
+			return false
+		}
+	}
+	return true
+}
+
 func (t Slice) size() int {
 	return len(t)
 }
@@ -395,6 +470,12 @@ type String string
 // Copy the value
 func (t String) Copy() DataType { return t }
 
+// Equal checks whether other is a String with the same value.
+func (t String) Equal(other DataType) bool {
+	o, ok := other.(String)
+	return ok && t == o
+}
+
 func (t String) size() int {
 	return len(t)
 }
@@ -424,6 +505,12 @@ type Uint16 uint16
 // Copy the value
 func (t Uint16) Copy() DataType { return t }
 
+// Equal checks whether other is a Uint16 with the same value.
+func (t Uint16) Equal(other DataType) bool {
+	o, ok := other.(Uint16)
+	return ok && t == o
+}
+
 func (t Uint16) size() int {
 	return 2 - bits.LeadingZeros16(uint16(t))/8
 }
@@ -456,6 +543,12 @@ type Uint32 uint32
 // Copy the value
 func (t Uint32) Copy() DataType { return t }
 
+// Equal checks whether other is a Uint32 with the same value.
+func (t Uint32) Equal(other DataType) bool {
+	o, ok := other.(Uint32)
+	return ok && t == o
+}
+
 func (t Uint32) size() int {
 	return 4 - bits.LeadingZeros32(uint32(t))/8
 }
@@ -488,6 +581,12 @@ type Uint64 uint64
 // Copy the value
 func (t Uint64) Copy() DataType { return t }
 
+// Equal checks whether other is a Uint64 with the same value.
+func (t Uint64) Equal(other DataType) bool {
+	o, ok := other.(Uint64)
+	return ok && t == o
+}
+
 func (t Uint64) size() int {
 	return 8 - bits.LeadingZeros64(uint64(t))/8
 }
@@ -526,6 +625,15 @@ func (t *Uint128) Copy() DataType {
 	return &uv
 }
 
+// Equal checks whether other is a *Uint128 with the same value.
+func (t *Uint128) Equal(other DataType) bool {
+	o, ok := other.(*Uint128)
+	if !ok || t == nil || o == nil {
+		return ok && t == o
+	}
+	return (*big.Int)(t).Cmp((*big.Int)(o)) == 0
+}
+
 func (t *Uint128) size() int {
 	// We add 7 here as we want the ceiling of the division operation rather
 	// than the floor.
@@ -551,6 +659,15 @@ func (t *Uint128) WriteTo(w writer) (int64, error) {
 	return numBytes, nil
 }
 
+// equal compares values that may be nil, as in a Map or Slice that has not
+// been validated.
+func equal(a, b DataType) bool {
+	if a == nil || b == nil {
+		return a == nil && b == nil
+	}
+	return a.Equal(b)
+}
+
 const (
 	firstSize  = 29
 	secondSize = firstSize + 256
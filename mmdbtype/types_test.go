@@ -3,6 +3,7 @@ package mmdbtype
 import (
 	"bytes"
 	"encoding/hex"
+	"math"
 	"math/big"
 	"strings"
 	"testing"
@@ -70,6 +71,27 @@ func TestInt32(t *testing.T) {
 	validateEncoding(t, int32s)
 }
 
+func TestMapEncodingIsSorted(t *testing.T) {
+	m := Map{}
+	for _, k := range []string{"z", "b", "y", "a", "m", "c", "x"} {
+		m[String(k)] = String(k)
+	}
+
+	w := &dataWriter{Buffer: &bytes.Buffer{}}
+	_, err := m.WriteTo(w)
+	require.NoError(t, err)
+	expected := w.String()
+
+	for i := 0; i < 20; i++ {
+		w := &dataWriter{Buffer: &bytes.Buffer{}}
+		_, err := m.Copy().WriteTo(w)
+		require.NoError(t, err)
+		assert.Equal(t, expected, w.String())
+	}
+	// The map header is followed by "a": "a", "b": "b", and so on.
+	assert.Equal(t, "e74161416141624162", hex.EncodeToString([]byte(expected))[:18])
+}
+
 func TestMap(t *testing.T) {
 	maps := map[string]DataType{
 		"e0":                             Map{},
@@ -223,6 +245,46 @@ func powBigInt(bi *big.Int, pow uint) *big.Int {
 	return newInt
 }
 
+func TestEqual(t *testing.T) {
+	nan := Float64(math.NaN())
+	one := Uint128(*big.NewInt(1))
+	otherOne := Uint128(*big.NewInt(1))
+	two := Uint128(*big.NewInt(2))
+	value := Map{
+		"a": Slice{String("x"), Uint32(1), Bytes{1}},
+		"b": Map{"c": &one, "d": nan},
+	}
+
+	tests := []struct {
+		a, b     DataType
+		expected bool
+	}{
+		{a: Bool(true), b: Bool(true), expected: true},
+		{a: Bool(true), b: Bool(false)},
+		{a: Uint32(1), b: Uint64(1)},
+		{a: Int32(-1), b: Int32(-1), expected: true},
+		{a: Float32(0), b: Float32(float32(math.Copysign(0, -1)))},
+		{a: nan, b: nan, expected: true},
+		{a: Bytes(nil), b: Bytes{}, expected: true},
+		{a: Bytes{1}, b: Bytes{2}},
+		{a: &one, b: &otherOne, expected: true},
+		{a: &one, b: &two},
+		{a: &one, b: (*Uint128)(nil)},
+		{a: Pointer(1), b: Pointer(1), expected: true},
+		{a: value, b: value.Copy(), expected: true},
+		{a: value, b: Map{"a": value["a"]}},
+		{a: Map{"a": String("x")}, b: Map{"b": String("x")}},
+		{a: Map{"a": nil}, b: Map{"a": nil}, expected: true},
+		{a: Slice{String("x"), String("y")}, b: Slice{String("y"), String("x")}},
+		{a: Slice{String("x")}, b: Slice{nil}},
+		{a: Slice{}, b: Map{}},
+	}
+	for _, test := range tests {
+		assert.Equal(t, test.expected, test.a.Equal(test.b), "%v.Equal(%v)", test.a, test.b)
+		assert.Equal(t, test.expected, test.b.Equal(test.a), "%v.Equal(%v)", test.b, test.a)
+	}
+}
+
 func validateEncoding(t *testing.T, tests map[string]DataType) {
 	for expected, dt := range tests {
 		w := &dataWriter{Buffer: &bytes.Buffer{}}
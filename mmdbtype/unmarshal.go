@@ -0,0 +1,296 @@
+package mmdbtype
+
+import (
+	"math/big"
+	"reflect"
+
+	"github.com/pkg/errors"
+)
+
+// Unmarshal stores the value of dt in the value pointed to by v, which must
+// be a non-nil pointer. It is the inverse of Marshal and uses the same
+// struct tags.
+//
+// Integer types may be decoded into any Go integer type large enough to hold
+// the value and Float32 and Float64 values may be decoded into either Go
+// float type. A Map may be decoded into a struct or a map with string keys,
+// Bytes into a []byte, and a Slice into a slice or array. Pointers are
+// allocated as necessary. Map keys without a corresponding struct field are
+// ignored.
+//
+// When decoding into an empty interface, the same Go types that
+// github.com/oschwald/maxminddb-golang uses are stored, e.g.,
+// map[string]interface{} for a Map and uint64 for a Uint16, Uint32, or
+// Uint64. When decoding into a DataType, dt is stored as is.
+//
+// A nil dt leaves the value unchanged.
+func Unmarshal(dt DataType, v interface{}) error {
+	rv := reflect.ValueOf(v)
+	if rv.Kind() != reflect.Ptr || rv.IsNil() {
+		return errors.Errorf("result must be a non-nil pointer, not %T", v)
+	}
+	return unmarshal(dt, rv.Elem())
+}
+
+func unmarshal(dt DataType, v reflect.Value) error {
+	if dt == nil {
+		return nil
+	}
+
+	// Non-empty interfaces such as DataType get the value as is. Empty
+	// interfaces get the Go representation below.
+	if v.Kind() == reflect.Interface && v.NumMethod() != 0 {
+		dv := reflect.ValueOf(dt)
+		if !dv.Type().Implements(v.Type()) {
+			return unmarshalTypeError(dt, v)
+		}
+		v.Set(dv)
+		return nil
+	}
+
+	if v.Type() == bigIntType {
+		return unmarshalBigInt(dt, v)
+	}
+
+	switch v.Kind() {
+	case reflect.Ptr:
+		if v.IsNil() {
+			v.Set(reflect.New(v.Type().Elem()))
+		}
+		return unmarshal(dt, v.Elem())
+	case reflect.Interface:
+		gv, err := toInterface(dt)
+		if err != nil {
+			return err
+		}
+		v.Set(reflect.ValueOf(gv))
+		return nil
+	case reflect.Bool:
+		b, ok := dt.(Bool)
+		if !ok {
+			return unmarshalTypeError(dt, v)
+		}
+		v.SetBool(bool(b))
+		return nil
+	case reflect.String:
+		s, ok := dt.(String)
+		if !ok {
+			return unmarshalTypeError(dt, v)
+		}
+		v.SetString(string(s))
+		return nil
+	case reflect.Float32, reflect.Float64:
+		switch dt := dt.(type) {
+		case Float32:
+			v.SetFloat(float64(dt))
+		case Float64:
+			v.SetFloat(float64(dt))
+		default:
+			return unmarshalTypeError(dt, v)
+		}
+		return nil
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		i, ok := integerValue(dt)
+		if !ok {
+			return unmarshalTypeError(dt, v)
+		}
+		if !i.IsInt64() || v.OverflowInt(i.Int64()) {
+			return unmarshalOverflowError(i, v)
+		}
+		v.SetInt(i.Int64())
+		return nil
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		i, ok := integerValue(dt)
+		if !ok {
+			return unmarshalTypeError(dt, v)
+		}
+		if i.Sign() < 0 || !i.IsUint64() || v.OverflowUint(i.Uint64()) {
+			return unmarshalOverflowError(i, v)
+		}
+		v.SetUint(i.Uint64())
+		return nil
+	case reflect.Map:
+		return unmarshalMap(dt, v)
+	case reflect.Slice:
+		if b, ok := dt.(Bytes); ok && v.Type().Elem().Kind() == reflect.Uint8 {
+			nb := make([]byte, len(b))
+			copy(nb, b)
+			v.SetBytes(nb)
+			return nil
+		}
+		s, ok := dt.(Slice)
+		if !ok {
+			return unmarshalTypeError(dt, v)
+		}
+		nv := reflect.MakeSlice(v.Type(), len(s), len(s))
+		for i, e := range s {
+			if err := unmarshal(e, nv.Index(i)); err != nil {
+				return err
+			}
+		}
+		v.Set(nv)
+		return nil
+	case reflect.Array:
+		s, ok := dt.(Slice)
+		if !ok {
+			return unmarshalTypeError(dt, v)
+		}
+		if len(s) > v.Len() {
+			return errors.Errorf("cannot unmarshal a Slice of length %d into %s", len(s), v.Type())
+		}
+		for i, e := range s {
+			if err := unmarshal(e, v.Index(i)); err != nil {
+				return err
+			}
+		}
+		return nil
+	case reflect.Struct:
+		m, ok := dt.(Map)
+		if !ok {
+			return unmarshalTypeError(dt, v)
+		}
+		return unmarshalStruct(m, v)
+	default:
+		return unmarshalTypeError(dt, v)
+	}
+}
+
+func unmarshalBigInt(dt DataType, v reflect.Value) error {
+	i, ok := integerValue(dt)
+	if !ok {
+		return unmarshalTypeError(dt, v)
+	}
+	v.Set(reflect.ValueOf(*i))
+	return nil
+}
+
+func unmarshalMap(dt DataType, v reflect.Value) error {
+	m, ok := dt.(Map)
+	if !ok {
+		return unmarshalTypeError(dt, v)
+	}
+	if v.Type().Key().Kind() != reflect.String {
+		return errors.Errorf("cannot unmarshal into %s; map keys must be strings", v.Type())
+	}
+
+	if v.IsNil() {
+		v.Set(reflect.MakeMapWithSize(v.Type(), len(m)))
+	}
+
+	elemType := v.Type().Elem()
+	for key, value := range m {
+		elem := reflect.New(elemType).Elem()
+		if err := unmarshal(value, elem); err != nil {
+			return err
+		}
+		v.SetMapIndex(reflect.ValueOf(string(key)).Convert(v.Type().Key()), elem)
+	}
+	return nil
+}
+
+func unmarshalStruct(m Map, v reflect.Value) error {
+	for _, f := range cachedFields(v.Type()) {
+		fv := v.Field(f.index)
+		if f.embedded {
+			if fv.Kind() == reflect.Ptr {
+				if fv.IsNil() {
+					if !fv.CanSet() {
+						continue
+					}
+					fv.Set(reflect.New(fv.Type().Elem()))
+				}
+				fv = fv.Elem()
+			}
+			if err := unmarshalStruct(m, fv); err != nil {
+				return err
+			}
+			continue
+		}
+
+		value, ok := m[String(f.name)]
+		if !ok {
+			continue
+		}
+		if err := unmarshal(value, fv); err != nil {
+			return errors.WithMessagef(err, "error unmarshaling field %s", f.goName)
+		}
+	}
+	return nil
+}
+
+// integerValue returns the value of integer types as a *big.Int.
+func integerValue(dt DataType) (*big.Int, bool) {
+	switch dt := dt.(type) {
+	case Int32:
+		return big.NewInt(int64(dt)), true
+	case Uint16:
+		return new(big.Int).SetUint64(uint64(dt)), true
+	case Uint32:
+		return new(big.Int).SetUint64(uint64(dt)), true
+	case Uint64:
+		return new(big.Int).SetUint64(uint64(dt)), true
+	case *Uint128:
+		return new(big.Int).Set((*big.Int)(dt)), true
+	default:
+		return nil, false
+	}
+}
+
+// toInterface converts the DataType to the Go types used by
+// github.com/oschwald/maxminddb-golang when decoding into an interface{}.
+func toInterface(dt DataType) (interface{}, error) {
+	switch dt := dt.(type) {
+	case Bool:
+		return bool(dt), nil
+	case Bytes:
+		b := make([]byte, len(dt))
+		copy(b, dt)
+		return b, nil
+	case Float32:
+		return float32(dt), nil
+	case Float64:
+		return float64(dt), nil
+	case Int32:
+		return int(dt), nil
+	case Map:
+		m := make(map[string]interface{}, len(dt))
+		for k, v := range dt {
+			gv, err := toInterface(v)
+			if err != nil {
+				return nil, err
+			}
+			m[string(k)] = gv
+		}
+		return m, nil
+	case Slice:
+		s := make([]interface{}, len(dt))
+		for i, v := range dt {
+			gv, err := toInterface(v)
+			if err != nil {
+				return nil, err
+			}
+			s[i] = gv
+		}
+		return s, nil
+	case String:
+		return string(dt), nil
+	case Uint16:
+		return uint64(dt), nil
+	case Uint32:
+		return uint64(dt), nil
+	case Uint64:
+		return uint64(dt), nil
+	case *Uint128:
+		return new(big.Int).Set((*big.Int)(dt)), nil
+	default:
+		return nil, errors.Errorf("cannot unmarshal %T into an interface{}", dt)
+	}
+}
+
+func unmarshalTypeError(dt DataType, v reflect.Value) error {
+	return errors.Errorf("cannot unmarshal %T into Go value of type %s", dt, v.Type())
+}
+
+func unmarshalOverflowError(i *big.Int, v reflect.Value) error {
+	return errors.Errorf("cannot unmarshal %s into Go value of type %s; the value is out of range", i, v.Type())
+}
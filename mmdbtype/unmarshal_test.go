@@ -0,0 +1,114 @@
+package mmdbtype
+
+import (
+	"math/big"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestUnmarshalStruct(t *testing.T) {
+	confidence := uint16(90)
+	city := testCity{
+		testBase:    testBase{GeoNameID: 2643743},
+		Names:       testNames{EN: "London", DE: "London"},
+		Confidence:  &confidence,
+		Population:  8982000,
+		Elevation:   -2,
+		Latitude:    51.5072,
+		Accuracy:    0.5,
+		IsCapital:   true,
+		Postcodes:   []string{"EC1A", "W1A"},
+		Hash:        []byte{0xAB},
+		Extra:       map[string]string{"k": "v"},
+		Custom:      Map{"a": String("b")},
+		Untagged:    "untagged",
+		EmptySlice:  []string{},
+		OmittedZero: 5,
+	}
+
+	dt, err := Marshal(city)
+	require.NoError(t, err)
+
+	var actual testCity
+	require.NoError(t, Unmarshal(dt, &actual))
+	assert.Equal(t, city, actual)
+}
+
+func TestUnmarshal(t *testing.T) {
+	bigInt := new(big.Int).Lsh(big.NewInt(1), 100)
+	uint128 := Uint128(*bigInt)
+
+	var i int
+	require.NoError(t, Unmarshal(Uint16(5), &i))
+	assert.Equal(t, 5, i)
+
+	var u8 uint8
+	assert.EqualError(
+		t,
+		Unmarshal(Uint32(256), &u8),
+		"cannot unmarshal 256 into Go value of type uint8; the value is out of range",
+	)
+	assert.EqualError(
+		t,
+		Unmarshal(Int32(-1), &u8),
+		"cannot unmarshal -1 into Go value of type uint8; the value is out of range",
+	)
+
+	var f float64
+	require.NoError(t, Unmarshal(Float32(1.5), &f))
+	assert.Equal(t, 1.5, f)
+
+	var bi *big.Int
+	require.NoError(t, Unmarshal(&uint128, &bi))
+	assert.Equal(t, bigInt, bi)
+
+	var arr [3]string
+	require.NoError(t, Unmarshal(Slice{String("a"), String("b")}, &arr))
+	assert.Equal(t, [3]string{"a", "b", ""}, arr)
+
+	var m map[string][]uint32
+	require.NoError(t, Unmarshal(Map{"a": Slice{Uint32(1), Uint64(2)}}, &m))
+	assert.Equal(t, map[string][]uint32{"a": {1, 2}}, m)
+
+	var iface interface{}
+	require.NoError(t, Unmarshal(
+		Map{
+			"a": Slice{Uint16(1), Int32(-1), Bool(true)},
+			"b": Bytes{1},
+			"c": &uint128,
+		},
+		&iface,
+	))
+	assert.Equal(
+		t,
+		map[string]interface{}{
+			"a": []interface{}{uint64(1), -1, true},
+			"b": []byte{1},
+			"c": bigInt,
+		},
+		iface,
+	)
+
+	var dt DataType
+	require.NoError(t, Unmarshal(Map{"a": Bool(true)}, &dt))
+	assert.Equal(t, Map{"a": Bool(true)}, dt)
+
+	s := "unchanged"
+	require.NoError(t, Unmarshal(nil, &s))
+	assert.Equal(t, "unchanged", s)
+
+	assert.EqualError(t, Unmarshal(String("a"), s), "result must be a non-nil pointer, not string")
+	assert.EqualError(
+		t,
+		Unmarshal(String("a"), &i),
+		"cannot unmarshal mmdbtype.String into Go value of type int",
+	)
+	assert.EqualError(
+		t,
+		Unmarshal(Map{"names": Map{"en": Uint16(1)}}, &testCity{}),
+		"error unmarshaling field Names: error unmarshaling field EN: "+
+			"cannot unmarshal mmdbtype.Uint16 into Go value of type string",
+	)
+}
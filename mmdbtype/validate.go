@@ -0,0 +1,116 @@
+package mmdbtype
+
+import (
+	"math/big"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/pkg/errors"
+)
+
+// maxDepth is the maximum depth of nested Map and Slice values. Readers
+// such as libmaxminddb refuse to decode values nested more deeply.
+const maxDepth = 512
+
+// Validate checks that the value can be written to a MaxMind DB database
+// and read back by the common reader implementations. It returns an error
+// if a Map or Slice contains a nil value, if a Uint128 is negative or does
+// not fit in 128 bits, if a value is too large to be encoded, if Map and
+// Slice values are nested more than 512 levels deep, or if the value
+// contains a Pointer, which is only used internally by the writer.
+//
+// The error includes the location of the invalid value, e.g.,
+// "invalid value at a.b[1]: element 0 is nil".
+func Validate(dt DataType) error {
+	if dt == nil {
+		return errors.New("invalid value: the value is nil")
+	}
+	return validate(dt, nil, false)
+}
+
+// ValidateStrict is like Validate, but it also requires that every String
+// and Map key is valid UTF-8, as the MaxMind DB spec requires. Readers
+// differ in how they handle invalid UTF-8, e.g., some replace the invalid
+// bytes and others return an error.
+func ValidateStrict(dt DataType) error {
+	if dt == nil {
+		return errors.New("invalid value: the value is nil")
+	}
+	return validate(dt, nil, true)
+}
+
+func validate(dt DataType, path []string, strict bool) error {
+	switch v := dt.(type) {
+	case Map:
+		if len(path) >= maxDepth {
+			return errors.Errorf("invalid value: values are nested more than %d levels deep", maxDepth)
+		}
+		if len(v) > maxSize {
+			return validationError(path, "the Map has %d entries; the maximum is %d", len(v), maxSize)
+		}
+		for k, mv := range v {
+			if len(k) > maxSize {
+				return validationError(path, "a key is %d bytes; the maximum is %d", len(k), maxSize)
+			}
+			if strict && !utf8.ValidString(string(k)) {
+				return validationError(path, "the key %q is not valid UTF-8", k)
+			}
+			if mv == nil {
+				return validationError(path, "the value for key %q is nil", k)
+			}
+			if err := validate(mv, append(path, "."+string(k)), strict); err != nil {
+				return err
+			}
+		}
+	case Slice:
+		if len(path) >= maxDepth {
+			return errors.Errorf("invalid value: values are nested more than %d levels deep", maxDepth)
+		}
+		if len(v) > maxSize {
+			return validationError(path, "the Slice has %d elements; the maximum is %d", len(v), maxSize)
+		}
+		for i, sv := range v {
+			if sv == nil {
+				return validationError(path, "element %d is nil", i)
+			}
+			if err := validate(sv, append(path, "["+strconv.Itoa(i)+"]"), strict); err != nil {
+				return err
+			}
+		}
+	case String:
+		if len(v) > maxSize {
+			return validationError(path, "the String is %d bytes; the maximum is %d", len(v), maxSize)
+		}
+		if strict && !utf8.ValidString(string(v)) {
+			return validationError(path, "the String %q is not valid UTF-8", v)
+		}
+	case Bytes:
+		if len(v) > maxSize {
+			return validationError(path, "the Bytes is %d bytes; the maximum is %d", len(v), maxSize)
+		}
+	case *Uint128:
+		if v == nil {
+			return validationError(path, "the Uint128 is nil")
+		}
+		i := (*big.Int)(v)
+		if i.Sign() < 0 {
+			return validationError(path, "the Uint128 %s is negative", i)
+		}
+		if i.BitLen() > 128 {
+			return validationError(path, "the Uint128 %s does not fit in 128 bits", i)
+		}
+	case Pointer:
+		return validationError(path, "a Pointer cannot be inserted")
+	default:
+	}
+	return nil
+}
+
+func validationError(path []string, format string, args ...interface{}) error {
+	location := ""
+	if len(path) > 0 {
+		location = " at " + strings.TrimPrefix(strings.Join(path, ""), ".")
+	}
+	return errors.Errorf("invalid value"+location+": "+format, args...)
+}
@@ -0,0 +1,97 @@
+package mmdbtype
+
+import (
+	"math/big"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestValidate(t *testing.T) {
+	negative := Uint128(*big.NewInt(-1))
+	tooLarge := Uint128(*new(big.Int).Lsh(big.NewInt(1), 128))
+	maxUint := Uint128(*maxUint128)
+
+	var deep DataType = String("leaf")
+	for i := 0; i < maxDepth; i++ {
+		deep = Slice{deep}
+	}
+
+	tests := []struct {
+		name        string
+		value       DataType
+		expectedErr string
+	}{
+		{
+			name: "valid",
+			value: Map{
+				"a": Slice{Uint16(1), &maxUint, Bytes{1}},
+				"b": Map{"c": Bool(true)},
+			},
+		},
+		{name: "max depth", value: deep},
+		{name: "nil", expectedErr: "invalid value: the value is nil"},
+		{
+			name:        "nil map value",
+			value:       Map{"a": Map{"b": nil}},
+			expectedErr: `invalid value at a: the value for key "b" is nil`,
+		},
+		{
+			name:        "nil slice element",
+			value:       Slice{Uint16(1), nil},
+			expectedErr: "invalid value: element 1 is nil",
+		},
+		{
+			name:        "negative uint128",
+			value:       Slice{&negative},
+			expectedErr: "invalid value at [0]: the Uint128 -1 is negative",
+		},
+		{
+			name:        "large uint128",
+			value:       &tooLarge,
+			expectedErr: "invalid value: the Uint128 340282366920938463463374607431768211456 does not fit in 128 bits",
+		},
+		{
+			name:        "nested",
+			value:       Map{"a": Slice{Map{"b": Slice{Pointer(1)}}}},
+			expectedErr: "invalid value at a[0].b[0]: a Pointer cannot be inserted",
+		},
+		{
+			name:        "pointer",
+			value:       Pointer(1),
+			expectedErr: "invalid value: a Pointer cannot be inserted",
+		},
+		{
+			name:        "too deep",
+			value:       Slice{deep},
+			expectedErr: "invalid value: values are nested more than 512 levels deep",
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			err := Validate(test.value)
+			if test.expectedErr == "" {
+				assert.NoError(t, err)
+				return
+			}
+			assert.EqualError(t, err, test.expectedErr)
+		})
+	}
+}
+
+func TestValidateStrict(t *testing.T) {
+	assert.NoError(t, Validate(Map{"a": String("\xff")}))
+	assert.NoError(t, ValidateStrict(Map{"a": String("é")}))
+	assert.EqualError(
+		t,
+		ValidateStrict(Map{"a": Slice{String("\xff")}}),
+		`invalid value at a[0]: the String "\xff" is not valid UTF-8`,
+	)
+	assert.EqualError(
+		t,
+		ValidateStrict(Map{"\xff": Bool(true)}),
+		`invalid value: the key "\xff" is not valid UTF-8`,
+	)
+	assert.EqualError(t, ValidateStrict(Pointer(1)), "invalid value: a Pointer cannot be inserted")
+}
@@ -0,0 +1,76 @@
+//go:build go1.18
+// +build go1.18
+
+package mmdbwriter
+
+import (
+	"net/netip"
+
+	"github.com/maxmind/mmdbwriter/inserter"
+	"github.com/maxmind/mmdbwriter/mmdbtype"
+	"github.com/pkg/errors"
+)
+
+// InsertPrefix is the same as Insert except that it takes a netip.Prefix. An
+// IPv4 prefix is inserted into the IPv4 subtree of an IPv6 tree. IPv4-mapped
+// IPv6 prefixes, e.g., ::ffff:1.1.1.0/120, are treated as IPv6 prefixes.
+//
+// This is not safe to call from multiple threads unless Options.ThreadSafe
+// is set.
+func (t *Tree) InsertPrefix(prefix netip.Prefix, value mmdbtype.DataType) error {
+	if !prefix.IsValid() {
+		return errors.Errorf("invalid prefix: %s", prefix)
+	}
+	prefix = prefix.Masked()
+
+	return t.insertIP(
+		prefix.Addr().AsSlice(),
+		prefix.Bits(),
+		recordTypeData,
+		inserter.ReplaceWith(value),
+		nil,
+	)
+}
+
+// GetAddr is the same as Get except that it takes a netip.Addr and returns a
+// netip.Prefix. If an IPv4 address is looked up in an IPv6 tree and the
+// record is within the IPv4 subtree, an IPv4 prefix is returned. Looking up
+// an IPv6 address other than an IPv4-mapped address in an IPv4 tree returns
+// the zero Prefix and a nil value.
+func (t *Tree) GetAddr(addr netip.Addr) (netip.Prefix, mmdbtype.DataType) {
+	addr = addr.WithZone("")
+
+	if t.treeDepth == 32 {
+		addr = addr.Unmap()
+		if !addr.Is4() {
+			return netip.Prefix{}, nil
+		}
+	}
+
+	lookupIP := addr.AsSlice()
+	if t.treeDepth == 128 && addr.Is4() {
+		lookupIP = ipV4ToV6(lookupIP)
+	}
+
+	prefixLen, r := t.get(lookupIP)
+
+	var value mmdbtype.DataType
+	if r.recordType == recordTypeData {
+		value = r.value.data
+	}
+
+	if addr.Is4() && t.treeDepth == 128 {
+		if prefixLen < 96 {
+			// The record is for a network that contains the whole IPv4
+			// subtree, so we return it as an IPv6 prefix.
+			var ip16 [16]byte
+			copy(ip16[:], lookupIP)
+			prefix, _ := netip.AddrFrom16(ip16).Prefix(prefixLen)
+			return prefix, value
+		}
+		prefixLen -= 96
+	}
+
+	prefix, _ := addr.Prefix(prefixLen)
+	return prefix, value
+}
@@ -0,0 +1,45 @@
+//go:build go1.18
+// +build go1.18
+
+package mmdbwriter
+
+import (
+	"net"
+	"net/netip"
+	"testing"
+
+	"github.com/maxmind/mmdbwriter/mmdbtype"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestInsertPrefixAndGetAddr(t *testing.T) {
+	tree, err := New(Options{})
+	require.NoError(t, err)
+
+	require.NoError(t, tree.InsertPrefix(netip.MustParsePrefix("1.1.1.0/24"), mmdbtype.String("v4")))
+	require.NoError(t, tree.InsertPrefix(netip.MustParsePrefix("2003:1::1/32"), mmdbtype.String("v6")))
+
+	tests := []struct {
+		addr           string
+		expectedPrefix string
+		expectedValue  mmdbtype.DataType
+	}{
+		{addr: "1.1.1.1", expectedPrefix: "1.1.1.0/24", expectedValue: mmdbtype.String("v4")},
+		{addr: "::1.1.1.1", expectedPrefix: "::101:100/120", expectedValue: mmdbtype.String("v4")},
+		{addr: "::ffff:1.1.1.1", expectedPrefix: "::ffff:1.1.1.0/120", expectedValue: mmdbtype.String("v4")},
+		{addr: "2003:1::5", expectedPrefix: "2003:1::/32", expectedValue: mmdbtype.String("v6")},
+		{addr: "2003:2::", expectedPrefix: "2003:2::/31"},
+	}
+
+	for _, test := range tests {
+		prefix, value := tree.GetAddr(netip.MustParseAddr(test.addr))
+		assert.Equal(t, test.expectedPrefix, prefix.String(), "prefix for %s", test.addr)
+		assert.Equal(t, test.expectedValue, value, "value for %s", test.addr)
+
+		_, netValue := tree.Get(net.ParseIP(test.addr))
+		assert.Equal(t, netValue, value, "GetAddr and Get agree for %s", test.addr)
+	}
+
+	assert.Error(t, tree.InsertPrefix(netip.Prefix{}, mmdbtype.String("invalid")))
+}
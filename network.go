@@ -0,0 +1,210 @@
+package mmdbwriter
+
+import (
+	"bytes"
+	"net"
+
+	"github.com/pkg/errors"
+)
+
+// rangeToNetworks returns the minimal set of networks that exactly cover
+// the range from start to end, inclusive. The networks are returned in
+// address order.
+func rangeToNetworks(start, end net.IP) ([]*net.IPNet, error) {
+	start, end, err := normalizeRange(start, end)
+	if err != nil {
+		return nil, err
+	}
+
+	bitLen := len(start) * 8
+
+	var networks []*net.IPNet
+	current := start
+	for {
+		// The largest network starting at current is bounded by the
+		// alignment of current. We then shrink it until it no longer
+		// extends past the end of the range.
+		prefixLen := bitLen - trailingZeroBits(current)
+		for ; prefixLen < bitLen; prefixLen++ {
+			if bytes.Compare(lastIP(current, prefixLen), end) <= 0 {
+				break
+			}
+		}
+
+		networks = append(networks, &net.IPNet{
+			IP:   current,
+			Mask: net.CIDRMask(prefixLen, bitLen),
+		})
+
+		last := lastIP(current, prefixLen)
+		if bytes.Equal(last, end) {
+			return networks, nil
+		}
+		current = nextIP(last)
+	}
+}
+
+// excludeNetworks returns the minimal set of networks that exactly cover the
+// network except for the exclusions. The networks are returned in address
+// order. Exclusions that do not overlap the network are ignored. The
+// exclusions must be of the same IP version as the network.
+func excludeNetworks(network *net.IPNet, exclusions []*net.IPNet) ([]*net.IPNet, error) {
+	ip, prefixLen := canonicalNetwork(network)
+
+	normalized := make([]*net.IPNet, 0, len(exclusions))
+	for _, exclusion := range exclusions {
+		eIP, ePrefixLen := canonicalNetwork(exclusion)
+		if len(eIP) != len(ip) {
+			return nil, errors.Errorf(
+				"cannot exclude %s from %s as they are not the same IP version",
+				exclusion,
+				network,
+			)
+		}
+		normalized = append(normalized, &net.IPNet{IP: eIP, Mask: net.CIDRMask(ePrefixLen, len(eIP)*8)})
+	}
+	return subtractNetworks(ip, prefixLen, normalized, nil), nil
+}
+
+// subtractNetworks appends the networks that cover the network with the IP
+// and prefix length except for the exclusions to networks.
+func subtractNetworks(ip net.IP, prefixLen int, exclusions, networks []*net.IPNet) []*net.IPNet {
+	bitLen := len(ip) * 8
+	network := &net.IPNet{IP: ip, Mask: net.CIDRMask(prefixLen, bitLen)}
+
+	var within []*net.IPNet
+	for _, exclusion := range exclusions {
+		ePrefixLen, _ := exclusion.Mask.Size()
+		switch {
+		case ePrefixLen <= prefixLen && exclusion.Contains(ip):
+			return networks
+		case ePrefixLen > prefixLen && network.Contains(exclusion.IP):
+			within = append(within, exclusion)
+		default:
+		}
+	}
+	if len(within) == 0 {
+		return append(networks, network)
+	}
+
+	// Part of the network is excluded, so each half is covered separately.
+	for i := 0; i < 2; i++ {
+		half := make(net.IP, len(ip))
+		copy(half, ip)
+		setBit(half, prefixLen, byte(i))
+		networks = subtractNetworks(half, prefixLen+1, within, networks)
+	}
+	return networks
+}
+
+// canonicalNetwork returns the IP and prefix length of the network with the
+// bits after the prefix cleared. IPv4 networks always have a 4 byte IP.
+func canonicalNetwork(network *net.IPNet) (net.IP, int) {
+	ip, prefixLen := networkIP(network)
+	return ip.Mask(net.CIDRMask(prefixLen, len(ip)*8)), prefixLen
+}
+
+// normalizeRange converts start and end to the same length, using the
+// 4-byte form for IPv4 addresses, and checks that the range is valid.
+func normalizeRange(start, end net.IP) (net.IP, net.IP, error) {
+	if start4, end4 := start.To4(), end.To4(); start4 != nil || end4 != nil {
+		if start4 == nil || end4 == nil {
+			return nil, nil, errors.Errorf(
+				"start (%s) and end (%s) of range must be the same IP version",
+				start,
+				end,
+			)
+		}
+		start, end = start4, end4
+	} else {
+		start, end = start.To16(), end.To16()
+		if start == nil || end == nil {
+			return nil, nil, errors.New("invalid IP address in range")
+		}
+	}
+
+	if bytes.Compare(start, end) > 0 {
+		return nil, nil, errors.Errorf(
+			"start of range (%s) is greater than the end (%s)",
+			start,
+			end,
+		)
+	}
+	return start, end, nil
+}
+
+// rangeEnd returns the last address of the block of count addresses
+// starting at start.
+func rangeEnd(start net.IP, count uint64) (net.IP, error) {
+	if count == 0 {
+		return nil, errors.New("the number of addresses must be positive")
+	}
+	ip := start.To4()
+	if ip == nil {
+		ip = start.To16()
+		if ip == nil {
+			return nil, errors.New("invalid IP address in range")
+		}
+	}
+
+	end := make(net.IP, len(ip))
+	carry := count - 1
+	for i := len(ip) - 1; i >= 0; i-- {
+		sum := uint64(ip[i]) + carry&0xFF
+		end[i] = byte(sum)
+		carry = carry>>8 + sum>>8
+	}
+	if carry != 0 {
+		return nil, errors.Errorf("%d addresses starting at %s extend past the last address", count, start)
+	}
+	return end, nil
+}
+
+// lastIP returns the last address in the network with the given IP and
+// prefix length.
+func lastIP(ip net.IP, prefixLen int) net.IP {
+	last := make(net.IP, len(ip))
+	for i := range ip {
+		networkBits := prefixLen - i*8
+		switch {
+		case networkBits >= 8:
+			last[i] = ip[i]
+		case networkBits <= 0:
+			last[i] = 0xFF
+		default:
+			last[i] = ip[i] | (0xFF >> networkBits)
+		}
+	}
+	return last
+}
+
+// nextIP returns the address following ip. It wraps around to the zero
+// address if ip is the last address of its family.
+func nextIP(ip net.IP) net.IP {
+	next := make(net.IP, len(ip))
+	copy(next, ip)
+	for i := len(next) - 1; i >= 0; i-- {
+		next[i]++
+		if next[i] != 0 {
+			break
+		}
+	}
+	return next
+}
+
+func trailingZeroBits(ip net.IP) int {
+	zeros := 0
+	for i := len(ip) - 1; i >= 0; i-- {
+		b := ip[i]
+		if b == 0 {
+			zeros += 8
+			continue
+		}
+		for b&1 == 0 {
+			zeros++
+			b >>= 1
+		}
+		break
+	}
+	return zeros
+}
@@ -0,0 +1,198 @@
+package mmdbwriter
+
+import (
+	"net"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRangeToNetworks(t *testing.T) {
+	tests := []struct {
+		start       string
+		end         string
+		expected    []string
+		expectedErr string
+	}{
+		{
+			start:    "1.2.3.0",
+			end:      "1.2.3.255",
+			expected: []string{"1.2.3.0/24"},
+		},
+		{
+			start:    "1.2.3.4",
+			end:      "1.2.3.4",
+			expected: []string{"1.2.3.4/32"},
+		},
+		{
+			start: "1.2.3.7",
+			end:   "1.2.9.255",
+			expected: []string{
+				"1.2.3.7/32",
+				"1.2.3.8/29",
+				"1.2.3.16/28",
+				"1.2.3.32/27",
+				"1.2.3.64/26",
+				"1.2.3.128/25",
+				"1.2.4.0/22",
+				"1.2.8.0/23",
+			},
+		},
+		{
+			start:    "0.0.0.0",
+			end:      "255.255.255.255",
+			expected: []string{"0.0.0.0/0"},
+		},
+		{
+			start:    "::",
+			end:      "ffff:ffff:ffff:ffff:ffff:ffff:ffff:ffff",
+			expected: []string{"::/0"},
+		},
+		{
+			start:    "2001:db8::",
+			end:      "2001:db8::1:0",
+			expected: []string{"2001:db8::/112", "2001:db8::1:0/128"},
+		},
+		{
+			start:       "1.2.3.4",
+			end:         "1.2.3.3",
+			expectedErr: "start of range (1.2.3.4) is greater than the end (1.2.3.3)",
+		},
+		{
+			start:       "1.2.3.4",
+			end:         "2001:db8::",
+			expectedErr: "start (1.2.3.4) and end (2001:db8::) of range must be the same IP version",
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.start+"-"+test.end, func(t *testing.T) {
+			networks, err := rangeToNetworks(net.ParseIP(test.start), net.ParseIP(test.end))
+			if test.expectedErr != "" {
+				assert.EqualError(t, err, test.expectedErr)
+				return
+			}
+			require.NoError(t, err)
+
+			var actual []string
+			for _, n := range networks {
+				actual = append(actual, n.String())
+			}
+			assert.Equal(t, test.expected, actual)
+		})
+	}
+}
+
+func TestRangeEnd(t *testing.T) {
+	tests := []struct {
+		start       string
+		count       uint64
+		expected    string
+		expectedErr string
+	}{
+		{start: "1.2.3.0", count: 1, expected: "1.2.3.0"},
+		{start: "1.2.3.0", count: 256, expected: "1.2.3.255"},
+		{start: "1.2.3.7", count: 1000, expected: "1.2.6.238"},
+		{start: "255.255.255.0", count: 256, expected: "255.255.255.255"},
+		{start: "2001:db8::", count: 1<<64 - 1, expected: "2001:db8::ffff:ffff:ffff:fffe"},
+		{start: "1.2.3.0", count: 0, expectedErr: "the number of addresses must be positive"},
+		{
+			start:       "255.255.255.0",
+			count:       257,
+			expectedErr: "257 addresses starting at 255.255.255.0 extend past the last address",
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.start, func(t *testing.T) {
+			end, err := rangeEnd(net.ParseIP(test.start), test.count)
+			if test.expectedErr != "" {
+				assert.EqualError(t, err, test.expectedErr)
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, test.expected, end.String())
+		})
+	}
+}
+
+func TestExcludeNetworks(t *testing.T) {
+	tests := []struct {
+		network     string
+		exclusions  []string
+		expected    []string
+		expectedErr string
+	}{
+		{
+			network:  "1.2.0.0/16",
+			expected: []string{"1.2.0.0/16"},
+		},
+		{
+			network:    "1.2.0.0/16",
+			exclusions: []string{"1.2.3.0/24"},
+			expected: []string{
+				"1.2.0.0/23",
+				"1.2.2.0/24",
+				"1.2.4.0/22",
+				"1.2.8.0/21",
+				"1.2.16.0/20",
+				"1.2.32.0/19",
+				"1.2.64.0/18",
+				"1.2.128.0/17",
+			},
+		},
+		{
+			network:    "1.2.3.0/24",
+			exclusions: []string{"1.2.3.128/25", "1.2.3.0/26", "5.0.0.0/8"},
+			expected:   []string{"1.2.3.64/26"},
+		},
+		{
+			// An exclusion with bits set after its prefix.
+			network:    "1.2.3.0/24",
+			exclusions: []string{"1.2.3.129/25"},
+			expected:   []string{"1.2.3.0/25"},
+		},
+		{
+			network:    "1.2.3.0/24",
+			exclusions: []string{"1.0.0.0/8"},
+		},
+		{
+			network:    "2001:db8::/32",
+			exclusions: []string{"2001:db8:8000::/33"},
+			expected:   []string{"2001:db8::/33"},
+		},
+		{
+			network:     "1.2.3.0/24",
+			exclusions:  []string{"2001:db8::/32"},
+			expectedErr: "cannot exclude 2001:db8::/32 from 1.2.3.0/24 as they are not the same IP version",
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.network, func(t *testing.T) {
+			_, network, err := net.ParseCIDR(test.network)
+			require.NoError(t, err)
+			var exclusions []*net.IPNet
+			for _, e := range test.exclusions {
+				ip, exclusion, err := net.ParseCIDR(e)
+				require.NoError(t, err)
+				exclusion.IP = ip
+				exclusions = append(exclusions, exclusion)
+			}
+
+			networks, err := excludeNetworks(network, exclusions)
+			if test.expectedErr != "" {
+				assert.EqualError(t, err, test.expectedErr)
+				return
+			}
+			require.NoError(t, err)
+
+			var actual []string
+			for _, n := range networks {
+				actual = append(actual, n.String())
+			}
+			assert.Equal(t, test.expected, actual)
+		})
+	}
+}
@@ -1,6 +1,7 @@
 package mmdbwriter
 
 import (
+	"fmt"
 	"net"
 
 	"github.com/maxmind/mmdbwriter/mmdbtype"
@@ -28,31 +29,156 @@ type record struct {
 type node struct {
 	children [2]record
 	nodeNum  int
+	// size is the number of nodes in the subtree as of when it was last
+	// finalized. It is 0 if the subtree has changed since then.
+	size int
+}
+
+// nodeChunkSize is the number of nodes allocated at once by nodeAllocator.
+const nodeChunkSize = 1 << 12
+
+// nodeAllocator allocates nodes from contiguous chunks rather than
+// individually. Large trees have tens of millions of nodes, and allocating
+// them in chunks greatly reduces the number of heap objects that the garbage
+// collector must track. Nodes pruned when the tree is finalized are kept on
+// a free list and reused by later inserts.
+type nodeAllocator struct {
+	chunk []node
+	free  []*node
+	// live is the number of nodes that have been allocated and not
+	// released, i.e., the number of nodes in the tree.
+	live int
+}
+
+func (a *nodeAllocator) new() *node {
+	a.live++
+	if i := len(a.free) - 1; i >= 0 {
+		n := a.free[i]
+		a.free[i] = nil
+		a.free = a.free[:i]
+		return n
+	}
+
+	if len(a.chunk) == 0 {
+		a.chunk = make([]node, nodeChunkSize)
+	}
+	n := &a.chunk[0]
+	a.chunk = a.chunk[1:]
+	return n
+}
+
+// release returns a node that is no longer referenced to the allocator.
+func (a *nodeAllocator) release(n *node) {
+	*n = node{}
+	a.live--
+	a.free = append(a.free, n)
 }
 
 type insertRecord struct {
 	inserter func(value mmdbtype.DataType) (mmdbtype.DataType, error)
 
 	dataMap      *dataMap
+	nodes        *nodeAllocator
 	insertedNode *node
 
 	ip        net.IP
 	prefixLen int
 
+	// inputIP and inputPrefixLen are the network as it was passed to the
+	// tree, e.g., an IPv4 network before it was mapped into the IPv4 subtree
+	// of an IPv6 tree. They are only used in errors.
+	inputIP        net.IP
+	inputPrefixLen int
+
+	// whole, if set, is the insert that this one is a part of, e.g., of the
+	// network passed to ShardedBuilder.InsertFunc when one of its partitions
+	// is inserted into a shard. The reserved and aliased networks are then
+	// handled as for whole, which is also the network named in errors.
+	whole *insertRecord
+
 	recordType recordType
+
+	// provenance is set if the tree tracks the provenance of its data.
+	provenance *provenanceTracker
+
+	// skipReserved makes inserts into reserved and aliased networks return
+	// errInsertSkipped rather than a descriptive error.
+	skipReserved bool
+
+	// onInsert, if set, is called for each data record set by the insert.
+	// path is then used as a buffer for the path to the current record.
+	onInsert func(ip net.IP, prefixLen int, old, new mmdbtype.DataType)
+	path     net.IP
+
+	metrics Metrics
+
+	// maxNodes is the maximum number of nodes in the tree, or 0 if there
+	// is no limit.
+	maxNodes int
+
+	// emptyOnly makes the insert leave the records that have data as they
+	// are, so that only the empty parts of the network are set.
+	emptyOnly bool
+}
+
+// network returns the network being inserted for use in errors. If the
+// network was translated when it was inserted, both forms are included.
+func (iRec insertRecord) network() string {
+	if iRec.whole != nil {
+		return iRec.whole.network()
+	}
+	network := fmt.Sprintf("%s/%d", iRec.ip, iRec.prefixLen)
+	if iRec.inputIP == nil || len(iRec.inputIP) == len(iRec.ip) {
+		return network
+	}
+	return fmt.Sprintf("%s/%d (%s in the tree)", iRec.inputIP, iRec.inputPrefixLen, network)
+}
+
+// wholePrefixLen returns the prefix length of the whole network being
+// inserted, in the tree's representation.
+func (iRec insertRecord) wholePrefixLen() int {
+	if iRec.whole != nil {
+		return iRec.whole.prefixLen
+	}
+	return iRec.prefixLen
+}
+
+// errInsertSkipped is returned, wrapped with the reason, when an insert into
+// a reserved or aliased network is skipped. The tree is not modified in this
+// case as the insert stops as soon as it reaches the reserved or aliased
+// record.
+var errInsertSkipped = errors.New("insert skipped")
+
+// reservedError returns the error for an insert into a reserved or aliased
+// network, which wraps errInsertSkipped if such inserts are skipped and
+// sentinel, i.e., ErrReservedNetwork or ErrAliasedNetwork, otherwise.
+func (iRec insertRecord) reservedError(kind string, sentinel error) error {
+	msg := fmt.Sprintf("attempt to insert %s, which is in %s network", iRec.network(), kind)
+	if iRec.skipReserved {
+		return errors.WithMessage(errInsertSkipped, msg)
+	}
+	return errors.WithStack(&sentinelError{msg: msg, sentinel: sentinel})
 }
 
 func (n *node) insert(iRec insertRecord, currentDepth int) error {
+	n.size = 0
 	newDepth := currentDepth + 1
 	// Check if we are inside the network already
 	if newDepth > iRec.prefixLen {
 		// Data already exists for the network so insert into all the children.
 		// We will prune duplicate nodes when we finalize.
-		err := n.children[0].insert(iRec, newDepth)
-		if err != nil {
-			return err
+		for i := 0; i < 2; i++ {
+			if iRec.path != nil {
+				setBit(iRec.path, currentDepth, byte(i))
+			}
+			if err := n.children[i].insert(iRec, newDepth); err != nil {
+				return err
+			}
+		}
+		if iRec.path != nil {
+			setBit(iRec.path, currentDepth, 0)
 		}
-		return n.children[1].insert(iRec, newDepth)
+		return nil
 	}
 
 	// We haven't reached the network yet.
@@ -68,13 +194,18 @@ func (r *record) insert(
 	switch r.recordType {
 	case recordTypeNode, recordTypeFixedNode:
 	case recordTypeEmpty, recordTypeData:
+		if iRec.emptyOnly && r.recordType == recordTypeData {
+			return nil
+		}
 		if newDepth >= iRec.prefixLen {
 			r.node = iRec.insertedNode
 			r.recordType = iRec.recordType
 			if iRec.recordType == recordTypeData {
 				var data mmdbtype.DataType
+				existing := r.value
 				if r.value != nil {
 					data = r.value.data
+					addMetric(iRec.metrics, CounterMerges, 1)
 
 					// Potentially we could avoid this if the
 					// new value is the same, but it would likely
@@ -90,12 +221,26 @@ func (r *record) insert(
 					r.recordType = recordTypeEmpty
 					r.value = nil
 				} else {
-					value, err := iRec.dataMap.store(value)
+					var prov *Provenance
+					if iRec.provenance != nil {
+						prov, err = iRec.provenance.next(existing, value)
+						if err != nil {
+							return err
+						}
+					}
+					value, err := iRec.dataMap.store(value, prov)
 					if err != nil {
 						return err
 					}
 					r.value = value
 				}
+				if iRec.onInsert != nil {
+					var newData mmdbtype.DataType
+					if r.value != nil {
+						newData = r.value.data
+					}
+					iRec.onInsert(iRec.path, newDepth, data, newData)
+				}
 			} else {
 				r.value = nil
 			}
@@ -104,32 +249,40 @@ func (r *record) insert(
 
 		// We are splitting this record so we create two duplicate child
 		// records.
-		r.node = &node{children: [2]record{*r, *r}}
+		if iRec.maxNodes > 0 && iRec.nodes.live >= iRec.maxNodes {
+			return errors.WithStack(&sentinelError{
+				msg: fmt.Sprintf(
+					"attempt to insert %s would exceed the limit of %d nodes",
+					iRec.network(),
+					iRec.maxNodes,
+				),
+				sentinel: ErrNodeLimitExceeded,
+			})
+		}
+		r.node = iRec.nodes.new()
+		addMetric(iRec.metrics, CounterNodesCreated, 1)
+		r.node.children = [2]record{*r, *r}
+		if r.value != nil {
+			// Both children now reference the value.
+			r.value.refCount++
+		}
 		r.value = nil
 		r.recordType = recordTypeNode
 	case recordTypeReserved:
-		if iRec.prefixLen >= newDepth {
-			return errors.Errorf(
-				"attempt to insert %s/%d, which is in a reserved network",
-				iRec.ip,
-				iRec.prefixLen,
-			)
+		if iRec.wholePrefixLen() >= newDepth {
+			return iRec.reservedError("a reserved", ErrReservedNetwork)
 		}
 		// If we are inserting a network that contains a reserved network,
 		// we silently remove the reserved network.
 		return nil
 	case recordTypeAlias:
-		if iRec.prefixLen < newDepth {
+		if iRec.wholePrefixLen() < newDepth {
 			// Do nothing. We are inserting a network that contains an aliased
 			// network. We silently ignore.
 			return nil
 		}
 		// attempting to insert _into_ an aliased network
-		return errors.Errorf(
-			"attempt to insert %s/%d, which is in an aliased network",
-			iRec.ip,
-			iRec.prefixLen,
-		)
+		return iRec.reservedError("an aliased", ErrAliasedNetwork)
 	default:
 		return errors.Errorf("inserting into record type %d not implemented!", r.recordType)
 	}
@@ -153,35 +306,97 @@ func (n *node) get(
 	}
 }
 
-// finalize prunes unnecessary nodes (e.g., where the two records are the same) and
-// sets the node number for the node. It returns a record pointer that is nil if
-// the node is not mergeable or the value of the merged record if it can be merged.
-// The second return value is the current node count, including the subtree.
-func (n *node) finalize(currentNum int) (*record, int) {
-	n.nodeNum = currentNum
+// walk calls fn for each data record in the subtree in address order. ip is
+// used as a buffer for the path to the current record and must be the full
+// length of the tree's addresses. Aliased and reserved records are skipped.
+func (n *node) walk(
+	ip net.IP,
+	depth int,
+	fn func(ip net.IP, prefixLen int, r *record) error,
+) error {
+	for i := 0; i < 2; i++ {
+		setBit(ip, depth, byte(i))
+
+		r := &n.children[i]
+		switch r.recordType {
+		case recordTypeNode, recordTypeFixedNode:
+			if err := r.node.walk(ip, depth+1, fn); err != nil {
+				return err
+			}
+		case recordTypeData:
+			if err := fn(ip, depth+1, r); err != nil {
+				return err
+			}
+		default:
+		}
+	}
+	setBit(ip, depth, 0)
+	return nil
+}
+
+// finalize prunes unnecessary nodes (e.g., where the two records are the same).
+// It returns a record pointer that is nil if the node is not mergeable or the
+// value of the merged record if it can be merged. The second return value is
+// the current node count, including the subtree.
+//
+// Subtrees that have not changed since they were last finalized are skipped.
+// They were not mergeable then, so they are not now.
+//
+// If the progress reporter is canceled, the nodes that have not been
+// finalized yet are left as they are, so that they are finalized next time.
+func (n *node) finalize(
+	currentNum int,
+	nodes *nodeAllocator,
+	dataMap *dataMap,
+	progress *progressReporter,
+) (*record, int) {
+	if n.size != 0 {
+		progress.add(n.size)
+		return nil, currentNum + n.size
+	}
+	progress.add(1)
+	if progress.canceled() != nil {
+		return nil, currentNum
+	}
+
+	start := currentNum
 	currentNum++
 
 	for i := 0; i < 2; i++ {
 		switch n.children[i].recordType {
 		case recordTypeFixedNode:
 			// We don't consider merging for fixed nodes
-			_, currentNum = n.children[i].node.finalize(currentNum)
+			_, currentNum = n.children[i].node.finalize(currentNum, nodes, dataMap, progress)
 		case recordTypeNode:
-			record, newCurrentNum := n.children[i].node.finalize(currentNum)
+			record, newCurrentNum := n.children[i].node.finalize(currentNum, nodes, dataMap, progress)
 			if record == nil {
 				// nothing to merge. Use current number from child.
 				currentNum = newCurrentNum
 			} else {
+				if record.recordType == recordTypeData {
+					// Both records of the node referenced the value, but
+					// only the merged record does now.
+					dataMap.remove(record.value)
+				}
+				nodes.release(n.children[i].node)
 				n.children[i] = *record
 			}
 		default:
 		}
 	}
+	if progress.canceled() != nil {
+		return nil, currentNum
+	}
+
+	n.size = currentNum - start
 
+	// The values are compared by identity rather than by key so that
+	// records with the same data but a different provenance are not merged.
+	// Otherwise, the values are the same if and only if their keys are.
 	if n.children[0].recordType == n.children[1].recordType &&
 		(n.children[0].recordType == recordTypeEmpty ||
 			(n.children[0].recordType == recordTypeData &&
-				n.children[0].value.key == n.children[1].value.key)) {
+				n.children[0].value == n.children[1].value)) {
 		return &record{
 			recordType: n.children[0].recordType,
 			value:      n.children[0].value,
@@ -191,6 +406,28 @@ func (n *node) finalize(currentNum int) (*record, int) {
 	return nil, currentNum
 }
 
+// invalidate marks every node in the subtree as changed so that it is
+// finalized again.
+func (n *node) invalidate() {
+	n.size = 0
+	for i := 0; i < 2; i++ {
+		switch n.children[i].recordType {
+		case recordTypeNode, recordTypeFixedNode:
+			n.children[i].node.invalidate()
+		default:
+		}
+	}
+}
+
 func bitAt(ip net.IP, depth int) byte {
 	return (ip[depth/8] >> (7 - (depth % 8))) & 1
 }
+
+func setBit(ip net.IP, depth int, bit byte) {
+	mask := byte(1) << (7 - (depth % 8))
+	if bit == 0 {
+		ip[depth/8] &^= mask
+	} else {
+		ip[depth/8] |= mask
+	}
+}
@@ -0,0 +1,99 @@
+package mmdbwriter
+
+import "context"
+
+// ProgressStage is a stage of building, finalizing, or writing a tree.
+type ProgressStage int
+
+const (
+	// ProgressFinalize is the pruning and numbering of the nodes, which is
+	// done before the tree is written or walked if it has changed. The total
+	// is not known until the stage completes.
+	ProgressFinalize ProgressStage = iota + 1
+	// ProgressWriteData is the encoding of the data section.
+	ProgressWriteData
+	// ProgressWriteNodes is the encoding and writing of the search tree.
+	ProgressWriteNodes
+	// ProgressInsert is the inserting of the networks read by
+	// Tree.InsertAll. Done and Total are numbers of networks rather than
+	// nodes, and the total is not known until the stage completes.
+	ProgressInsert
+)
+
+// Progress is passed to Options.Progress during the long-running stages of
+// building, finalizing, and writing a tree.
+type Progress struct {
+	Stage ProgressStage
+	// Done is the number of nodes processed in the stage so far.
+	Done int
+	// Total is the number of nodes in the stage or 0 if it is not yet
+	// known. It is always set when the stage completes, at which point
+	// Done equals Total.
+	Total int
+}
+
+// progressInterval is the number of nodes processed between calls to
+// Options.Progress.
+const progressInterval = 1 << 16
+
+// cancelCheckInterval is the number of nodes processed between checks of
+// whether the context of a stage is done.
+const cancelCheckInterval = 1 << 12
+
+// progressReporter calls Options.Progress as the nodes in a stage are
+// processed and checks whether the context of the stage is done. A nil
+// *progressReporter does nothing, so callers do not need to check whether
+// progress reporting or cancellation is enabled.
+type progressReporter struct {
+	fn    func(Progress)
+	ctx   context.Context
+	err   error
+	stage ProgressStage
+	done  int
+	total int
+}
+
+func (t *Tree) newProgressReporter(ctx context.Context, stage ProgressStage, total int) *progressReporter {
+	// The Done channel of a context that can never be canceled, e.g.,
+	// context.Background(), is nil.
+	if ctx.Done() == nil {
+		ctx = nil
+	}
+	if t.progress == nil && ctx == nil {
+		return nil
+	}
+	return &progressReporter{fn: t.progress, ctx: ctx, stage: stage, total: total}
+}
+
+// add records n more processed nodes and reports the progress each time
+// another progressInterval nodes have been processed.
+func (p *progressReporter) add(n int) {
+	if p == nil {
+		return
+	}
+	before := p.done
+	p.done += n
+	if p.fn != nil && p.done/progressInterval != before/progressInterval && p.done != p.total {
+		p.fn(Progress{Stage: p.stage, Done: p.done, Total: p.total})
+	}
+	if p.ctx != nil && p.err == nil && p.done/cancelCheckInterval != before/cancelCheckInterval {
+		p.err = p.ctx.Err()
+	}
+}
+
+// canceled returns the error of the context if it was found to be done,
+// after which the stage should stop as soon as possible.
+func (p *progressReporter) canceled() error {
+	if p == nil {
+		return nil
+	}
+	return p.err
+}
+
+// finish reports the completion of the stage.
+func (p *progressReporter) finish() {
+	if p == nil || p.fn == nil {
+		return
+	}
+	p.fn(Progress{Stage: p.stage, Done: p.done, Total: p.done})
+}
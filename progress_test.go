@@ -0,0 +1,142 @@
+package mmdbwriter
+
+import (
+	"bytes"
+	"context"
+	"io/ioutil"
+	"net"
+	"testing"
+
+	"github.com/maxmind/mmdbwriter/mmdbtype"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// newProgressTestTree returns a tree with enough networks that each stage
+// reports its progress before it completes.
+func newProgressTestTree(t *testing.T, progress func(Progress)) *Tree {
+	tree, err := New(Options{Progress: progress})
+	require.NoError(t, err)
+
+	for i := 0; i < 1<<16; i++ {
+		ip := net.IPv4(1, byte(i>>8), byte(i), 0).To4()
+		network := &net.IPNet{IP: ip, Mask: net.CIDRMask(24, 32)}
+		require.NoError(t, tree.Insert(network, mmdbtype.Uint32(i%1000)))
+	}
+	return tree
+}
+
+func TestProgress(t *testing.T) {
+	var reports []Progress
+	tree := newProgressTestTree(t, func(p Progress) {
+		reports = append(reports, p)
+	})
+
+	_, err := tree.WriteTo(ioutil.Discard)
+	require.NoError(t, err)
+
+	final := map[ProgressStage]Progress{}
+	var stages []ProgressStage
+	for _, p := range reports {
+		if len(stages) == 0 || stages[len(stages)-1] != p.Stage {
+			stages = append(stages, p.Stage)
+		}
+		if p.Total != 0 {
+			assert.LessOrEqual(t, p.Done, p.Total)
+		}
+		if p.Done == p.Total {
+			final[p.Stage] = p
+		}
+	}
+	assert.Equal(t, []ProgressStage{ProgressFinalize, ProgressWriteData, ProgressWriteNodes}, stages)
+	assert.Greater(t, len(reports), len(stages), "progress is reported before the stages complete")
+
+	assert.GreaterOrEqual(t, final[ProgressFinalize].Total, tree.nodeCount)
+	assert.Equal(t, tree.nodeCount, final[ProgressWriteData].Total)
+	assert.Equal(t, tree.nodeCount, final[ProgressWriteNodes].Total)
+}
+
+func TestProgressInsert(t *testing.T) {
+	var reports []Progress
+	tree, err := New(Options{
+		Progress: func(p Progress) {
+			reports = append(reports, p)
+		},
+	})
+	require.NoError(t, err)
+
+	count := 0
+	err = tree.InsertAll(func() (*net.IPNet, mmdbtype.DataType, bool) {
+		if count == progressInterval+1 {
+			return nil, nil, false
+		}
+		ip := net.IPv4(1, byte(count>>8), byte(count), 0).To4()
+		count++
+		return &net.IPNet{IP: ip, Mask: net.CIDRMask(24, 32)}, mmdbtype.Uint32(count), true
+	})
+	require.NoError(t, err)
+
+	assert.Equal(t, []Progress{
+		{Stage: ProgressInsert, Done: progressInterval},
+		{Stage: ProgressInsert, Done: progressInterval + 1, Total: progressInterval + 1},
+	}, reports)
+}
+
+func TestFinalizeCtx(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	tree := newProgressTestTree(t, func(p Progress) {
+		if p.Stage == ProgressFinalize {
+			cancel()
+		}
+	})
+
+	assert.Equal(t, context.Canceled, tree.FinalizeCtx(ctx))
+	assert.Equal(t, 0, tree.nodeCount, "the tree is not marked as finalized")
+	assert.Equal(t, context.Canceled, tree.FinalizeCtx(ctx))
+
+	// The partially finalized tree is written the same as one that was
+	// never finalized.
+	require.NoError(t, tree.FinalizeCtx(context.Background()))
+	assert.NotEqual(t, 0, tree.nodeCount)
+	buf := &bytes.Buffer{}
+	_, err := tree.WriteTo(buf)
+	require.NoError(t, err)
+
+	expected := &bytes.Buffer{}
+	other := newProgressTestTree(t, nil)
+	other.buildEpoch = tree.buildEpoch
+	_, err = other.WriteTo(expected)
+	require.NoError(t, err)
+	assert.Equal(t, expected.Bytes(), buf.Bytes())
+}
+
+func TestWriteToCtx(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	tree := newProgressTestTree(t, nil)
+	n, err := tree.WriteToCtx(ctx, ioutil.Discard)
+	assert.Equal(t, context.Canceled, err)
+	assert.Equal(t, int64(0), n)
+
+	for _, stage := range []ProgressStage{ProgressWriteData, ProgressWriteNodes} {
+		ctx, cancel := context.WithCancel(context.Background())
+		var stages []ProgressStage
+		tree := newProgressTestTree(t, func(p Progress) {
+			if len(stages) == 0 || stages[len(stages)-1] != p.Stage {
+				stages = append(stages, p.Stage)
+			}
+			if p.Stage == stage {
+				cancel()
+			}
+		})
+		_, err := tree.WriteToCtx(ctx, ioutil.Discard)
+		cancel()
+		assert.Equal(t, context.Canceled, err)
+		assert.Equal(t, stage, stages[len(stages)-1], "no stage is started after the cancellation")
+
+		// The tree may still be written afterward.
+		_, err = tree.WriteTo(ioutil.Discard)
+		assert.NoError(t, err)
+	}
+}
@@ -0,0 +1,155 @@
+package mmdbwriter
+
+import (
+	"bytes"
+	"net"
+	"sort"
+	"strings"
+
+	"github.com/maxmind/mmdbwriter/mmdbtype"
+)
+
+// Provenance describes which sources set the data for a network. It is only
+// tracked if Options.TrackProvenance is set. A Provenance is shared between
+// networks and must not be modified.
+type Provenance struct {
+	// Source is the source that last set the network's value.
+	Source string
+	// Fields are the sources that last set each top-level key of the
+	// network's value, if it is a Map. A key keeps its source if a later
+	// insert leaves its value unchanged, e.g., when another key is merged
+	// into the Map.
+	Fields map[string]string
+
+	k string
+}
+
+// key returns a string that uniquely identifies the provenance.
+func (p *Provenance) key() string {
+	if p.k != "" {
+		return p.k
+	}
+
+	fields := make([]string, 0, len(p.Fields))
+	for k := range p.Fields {
+		fields = append(fields, k)
+	}
+	sort.Strings(fields)
+
+	var b strings.Builder
+	b.WriteString("\x00")
+	b.WriteString(p.Source)
+	for _, k := range fields {
+		b.WriteString("\x00")
+		b.WriteString(k)
+		b.WriteString("\x00")
+		b.WriteString(p.Fields[k])
+	}
+	p.k = b.String()
+	return p.k
+}
+
+// provenanceTracker creates the provenance for the values inserted into a
+// tree.
+type provenanceTracker struct {
+	source    string
+	keyWriter *keyWriter
+	// interned holds a single copy of each provenance as many networks
+	// share one.
+	interned map[string]*Provenance
+}
+
+func newProvenanceTracker() *provenanceTracker {
+	return &provenanceTracker{
+		keyWriter: newKeyWriter(),
+		interned:  map[string]*Provenance{},
+	}
+}
+
+func (pt *provenanceTracker) clone() *provenanceTracker {
+	c := newProvenanceTracker()
+	c.source = pt.source
+	for k, v := range pt.interned {
+		c.interned[k] = v
+	}
+	return c
+}
+
+// next returns the provenance of a record whose value is changed from
+// existing, which may be nil, to value by the current source.
+func (pt *provenanceTracker) next(existing *dataMapValue, value mmdbtype.DataType) (*Provenance, error) {
+	prov := &Provenance{Source: pt.source}
+
+	if m, ok := value.(mmdbtype.Map); ok {
+		var existingMap mmdbtype.Map
+		var existingFields map[string]string
+		if existing != nil && existing.provenance != nil {
+			existingMap, _ = existing.data.(mmdbtype.Map)
+			existingFields = existing.provenance.Fields
+		}
+
+		prov.Fields = make(map[string]string, len(m))
+		for k, v := range m {
+			source := pt.source
+			if s, ok := existingFields[string(k)]; ok {
+				unchanged, err := pt.equal(existingMap[k], v)
+				if err != nil {
+					return nil, err
+				}
+				if unchanged {
+					source = s
+				}
+			}
+			prov.Fields[string(k)] = source
+		}
+	}
+
+	if interned, ok := pt.interned[prov.key()]; ok {
+		return interned, nil
+	}
+	pt.interned[prov.key()] = prov
+	return prov, nil
+}
+
+func (pt *provenanceTracker) equal(a, b mmdbtype.DataType) (bool, error) {
+	if a == nil || b == nil {
+		return a == nil && b == nil, nil
+	}
+	aKey, err := pt.keyWriter.key(a)
+	if err != nil {
+		return false, err
+	}
+	bKey, err := pt.keyWriter.key(b)
+	if err != nil {
+		return false, err
+	}
+	return bytes.Equal(aKey, bKey), nil
+}
+
+// SetSource sets the source recorded for the data inserted into the tree
+// from now on. It has no effect unless Options.TrackProvenance is set.
+//
+// This is not safe to call from multiple threads unless Options.ThreadSafe
+// is set.
+func (t *Tree) SetSource(source string) {
+	t.lock()
+	defer t.unlock()
+
+	if t.provenance != nil {
+		t.provenance.source = source
+	}
+}
+
+// Provenance returns the network containing the IP address, as with Get, and
+// the provenance of its data. nil is returned for the provenance if the
+// network does not have data or if Options.TrackProvenance is not set.
+//
+// This is not safe to call from multiple threads unless Options.ThreadSafe
+// is set.
+func (t *Tree) Provenance(ip net.IP) (*net.IPNet, *Provenance) {
+	network, r := t.getRecord(ip)
+	if r.recordType != recordTypeData {
+		return network, nil
+	}
+	return network, r.value.provenance
+}
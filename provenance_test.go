@@ -0,0 +1,79 @@
+package mmdbwriter
+
+import (
+	"net"
+	"testing"
+
+	"github.com/maxmind/mmdbwriter/inserter"
+	"github.com/maxmind/mmdbwriter/mmdbtype"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestProvenance(t *testing.T) {
+	tree, err := New(Options{TrackProvenance: true})
+	require.NoError(t, err)
+
+	tree.SetSource("country")
+	require.NoError(t, tree.Insert(mustParseNetwork(t, "1.1.0.0/16"), mmdbtype.Map{"country": mmdbtype.String("AU")}))
+	tree.SetSource("asn")
+	require.NoError(t, tree.InsertFunc(
+		mustParseNetwork(t, "1.1.1.0/24"),
+		inserter.TopLevelMergeWith(mmdbtype.Map{"asn": mmdbtype.Uint32(13335)}),
+	))
+	tree.SetSource("other")
+	require.NoError(t, tree.Insert(
+		mustParseNetwork(t, "1.1.128.0/17"),
+		mmdbtype.Map{"country": mmdbtype.String("AU")},
+	))
+
+	tests := []struct {
+		ip       string
+		network  string
+		expected *Provenance
+	}{
+		{
+			ip:      "1.1.1.1",
+			network: "1.1.1.0/24",
+			expected: &Provenance{
+				Source: "asn",
+				Fields: map[string]string{"asn": "asn", "country": "country"},
+			},
+		},
+		{
+			ip:       "1.1.2.1",
+			network:  "1.1.2.0/23",
+			expected: &Provenance{Source: "country", Fields: map[string]string{"country": "country"}},
+		},
+		{
+			ip:      "1.1.128.1",
+			network: "1.1.128.0/17",
+			// The value of country did not change, so it keeps its source.
+			expected: &Provenance{Source: "other", Fields: map[string]string{"country": "country"}},
+		},
+		{ip: "2.2.2.2", network: "2.0.0.0/7"},
+	}
+
+	// The networks with the same data from different sources are not
+	// merged when the tree is finalized.
+	require.NoError(t, tree.Walk(func(*net.IPNet, mmdbtype.DataType) error { return nil }))
+
+	for _, test := range tests {
+		network, prov := tree.Provenance(net.ParseIP(test.ip))
+		assert.Equal(t, test.network, network.String(), test.ip)
+		if test.expected == nil {
+			assert.Nil(t, prov, test.ip)
+			continue
+		}
+		require.NotNil(t, prov, test.ip)
+		assert.Equal(t, test.expected.Source, prov.Source, test.ip)
+		assert.Equal(t, test.expected.Fields, prov.Fields, test.ip)
+	}
+
+	untracked, err := New(Options{})
+	require.NoError(t, err)
+	untracked.SetSource("ignored")
+	require.NoError(t, untracked.Insert(mustParseNetwork(t, "1.1.0.0/16"), mmdbtype.String("x")))
+	_, prov := untracked.Provenance(net.ParseIP("1.1.1.1"))
+	assert.Nil(t, prov)
+}
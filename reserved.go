@@ -1,5 +1,24 @@
 package mmdbwriter
 
+import "net"
+
+// DefaultReservedNetworks returns the networks that are reserved by default
+// in a tree of the IP version, 4 or 6. The IPv4 networks are included in the
+// IPv6 networks.
+func DefaultReservedNetworks(ipVersion int) []*net.IPNet {
+	networks := reservedNetworksIPv4
+	if ipVersion == 6 {
+		networks = append(networks[:len(networks):len(networks)], reservedNetworksIPv6...)
+	}
+
+	ipnets := make([]*net.IPNet, 0, len(networks))
+	for _, network := range networks {
+		_, ipnet, _ := net.ParseCIDR(network)
+		ipnets = append(ipnets, ipnet)
+	}
+	return ipnets
+}
+
 // These were taken from the Perl writer.
 //
 // https://www.iana.org/assignments/iana-ipv4-special-registry/iana-ipv4-special-registry.xhtml
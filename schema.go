@@ -0,0 +1,109 @@
+package mmdbwriter
+
+import (
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/maxmind/mmdbwriter/mmdbtype"
+	"github.com/pkg/errors"
+)
+
+// Schema describes the keys of the Map values in a tree. See
+// Options.Schema. Keys that are not in the schema are rejected, so that a
+// misspelled key is reported rather than silently creating a new field:
+//
+//	mmdbwriter.Schema{
+//		"country": {Type: mmdbtype.Map{}, Required: true, Fields: mmdbwriter.Schema{
+//			"iso_code": {Type: mmdbtype.String(""), Required: true},
+//			"names":    {Type: mmdbtype.Map{}},
+//		}},
+//		"tags": {Type: mmdbtype.Slice{}, Elem: &mmdbwriter.Field{Type: mmdbtype.String("")}},
+//	}
+type Schema map[mmdbtype.String]Field
+
+// Field describes a key of a Map in a Schema and its value.
+type Field struct {
+	// Type is a value of the type the value must have, e.g.,
+	// mmdbtype.String(""). The value itself is ignored. If Type is nil, the
+	// value may have any type.
+	Type mmdbtype.DataType
+
+	// Required is set if the key must be in the Map.
+	Required bool
+
+	// Fields is the schema of a Map value. If it is nil, the keys of the
+	// Map are not checked.
+	Fields Schema
+
+	// Elem describes the elements of a Slice value. If it is nil, the
+	// elements are not checked.
+	Elem *Field
+}
+
+// Validate checks that the value is a Map that matches the schema. The
+// error includes the location of the invalid value, e.g.,
+// "value does not match the schema at country.iso_code: the value is a
+// mmdbtype.Uint32, not a mmdbtype.String".
+func (s Schema) Validate(value mmdbtype.DataType) error {
+	return validateField(Field{Type: mmdbtype.Map{}, Fields: s}, value, nil)
+}
+
+func validateField(f Field, value mmdbtype.DataType, path []string) error {
+	if f.Type != nil && reflect.TypeOf(value) != reflect.TypeOf(f.Type) {
+		return schemaError(path, "the value is a %T, not a %T", value, f.Type)
+	}
+
+	switch v := value.(type) {
+	case mmdbtype.Map:
+		if f.Fields == nil {
+			return nil
+		}
+		keys := make([]string, 0, len(v))
+		for k := range v {
+			keys = append(keys, string(k))
+		}
+		// Sorting the keys makes the error deterministic when there are
+		// several invalid keys.
+		sort.Strings(keys)
+		for _, k := range keys {
+			field, ok := f.Fields[mmdbtype.String(k)]
+			if !ok {
+				return schemaError(path, "the key %q is not in the schema", k)
+			}
+			if err := validateField(field, v[mmdbtype.String(k)], append(path, "."+k)); err != nil {
+				return err
+			}
+		}
+		var missing []string
+		for k, field := range f.Fields {
+			if _, ok := v[k]; field.Required && !ok {
+				missing = append(missing, string(k))
+			}
+		}
+		if len(missing) > 0 {
+			sort.Strings(missing)
+			return schemaError(path, "the required key %q is missing", missing[0])
+		}
+	case mmdbtype.Slice:
+		if f.Elem == nil {
+			return nil
+		}
+		for i, e := range v {
+			if err := validateField(*f.Elem, e, append(path, "["+strconv.Itoa(i)+"]")); err != nil {
+				return err
+			}
+		}
+	default:
+	}
+	return nil
+}
+
+func schemaError(path []string, format string, args ...interface{}) error {
+	location := ""
+	if len(path) > 0 {
+		location = " at " + strings.TrimPrefix(strings.Join(path, ""), ".")
+	}
+	return errors.Errorf("value does not match the schema"+location+": "+format, args...)
+}
@@ -0,0 +1,137 @@
+package geoip2
+
+import (
+	"github.com/maxmind/mmdbwriter/inserter"
+	"github.com/maxmind/mmdbwriter/mmdbtype"
+	"github.com/pkg/errors"
+)
+
+// AnonymousIPRecord is a record in a GeoIP2 Anonymous IP database.
+type AnonymousIPRecord struct {
+	// IsAnonymous is set in the Map if it or any of the other fields is
+	// true.
+	IsAnonymous        bool
+	IsAnonymousVPN     bool
+	IsHostingProvider  bool
+	IsPublicProxy      bool
+	IsResidentialProxy bool
+	IsTorExitNode      bool
+}
+
+// anonymousIPFlags are the keys of the fields of an AnonymousIPRecord other
+// than is_anonymous.
+var anonymousIPFlags = []mmdbtype.String{
+	"is_anonymous_vpn",
+	"is_hosting_provider",
+	"is_public_proxy",
+	"is_residential_proxy",
+	"is_tor_exit_node",
+}
+
+// Map returns the record as a Map. Fields that are false are omitted.
+func (r AnonymousIPRecord) Map() mmdbtype.Map {
+	m := mmdbtype.Map{}
+	putBool(m, "is_anonymous_vpn", r.IsAnonymousVPN)
+	putBool(m, "is_hosting_provider", r.IsHostingProvider)
+	putBool(m, "is_public_proxy", r.IsPublicProxy)
+	putBool(m, "is_residential_proxy", r.IsResidentialProxy)
+	putBool(m, "is_tor_exit_node", r.IsTorExitNode)
+	putBool(m, "is_anonymous", r.IsAnonymous || len(m) > 0)
+	return m
+}
+
+// MergeAnonymousIPWith is an inserter.FuncGenerator for layering multiple
+// proxy and VPN feeds in an Anonymous IP database. The flags of the new and
+// existing Map are combined, so that a flag is true if any feed set it, and
+// is_anonymous is set if any flag is. Other keys of the new Map replace
+// those of the existing Map, as with inserter.TopLevelMergeWith.
+//
+// If the new Map has no keys other than flags that are already set, the
+// existing Map is kept as is rather than copied, so that feeds that mostly
+// overlap do not create new values.
+//
+// Both the new and existing value must be a Map and the flags must be Bool
+// values. An error will be returned otherwise.
+func MergeAnonymousIPWith(newValue mmdbtype.DataType) inserter.Func {
+	return func(existingValue mmdbtype.DataType) (mmdbtype.DataType, error) {
+		newMap, ok := newValue.(mmdbtype.Map)
+		if !ok {
+			return nil, errors.Errorf(
+				"the new value is a %T, not a Map. MergeAnonymousIPWith only works if both values are Map values.",
+				newValue,
+			)
+		}
+		if err := checkAnonymousIPFlags(newMap); err != nil {
+			return nil, err
+		}
+
+		if existingValue == nil {
+			return newMap, nil
+		}
+
+		existingMap, ok := existingValue.(mmdbtype.Map)
+		if !ok {
+			return nil, errors.Errorf(
+				"the existing value is a %T, not a Map. MergeAnonymousIPWith only works if both values are Map values.",
+				existingValue,
+			)
+		}
+		if err := checkAnonymousIPFlags(existingMap); err != nil {
+			return nil, err
+		}
+
+		changed := false
+		for k, v := range newMap {
+			if isAnonymousIPFlag(k) && (v == mmdbtype.Bool(false) || existingMap[k] == mmdbtype.Bool(true)) {
+				continue
+			}
+			changed = true
+			break
+		}
+		if !changed {
+			return existingMap, nil
+		}
+
+		returnMap := existingMap.Copy().(mmdbtype.Map)
+		for k, v := range newMap {
+			if isAnonymousIPFlag(k) {
+				if v == mmdbtype.Bool(true) {
+					returnMap[k] = v
+				}
+				continue
+			}
+			returnMap[k] = v.Copy()
+		}
+		for _, k := range anonymousIPFlags {
+			if returnMap[k] == mmdbtype.Bool(true) {
+				returnMap["is_anonymous"] = mmdbtype.Bool(true)
+				break
+			}
+		}
+		return returnMap, nil
+	}
+}
+
+func isAnonymousIPFlag(k mmdbtype.String) bool {
+	if k == "is_anonymous" {
+		return true
+	}
+	for _, flag := range anonymousIPFlags {
+		if k == flag {
+			return true
+		}
+	}
+	return false
+}
+
+func checkAnonymousIPFlags(m mmdbtype.Map) error {
+	for k, v := range m {
+		if !isAnonymousIPFlag(k) {
+			continue
+		}
+		if _, ok := v.(mmdbtype.Bool); !ok {
+			return errors.Errorf("the value for %s is a %T, not a Bool", k, v)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,98 @@
+package geoip2
+
+import (
+	"net"
+	"testing"
+
+	"github.com/maxmind/mmdbwriter"
+	"github.com/maxmind/mmdbwriter/mmdbtype"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestAnonymousIPRecord(t *testing.T) {
+	assert.Equal(
+		t,
+		mmdbtype.Map{
+			"is_anonymous":     mmdbtype.Bool(true),
+			"is_anonymous_vpn": mmdbtype.Bool(true),
+			"is_tor_exit_node": mmdbtype.Bool(true),
+		},
+		AnonymousIPRecord{IsAnonymousVPN: true, IsTorExitNode: true}.Map(),
+	)
+	assert.Equal(
+		t,
+		mmdbtype.Map{"is_anonymous": mmdbtype.Bool(true)},
+		AnonymousIPRecord{IsAnonymous: true}.Map(),
+	)
+	assert.Equal(t, mmdbtype.Map{}, AnonymousIPRecord{}.Map())
+}
+
+func TestMergeAnonymousIPWith(t *testing.T) {
+	existing := AnonymousIPRecord{IsPublicProxy: true}.Map()
+
+	value, err := MergeAnonymousIPWith(AnonymousIPRecord{IsAnonymousVPN: true}.Map())(existing)
+	require.NoError(t, err)
+	assert.Equal(t, AnonymousIPRecord{IsAnonymousVPN: true, IsPublicProxy: true}.Map(), value)
+	assert.Equal(t, AnonymousIPRecord{IsPublicProxy: true}.Map(), existing, "the existing value is not modified")
+
+	// Flags that are already set, or false, do not change the existing
+	// value.
+	value, err = MergeAnonymousIPWith(mmdbtype.Map{
+		"is_anonymous":     mmdbtype.Bool(true),
+		"is_public_proxy":  mmdbtype.Bool(true),
+		"is_tor_exit_node": mmdbtype.Bool(false),
+	})(existing)
+	require.NoError(t, err)
+	assert.Equal(t, existing, value)
+
+	value, err = MergeAnonymousIPWith(mmdbtype.Map{
+		"is_hosting_provider": mmdbtype.Bool(true),
+		"source":              mmdbtype.String("feed"),
+	})(mmdbtype.Map{"source": mmdbtype.String("other")})
+	require.NoError(t, err)
+	assert.Equal(
+		t,
+		mmdbtype.Map{
+			"is_anonymous":        mmdbtype.Bool(true),
+			"is_hosting_provider": mmdbtype.Bool(true),
+			"source":              mmdbtype.String("feed"),
+		},
+		value,
+	)
+
+	value, err = MergeAnonymousIPWith(existing)(nil)
+	require.NoError(t, err)
+	assert.Equal(t, existing, value)
+
+	_, err = MergeAnonymousIPWith(mmdbtype.String("a"))(nil)
+	assert.EqualError(
+		t,
+		err,
+		"the new value is a mmdbtype.String, not a Map. MergeAnonymousIPWith only works if both values are Map values.",
+	)
+	_, err = MergeAnonymousIPWith(existing)(mmdbtype.Uint32(1))
+	assert.EqualError(
+		t,
+		err,
+		"the existing value is a mmdbtype.Uint32, not a Map. MergeAnonymousIPWith only works if both values are Map values.",
+	)
+	_, err = MergeAnonymousIPWith(mmdbtype.Map{"is_tor_exit_node": mmdbtype.Uint32(1)})(nil)
+	assert.EqualError(t, err, "the value for is_tor_exit_node is a mmdbtype.Uint32, not a Bool")
+
+	// Layering feeds in a tree.
+	tree, err := mmdbwriter.New(mmdbwriter.Options{})
+	require.NoError(t, err)
+	for network, record := range map[string]AnonymousIPRecord{
+		"1.1.0.0/16": {IsHostingProvider: true},
+		"1.1.1.0/24": {IsAnonymousVPN: true},
+	} {
+		_, ipNet, err := net.ParseCIDR(network)
+		require.NoError(t, err)
+		require.NoError(t, tree.InsertFunc(ipNet, MergeAnonymousIPWith(record.Map())))
+	}
+	_, value = tree.Get(net.ParseIP("1.1.1.1"))
+	assert.Equal(t, AnonymousIPRecord{IsAnonymousVPN: true, IsHostingProvider: true}.Map(), value)
+	_, value = tree.Get(net.ParseIP("1.1.2.1"))
+	assert.Equal(t, AnonymousIPRecord{IsHostingProvider: true}.Map(), value)
+}
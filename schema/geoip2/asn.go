@@ -0,0 +1,60 @@
+package geoip2
+
+import (
+	"strconv"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/maxmind/mmdbwriter/mmdbtype"
+	"github.com/pkg/errors"
+)
+
+// ASNRecord is a record in a GeoLite2 ASN database.
+type ASNRecord struct {
+	AutonomousSystemNumber       uint32
+	AutonomousSystemOrganization string
+}
+
+// Map returns the record as a Map. Fields with the zero value are omitted.
+func (r ASNRecord) Map() mmdbtype.Map {
+	m := mmdbtype.Map{}
+	putUint32(m, "autonomous_system_number", r.AutonomousSystemNumber)
+	putString(m, "autonomous_system_organization", r.AutonomousSystemOrganization)
+	return m
+}
+
+// reservedASNs are the AS numbers that may not be used to identify an AS:
+// 0 (RFC 7607), AS_TRANS (RFC 6793), and the last 16 and 32 bit numbers
+// (RFC 7300).
+var reservedASNs = map[uint32]bool{
+	0:          true,
+	23456:      true,
+	65535:      true,
+	4294967295: true,
+}
+
+// Validate checks that the AS number is set and is not reserved and that
+// the organization is valid UTF-8.
+func (r ASNRecord) Validate() error {
+	if reservedASNs[r.AutonomousSystemNumber] {
+		return errors.Errorf("the AS number %d is reserved", r.AutonomousSystemNumber)
+	}
+	if !utf8.ValidString(r.AutonomousSystemOrganization) {
+		return errors.Errorf("the AS organization %q is not valid UTF-8", r.AutonomousSystemOrganization)
+	}
+	return nil
+}
+
+// ParseASN parses an AS number with or without an "AS" prefix, e.g., "AS13335"
+// or "13335".
+func ParseASN(s string) (uint32, error) {
+	digits := s
+	if len(digits) >= 2 && strings.EqualFold(digits[:2], "AS") {
+		digits = digits[2:]
+	}
+	asn, err := strconv.ParseUint(digits, 10, 32)
+	if err != nil {
+		return 0, errors.Errorf("invalid AS number: %q", s)
+	}
+	return uint32(asn), nil
+}
@@ -0,0 +1,54 @@
+package geoip2
+
+import (
+	"testing"
+
+	"github.com/maxmind/mmdbwriter/mmdbtype"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestASNRecord(t *testing.T) {
+	record := ASNRecord{AutonomousSystemNumber: 13335, AutonomousSystemOrganization: "CLOUDFLARENET"}
+	assert.Equal(
+		t,
+		mmdbtype.Map{
+			"autonomous_system_number":       mmdbtype.Uint32(13335),
+			"autonomous_system_organization": mmdbtype.String("CLOUDFLARENET"),
+		},
+		record.Map(),
+	)
+	assert.NoError(t, record.Validate())
+
+	assert.Equal(
+		t,
+		mmdbtype.Map{"autonomous_system_number": mmdbtype.Uint32(64512)},
+		ASNRecord{AutonomousSystemNumber: 64512}.Map(),
+	)
+
+	assert.EqualError(t, ASNRecord{}.Validate(), "the AS number 0 is reserved")
+	assert.EqualError(t, ASNRecord{AutonomousSystemNumber: 23456}.Validate(), "the AS number 23456 is reserved")
+	assert.EqualError(
+		t,
+		ASNRecord{AutonomousSystemNumber: 1, AutonomousSystemOrganization: "\xff"}.Validate(),
+		`the AS organization "\xff" is not valid UTF-8`,
+	)
+}
+
+func TestParseASN(t *testing.T) {
+	for input, expected := range map[string]uint32{
+		"13335":      13335,
+		"AS13335":    13335,
+		"as15169":    15169,
+		"4294967294": 4294967294,
+	} {
+		asn, err := ParseASN(input)
+		require.NoError(t, err, input)
+		assert.Equal(t, expected, asn, input)
+	}
+
+	for _, input := range []string{"", "AS", "AS-1", "4294967296", "13335a"} {
+		_, err := ParseASN(input)
+		assert.EqualError(t, err, "invalid AS number: \""+input+"\"")
+	}
+}
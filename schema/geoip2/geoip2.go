@@ -0,0 +1,273 @@
+// Package geoip2 builds values with the same structure as the records in the
+// GeoIP2 and GeoLite2 databases, so that custom databases may be read by
+// the existing GeoIP2 readers and client libraries. Each type has the
+// fields of the corresponding part of a record and a method that converts
+// it to an mmdbtype value with the keys and types those readers expect.
+// Fields with the zero value are omitted:
+//
+//	record := geoip2.CityRecord{
+//		City: geoip2.City{GeoNameID: 2950159, Names: geoip2.Names{"en": "Berlin"}},
+//		Country: geoip2.Country{
+//			GeoNameID:         2921044,
+//			ISOCode:           "DE",
+//			Names:             geoip2.Names{"en": "Germany"},
+//			IsInEuropeanUnion: true,
+//		},
+//		Location: geoip2.Location{Latitude: 52.52, Longitude: 13.405, TimeZone: "Europe/Berlin"},
+//	}
+//	err := tree.Insert(network, record.Map())
+package geoip2
+
+import (
+	"github.com/maxmind/mmdbwriter/mmdbtype"
+)
+
+// Names maps locale codes, e.g., "en", to the name in that locale.
+type Names map[string]string
+
+// Map returns the names as a Map or nil if there are none.
+func (n Names) Map() mmdbtype.Map {
+	if len(n) == 0 {
+		return nil
+	}
+	m := make(mmdbtype.Map, len(n))
+	for locale, name := range n {
+		m[mmdbtype.String(locale)] = mmdbtype.String(name)
+	}
+	return m
+}
+
+// City is the city of a record.
+type City struct {
+	GeoNameID uint32
+	Names     Names
+}
+
+// Map returns the city as a Map. It is empty if the city is.
+func (c City) Map() mmdbtype.Map {
+	m := mmdbtype.Map{}
+	putUint32(m, "geoname_id", c.GeoNameID)
+	putMap(m, "names", c.Names.Map())
+	return m
+}
+
+// Continent is the continent of a record.
+type Continent struct {
+	// Code is the two-letter continent code, e.g., "EU".
+	Code      string
+	GeoNameID uint32
+	Names     Names
+}
+
+// Map returns the continent as a Map. It is empty if the continent is.
+func (c Continent) Map() mmdbtype.Map {
+	m := mmdbtype.Map{}
+	putString(m, "code", c.Code)
+	putUint32(m, "geoname_id", c.GeoNameID)
+	putMap(m, "names", c.Names.Map())
+	return m
+}
+
+// Country is a country of a record, e.g., its country or registered
+// country.
+type Country struct {
+	GeoNameID uint32
+	// ISOCode is the ISO 3166-1 alpha-2 code of the country, e.g., "DE".
+	ISOCode           string
+	Names             Names
+	IsInEuropeanUnion bool
+}
+
+// Map returns the country as a Map. It is empty if the country is.
+func (c Country) Map() mmdbtype.Map {
+	m := mmdbtype.Map{}
+	putUint32(m, "geoname_id", c.GeoNameID)
+	putString(m, "iso_code", c.ISOCode)
+	putMap(m, "names", c.Names.Map())
+	putBool(m, "is_in_european_union", c.IsInEuropeanUnion)
+	return m
+}
+
+// RepresentedCountry is the country represented by the users of a network,
+// e.g., for military bases.
+type RepresentedCountry struct {
+	Country
+	// Type is the type of entity, e.g., "military".
+	Type string
+}
+
+// Map returns the represented country as a Map. It is empty if the
+// represented country is.
+func (c RepresentedCountry) Map() mmdbtype.Map {
+	m := c.Country.Map()
+	putString(m, "type", c.Type)
+	return m
+}
+
+// Subdivision is a subdivision of a country, e.g., a state.
+type Subdivision struct {
+	GeoNameID uint32
+	// ISOCode is the ISO 3166-2 code of the subdivision without the country
+	// code, e.g., "BE".
+	ISOCode string
+	Names   Names
+}
+
+// Map returns the subdivision as a Map. It is empty if the subdivision is.
+func (s Subdivision) Map() mmdbtype.Map {
+	m := mmdbtype.Map{}
+	putUint32(m, "geoname_id", s.GeoNameID)
+	putString(m, "iso_code", s.ISOCode)
+	putMap(m, "names", s.Names.Map())
+	return m
+}
+
+// Subdivisions are the subdivisions of a record, from the largest to the
+// smallest.
+type Subdivisions []Subdivision
+
+// Slice returns the subdivisions as a Slice of Maps or nil if there are none.
+func (s Subdivisions) Slice() mmdbtype.Slice {
+	if len(s) == 0 {
+		return nil
+	}
+	slice := make(mmdbtype.Slice, 0, len(s))
+	for _, subdivision := range s {
+		slice = append(slice, subdivision.Map())
+	}
+	return slice
+}
+
+// Location is the location of a record.
+type Location struct {
+	// AccuracyRadius is the radius in kilometers around the coordinates
+	// within which the address is likely to be.
+	AccuracyRadius uint16
+	// Latitude and Longitude are omitted if both are 0.
+	Latitude  float64
+	Longitude float64
+	MetroCode uint16
+	// TimeZone is the IANA time zone, e.g., "Europe/Berlin".
+	TimeZone string
+}
+
+// Map returns the location as a Map. It is empty if the location is.
+func (l Location) Map() mmdbtype.Map {
+	m := mmdbtype.Map{}
+	if l.AccuracyRadius != 0 {
+		m["accuracy_radius"] = mmdbtype.Uint16(l.AccuracyRadius)
+	}
+	if l.Latitude != 0 || l.Longitude != 0 {
+		m["latitude"] = mmdbtype.Float64(l.Latitude)
+		m["longitude"] = mmdbtype.Float64(l.Longitude)
+	}
+	if l.MetroCode != 0 {
+		m["metro_code"] = mmdbtype.Uint16(l.MetroCode)
+	}
+	putString(m, "time_zone", l.TimeZone)
+	return m
+}
+
+// Postal is the postal code of a record.
+type Postal struct {
+	Code string
+}
+
+// Map returns the postal code as a Map. It is empty if there is no code.
+func (p Postal) Map() mmdbtype.Map {
+	m := mmdbtype.Map{}
+	putString(m, "code", p.Code)
+	return m
+}
+
+// Traits are the traits of a network in the City and Country databases.
+type Traits struct {
+	IsAnonymousProxy    bool
+	IsAnycast           bool
+	IsSatelliteProvider bool
+}
+
+// Map returns the traits as a Map. It is empty if none of the traits are
+// set.
+func (t Traits) Map() mmdbtype.Map {
+	m := mmdbtype.Map{}
+	putBool(m, "is_anonymous_proxy", t.IsAnonymousProxy)
+	putBool(m, "is_anycast", t.IsAnycast)
+	putBool(m, "is_satellite_provider", t.IsSatelliteProvider)
+	return m
+}
+
+// CountryRecord is a record in a GeoIP2 or GeoLite2 Country database.
+type CountryRecord struct {
+	Continent          Continent
+	Country            Country
+	RegisteredCountry  Country
+	RepresentedCountry RepresentedCountry
+	Traits             Traits
+}
+
+// Map returns the record as a Map. Empty parts of the record are omitted.
+func (r CountryRecord) Map() mmdbtype.Map {
+	m := mmdbtype.Map{}
+	putMap(m, "continent", r.Continent.Map())
+	putMap(m, "country", r.Country.Map())
+	putMap(m, "registered_country", r.RegisteredCountry.Map())
+	putMap(m, "represented_country", r.RepresentedCountry.Map())
+	putMap(m, "traits", r.Traits.Map())
+	return m
+}
+
+// CityRecord is a record in a GeoIP2 or GeoLite2 City database.
+type CityRecord struct {
+	City               City
+	Continent          Continent
+	Country            Country
+	Location           Location
+	Postal             Postal
+	RegisteredCountry  Country
+	RepresentedCountry RepresentedCountry
+	Subdivisions       Subdivisions
+	Traits             Traits
+}
+
+// Map returns the record as a Map. Empty parts of the record are omitted.
+func (r CityRecord) Map() mmdbtype.Map {
+	m := CountryRecord{
+		Continent:          r.Continent,
+		Country:            r.Country,
+		RegisteredCountry:  r.RegisteredCountry,
+		RepresentedCountry: r.RepresentedCountry,
+		Traits:             r.Traits,
+	}.Map()
+	putMap(m, "city", r.City.Map())
+	putMap(m, "location", r.Location.Map())
+	putMap(m, "postal", r.Postal.Map())
+	if s := r.Subdivisions.Slice(); s != nil {
+		m["subdivisions"] = s
+	}
+	return m
+}
+
+func putString(m mmdbtype.Map, key mmdbtype.String, v string) {
+	if v != "" {
+		m[key] = mmdbtype.String(v)
+	}
+}
+
+func putUint32(m mmdbtype.Map, key mmdbtype.String, v uint32) {
+	if v != 0 {
+		m[key] = mmdbtype.Uint32(v)
+	}
+}
+
+func putBool(m mmdbtype.Map, key mmdbtype.String, v bool) {
+	if v {
+		m[key] = mmdbtype.Bool(true)
+	}
+}
+
+func putMap(m mmdbtype.Map, key mmdbtype.String, v mmdbtype.Map) {
+	if len(v) > 0 {
+		m[key] = v
+	}
+}
@@ -0,0 +1,141 @@
+package geoip2
+
+import (
+	"bytes"
+	"net"
+	"testing"
+
+	"github.com/maxmind/mmdbwriter"
+	"github.com/maxmind/mmdbwriter/mmdbtype"
+	"github.com/oschwald/maxminddb-golang"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestCityRecord(t *testing.T) {
+	germany := Country{
+		GeoNameID:         2921044,
+		ISOCode:           "DE",
+		Names:             Names{"de": "Deutschland", "en": "Germany"},
+		IsInEuropeanUnion: true,
+	}
+	record := CityRecord{
+		City:      City{GeoNameID: 2950159, Names: Names{"en": "Berlin"}},
+		Continent: Continent{Code: "EU", GeoNameID: 6255148, Names: Names{"en": "Europe"}},
+		Country:   germany,
+		Location: Location{
+			AccuracyRadius: 20,
+			Latitude:       52.52,
+			Longitude:      13.405,
+			TimeZone:       "Europe/Berlin",
+		},
+		Postal:            Postal{Code: "10115"},
+		RegisteredCountry: germany,
+		RepresentedCountry: RepresentedCountry{
+			Country: Country{GeoNameID: 6252001, ISOCode: "US"},
+			Type:    "military",
+		},
+		Subdivisions: Subdivisions{{GeoNameID: 2950157, ISOCode: "BE", Names: Names{"en": "Land Berlin"}}},
+		Traits:       Traits{IsAnycast: true},
+	}
+
+	germanyMap := mmdbtype.Map{
+		"geoname_id": mmdbtype.Uint32(2921044),
+		"iso_code":   mmdbtype.String("DE"),
+		"names": mmdbtype.Map{
+			"de": mmdbtype.String("Deutschland"),
+			"en": mmdbtype.String("Germany"),
+		},
+		"is_in_european_union": mmdbtype.Bool(true),
+	}
+	assert.Equal(
+		t,
+		mmdbtype.Map{
+			"city": mmdbtype.Map{
+				"geoname_id": mmdbtype.Uint32(2950159),
+				"names":      mmdbtype.Map{"en": mmdbtype.String("Berlin")},
+			},
+			"continent": mmdbtype.Map{
+				"code":       mmdbtype.String("EU"),
+				"geoname_id": mmdbtype.Uint32(6255148),
+				"names":      mmdbtype.Map{"en": mmdbtype.String("Europe")},
+			},
+			"country": germanyMap,
+			"location": mmdbtype.Map{
+				"accuracy_radius": mmdbtype.Uint16(20),
+				"latitude":        mmdbtype.Float64(52.52),
+				"longitude":       mmdbtype.Float64(13.405),
+				"time_zone":       mmdbtype.String("Europe/Berlin"),
+			},
+			"postal":             mmdbtype.Map{"code": mmdbtype.String("10115")},
+			"registered_country": germanyMap,
+			"represented_country": mmdbtype.Map{
+				"geoname_id": mmdbtype.Uint32(6252001),
+				"iso_code":   mmdbtype.String("US"),
+				"type":       mmdbtype.String("military"),
+			},
+			"subdivisions": mmdbtype.Slice{
+				mmdbtype.Map{
+					"geoname_id": mmdbtype.Uint32(2950157),
+					"iso_code":   mmdbtype.String("BE"),
+					"names":      mmdbtype.Map{"en": mmdbtype.String("Land Berlin")},
+				},
+			},
+			"traits": mmdbtype.Map{"is_anycast": mmdbtype.Bool(true)},
+		},
+		record.Map(),
+	)
+
+	assert.Equal(t, mmdbtype.Map{}, CityRecord{}.Map())
+	assert.Equal(
+		t,
+		mmdbtype.Map{"country": mmdbtype.Map{"iso_code": mmdbtype.String("DE")}},
+		CountryRecord{Country: Country{ISOCode: "DE"}}.Map(),
+	)
+
+	// The record must decode into the structure used by the GeoIP2
+	// readers.
+	tree, err := mmdbwriter.New(mmdbwriter.Options{DatabaseType: "GeoIP2-City"})
+	require.NoError(t, err)
+	_, network, err := net.ParseCIDR("2.16.20.0/24")
+	require.NoError(t, err)
+	require.NoError(t, tree.Insert(network, record.Map()))
+	buf := &bytes.Buffer{}
+	_, err = tree.WriteTo(buf)
+	require.NoError(t, err)
+	reader, err := maxminddb.FromBytes(buf.Bytes())
+	require.NoError(t, err)
+
+	var city struct {
+		City struct {
+			GeoNameID uint              `maxminddb:"geoname_id"`
+			Names     map[string]string `maxminddb:"names"`
+		} `maxminddb:"city"`
+		Country struct {
+			IsInEuropeanUnion bool   `maxminddb:"is_in_european_union"`
+			IsoCode           string `maxminddb:"iso_code"`
+		} `maxminddb:"country"`
+		Location struct {
+			AccuracyRadius uint16  `maxminddb:"accuracy_radius"`
+			Latitude       float64 `maxminddb:"latitude"`
+			TimeZone       string  `maxminddb:"time_zone"`
+		} `maxminddb:"location"`
+		Subdivisions []struct {
+			IsoCode string `maxminddb:"iso_code"`
+		} `maxminddb:"subdivisions"`
+		Traits struct {
+			IsAnycast bool `maxminddb:"is_anycast"`
+		} `maxminddb:"traits"`
+	}
+	require.NoError(t, reader.Lookup(net.ParseIP("2.16.20.1"), &city))
+	assert.Equal(t, uint(2950159), city.City.GeoNameID)
+	assert.Equal(t, "Berlin", city.City.Names["en"])
+	assert.True(t, city.Country.IsInEuropeanUnion)
+	assert.Equal(t, "DE", city.Country.IsoCode)
+	assert.Equal(t, uint16(20), city.Location.AccuracyRadius)
+	assert.Equal(t, 52.52, city.Location.Latitude)
+	assert.Equal(t, "Europe/Berlin", city.Location.TimeZone)
+	require.Len(t, city.Subdivisions, 1)
+	assert.Equal(t, "BE", city.Subdivisions[0].IsoCode)
+	assert.True(t, city.Traits.IsAnycast)
+}
@@ -0,0 +1,46 @@
+package geoip2
+
+import (
+	"unicode/utf8"
+
+	"github.com/maxmind/mmdbwriter/mmdbtype"
+	"github.com/pkg/errors"
+)
+
+// ISPRecord is a record in a GeoIP2 ISP database.
+type ISPRecord struct {
+	ASNRecord
+	ISP          string
+	Organization string
+	// MobileCountryCode and MobileNetworkCode identify the mobile network
+	// of the ISP, e.g., "310" and "004". They are strings so that leading
+	// zeros are retained.
+	MobileCountryCode string
+	MobileNetworkCode string
+}
+
+// Map returns the record as a Map. Fields with the zero value are omitted.
+func (r ISPRecord) Map() mmdbtype.Map {
+	m := r.ASNRecord.Map()
+	putString(m, "isp", r.ISP)
+	putString(m, "organization", r.Organization)
+	putString(m, "mobile_country_code", r.MobileCountryCode)
+	putString(m, "mobile_network_code", r.MobileNetworkCode)
+	return m
+}
+
+// Validate checks the record as ASNRecord.Validate does, except that the AS
+// number may be unset, and that the other fields are valid UTF-8.
+func (r ISPRecord) Validate() error {
+	if r.AutonomousSystemNumber != 0 || r.AutonomousSystemOrganization != "" {
+		if err := r.ASNRecord.Validate(); err != nil {
+			return err
+		}
+	}
+	for _, v := range []string{r.ISP, r.Organization, r.MobileCountryCode, r.MobileNetworkCode} {
+		if !utf8.ValidString(v) {
+			return errors.Errorf("%q is not valid UTF-8", v)
+		}
+	}
+	return nil
+}
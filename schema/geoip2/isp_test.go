@@ -0,0 +1,70 @@
+package geoip2
+
+import (
+	"bytes"
+	"net"
+	"testing"
+
+	"github.com/maxmind/mmdbwriter"
+	"github.com/maxmind/mmdbwriter/mmdbtype"
+	"github.com/oschwald/maxminddb-golang"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestISPRecord(t *testing.T) {
+	record := ISPRecord{
+		ASNRecord: ASNRecord{
+			AutonomousSystemNumber:       6167,
+			AutonomousSystemOrganization: "CELLCO-PART",
+		},
+		ISP:               "Verizon Wireless",
+		Organization:      "Verizon Wireless",
+		MobileCountryCode: "310",
+		MobileNetworkCode: "004",
+	}
+	assert.Equal(
+		t,
+		mmdbtype.Map{
+			"autonomous_system_number":       mmdbtype.Uint32(6167),
+			"autonomous_system_organization": mmdbtype.String("CELLCO-PART"),
+			"isp":                            mmdbtype.String("Verizon Wireless"),
+			"organization":                   mmdbtype.String("Verizon Wireless"),
+			"mobile_country_code":            mmdbtype.String("310"),
+			"mobile_network_code":            mmdbtype.String("004"),
+		},
+		record.Map(),
+	)
+	assert.NoError(t, record.Validate())
+	assert.Equal(t, mmdbtype.Map{"isp": mmdbtype.String("ISP")}, ISPRecord{ISP: "ISP"}.Map())
+	assert.NoError(t, ISPRecord{ISP: "ISP"}.Validate())
+	assert.EqualError(
+		t,
+		ISPRecord{ASNRecord: ASNRecord{AutonomousSystemOrganization: "Org"}}.Validate(),
+		"the AS number 0 is reserved",
+	)
+	assert.EqualError(t, ISPRecord{ISP: "\xff"}.Validate(), `"\xff" is not valid UTF-8`)
+
+	// The record must decode into the structure used by the GeoIP2
+	// readers.
+	tree, err := mmdbwriter.New(mmdbwriter.Options{DatabaseType: "GeoIP2-ISP"})
+	require.NoError(t, err)
+	_, network, err := net.ParseCIDR("2.16.20.0/24")
+	require.NoError(t, err)
+	require.NoError(t, tree.Insert(network, record.Map()))
+	buf := &bytes.Buffer{}
+	_, err = tree.WriteTo(buf)
+	require.NoError(t, err)
+	reader, err := maxminddb.FromBytes(buf.Bytes())
+	require.NoError(t, err)
+
+	var isp struct {
+		AutonomousSystemNumber uint   `maxminddb:"autonomous_system_number"`
+		ISP                    string `maxminddb:"isp"`
+		MobileNetworkCode      string `maxminddb:"mobile_network_code"`
+	}
+	require.NoError(t, reader.Lookup(net.ParseIP("2.16.20.1"), &isp))
+	assert.Equal(t, uint(6167), isp.AutonomousSystemNumber)
+	assert.Equal(t, "Verizon Wireless", isp.ISP)
+	assert.Equal(t, "004", isp.MobileNetworkCode)
+}
@@ -0,0 +1,105 @@
+package mmdbwriter
+
+import (
+	"testing"
+
+	"github.com/maxmind/mmdbwriter/mmdbtype"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSchema(t *testing.T) {
+	schema := Schema{
+		"country": {Type: mmdbtype.Map{}, Required: true, Fields: Schema{
+			"iso_code": {Type: mmdbtype.String(""), Required: true},
+			"names":    {Type: mmdbtype.Map{}},
+		}},
+		"tags":  {Type: mmdbtype.Slice{}, Elem: &Field{Type: mmdbtype.String("")}},
+		"extra": {},
+	}
+
+	tests := []struct {
+		name  string
+		value mmdbtype.DataType
+		err   string
+	}{
+		{
+			name:  "required only",
+			value: mmdbtype.Map{"country": mmdbtype.Map{"iso_code": mmdbtype.String("DE")}},
+		},
+		{
+			name: "all keys",
+			value: mmdbtype.Map{
+				"country": mmdbtype.Map{
+					"iso_code": mmdbtype.String("DE"),
+					"names":    mmdbtype.Map{"en": mmdbtype.String("Germany"), "any": mmdbtype.Uint32(1)},
+				},
+				"tags":  mmdbtype.Slice{mmdbtype.String("a"), mmdbtype.String("b")},
+				"extra": mmdbtype.Bool(true),
+			},
+		},
+		{
+			name:  "not a Map",
+			value: mmdbtype.String("DE"),
+			err:   "value does not match the schema: the value is a mmdbtype.String, not a mmdbtype.Map",
+		},
+		{
+			name: "unknown key",
+			value: mmdbtype.Map{
+				"country": mmdbtype.Map{"iso_code": mmdbtype.String("DE")},
+				"contry":  mmdbtype.Map{"iso_code": mmdbtype.String("DE")},
+			},
+			err: `value does not match the schema: the key "contry" is not in the schema`,
+		},
+		{
+			name:  "missing key",
+			value: mmdbtype.Map{"country": mmdbtype.Map{"names": mmdbtype.Map{}}},
+			err:   `value does not match the schema at country: the required key "iso_code" is missing`,
+		},
+		{
+			name:  "wrong type",
+			value: mmdbtype.Map{"country": mmdbtype.Map{"iso_code": mmdbtype.Uint32(1)}},
+			err:   "value does not match the schema at country.iso_code: the value is a mmdbtype.Uint32, not a mmdbtype.String",
+		},
+		{
+			name: "wrong element type",
+			value: mmdbtype.Map{
+				"country": mmdbtype.Map{"iso_code": mmdbtype.String("DE")},
+				"tags":    mmdbtype.Slice{mmdbtype.String("a"), mmdbtype.Bool(true)},
+			},
+			err: "value does not match the schema at tags[1]: the value is a mmdbtype.Bool, not a mmdbtype.String",
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			err := schema.Validate(test.value)
+			if test.err == "" {
+				assert.NoError(t, err)
+			} else {
+				assert.EqualError(t, err, test.err)
+			}
+		})
+	}
+}
+
+func TestSchemaInsert(t *testing.T) {
+	tree, err := New(Options{Schema: Schema{"a": {Type: mmdbtype.Uint32(0), Required: true}}})
+	require.NoError(t, err)
+
+	network := mustParseNetwork(t, "1.1.1.0/24")
+	require.NoError(t, tree.Insert(network, mmdbtype.Map{"a": mmdbtype.Uint32(1)}))
+	assert.EqualError(
+		t,
+		tree.Insert(network, mmdbtype.Map{"a": mmdbtype.Uint32(1), "b": mmdbtype.Uint32(2)}),
+		`value does not match the schema: the key "b" is not in the schema`,
+	)
+	// Removing a value does not insert one.
+	require.NoError(t, tree.Remove(network))
+
+	clone := tree.Clone()
+	assert.EqualError(
+		t,
+		clone.Insert(network, mmdbtype.Map{}),
+		`value does not match the schema: the required key "a" is missing`,
+	)
+}
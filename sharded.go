@@ -0,0 +1,300 @@
+package mmdbwriter
+
+import (
+	"bytes"
+	"fmt"
+	"net"
+
+	"github.com/maxmind/mmdbwriter/inserter"
+	"github.com/maxmind/mmdbwriter/mmdbtype"
+	"github.com/pkg/errors"
+)
+
+// ShardedBuilder builds a tree from multiple goroutines in parallel. The
+// address space is partitioned by the leading bits of the addresses, with
+// IPv4 addresses partitioned separately in IPv6 trees, and each partition
+// is assigned to one of several shards. Each shard is a tree of its own, so
+// inserts into different shards run in parallel, while inserts into the
+// same shard are serialized. Tree stitches the shards together into a
+// single tree once all of the data has been inserted.
+//
+// As each partition is only ever changed by its own shard, the result is the
+// same as if the networks had been inserted into a single tree, provided
+// that inserts with overlapping networks are made in the intended order,
+// e.g., from the same goroutine.
+type ShardedBuilder struct {
+	tree       *Tree
+	shards     []*Tree
+	partitions []partition
+}
+
+// partition is a network, in the tree's representation, and the shard that
+// it is assigned to.
+type partition struct {
+	ip        net.IP
+	prefixLen int
+	shard     int
+}
+
+// maxShards is the maximum number of shards for a ShardedBuilder.
+const maxShards = 1 << 12
+
+// NewShardedBuilder returns a ShardedBuilder with the number of shards,
+// which is typically the number of goroutines inserting into it. The shards
+// are balanced best when the number is a power of two. The options are
+// those of the tree returned by Tree. Functions set in the options, such as
+// OnInsert, may be called concurrently by the shards.
+//
+// As the shards do not share their nodes, Options.MaxNodes limits the nodes
+// of each shard separately while the networks are inserted. Tree returns an
+// error that wraps ErrNodeLimitExceeded if the stitched tree has more nodes
+// than the limit.
+func NewShardedBuilder(opts Options, shards int) (*ShardedBuilder, error) {
+	if shards < 1 || shards > maxShards {
+		return nil, errors.Errorf("the number of shards must be between 1 and %d", maxShards)
+	}
+
+	tree, err := New(opts)
+	if err != nil {
+		return nil, err
+	}
+
+	b := &ShardedBuilder{tree: tree}
+
+	shardOpts := opts
+	shardOpts.BuildEpoch = tree.buildEpoch
+	shardOpts.ThreadSafe = true
+	for i := 0; i < shards; i++ {
+		shard, err := New(shardOpts)
+		if err != nil {
+			return nil, err
+		}
+		b.shards = append(b.shards, shard)
+	}
+
+	bits := 0
+	for 1<<bits < shards {
+		bits++
+	}
+
+	// The space is divided into 2^bits partitions. In IPv6 trees, the
+	// IPv4 subtree at ::/96 is divided the same way, and the rest of the
+	// first partition, which contains ::/96, is split around it and
+	// assigned to the first shard.
+	v4Depth := 0
+	if tree.treeDepth == 128 {
+		v4Depth = 96
+	}
+	for i := 0; i < 1<<bits; i++ {
+		if v4Depth != 0 && i != 0 {
+			b.partitions = append(b.partitions, b.newPartition(0, i, bits, i%shards))
+		}
+		b.partitions = append(b.partitions, b.newPartition(v4Depth, i, bits, i%shards))
+	}
+	for depth := bits; depth < v4Depth; depth++ {
+		b.partitions = append(b.partitions, b.newPartition(depth, 1, 1, 0))
+	}
+
+	return b, nil
+}
+
+// newPartition returns the partition for the network whose prefixLen bits
+// after the first depth bits are num and whose earlier bits are 0.
+func (b *ShardedBuilder) newPartition(depth, num, prefixLen, shard int) partition {
+	ip := make(net.IP, b.tree.treeDepth/8)
+	for i := 0; i < prefixLen; i++ {
+		setBit(ip, depth+i, byte(num>>(prefixLen-1-i)&1))
+	}
+	return partition{ip: ip, prefixLen: depth + prefixLen, shard: shard}
+}
+
+// Insert inserts the value into the shards for the network.
+//
+// This is safe to call from multiple goroutines.
+func (b *ShardedBuilder) Insert(network *net.IPNet, value mmdbtype.DataType) error {
+	return b.InsertFunc(network, inserter.ReplaceWith(value))
+}
+
+// InsertFunc is the same as Tree.InsertFunc, but for the shards for the
+// network. If the network spans multiple partitions, the inserter function
+// is used for each and an error may leave the network partially inserted.
+//
+// This is safe to call from multiple goroutines.
+func (b *ShardedBuilder) InsertFunc(
+	network *net.IPNet,
+	inserter func(value mmdbtype.DataType) (mmdbtype.DataType, error),
+) error {
+	ip, prefixLen, err := b.tree.treeNetwork(network)
+	if err != nil {
+		return err
+	}
+
+	for _, p := range b.partitions {
+		switch {
+		case p.prefixLen <= prefixLen && prefixMatch(p.ip, ip, p.prefixLen):
+			// The network is within the partition.
+			return b.shards[p.shard].InsertFunc(network, inserter)
+		case prefixLen < p.prefixLen && prefixMatch(p.ip, ip, prefixLen):
+			err := b.shards[p.shard].insertPartition(p.ip, p.prefixLen, network, inserter)
+			if err != nil {
+				return err
+			}
+		default:
+		}
+	}
+	return nil
+}
+
+// insertPartition inserts the partition at ip and prefixLen, which is a
+// part of network. The reserved and aliased networks are handled and errors
+// name the network as for an insert of the whole network into a single
+// tree.
+func (t *Tree) insertPartition(
+	ip net.IP,
+	prefixLen int,
+	network *net.IPNet,
+	inserter func(value mmdbtype.DataType) (mmdbtype.DataType, error),
+) error {
+	t.lock()
+	defer t.unlock()
+
+	wholeIP, wholePrefixLen := networkIP(network)
+	whole, err := t.newInsertRecord(wholeIP, wholePrefixLen, recordTypeData, inserter, nil)
+	if err != nil {
+		return err
+	}
+	iRec, err := t.newInsertRecord(ip, prefixLen, recordTypeData, inserter, nil)
+	if err != nil {
+		return err
+	}
+	iRec.whole = &whole
+	return t.insertFrom(t.root, 0, iRec)
+}
+
+// Tree stitches the shards together and returns the resulting tree. The
+// builder must not be used afterward.
+func (b *ShardedBuilder) Tree() (*Tree, error) {
+	t := b.tree
+	for _, shard := range b.shards {
+		s := &stitcher{tree: t, values: map[*dataMapValue]*dataMapValue{}}
+		if err := s.stitch(t.root, shard.root); err != nil {
+			return nil, err
+		}
+		t.skippedInserts += shard.skippedInserts
+	}
+	b.shards = nil
+	t.nodeCount = 0
+
+	if t.maxNodes > 0 && t.allocator.live > t.maxNodes {
+		return nil, errors.WithStack(&sentinelError{
+			msg: fmt.Sprintf(
+				"the stitched tree has %d nodes, which exceeds the limit of %d nodes",
+				t.allocator.live,
+				t.maxNodes,
+			),
+			sentinel: ErrNodeLimitExceeded,
+		})
+	}
+	return t, nil
+}
+
+// prefixMatch returns whether the first prefixLen bits of a and b are the
+// same.
+func prefixMatch(a, b net.IP, prefixLen int) bool {
+	n := prefixLen / 8
+	if !bytes.Equal(a[:n], b[:n]) {
+		return false
+	}
+	if rem := prefixLen % 8; rem != 0 {
+		mask := byte(0xFF) << (8 - rem)
+		return a[n]&mask == b[n]&mask
+	}
+	return true
+}
+
+// stitcher moves the data from a shard into the tree.
+type stitcher struct {
+	tree *Tree
+	// values maps the values of the shard to those of the tree.
+	values map[*dataMapValue]*dataMapValue
+}
+
+// stitch moves the records from the shard's node src into the tree's node
+// dst. The shards share the aliased and reserved networks, and the nodes
+// leading to them, with the tree, and otherwise only have data in their own
+// partitions, so each record in src that is not a node either matches the
+// record in dst or dst is empty.
+func (s *stitcher) stitch(dst, src *node) error {
+	dst.size = 0
+	for i := 0; i < 2; i++ {
+		d := &dst.children[i]
+		r := &src.children[i]
+		switch {
+		case r.recordType == recordTypeEmpty ||
+			r.recordType == recordTypeAlias ||
+			r.recordType == recordTypeReserved:
+		case d.recordType == recordTypeEmpty && r.recordType == recordTypeNode:
+			if err := s.moveValues(r.node); err != nil {
+				return err
+			}
+			*d = *r
+		case d.recordType == recordTypeEmpty && r.recordType == recordTypeData:
+			value, err := s.value(r.value)
+			if err != nil {
+				return err
+			}
+			d.value = value
+			d.recordType = recordTypeData
+		case d.recordType == r.recordType &&
+			(r.recordType == recordTypeNode || r.recordType == recordTypeFixedNode):
+			if err := s.stitch(d.node, r.node); err != nil {
+				return err
+			}
+		default:
+			// This should only happen if there is a programming bug in
+			// this library.
+			return errors.Errorf("cannot stitch a record of type %d into one of type %d", r.recordType, d.recordType)
+		}
+	}
+	return nil
+}
+
+// moveValues replaces the values in the subtree, which is moved from a shard
+// to the tree, with the tree's values. The nodes of the subtree are counted
+// as nodes of the tree's allocator, which releases them if they are pruned.
+func (s *stitcher) moveValues(n *node) error {
+	n.size = 0
+	s.tree.allocator.live++
+	for i := 0; i < 2; i++ {
+		r := &n.children[i]
+		switch r.recordType {
+		case recordTypeNode:
+			if err := s.moveValues(r.node); err != nil {
+				return err
+			}
+		case recordTypeData:
+			value, err := s.value(r.value)
+			if err != nil {
+				return err
+			}
+			r.value = value
+		default:
+		}
+	}
+	return nil
+}
+
+// value returns the tree's value for the shard's value, with a reference
+// added for the record using it.
+func (s *stitcher) value(v *dataMapValue) (*dataMapValue, error) {
+	if value, ok := s.values[v]; ok {
+		value.refCount++
+		return value, nil
+	}
+	value, err := s.tree.dataMap.store(v.data, v.provenance)
+	if err != nil {
+		return nil, err
+	}
+	s.values[v] = value
+	return value, nil
+}
@@ -0,0 +1,182 @@
+package mmdbwriter
+
+import (
+	"bytes"
+	"fmt"
+	"net"
+	"sync"
+	"testing"
+
+	"github.com/maxmind/mmdbwriter/inserter"
+	"github.com/maxmind/mmdbwriter/mmdbtype"
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestShardedBuilder(t *testing.T) {
+	for _, ipVersion := range []int{4, 6} {
+		for _, numShards := range []int{1, 3, 4, 16} {
+			t.Run(fmt.Sprintf("IPv%d with %d shards", ipVersion, numShards), func(t *testing.T) {
+				opts := Options{
+					BuildEpoch:       1,
+					DatabaseType:     "mmdbwriter-test",
+					IPVersion:        ipVersion,
+					ReservedNetworks: []*net.IPNet{mustParseNetwork(t, "10.0.0.0/8")},
+				}
+				tree, err := New(opts)
+				require.NoError(t, err)
+				builder, err := NewShardedBuilder(opts, numShards)
+				require.NoError(t, err)
+
+				// The networks inserted concurrently do not overlap.
+				var networks []string
+				for i := 0; i < 256; i += 3 {
+					networks = append(networks, fmt.Sprintf("%d.%d.0.0/16", i, 255-i))
+				}
+				if ipVersion == 6 {
+					for i := 0x111; i < 0x10000; i += 0x111 {
+						networks = append(networks, fmt.Sprintf("%x::/16", i))
+					}
+				}
+
+				var wg sync.WaitGroup
+				errs := make(chan error, len(networks))
+				for _, network := range networks {
+					value := mmdbtype.Map{"network": mmdbtype.String(network)}
+					n := mustParseNetwork(t, network)
+					require.NoError(t, tree.Insert(n, value))
+					wg.Add(1)
+					go func() {
+						defer wg.Done()
+						errs <- builder.Insert(n, value)
+					}()
+				}
+				wg.Wait()
+				close(errs)
+				for err := range errs {
+					require.NoError(t, err)
+				}
+
+				// These span multiple partitions.
+				spanning := []string{"0.0.0.0/0", "128.0.0.0/2"}
+				if ipVersion == 6 {
+					spanning = append(spanning, "::/0", "::/64")
+				}
+				for _, network := range spanning {
+					n := mustParseNetwork(t, network)
+					value := mmdbtype.Map{"spanning": mmdbtype.String(network)}
+					require.NoError(t, tree.InsertWith(n, value, inserter.TopLevelMergeWith))
+					require.NoError(t, builder.InsertFunc(n, inserter.TopLevelMergeWith(value)))
+				}
+
+				require.EqualError(
+					t,
+					builder.Insert(mustParseNetwork(t, "10.0.0.0/24"), mmdbtype.String("reserved")),
+					tree.Insert(mustParseNetwork(t, "10.0.0.0/24"), mmdbtype.String("reserved")).Error(),
+				)
+
+				stitched, err := builder.Tree()
+				require.NoError(t, err)
+
+				expected := &bytes.Buffer{}
+				_, err = tree.WriteTo(expected)
+				require.NoError(t, err)
+				actual := &bytes.Buffer{}
+				_, err = stitched.WriteTo(actual)
+				require.NoError(t, err)
+				assert.Equal(t, expected.Bytes(), actual.Bytes())
+				assert.Equal(t, len(tree.dataMap.data), len(stitched.dataMap.data))
+				assert.Equal(t, tree.allocator.live, stitched.allocator.live, "the moved nodes are counted")
+				assert.Equal(t, stitched.nodeCount, stitched.allocator.live)
+			})
+		}
+	}
+
+	_, err := NewShardedBuilder(Options{}, 0)
+	assert.EqualError(t, err, "the number of shards must be between 1 and 4096")
+}
+
+func TestShardedBuilderSpanningReserved(t *testing.T) {
+	// With 256 shards, the reserved network fc00::/7 and the aliased
+	// network ::ffff:0:0/96 contain whole partitions.
+	opts := Options{BuildEpoch: 1}
+	tree, err := New(opts)
+	require.NoError(t, err)
+	builder, err := NewShardedBuilder(opts, 256)
+	require.NoError(t, err)
+
+	for _, network := range []string{"fc00::/7", "::ffff:0:0/96"} {
+		n := mustParseNetwork(t, network)
+		value := mmdbtype.String("reserved")
+		err := tree.Insert(n, value)
+		require.Error(t, err)
+		assert.EqualError(t, builder.Insert(n, value), err.Error())
+	}
+
+	// Networks that contain reserved or aliased partitions skip them as in
+	// a single tree.
+	for _, network := range []string{"f000::/4", "::/0"} {
+		n := mustParseNetwork(t, network)
+		value := mmdbtype.String(network)
+		require.NoError(t, tree.Insert(n, value))
+		require.NoError(t, builder.Insert(n, value))
+	}
+
+	stitched, err := builder.Tree()
+	require.NoError(t, err)
+	assert.Equal(t, 0, stitched.SkippedInserts())
+
+	expected := &bytes.Buffer{}
+	_, err = tree.WriteTo(expected)
+	require.NoError(t, err)
+	actual := &bytes.Buffer{}
+	_, err = stitched.WriteTo(actual)
+	require.NoError(t, err)
+	assert.Equal(t, expected.Bytes(), actual.Bytes())
+}
+
+func TestShardedBuilderMaxNodes(t *testing.T) {
+	opts := Options{IPVersion: 4, IncludeReservedNetworks: true, MaxNodes: 20}
+	builder, err := NewShardedBuilder(opts, 2)
+	require.NoError(t, err)
+
+	// Each shard has fewer nodes than the limit, but the stitched tree has
+	// more.
+	require.NoError(t, builder.Insert(mustParseNetwork(t, "1.0.0.0/16"), mmdbtype.Uint32(1)))
+	require.NoError(t, builder.Insert(mustParseNetwork(t, "129.0.0.0/16"), mmdbtype.Uint32(2)))
+
+	_, err = builder.Tree()
+	assert.EqualError(t, err, "the stitched tree has 31 nodes, which exceeds the limit of 20 nodes")
+	assert.True(t, errors.Is(err, ErrNodeLimitExceeded))
+}
+
+func TestShardedBuilderMaxNodesSpanning(t *testing.T) {
+	opts := Options{IPVersion: 4, IncludeReservedNetworks: true, MaxNodes: 5}
+	builder, err := NewShardedBuilder(opts, 256)
+	require.NoError(t, err)
+
+	// The error names the inserted network rather than the partition.
+	err = builder.Insert(mustParseNetwork(t, "0.0.0.0/0"), mmdbtype.Uint32(1))
+	assert.EqualError(t, err, "attempt to insert 0.0.0.0/0 would exceed the limit of 5 nodes")
+	assert.True(t, errors.Is(err, ErrNodeLimitExceeded))
+}
+
+func TestShardedBuilderPartitions(t *testing.T) {
+	builder, err := NewShardedBuilder(Options{}, 2)
+	require.NoError(t, err)
+
+	// The partitions must cover the whole address space exactly once.
+	tree, err := New(Options{IncludeReservedNetworks: true, DisableIPv4Aliasing: true})
+	require.NoError(t, err)
+	for _, p := range builder.partitions {
+		network := &net.IPNet{IP: p.ip, Mask: net.CIDRMask(p.prefixLen, 128)}
+		require.NoError(t, tree.InsertFunc(network, func(v mmdbtype.DataType) (mmdbtype.DataType, error) {
+			assert.Nil(t, v, "%s overlaps another partition", network)
+			return mmdbtype.Uint32(p.shard), nil
+		}))
+	}
+	gaps, err := tree.Gaps(mustParseNetwork(t, "::/0"))
+	require.NoError(t, err)
+	assert.Empty(t, gaps)
+}
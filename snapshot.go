@@ -0,0 +1,300 @@
+package mmdbwriter
+
+import (
+	"bytes"
+	"encoding/binary"
+
+	"github.com/maxmind/mmdbwriter/mmdbtype"
+	"github.com/oschwald/maxminddb-golang"
+	"github.com/pkg/errors"
+)
+
+var snapshotMagic = []byte("mmdbwriter snapshot\x00")
+
+const snapshotVersion = 1
+
+// MarshalBinary returns a snapshot of the data in the tree, e.g., to
+// checkpoint a long build so that it may be resumed with UnmarshalBinary
+// rather than rebuilt from its sources. The snapshot is in an internal
+// format rather than the MaxMind DB format. It holds the search tree and its
+// values, which are stored once each, but not the options or the metadata.
+//
+// Snapshots of trees that track provenance are not supported.
+//
+// This is not safe to call from multiple threads unless Options.ThreadSafe
+// is set.
+func (t *Tree) MarshalBinary() ([]byte, error) {
+	t.rlock()
+	defer t.runlock()
+
+	if t.provenance != nil {
+		return nil, errors.New("snapshots of trees that track provenance are not supported")
+	}
+
+	s := &snapshotEncoder{
+		offsets: map[*dataMapValue]int{},
+		values:  &keyWriter{Buffer: &bytes.Buffer{}},
+		tree:    &bytes.Buffer{},
+	}
+	if err := s.encodeNode(t.root); err != nil {
+		return nil, err
+	}
+
+	buf := &bytes.Buffer{}
+	buf.Write(snapshotMagic)
+	s.writeUvarint(buf, snapshotVersion)
+	s.writeUvarint(buf, uint64(t.ipVersion))
+	s.writeUvarint(buf, uint64(t.skippedInserts))
+	s.writeUvarint(buf, uint64(s.values.Len()))
+	buf.Write(s.values.Bytes())
+	buf.Write(s.tree.Bytes())
+	return buf.Bytes(), nil
+}
+
+type snapshotEncoder struct {
+	offsets map[*dataMapValue]int
+	// values holds the values as in the data section of a database, without
+	// pointers.
+	values  *keyWriter
+	tree    *bytes.Buffer
+	scratch [binary.MaxVarintLen64]byte
+}
+
+// encodeNode writes the type of each record in the subtree in node order.
+// Data records are followed by the offset of their value and node records by
+// their node.
+func (s *snapshotEncoder) encodeNode(n *node) error {
+	for i := 0; i < 2; i++ {
+		r := n.children[i]
+		s.tree.WriteByte(byte(r.recordType))
+		switch r.recordType {
+		case recordTypeNode, recordTypeFixedNode:
+			if err := s.encodeNode(r.node); err != nil {
+				return err
+			}
+		case recordTypeData:
+			offset, ok := s.offsets[r.value]
+			if !ok {
+				offset = s.values.Len()
+				s.offsets[r.value] = offset
+				if _, err := r.value.data.WriteTo(s.values); err != nil {
+					return errors.Wrap(err, "error encoding value")
+				}
+			}
+			s.writeUvarint(s.tree, uint64(offset))
+		default:
+		}
+	}
+	return nil
+}
+
+func (s *snapshotEncoder) writeUvarint(buf *bytes.Buffer, v uint64) {
+	n := binary.PutUvarint(s.scratch[:], v)
+	buf.Write(s.scratch[:n])
+}
+
+// UnmarshalBinary replaces the data in the tree with the data from a
+// snapshot returned by MarshalBinary. The tree keeps its own options and
+// metadata. It must have the same IP version, aliases, and reserved networks
+// as the tree the snapshot was made from; otherwise, an error is returned and
+// the tree is not changed.
+//
+// This is not safe to call from multiple threads unless Options.ThreadSafe
+// is set.
+func (t *Tree) UnmarshalBinary(data []byte) error {
+	t.lock()
+	defer t.unlock()
+
+	restored, err := t.newDerived()
+	if err != nil {
+		return err
+	}
+	if restored.provenance != nil {
+		return errors.New("snapshots of trees that track provenance are not supported")
+	}
+
+	d := &snapshotDecoder{
+		buf:    data,
+		values: map[uint64]*dataMapValue{},
+		tree:   restored,
+	}
+	if !bytes.HasPrefix(d.buf, snapshotMagic) {
+		return errors.New("the data is not an mmdbwriter snapshot")
+	}
+	d.buf = d.buf[len(snapshotMagic):]
+
+	version, err := d.uvarint()
+	if err != nil {
+		return err
+	}
+	if version != snapshotVersion {
+		return errors.Errorf("unsupported snapshot version: %d", version)
+	}
+
+	ipVersion, err := d.uvarint()
+	if err != nil {
+		return err
+	}
+	if int(ipVersion) != t.ipVersion {
+		return errors.Errorf("cannot restore a snapshot of an IPv%d tree into an IPv%d tree", ipVersion, t.ipVersion)
+	}
+
+	skippedInserts, err := d.uvarint()
+	if err != nil {
+		return err
+	}
+
+	valuesSize, err := d.uvarint()
+	if err != nil {
+		return err
+	}
+	if valuesSize > uint64(len(d.buf)) {
+		return errSnapshotTruncated
+	}
+	if err := d.openValues(d.buf[:valuesSize]); err != nil {
+		return err
+	}
+	d.buf = d.buf[valuesSize:]
+
+	if err := d.node(restored.root, 0); err != nil {
+		return err
+	}
+	if len(d.buf) > 0 {
+		return errors.New("the snapshot has unexpected data after the search tree")
+	}
+
+	// Each value has a reference for each record using it and one from
+	// when it was decoded.
+	for _, v := range d.values {
+		restored.dataMap.remove(v)
+	}
+
+	restored.skippedInserts = int(skippedInserts)
+	restored.mu = t.mu
+	restored.snapshot = t.snapshot
+	*t = *restored
+	return nil
+}
+
+type snapshotDecoder struct {
+	buf []byte
+	// reader decodes the values, which are in the format of the data
+	// section of a database.
+	reader *maxminddb.Reader
+	dser   *deserializer
+	// values are the decoded values by their offset.
+	values map[uint64]*dataMapValue
+	tree   *Tree
+}
+
+var errSnapshotTruncated = errors.New("the snapshot is truncated")
+
+// openValues sets up the reader for the values of the snapshot. The values
+// are wrapped in a database without a search tree so that they are decoded
+// as when loading a database.
+func (d *snapshotDecoder) openValues(values []byte) error {
+	metadata := &bytes.Buffer{}
+	_, err := mmdbtype.Map{
+		"binary_format_major_version": mmdbtype.Uint16(2),
+		"binary_format_minor_version": mmdbtype.Uint16(0),
+		"ip_version":                  mmdbtype.Uint16(d.tree.ipVersion),
+		"node_count":                  mmdbtype.Uint32(0),
+		"record_size":                 mmdbtype.Uint16(24),
+	}.WriteTo(&keyWriter{Buffer: metadata})
+	if err != nil {
+		return errors.Wrap(err, "error encoding metadata")
+	}
+
+	db := make([]byte, 0, len(dataSectionSeparator)+len(values)+len(metadataStartMarker)+metadata.Len())
+	db = append(db, dataSectionSeparator...)
+	db = append(db, values...)
+	db = append(db, metadataStartMarker...)
+	db = append(db, metadata.Bytes()...)
+
+	d.reader, err = maxminddb.FromBytes(db)
+	if err != nil {
+		return errors.Wrap(err, "error opening the values in the snapshot")
+	}
+	d.dser = newDeserializer()
+	return nil
+}
+
+// value returns the value at the offset, decoding and storing it the first
+// time that it is used.
+func (d *snapshotDecoder) value(offset uint64) (*dataMapValue, error) {
+	if v, ok := d.values[offset]; ok {
+		return v, nil
+	}
+
+	d.dser.clear()
+	if err := d.reader.Decode(uintptr(offset), d.dser); err != nil {
+		return nil, errors.WithMessagef(err, "error decoding the value at offset %d", offset)
+	}
+	if d.dser.rv == nil {
+		return nil, errors.Errorf("error decoding the value at offset %d", offset)
+	}
+	v, err := d.tree.dataMap.store(d.dser.rv, nil)
+	if err != nil {
+		return nil, err
+	}
+	d.values[offset] = v
+	return v, nil
+}
+
+// node decodes the records of the node. The records set up by New, i.e.,
+// the aliased and reserved networks and the nodes leading to them, must be
+// the same as those in the snapshot.
+func (d *snapshotDecoder) node(n *node, depth int) error {
+	if depth >= d.tree.treeDepth {
+		return errors.New("the search tree in the snapshot is too deep")
+	}
+
+	for i := 0; i < 2; i++ {
+		if len(d.buf) == 0 {
+			return errSnapshotTruncated
+		}
+		rt := recordType(d.buf[0])
+		d.buf = d.buf[1:]
+
+		r := &n.children[i]
+		switch {
+		case rt == recordTypeNode && r.recordType == recordTypeEmpty:
+			r.node = d.tree.allocator.new()
+			r.recordType = recordTypeNode
+			if err := d.node(r.node, depth+1); err != nil {
+				return err
+			}
+		case rt == recordTypeData && r.recordType == recordTypeEmpty:
+			offset, err := d.uvarint()
+			if err != nil {
+				return err
+			}
+			value, err := d.value(offset)
+			if err != nil {
+				return err
+			}
+			r.value = value
+			r.value.refCount++
+			r.recordType = recordTypeData
+		case rt != r.recordType:
+			return errors.New(
+				"the aliased and reserved networks in the snapshot differ from those of the tree",
+			)
+		case rt == recordTypeNode || rt == recordTypeFixedNode:
+			if err := d.node(r.node, depth+1); err != nil {
+				return err
+			}
+		default:
+		}
+	}
+	return nil
+}
+
+func (d *snapshotDecoder) uvarint() (uint64, error) {
+	v, n := binary.Uvarint(d.buf)
+	if n <= 0 {
+		return 0, errSnapshotTruncated
+	}
+	d.buf = d.buf[n:]
+	return v, nil
+}
@@ -0,0 +1,106 @@
+package mmdbwriter
+
+import (
+	"bytes"
+	"math/big"
+	"strings"
+	"testing"
+
+	"github.com/maxmind/mmdbwriter/mmdbtype"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSnapshot(t *testing.T) {
+	opts := Options{BuildEpoch: 1, DatabaseType: "mmdbwriter-test"}
+	tree, err := New(opts)
+	require.NoError(t, err)
+
+	uint128 := mmdbtype.Uint128(*new(big.Int).Lsh(big.NewInt(1), 100))
+	values := map[string]mmdbtype.DataType{
+		"1.1.0.0/16": mmdbtype.Map{
+			"bool":    mmdbtype.Bool(true),
+			"bytes":   mmdbtype.Bytes{0, 1, 2},
+			"float32": mmdbtype.Float32(1.5),
+			"float64": mmdbtype.Float64(-2.25),
+			"int32":   mmdbtype.Int32(-7),
+			"uint16":  mmdbtype.Uint16(300),
+			"uint32":  mmdbtype.Uint32(0),
+			"uint64":  mmdbtype.Uint64(1 << 40),
+			"uint128": &uint128,
+			"slice":   mmdbtype.Slice{mmdbtype.String("a"), mmdbtype.Map{}},
+		},
+		"1.1.1.0/24": mmdbtype.String(strings.Repeat("long", 100)),
+		"2.2.2.0/24": mmdbtype.String("a"),
+		"2003::/16":  mmdbtype.String("a"),
+	}
+	for network, value := range values {
+		require.NoError(t, tree.Insert(mustParseNetwork(t, network), value))
+	}
+
+	snapshot, err := tree.MarshalBinary()
+	require.NoError(t, err)
+
+	restored, err := New(opts)
+	require.NoError(t, err)
+	require.NoError(t, restored.Insert(mustParseNetwork(t, "3.3.3.0/24"), mmdbtype.String("replaced")))
+	require.NoError(t, restored.UnmarshalBinary(snapshot))
+
+	write := func(tree *Tree) []byte {
+		buf := &bytes.Buffer{}
+		_, err := tree.WriteTo(buf)
+		require.NoError(t, err)
+		return buf.Bytes()
+	}
+	assert.Equal(t, write(tree), write(restored))
+
+	// The restored values must be shared and reference counted as with
+	// inserted values.
+	for _, tr := range []*Tree{tree, restored} {
+		require.NoError(t, tr.Remove(mustParseNetwork(t, "2.2.2.0/24")))
+		require.NoError(t, tr.Insert(mustParseNetwork(t, "4.4.4.0/24"), mmdbtype.String("b")))
+	}
+	assert.Equal(t, write(tree), write(restored))
+	assert.Equal(t, len(tree.dataMap.data), len(restored.dataMap.data))
+}
+
+func TestSnapshotErrors(t *testing.T) {
+	tree, err := New(Options{})
+	require.NoError(t, err)
+	require.NoError(t, tree.Insert(mustParseNetwork(t, "1.1.1.0/24"), mmdbtype.Map{"a": mmdbtype.Uint32(1)}))
+	snapshot, err := tree.MarshalBinary()
+	require.NoError(t, err)
+
+	ipv4, err := New(Options{IPVersion: 4})
+	require.NoError(t, err)
+	assert.EqualError(
+		t,
+		ipv4.UnmarshalBinary(snapshot),
+		"cannot restore a snapshot of an IPv6 tree into an IPv4 tree",
+	)
+
+	noReserved, err := New(Options{IncludeReservedNetworks: true})
+	require.NoError(t, err)
+	assert.EqualError(
+		t,
+		noReserved.UnmarshalBinary(snapshot),
+		"the aliased and reserved networks in the snapshot differ from those of the tree",
+	)
+
+	restored, err := New(Options{})
+	require.NoError(t, err)
+	assert.EqualError(t, restored.UnmarshalBinary([]byte("not a snapshot")), "the data is not an mmdbwriter snapshot")
+	for i := len(snapshotMagic); i < len(snapshot); i++ {
+		assert.Error(t, restored.UnmarshalBinary(snapshot[:i]), "truncated to %d bytes", i)
+	}
+	assert.EqualError(
+		t,
+		restored.UnmarshalBinary(append(snapshot, 0)),
+		"the snapshot has unexpected data after the search tree",
+	)
+
+	provenance, err := New(Options{TrackProvenance: true})
+	require.NoError(t, err)
+	_, err = provenance.MarshalBinary()
+	assert.EqualError(t, err, "snapshots of trees that track provenance are not supported")
+}
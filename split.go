@@ -0,0 +1,70 @@
+package mmdbwriter
+
+import (
+	"io"
+	"net"
+
+	"github.com/maxmind/mmdbwriter/mmdbtype"
+	"github.com/pkg/errors"
+)
+
+// WriteSplitTo writes the tree as two databases, e.g., for consumers that
+// only support one address family and want the smaller database. ipv4
+// receives an IPv4 database with the data for the IPv4 networks, i.e., those
+// in the IPv4 subtree, ::/96. ipv6 receives an IPv6 database with the data
+// for the other networks, so lookups of IPv4 addresses in it, including
+// through the aliased networks, return no data. Both databases have the
+// tree's metadata other than the IP version. It returns the number of bytes
+// written to each.
+//
+// The tree must be an IPv6 tree. The tree itself is not changed.
+//
+// This is not safe to call from multiple threads unless Options.ThreadSafe
+// is set.
+func (t *Tree) WriteSplitTo(ipv4, ipv6 io.Writer) (int64, int64, error) {
+	if t.ipVersion != 6 {
+		return 0, 0, errors.New("only IPv6 trees can be split")
+	}
+
+	ipv4Bytes, err := t.ipv4Copy().WriteTo(ipv4)
+	if err != nil {
+		return ipv4Bytes, 0, errors.WithMessage(err, "error writing the IPv4 database")
+	}
+
+	ipv6Tree := t.filteredCopy(func(network *net.IPNet, _ mmdbtype.DataType) bool {
+		return len(network.IP) != net.IPv4len
+	})
+	ipv6Bytes, err := ipv6Tree.WriteTo(ipv6)
+	if err != nil {
+		return ipv4Bytes, ipv6Bytes, errors.WithMessage(err, "error writing the IPv6 database")
+	}
+	return ipv4Bytes, ipv6Bytes, nil
+}
+
+// ipv4Copy returns an IPv4 tree with a copy of the IPv4 subtree of the
+// IPv6 tree.
+func (t *Tree) ipv4Copy() *Tree {
+	t.lock()
+	defer t.unlock()
+
+	if t.nodeCount == 0 {
+		t.finalize()
+	}
+
+	r, _ := t.prefixRecord(make(net.IP, net.IPv6len), 96)
+	root := r.node
+	if r.recordType != recordTypeNode && r.recordType != recordTypeFixedNode {
+		// The IPv4 subtree is part of a larger network, so both of its
+		// halves have the data, if any, of that network.
+		r.node = nil
+		root = &node{children: [2]record{r, r}}
+	}
+
+	c := t.cloneSubtree(root)
+	c.mu = nil
+	c.ipVersion = 4
+	c.options.IPVersion = 4
+	c.treeDepth = 32
+	c.nodeCount = 0
+	return c
+}
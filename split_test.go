@@ -0,0 +1,68 @@
+package mmdbwriter
+
+import (
+	"bytes"
+	"net"
+	"testing"
+
+	"github.com/maxmind/mmdbwriter/mmdbtype"
+	"github.com/oschwald/maxminddb-golang"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestWriteSplitTo(t *testing.T) {
+	tree, err := New(Options{DatabaseType: "mmdbwriter-test"})
+	require.NoError(t, err)
+	for network, value := range map[string]string{
+		"1.1.1.0/24": "ipv4",
+		"2003::/16":  "ipv6",
+	} {
+		require.NoError(t, tree.Insert(mustParseNetwork(t, network), mmdbtype.String(value)))
+	}
+
+	ipv4, ipv6 := &bytes.Buffer{}, &bytes.Buffer{}
+	ipv4Bytes, ipv6Bytes, err := tree.WriteSplitTo(ipv4, ipv6)
+	require.NoError(t, err)
+	assert.Equal(t, int64(ipv4.Len()), ipv4Bytes)
+	assert.Equal(t, int64(ipv6.Len()), ipv6Bytes)
+
+	lookup := func(db []byte, ip string) interface{} {
+		reader, err := maxminddb.FromBytes(db)
+		require.NoError(t, err)
+		var v interface{}
+		require.NoError(t, reader.Lookup(net.ParseIP(ip), &v))
+		return v
+	}
+
+	reader, err := maxminddb.FromBytes(ipv4.Bytes())
+	require.NoError(t, err)
+	assert.Equal(t, uint(4), reader.Metadata.IPVersion)
+	assert.Equal(t, "mmdbwriter-test", reader.Metadata.DatabaseType)
+	assert.Equal(t, "ipv4", lookup(ipv4.Bytes(), "1.1.1.1"))
+
+	reader, err = maxminddb.FromBytes(ipv6.Bytes())
+	require.NoError(t, err)
+	assert.Equal(t, uint(6), reader.Metadata.IPVersion)
+	assert.Nil(t, lookup(ipv6.Bytes(), "1.1.1.1"))
+	assert.Nil(t, lookup(ipv6.Bytes(), "::ffff:1.1.1.1"))
+	assert.Equal(t, "ipv6", lookup(ipv6.Bytes(), "2003::1"))
+
+	_, value := tree.Get(net.ParseIP("1.1.1.1"))
+	assert.Equal(t, mmdbtype.String("ipv4"), value, "the tree is not changed")
+
+	// The IPv4 subtree may be part of a larger network.
+	tree, err = New(Options{DatabaseType: "mmdbwriter-test", DisableIPv4Aliasing: true})
+	require.NoError(t, err)
+	require.NoError(t, tree.Insert(mustParseNetwork(t, "::/64"), mmdbtype.String("all")))
+	ipv4.Reset()
+	_, _, err = tree.WriteSplitTo(ipv4, &bytes.Buffer{})
+	require.NoError(t, err)
+	assert.Equal(t, "all", lookup(ipv4.Bytes(), "1.1.1.1"))
+	assert.Nil(t, lookup(ipv4.Bytes(), "10.0.0.1"))
+
+	tree, err = New(Options{IPVersion: 4})
+	require.NoError(t, err)
+	_, _, err = tree.WriteSplitTo(ipv4, ipv6)
+	assert.EqualError(t, err, "only IPv6 trees can be split")
+}
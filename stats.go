@@ -0,0 +1,100 @@
+package mmdbwriter
+
+import (
+	"net"
+	"sort"
+
+	"github.com/maxmind/mmdbwriter/mmdbtype"
+)
+
+// DataStats describes the data section of the database that WriteTo would
+// write for a tree. See Tree.DataStats.
+type DataStats struct {
+	// Values is the number of distinct values in the data section.
+	Values int
+	// Size is the size in bytes of the data section.
+	Size int64
+	// Top are the values with the most networks, in descending order of
+	// the number of networks and then of size.
+	Top []ValueStats
+}
+
+// ValueStats describes a value in the data section.
+type ValueStats struct {
+	Value mmdbtype.DataType
+	// Size is the size in bytes of the value in the data section. Parts of
+	// the value that are written as pointers to earlier values, e.g., a
+	// Map shared with other values, only count as the size of the pointer.
+	Size int64
+	// Networks is the number of networks in the search tree with the value.
+	// Aliased networks are not counted.
+	Networks int
+}
+
+// DataStats returns statistics about the data section of the database that
+// WriteTo would write for the tree in its current state, e.g., to find out
+// which values account for the growth of a database between builds. At most
+// limit values are returned in Top. If limit is 0 or less, all of the
+// values are returned.
+//
+// The tree is finalized and its data section is encoded, but the search
+// tree is not, as with EstimateSize.
+//
+// This is not safe to call from multiple threads unless Options.ThreadSafe
+// is set.
+func (t *Tree) DataStats(limit int) (*DataStats, error) {
+	t.lock()
+	defer t.unlock()
+
+	if t.nodeCount == 0 {
+		t.finalize()
+	}
+
+	dataWriter := newDataWriter(t.dataMap, true)
+	if err := t.writeData(t.root, dataWriter, nil); err != nil {
+		return nil, err
+	}
+
+	// Values with the same data but different provenance are written once,
+	// so they are counted together.
+	values := map[dataMapKey]*ValueStats{}
+	var keys []dataMapKey
+	ip := make(net.IP, t.treeDepth/8)
+	// The function never returns an error.
+	_ = t.root.walk(ip, 0, func(_ net.IP, _ int, r *record) error {
+		stats, ok := values[r.value.key]
+		if !ok {
+			stats = &ValueStats{
+				Value: r.value.data,
+				Size:  dataWriter.offsets[r.value.key].size,
+			}
+			values[r.value.key] = stats
+			keys = append(keys, r.value.key)
+		}
+		stats.Networks++
+		return nil
+	})
+
+	// keys are in the order in which the values are first used by a
+	// network, which makes the order deterministic for ties.
+	sort.SliceStable(keys, func(i, j int) bool {
+		a, b := values[keys[i]], values[keys[j]]
+		if a.Networks != b.Networks {
+			return a.Networks > b.Networks
+		}
+		return a.Size > b.Size
+	})
+	top := make([]ValueStats, 0, len(keys))
+	for _, key := range keys {
+		top = append(top, *values[key])
+	}
+	if limit > 0 && len(top) > limit {
+		top = top[:limit]
+	}
+
+	return &DataStats{
+		Values: len(values),
+		Size:   int64(dataWriter.Len()),
+		Top:    top,
+	}, nil
+}
@@ -0,0 +1,41 @@
+package mmdbwriter
+
+import (
+	"testing"
+
+	"github.com/maxmind/mmdbwriter/mmdbtype"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestDataStats(t *testing.T) {
+	tree, err := New(Options{})
+	require.NoError(t, err)
+
+	shared := mmdbtype.Map{"country": mmdbtype.String("DE")}
+	for _, network := range []string{"1.1.1.0/24", "1.1.3.0/24", "2003::/16"} {
+		require.NoError(t, tree.Insert(mustParseNetwork(t, network), shared))
+	}
+	require.NoError(t, tree.Insert(mustParseNetwork(t, "2.2.2.0/24"), mmdbtype.String("a")))
+	require.NoError(t, tree.Insert(mustParseNetwork(t, "2.2.4.0/24"), mmdbtype.String("long string")))
+
+	stats, err := tree.DataStats(0)
+	require.NoError(t, err)
+
+	assert.Equal(t, 3, stats.Values)
+	assert.Equal(
+		t,
+		[]ValueStats{
+			{Value: shared, Size: 12, Networks: 3},
+			{Value: mmdbtype.String("long string"), Size: 12, Networks: 1},
+			{Value: mmdbtype.String("a"), Size: 2, Networks: 1},
+		},
+		stats.Top,
+	)
+	assert.Equal(t, int64(12+12+2), stats.Size)
+
+	stats, err = tree.DataStats(1)
+	require.NoError(t, err)
+	assert.Equal(t, 3, stats.Values)
+	assert.Equal(t, []ValueStats{{Value: shared, Size: 12, Networks: 3}}, stats.Top)
+}
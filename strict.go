@@ -0,0 +1,57 @@
+package mmdbwriter
+
+import (
+	"fmt"
+	"net"
+	"strings"
+
+	"github.com/maxmind/mmdbwriter/mmdbtype"
+)
+
+// SpecViolationError is returned when writing a tree created with
+// Options.Strict if the database would not comply with the MaxMind DB spec.
+type SpecViolationError struct {
+	// Violations describes each violation, e.g., "1.1.1.0/24: invalid value
+	// at city: the String "\xff" is not valid UTF-8". Values shared by
+	// multiple networks are only reported for the first network.
+	Violations []string
+}
+
+func (e *SpecViolationError) Error() string {
+	return "the database does not comply with the MaxMind DB spec: " + strings.Join(e.Violations, "; ")
+}
+
+// checkSpec checks the tree against the MaxMind DB spec. The tree must be
+// finalized.
+func (t *Tree) checkSpec(recordSize int) error {
+	var violations []string
+
+	if t.databaseType == "" {
+		violations = append(violations, "metadata: the database type is empty")
+	}
+	if t.buildEpoch < 0 {
+		violations = append(violations, fmt.Sprintf("metadata: the build epoch %d is negative", t.buildEpoch))
+	}
+	if err := mmdbtype.ValidateStrict(t.metadata(recordSize)); err != nil {
+		violations = append(violations, "metadata: "+err.Error())
+	}
+
+	checked := map[dataMapKey]bool{}
+	ip := make(net.IP, t.treeDepth/8)
+	// The callback never returns an error.
+	_ = t.root.walk(ip, 0, func(ip net.IP, prefixLen int, r *record) error {
+		if checked[r.value.key] {
+			return nil
+		}
+		checked[r.value.key] = true
+		if err := mmdbtype.ValidateStrict(r.value.data); err != nil {
+			violations = append(violations, t.network(ip, prefixLen).String()+": "+err.Error())
+		}
+		return nil
+	})
+
+	if len(violations) > 0 {
+		return &SpecViolationError{Violations: violations}
+	}
+	return nil
+}
@@ -0,0 +1,48 @@
+package mmdbwriter
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/maxmind/mmdbwriter/mmdbtype"
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestStrict(t *testing.T) {
+	tree, err := New(Options{
+		Strict:      true,
+		Description: map[string]string{"en": "Test \xff database"},
+	})
+	require.NoError(t, err)
+	invalid := mmdbtype.Map{"city": mmdbtype.String("\xff")}
+	require.NoError(t, tree.Insert(mustParseNetwork(t, "1.1.1.0/24"), invalid))
+	require.NoError(t, tree.Insert(mustParseNetwork(t, "1.1.3.0/24"), invalid))
+	require.NoError(t, tree.Insert(mustParseNetwork(t, "2003::/16"), mmdbtype.Slice{mmdbtype.String("\xfe")}))
+	require.NoError(t, tree.Insert(mustParseNetwork(t, "2004::/16"), mmdbtype.String("valid")))
+
+	_, err = tree.WriteTo(&bytes.Buffer{})
+	var specErr *SpecViolationError
+	require.True(t, errors.As(err, &specErr))
+	assert.Equal(
+		t,
+		[]string{
+			"metadata: the database type is empty",
+			`metadata: invalid value at description.en: the String "Test \xff database" is not valid UTF-8`,
+			`1.1.1.0/24: invalid value at city: the String "\xff" is not valid UTF-8`,
+			`2003::/16: invalid value at [0]: the String "\xfe" is not valid UTF-8`,
+		},
+		specErr.Violations,
+	)
+
+	tree, err = New(Options{
+		Strict:       true,
+		DatabaseType: "mmdbwriter-test",
+		Description:  map[string]string{"en": "Test database"},
+	})
+	require.NoError(t, err)
+	require.NoError(t, tree.Insert(mustParseNetwork(t, "1.1.1.0/24"), mmdbtype.String("valid")))
+	_, err = tree.WriteTo(&bytes.Buffer{})
+	assert.NoError(t, err)
+}
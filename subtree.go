@@ -0,0 +1,139 @@
+package mmdbwriter
+
+import (
+	"net"
+
+	"github.com/maxmind/mmdbwriter/inserter"
+	"github.com/pkg/errors"
+)
+
+// ExtractSubtree returns a new tree with the same options and metadata as
+// the tree that contains only the data for the networks within prefix. An
+// IPv4 prefix refers to the IPv4 subtree of an IPv6 tree. Aliased networks
+// are not followed, e.g., extracting ::ffff:0:0/96 from an IPv6 tree with
+// IPv4 aliasing returns an empty tree.
+//
+// The values are shared between the trees rather than copied.
+//
+// This is not safe to call from multiple threads unless Options.ThreadSafe
+// is set.
+func (t *Tree) ExtractSubtree(prefix *net.IPNet) (*Tree, error) {
+	t.lock()
+	defer t.unlock()
+
+	if t.nodeCount == 0 {
+		t.finalize()
+	}
+
+	sub, err := t.newDerived()
+	if err != nil {
+		return nil, err
+	}
+
+	ip, prefixLen, err := t.treeNetwork(prefix)
+	if err != nil {
+		return nil, err
+	}
+
+	r, depth := t.prefixRecord(ip, prefixLen)
+	switch r.recordType {
+	case recordTypeData:
+		// The whole prefix is within a network with data.
+		return sub, sub.insertIP(ip, prefixLen, recordTypeData, inserter.ReplaceWith(r.value.data), nil)
+	case recordTypeNode, recordTypeFixedNode:
+		err := r.node.walk(ip, depth, func(ip net.IP, prefixLen int, r *record) error {
+			return sub.insertIP(ip, prefixLen, recordTypeData, inserter.ReplaceWith(r.value.data), nil)
+		})
+		if err != nil {
+			return nil, err
+		}
+	default:
+	}
+	return sub, nil
+}
+
+// Gaps returns the networks within prefix that have no data, in address
+// order, e.g., to check that the sources for a database cover all of the
+// address space that they are expected to. Reserved and aliased networks are
+// not considered gaps; if Options.IncludeReservedNetworks is set, empty
+// reserved networks are. An IPv4 prefix refers to the IPv4 subtree of an
+// IPv6 tree, and networks in that subtree are returned as IPv4 networks.
+//
+// This is not safe to call from multiple threads unless Options.ThreadSafe
+// is set.
+func (t *Tree) Gaps(prefix *net.IPNet) ([]*net.IPNet, error) {
+	t.lock()
+	defer t.unlock()
+
+	if t.nodeCount == 0 {
+		t.finalize()
+	}
+
+	ip, prefixLen, err := t.treeNetwork(prefix)
+	if err != nil {
+		return nil, err
+	}
+
+	var gaps []*net.IPNet
+	var walkEmpty func(n *node, depth int)
+	walkEmpty = func(n *node, depth int) {
+		for i := 0; i < 2; i++ {
+			setBit(ip, depth, byte(i))
+			r := n.children[i]
+			switch r.recordType {
+			case recordTypeEmpty:
+				gaps = append(gaps, t.network(ip, depth+1))
+			case recordTypeNode, recordTypeFixedNode:
+				walkEmpty(r.node, depth+1)
+			default:
+			}
+		}
+		setBit(ip, depth, 0)
+	}
+
+	r, depth := t.prefixRecord(ip, prefixLen)
+	switch r.recordType {
+	case recordTypeEmpty:
+		gaps = append(gaps, t.network(ip, prefixLen))
+	case recordTypeNode, recordTypeFixedNode:
+		walkEmpty(r.node, depth)
+	default:
+	}
+	return gaps, nil
+}
+
+// prefixRecord follows the path for the prefix from the root and returns the
+// first record that is not a node, if it is at or above the prefix, or the
+// record for the prefix. The second return value is the depth of the
+// record's children, i.e., the prefix length of a node record.
+func (t *Tree) prefixRecord(ip net.IP, prefixLen int) (record, int) {
+	r := record{recordType: recordTypeNode, node: t.root}
+	depth := 0
+	for ; depth < prefixLen; depth++ {
+		if r.recordType != recordTypeNode && r.recordType != recordTypeFixedNode {
+			break
+		}
+		r = r.node.children[bitAt(ip, depth)]
+	}
+	return r, depth
+}
+
+// treeNetwork returns a copy of the masked IP for the network in the tree's
+// representation, i.e., IPv4 networks are converted to networks in the IPv4
+// subtree of an IPv6 tree, and the prefix length relative to the tree.
+func (t *Tree) treeNetwork(network *net.IPNet) (net.IP, int, error) {
+	prefixLen, bits := network.Mask.Size()
+	ip := network.IP.Mask(network.Mask)
+	if ip == nil || len(ip)*8 != bits {
+		return nil, 0, errors.Errorf("invalid network: %s", network)
+	}
+
+	if len(ip) == net.IPv6len && t.treeDepth == 32 {
+		return nil, 0, errors.Errorf("cannot use the IPv6 network %s with an IPv4 tree", network)
+	}
+	if len(ip) == net.IPv4len && t.treeDepth == 128 {
+		ip = ipV4ToV6(ip)
+		prefixLen += 96
+	}
+	return ip, prefixLen, nil
+}
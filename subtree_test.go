@@ -0,0 +1,131 @@
+package mmdbwriter
+
+import (
+	"net"
+	"testing"
+
+	"github.com/maxmind/mmdbwriter/mmdbtype"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestExtractSubtree(t *testing.T) {
+	tree, err := New(Options{DatabaseType: "Test", BuildEpoch: 1000})
+	require.NoError(t, err)
+	for _, insert := range []testInsert{
+		{network: "1.0.0.0/8", value: mmdbtype.String("1/8")},
+		{network: "1.1.1.0/24", value: mmdbtype.String("1.1.1/24")},
+		{network: "2.0.0.0/8", value: mmdbtype.String("2/8")},
+		{network: "2003::/16", value: mmdbtype.String("2003/16")},
+	} {
+		_, network, err := net.ParseCIDR(insert.network)
+		require.NoError(t, err)
+		require.NoError(t, tree.Insert(network, insert.value))
+	}
+
+	tests := []struct {
+		prefix   string
+		expected []struct {
+			ip      string
+			network string
+			value   mmdbtype.DataType
+		}
+	}{
+		{
+			prefix: "1.1.0.0/16",
+			expected: []struct {
+				ip      string
+				network string
+				value   mmdbtype.DataType
+			}{
+				{ip: "1.1.1.1", network: "1.1.1.0/24", value: mmdbtype.String("1.1.1/24")},
+				{ip: "1.1.2.1", network: "1.1.2.0/23", value: mmdbtype.String("1/8")},
+				{ip: "1.2.0.1"},
+				{ip: "2.0.0.1"},
+			},
+		},
+		{
+			prefix: "1.1.1.128/25",
+			expected: []struct {
+				ip      string
+				network string
+				value   mmdbtype.DataType
+			}{
+				{ip: "1.1.1.129", network: "1.1.1.128/25", value: mmdbtype.String("1.1.1/24")},
+				{ip: "1.1.1.1"},
+			},
+		},
+		{
+			prefix: "2003::/15",
+			expected: []struct {
+				ip      string
+				network string
+				value   mmdbtype.DataType
+			}{
+				{ip: "2003::1", network: "2003::/16", value: mmdbtype.String("2003/16")},
+				{ip: "1.1.1.1"},
+			},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.prefix, func(t *testing.T) {
+			sub, err := tree.ExtractSubtree(mustParseNetwork(t, test.prefix))
+			require.NoError(t, err)
+
+			assert.Equal(t, "Test", sub.databaseType)
+			assert.Equal(t, int64(1000), sub.buildEpoch)
+
+			for _, get := range test.expected {
+				network, value := sub.Get(net.ParseIP(get.ip))
+				if get.network != "" {
+					assert.Equal(t, get.network, network.String(), get.ip)
+				}
+				assert.Equal(t, get.value, value, get.ip)
+			}
+		})
+	}
+
+	_, network, _ := net.ParseCIDR("::ffff:1.1.1.0/120")
+	sub, err := tree.ExtractSubtree(network)
+	require.NoError(t, err)
+	_, value := sub.Get(net.ParseIP("1.1.1.1"))
+	assert.Nil(t, value, "aliased networks are not extracted")
+}
+
+func TestGaps(t *testing.T) {
+	tree, err := New(Options{})
+	require.NoError(t, err)
+	for _, network := range []string{"1.0.0.0/9", "1.192.0.0/10", "2003::/16"} {
+		require.NoError(t, tree.Insert(mustParseNetwork(t, network), mmdbtype.String(network)))
+	}
+
+	tests := []struct {
+		prefix   string
+		expected []string
+	}{
+		{prefix: "1.0.0.0/8", expected: []string{"1.128.0.0/10"}},
+		{prefix: "1.0.0.0/9"},
+		{prefix: "1.1.0.0/16"},
+		{prefix: "2.0.0.0/16", expected: []string{"2.0.0.0/16"}},
+		// 10.0.0.0/8 is reserved.
+		{prefix: "8.0.0.0/6", expected: []string{"8.0.0.0/7", "11.0.0.0/8"}},
+		// 2002::/16 is aliased to the IPv4 subtree.
+		{prefix: "2002::/15"},
+		{prefix: "::ffff:0:0/96"},
+		{prefix: "2004::/14", expected: []string{"2004::/14"}},
+	}
+
+	for _, test := range tests {
+		t.Run(test.prefix, func(t *testing.T) {
+			gaps, err := tree.Gaps(mustParseNetwork(t, test.prefix))
+			require.NoError(t, err)
+
+			var actual []string
+			for _, gap := range gaps {
+				actual = append(actual, gap.String())
+			}
+			assert.Equal(t, test.expected, actual)
+		})
+	}
+}
@@ -0,0 +1,121 @@
+package mmdbwriter
+
+import (
+	"net"
+
+	"github.com/maxmind/mmdbwriter/mmdbtype"
+)
+
+// TransformAll calls fn for every network in the tree that has data and
+// replaces the network's value with the one returned, e.g., to rename a
+// field in every value. Returning nil removes the network's data. The
+// networks are visited as with Walk.
+//
+// fn must not modify the value passed to it; it should return a modified
+// copy instead. If fn returns an error, the transformation stops and the
+// error is returned. The networks visited before then keep their new
+// values.
+//
+// Each network transformed counts as an insert and a merge for
+// Options.Metrics, and Options.OnInsert is called for it, as if its value
+// had been replaced with InsertFunc.
+//
+// This is not safe to call from multiple threads unless Options.ThreadSafe
+// is set.
+func (t *Tree) TransformAll(
+	fn func(network *net.IPNet, value mmdbtype.DataType) (mmdbtype.DataType, error),
+) error {
+	t.lock()
+	defer t.unlock()
+
+	if t.nodeCount == 0 {
+		t.finalize()
+	}
+	// Changing the values may allow networks to be merged anywhere in the
+	// tree, so the whole tree must be finalized again.
+	t.nodeCount = 0
+	t.root.invalidate()
+
+	ip := make(net.IP, t.treeDepth/8)
+	return t.root.walk(ip, 0, func(ip net.IP, prefixLen int, r *record) error {
+		network := t.network(ip, prefixLen)
+		old := r.value.data
+		addMetric(t.metrics, CounterMerges, 1)
+		value, err := fn(network, old)
+		if err != nil {
+			return err
+		}
+		if err := t.setRecordValue(r, value); err != nil {
+			return err
+		}
+		addMetric(t.metrics, CounterInserts, 1)
+		if t.onInsert != nil {
+			t.onInsert(network, old, value)
+		}
+		return nil
+	})
+}
+
+// RemoveKey removes the top-level key from every Map value in the tree for
+// which match returns true, e.g., to remove "postal" from the networks in
+// some countries. If match is nil, the key is removed from every Map. Values
+// that are not a Map or that do not have the key are left as is.
+//
+// match must not modify the value passed to it.
+//
+// This is not safe to call from multiple threads unless Options.ThreadSafe
+// is set.
+func (t *Tree) RemoveKey(
+	key mmdbtype.String,
+	match func(network *net.IPNet, value mmdbtype.Map) bool,
+) error {
+	return t.TransformAll(func(network *net.IPNet, value mmdbtype.DataType) (mmdbtype.DataType, error) {
+		m, ok := value.(mmdbtype.Map)
+		if !ok {
+			return value, nil
+		}
+		if _, ok := m[key]; !ok {
+			return value, nil
+		}
+		if match != nil && !match(network, m) {
+			return value, nil
+		}
+
+		nm := make(mmdbtype.Map, len(m)-1)
+		for k, v := range m {
+			if k != key {
+				nm[k] = v
+			}
+		}
+		return nm, nil
+	})
+}
+
+// setRecordValue replaces the value of the data record. A nil value makes
+// the record empty.
+func (t *Tree) setRecordValue(r *record, value mmdbtype.DataType) error {
+	if value == nil {
+		t.dataMap.remove(r.value)
+		r.recordType = recordTypeEmpty
+		r.value = nil
+		return nil
+	}
+
+	// The new value is stored before the old one is removed so that the
+	// stored value is reused if they are the same.
+	var prov *Provenance
+	if t.provenance != nil {
+		var err error
+		prov, err = t.provenance.next(r.value, value)
+		if err != nil {
+			return err
+		}
+	}
+	newValue, err := t.dataMap.store(value, prov)
+	if err != nil {
+		return err
+	}
+	t.dataMap.remove(r.value)
+	r.value = newValue
+	return nil
+}
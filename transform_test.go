@@ -0,0 +1,110 @@
+package mmdbwriter
+
+import (
+	"fmt"
+	"net"
+	"testing"
+
+	"github.com/maxmind/mmdbwriter/mmdbtype"
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestTransformAll(t *testing.T) {
+	tree, err := New(Options{})
+	require.NoError(t, err)
+	for _, insert := range []testInsert{
+		{network: "1.1.0.0/24", value: mmdbtype.Map{"cc": mmdbtype.String("AU")}},
+		{network: "1.1.1.0/24", value: mmdbtype.Map{"cc": mmdbtype.String("AU"), "x": mmdbtype.Bool(true)}},
+		{network: "1.1.2.0/24", value: mmdbtype.Map{"cc": mmdbtype.String("NZ")}},
+	} {
+		require.NoError(t, tree.Insert(mustParseNetwork(t, insert.network), insert.value))
+	}
+
+	var networks []string
+	err = tree.TransformAll(func(network *net.IPNet, value mmdbtype.DataType) (mmdbtype.DataType, error) {
+		networks = append(networks, network.String())
+		m := value.(mmdbtype.Map)
+		if m["cc"] == mmdbtype.String("NZ") {
+			return nil, nil
+		}
+		return mmdbtype.Map{"country_code": m["cc"]}, nil
+	})
+	require.NoError(t, err)
+	assert.Equal(t, []string{"1.1.0.0/24", "1.1.1.0/24", "1.1.2.0/24"}, networks)
+
+	var walked []string
+	require.NoError(t, tree.Walk(func(network *net.IPNet, value mmdbtype.DataType) error {
+		assert.Equal(t, mmdbtype.Map{"country_code": mmdbtype.String("AU")}, value)
+		walked = append(walked, network.String())
+		return nil
+	}))
+	assert.Equal(t, []string{"1.1.0.0/23"}, walked, "networks with the same new value are merged")
+	assert.Len(t, tree.dataMap.data, 1)
+
+	err = tree.TransformAll(func(*net.IPNet, mmdbtype.DataType) (mmdbtype.DataType, error) {
+		return nil, errors.New("oops")
+	})
+	assert.EqualError(t, err, "oops")
+}
+
+func TestTransformAllHooks(t *testing.T) {
+	metrics := &testMetrics{counters: map[Counter]int{}, timers: map[Timer]int{}}
+	var inserted []string
+	tree, err := New(Options{
+		Metrics: metrics,
+		OnInsert: func(network *net.IPNet, old, new mmdbtype.DataType) {
+			inserted = append(inserted, fmt.Sprintf("%s: %v -> %v", network, old, new))
+		},
+	})
+	require.NoError(t, err)
+	require.NoError(t, tree.Insert(mustParseNetwork(t, "1.1.0.0/24"), mmdbtype.Uint32(1)))
+	require.NoError(t, tree.Insert(mustParseNetwork(t, "1.1.1.0/24"), mmdbtype.Uint32(2)))
+	inserted = nil
+	metrics.counters = map[Counter]int{}
+
+	err = tree.TransformAll(func(_ *net.IPNet, value mmdbtype.DataType) (mmdbtype.DataType, error) {
+		if value == mmdbtype.Uint32(2) {
+			return nil, nil
+		}
+		return value.(mmdbtype.Uint32) + 10, nil
+	})
+	require.NoError(t, err)
+
+	assert.Equal(t, []string{"1.1.0.0/24: 1 -> 11", "1.1.1.0/24: 2 -> <nil>"}, inserted)
+	assert.Equal(t, 2, metrics.counters[CounterInserts])
+	assert.Equal(t, 2, metrics.counters[CounterMerges])
+}
+
+func TestRemoveKey(t *testing.T) {
+	tree, err := New(Options{})
+	require.NoError(t, err)
+	for _, insert := range []testInsert{
+		{network: "1.1.0.0/24", value: mmdbtype.Map{"cc": mmdbtype.String("DE"), "postal": mmdbtype.String("1")}},
+		{network: "1.1.1.0/24", value: mmdbtype.Map{"cc": mmdbtype.String("US"), "postal": mmdbtype.String("2")}},
+		{network: "1.1.2.0/24", value: mmdbtype.Map{"cc": mmdbtype.String("FR")}},
+		{network: "1.1.3.0/24", value: mmdbtype.String("not a map")},
+	} {
+		require.NoError(t, tree.Insert(mustParseNetwork(t, insert.network), insert.value))
+	}
+
+	err = tree.RemoveKey("postal", func(_ *net.IPNet, value mmdbtype.Map) bool {
+		return value["cc"] != mmdbtype.String("US")
+	})
+	require.NoError(t, err)
+
+	for ip, expected := range map[string]mmdbtype.DataType{
+		"1.1.0.1": mmdbtype.Map{"cc": mmdbtype.String("DE")},
+		"1.1.1.1": mmdbtype.Map{"cc": mmdbtype.String("US"), "postal": mmdbtype.String("2")},
+		"1.1.2.1": mmdbtype.Map{"cc": mmdbtype.String("FR")},
+		"1.1.3.1": mmdbtype.String("not a map"),
+	} {
+		_, value := tree.Get(net.ParseIP(ip))
+		assert.Equal(t, expected, value, ip)
+	}
+
+	require.NoError(t, tree.RemoveKey("postal", nil))
+	_, value := tree.Get(net.ParseIP("1.1.1.1"))
+	assert.Equal(t, mmdbtype.Map{"cc": mmdbtype.String("US")}, value)
+}
@@ -4,13 +4,23 @@ package mmdbwriter
 
 import (
 	"bufio"
+	"bytes"
+	"context"
+	"fmt"
 	"io"
+	"io/ioutil"
+	"math/bits"
 	"net"
+	"os"
+	"path/filepath"
+	"runtime"
+	"sort"
+	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/maxmind/mmdbwriter/inserter"
 	"github.com/maxmind/mmdbwriter/mmdbtype"
-	"github.com/oschwald/maxminddb-golang"
 	"github.com/pkg/errors"
 )
 
@@ -37,7 +47,7 @@ type Options struct {
 
 	// DisableIPv4Aliasing will disable the IPv4 aliasing in IPv6 trees. This
 	// aliasing maps some IPv6 networks to the IPv4 network, e.g.,
-	// ::ffff:0:0/96.
+	// ::ffff:0:0/96. If this is set, Aliases is ignored.
 	DisableIPv4Aliasing bool
 
 	// IncludeReservedNetworks will allow reserved networks to be added to the
@@ -47,7 +57,8 @@ type Options struct {
 	// in an error and inserting a network that contains a reserved network will
 	// result in the reserved portion of the network being excluded. Reserved
 	// networks that are globally routable to an individual device, such as
-	// Teredo, may still be added.
+	// Teredo, may still be added. If this is set, ReservedNetworks is
+	// ignored.
 	IncludeReservedNetworks bool
 
 	// IPVersion indicates whether an IPv4 or IPv6 database should be built. An
@@ -64,7 +75,11 @@ type Options struct {
 	// RecordSize indicates the number of bits in a record in the search tree.
 	// The supported values are 24, 28, and 32. A smaller size will result in a
 	// smaller database, but it will limit the maximum size of the database.
-	// The default is 28.
+	//
+	// If this is 0, the default, the smallest record size that can address
+	// the whole database is chosen when the tree is written. Otherwise, if
+	// the record size cannot address the whole database, writing the tree
+	// returns a *RecordSizeError.
 	RecordSize int
 
 	// DisableMetadataPointers prevents the use of pointers in the metadata
@@ -72,8 +87,145 @@ type Options struct {
 	// implementations that do not correctly handle metadata pointers. Its
 	// use should primarily be limited to existing database types.
 	DisableMetadataPointers bool
+
+	// Aliases are the aliased networks in IPv6 trees. If this is nil, the
+	// default, the networks returned by DefaultAliases are used. To disable
+	// individual aliases, set this to the default aliases without them. An
+	// empty, non-nil slice disables aliasing altogether.
+	//
+	// This may only be set for IPv6 trees.
+	Aliases []AliasSpec
+
+	// ReservedNetworks are the networks treated as reserved. If this is nil,
+	// the default, the networks returned by DefaultReservedNetworks for the
+	// tree's IP version are used. To allow data for some of the default
+	// networks, e.g., 100.64.0.0/10, set this to the default networks without
+	// them.
+	ReservedNetworks []*net.IPNet
+
+	// OnReservedInsert determines what happens when a network within a
+	// reserved or aliased network is inserted. By default, an error is
+	// returned. With ReservedInsertSkip, the insert is skipped and counted,
+	// which allows bulk loads from sources that include such networks to
+	// continue. The count is returned by Tree.SkippedInserts.
+	OnReservedInsert ReservedInsertAction
+
+	// GrowRecordSize makes RecordSize the minimum record size rather than
+	// the exact one. If the database cannot be addressed with RecordSize,
+	// the smallest larger record size that can address it is used instead,
+	// e.g., when writing a loaded database that has grown.
+	GrowRecordSize bool
+
+	// Strict checks that the database complies with the MaxMind DB spec
+	// before it is written, beyond what the writer guarantees by
+	// construction. The database type must be set, the build epoch must not
+	// be negative, and every string in the data and metadata must be valid
+	// UTF-8. If any of these fail, WriteTo and WriteToAt return a
+	// *SpecViolationError listing all of the violations.
+	Strict bool
+
+	// ValidateValues checks each value with mmdbtype.Validate when it is
+	// inserted, so that values that cannot be written or read back, such as
+	// a negative Uint128, are rejected with an error by the insert rather
+	// than when the tree is written.
+	ValidateValues bool
+
+	// Schema, if set, checks each value with Schema.Validate when it is
+	// inserted, so that every value inserted, e.g., by several importers,
+	// has the same structure. Values that do not match the schema are
+	// rejected with an error by the insert.
+	Schema Schema
+
+	// KeyCacheSize is the number of Map and Slice values whose keys are
+	// cached. Each value inserted is encoded to compare it with the values
+	// already in the tree. With the cache, inserting the same Map or Slice,
+	// rather than an equal copy of it, again uses the cached key instead,
+	// which makes inserting a value for each of many networks, e.g., the Map
+	// for a country, considerably faster. The cache is disabled if this is 0,
+	// the default.
+	//
+	// The cache relies on values not being modified after they are
+	// inserted.
+	KeyCacheSize int
+
+	// MaxNodes, if set, is the maximum number of nodes in the tree. An
+	// insert that would need more nodes fails with an error that wraps
+	// ErrNodeLimitExceeded, e.g., to protect a long-running service from
+	// running out of memory when a feed unexpectedly has a record for each
+	// IP address. The nodes of the aliased and reserved networks are
+	// included. Nodes that are pruned when the tree is finalized, e.g., by
+	// WriteTo, no longer count towards the limit.
+	//
+	// As with other errors, an insert of a network that spans several
+	// records may have set some of them when it fails.
+	MaxNodes int
+
+	// OnInsert, if set, is called for each network whose data is set by an
+	// insert, including by InsertFunc, Remove, MergeTree, and TransformAll,
+	// with the value before and after the insert. Either value may be nil.
+	// An insert into a network that spans several records with different
+	// values calls OnInsert for each record, with the network of the
+	// record. If an insert fails partway through, the records set before
+	// the failure, which keep their new values, have already been reported.
+	//
+	// OnInsert is called while the insert is in progress and must not call
+	// methods on the tree.
+	OnInsert func(network *net.IPNet, old, new mmdbtype.DataType)
+
+	// Metrics, if set, receives counts of inserts, merges, deduplicated
+	// values, and created nodes, and the time taken to finalize and write
+	// the tree, e.g., so that long-running build services can export them.
+	Metrics Metrics
+
+	// Logger, if set, is used to report conditions that do not cause an
+	// error, such as inserts skipped because of OnReservedInsert, which
+	// are otherwise only counted, and record sizes grown because of
+	// GrowRecordSize.
+	Logger Logger
+
+	// Progress, if set, is called periodically while networks are inserted
+	// by InsertAll and while the tree is finalized and written, so that
+	// long builds can report their progress. It is
+	// called on the goroutine that triggered the work, e.g., the one calling
+	// WriteTo, and must not call methods on the tree.
+	Progress func(Progress)
+
+	// TrackProvenance records the source, as set by Tree.SetSource, of the
+	// data for each network and its top-level keys. The provenance may be
+	// retrieved with Tree.Provenance, which helps when debugging builds from
+	// multiple sources.
+	//
+	// Networks with the same data from different sources are not merged when
+	// the tree is finalized, so the search tree may be larger than without
+	// this option.
+	TrackProvenance bool
+
+	// ThreadSafe makes it safe to use the tree from multiple goroutines. The
+	// tree has a single lock, which each insert, lookup, walk, and write
+	// holds, so inserts from multiple goroutines are serialized and never
+	// run in parallel. This makes concurrent use safe but not faster. It is
+	// useful when the input is parsed concurrently and the parsing dominates
+	// the run time. Use ShardedBuilder to insert in parallel.
+	//
+	// The lock is held while the functions passed to InsertFunc and Walk run,
+	// so they must not call methods on the tree. Methods that use two trees
+	// never hold the lock of one while waiting for that of the other, except
+	// for Diff and IntersectTree, which lock both trees in an order that
+	// does not depend on the order of the arguments.
+	ThreadSafe bool
 }
 
+// ReservedInsertAction is the action taken when a network within a reserved
+// or aliased network is inserted.
+type ReservedInsertAction int
+
+const (
+	// ReservedInsertError returns an error for the insert.
+	ReservedInsertError ReservedInsertAction = iota
+	// ReservedInsertSkip skips the insert without an error.
+	ReservedInsertSkip
+)
+
 // Tree represents an MaxMind DB search tree.
 type Tree struct {
 	buildEpoch              int64
@@ -84,10 +236,32 @@ type Tree struct {
 	ipVersion               int
 	languages               []string
 	recordSize              int
+	allocator               *nodeAllocator
 	root                    *node
 	treeDepth               int
 	// This is set when the tree is finalized
 	nodeCount int
+	// skipReservedInserts is set after the tree is created if
+	// Options.OnReservedInsert is ReservedInsertSkip.
+	skipReservedInserts bool
+	skippedInserts      int
+	growRecordSize      bool
+	strict              bool
+	maxNodes            int
+	onInsert            func(network *net.IPNet, old, new mmdbtype.DataType)
+	metrics             Metrics
+	logger              Logger
+	progress            func(Progress)
+	// provenance is only set if Options.TrackProvenance is true.
+	provenance *provenanceTracker
+	// This is only set if Options.ThreadSafe is true.
+	mu *treeLock
+	// options are the options the tree was created with. They are used
+	// when creating new trees from this one.
+	options Options
+	// snapshot is the copy of the tree made by Begin. It is nil if there
+	// is no transaction in progress.
+	snapshot *Tree
 }
 
 // New creates a new Tree.
@@ -99,14 +273,25 @@ func New(opts Options) (*Tree, error) {
 		description:             map[string]string{},
 		disableMetadataPointers: opts.DisableMetadataPointers,
 		ipVersion:               6,
-		recordSize:              28,
-		root:                    &node{},
+		allocator:               &nodeAllocator{},
+		maxNodes:                opts.MaxNodes,
+		onInsert:                opts.OnInsert,
+		metrics:                 opts.Metrics,
+		logger:                  opts.Logger,
+		progress:                opts.Progress,
+		options:                 opts,
 	}
 
+	tree.root = tree.allocator.new()
+
 	if opts.BuildEpoch != 0 {
 		tree.buildEpoch = opts.BuildEpoch
 	}
 
+	if opts.ThreadSafe {
+		tree.mu = newTreeLock()
+	}
+
 	if opts.Description != nil {
 		tree.description = opts.Description
 	}
@@ -119,9 +304,13 @@ func New(opts Options) (*Tree, error) {
 		tree.languages = opts.Languages
 	}
 
-	if opts.RecordSize != 0 {
+	switch opts.RecordSize {
+	case 0, 24, 28, 32:
 		tree.recordSize = opts.RecordSize
+	default:
+		return nil, errors.Errorf("unsupported RecordSize: %d", opts.RecordSize)
 	}
+	tree.growRecordSize = opts.GrowRecordSize
 
 	switch tree.ipVersion {
 	case 6:
@@ -132,111 +321,511 @@ func New(opts Options) (*Tree, error) {
 		return nil, errors.Errorf("unsupported IPVersion: %d", tree.ipVersion)
 	}
 
-	if tree.ipVersion == 6 && !opts.DisableIPv4Aliasing {
-		if err := tree.insertIPv4Aliases(); err != nil {
+	if opts.MaxNodes < 0 {
+		return nil, errors.Errorf("unsupported MaxNodes: %d", opts.MaxNodes)
+	}
+
+	if !opts.DisableIPv4Aliasing {
+		aliases := opts.Aliases
+		if aliases == nil && tree.ipVersion == 6 {
+			aliases = DefaultAliases()
+		}
+		if len(aliases) > 0 && tree.ipVersion != 6 {
+			return nil, errors.New("aliases are only supported in IPv6 trees")
+		}
+		if err := tree.insertAliases(aliases); err != nil {
 			return nil, err
 		}
 	}
 
 	if !opts.IncludeReservedNetworks {
-		err := tree.insertReservedNetworks()
+		networks := opts.ReservedNetworks
+		if networks == nil {
+			networks = DefaultReservedNetworks(tree.ipVersion)
+		}
+		err := tree.insertReservedNetworks(networks)
 		if err != nil {
 			return nil, err
 		}
 	}
 
+	tree.dataMap.validate = opts.ValidateValues
+	tree.dataMap.schema = opts.Schema
+	tree.dataMap.metrics = opts.Metrics
+	if opts.KeyCacheSize > 0 {
+		tree.dataMap.keyCache = newKeyCache(opts.KeyCacheSize)
+	}
+	tree.strict = opts.Strict
+
+	if opts.TrackProvenance {
+		tree.provenance = newProvenanceTracker()
+	}
+
+	switch opts.OnReservedInsert {
+	case ReservedInsertError:
+	case ReservedInsertSkip:
+		tree.skipReservedInserts = true
+	default:
+		return nil, errors.Errorf("unsupported OnReservedInsert: %d", opts.OnReservedInsert)
+	}
+
 	return tree, nil
 }
 
-// Load an existing database into the writer.
-func Load(path string, opts Options) (*Tree, error) {
-	db, err := maxminddb.Open(path)
+// Insert a data value into the tree. If the value is nil, any data for the
+// network is removed instead, as with Remove, so rows that delete networks
+// from an incremental feed may be inserted directly. See
+// inserter.RemoveIfNil for doing the same with other insert policies.
+//
+// This is not safe to call from multiple threads unless Options.ThreadSafe
+// is set.
+func (t *Tree) Insert(network *net.IPNet, value mmdbtype.DataType) error {
+	return t.InsertFunc(network, inserter.ReplaceWith(value))
+}
+
+// InsertFunc will insert the output of the function passed to it. The argument
+// passed to the function is the existing value in the record. The function
+// should return the mmdbtype.DataType to be inserted. In both cases, a nil value means
+// an empty record.
+//
+// You must never modify the argument passed to the function as the value may
+// be shared with other records. If you want a copy of the mmdbtype.DataType to modify,
+// call the Copy method on it, which will make a deep copy. This isn't done
+// automatically before calling the function as not all functions will require
+// the record to be copied and there is a non-trivial performance impact.
+//
+// The function will be called multiple times per insert when the network
+// has multiple preexisting records associated with it.
+//
+// This is not safe to call from multiple threads unless Options.ThreadSafe
+// is set.
+func (t *Tree) InsertFunc(
+	network *net.IPNet,
+	inserter func(value mmdbtype.DataType) (mmdbtype.DataType, error),
+) error {
+	return t.insert(network, recordTypeData, inserter, nil)
+}
+
+// InsertWith inserts the value using the inserter function that generator
+// creates for it, e.g., InsertWith(network, value, inserter.DeepMergeWith).
+// generator may be one of the FuncGenerators in the inserter package or
+// one implemented by the application, so that merge policies can be
+// reused across inserts. If generator is nil, inserter.ReplaceWith is used.
+//
+// This is not safe to call from multiple threads unless Options.ThreadSafe
+// is set.
+func (t *Tree) InsertWith(
+	network *net.IPNet,
+	value mmdbtype.DataType,
+	generator inserter.FuncGenerator,
+) error {
+	if generator == nil {
+		generator = inserter.ReplaceWith
+	}
+	return t.InsertFunc(network, generator(value))
+}
+
+// Remove removes any data for the network from the tree. If the network is
+// part of a larger network that has data, the larger network is split and
+// only the removed portion becomes empty. Any smaller networks contained in
+// the network are removed as well.
+//
+// This is not safe to call from multiple threads unless Options.ThreadSafe
+// is set.
+func (t *Tree) Remove(network *net.IPNet) error {
+	return t.InsertFunc(network, inserter.Remove)
+}
+
+// FillEmpty sets the value for the parts of the network that have no data,
+// e.g., to mark the space not covered by a feed as unknown. Unlike
+// inserting with inserter.KeepExistingWith, the records that already have
+// data are not touched at all, so Options.OnInsert is not called for them
+// and their provenance is unchanged. As with Insert, the aliased and
+// reserved networks within the network are skipped.
+//
+// This is not safe to call from multiple threads unless Options.ThreadSafe
+// is set.
+func (t *Tree) FillEmpty(network *net.IPNet, value mmdbtype.DataType) error {
+	t.lock()
+	defer t.unlock()
+
+	ip, prefixLen := networkIP(network)
+	iRec, err := t.newInsertRecord(ip, prefixLen, recordTypeData, inserter.ReplaceWith(value), nil)
 	if err != nil {
-		return nil, err
+		return err
 	}
-	defer db.Close()
+	iRec.emptyOnly = true
+	return t.insertFrom(t.root, 0, iRec)
+}
 
-	metadata := db.Metadata
-	if opts.DatabaseType == "" {
-		opts.DatabaseType = metadata.DatabaseType
+// MergeTree inserts every network with data from other into the tree. The
+// strategy determines how conflicts with data already in the tree are
+// resolved; for each network in other, the inserter function returned by
+// strategy for its value is used as in InsertFunc. If strategy is nil,
+// inserter.ReplaceWith is used and the values from other take precedence.
+//
+// other is finalized as part of the merge. Values are shared between the
+// trees rather than copied.
+//
+// This is not safe to call from multiple threads unless Options.ThreadSafe
+// is set.
+func (t *Tree) MergeTree(other *Tree, strategy inserter.FuncGenerator) error {
+	if strategy == nil {
+		strategy = inserter.ReplaceWith
 	}
 
-	if opts.Description == nil {
-		opts.Description = metadata.Description
+	// The networks are collected first as other may be the tree itself,
+	// and so that the lock on other is not held while the tree is locked.
+	var records []Record
+	err := other.Walk(func(network *net.IPNet, value mmdbtype.DataType) error {
+		records = append(records, Record{Network: network, Value: value})
+		return nil
+	})
+	if err != nil {
+		return err
 	}
 
-	if opts.IPVersion == 0 {
-		opts.IPVersion = int(metadata.IPVersion)
+	for _, r := range records {
+		if err := t.InsertFunc(r.Network, strategy(r.Value)); err != nil {
+			return err
+		}
 	}
+	return nil
+}
 
-	if opts.Languages == nil {
-		opts.Languages = metadata.Languages
+// IntersectTree removes the data for every network in the tree that does not
+// have data in other, e.g., to restrict a database to the networks in an
+// allowlist. The values in the tree are kept as is; the values in other are
+// not used.
+//
+// Both trees are finalized as part of the intersection and must have the
+// same IP version.
+//
+// This is not safe to call from multiple threads unless Options.ThreadSafe
+// is set.
+func (t *Tree) IntersectTree(other *Tree) error {
+	if t.treeDepth != other.treeDepth {
+		return errors.Errorf(
+			"cannot intersect an IPv%d tree with an IPv%d tree",
+			t.ipVersion,
+			other.ipVersion,
+		)
 	}
 
-	if opts.RecordSize == 0 {
-		opts.RecordSize = int(metadata.RecordSize)
+	diffs, err := Diff(t, other)
+	if err != nil {
+		return err
+	}
+	for _, d := range diffs {
+		if d.Type != DifferenceRemoved {
+			continue
+		}
+		if err := t.Remove(d.Network); err != nil {
+			return err
+		}
 	}
+	return nil
+}
 
-	tree, err := New(opts)
+// SubtractTree removes the data for every network in the tree that has data
+// in other, e.g., to remove the networks in a blocklist from a database.
+// The values in other are not used.
+//
+// other is finalized as part of the subtraction.
+//
+// This is not safe to call from multiple threads unless Options.ThreadSafe
+// is set.
+func (t *Tree) SubtractTree(other *Tree) error {
+	// The networks are collected first as other may be the tree itself.
+	var networks []*net.IPNet
+	err := other.Walk(func(network *net.IPNet, _ mmdbtype.DataType) error {
+		networks = append(networks, network)
+		return nil
+	})
 	if err != nil {
-		return nil, err
+		return err
 	}
 
-	dser := newDeserializer()
+	for _, network := range networks {
+		if err := t.Remove(network); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// InsertRange inserts a data value into the tree for every address from
+// start to end, inclusive. The range is split into the minimal set of
+// networks that cover it and each network is inserted separately. Both IP
+// addresses must be of the same IP version.
+//
+// This is not safe to call from multiple threads unless Options.ThreadSafe
+// is set.
+func (t *Tree) InsertRange(start, end net.IP, value mmdbtype.DataType) error {
+	networks, err := rangeToNetworks(start, end)
+	if err != nil {
+		return err
+	}
+
+	for _, network := range networks {
+		if err := t.Insert(network, value); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// InsertCount inserts a data value into the tree for count addresses
+// starting at start, e.g., for feeds that describe blocks by their first
+// address and size rather than as networks. As with InsertRange, the block
+// is split into the minimal set of networks that cover it. The block must
+// not extend past the last address of start's IP version.
+//
+// This is not safe to call from multiple threads unless Options.ThreadSafe
+// is set.
+func (t *Tree) InsertCount(start net.IP, count uint64, value mmdbtype.DataType) error {
+	end, err := rangeEnd(start, count)
+	if err != nil {
+		return err
+	}
+	return t.InsertRange(start, end, value)
+}
 
-	var networkOpts []maxminddb.NetworksOption
-	if opts.IPVersion == 6 && !opts.DisableIPv4Aliasing {
-		networkOpts = append(networkOpts, maxminddb.SkipAliasedNetworks)
+// InsertExcept inserts a data value into the tree for the network except
+// for the excluded networks within it, e.g., to set the value for an
+// allocation other than the parts of it that are assigned elsewhere. As with
+// InsertRange, the rest of the network is split into the minimal set of
+// networks that cover it. Exclusions that do not overlap the network are
+// ignored, and nothing is inserted if an exclusion contains it. The
+// exclusions must be of the same IP version as the network.
+//
+// When Options.ThreadSafe is set, the lock is held for the whole insert, so
+// other goroutines never see only some of the networks inserted.
+//
+// This is not safe to call from multiple threads unless Options.ThreadSafe
+// is set.
+func (t *Tree) InsertExcept(network *net.IPNet, value mmdbtype.DataType, exclusions []*net.IPNet) error {
+	networks, err := excludeNetworks(network, exclusions)
+	if err != nil {
+		return err
 	}
 
-	networks := db.Networks(networkOpts...)
-	for networks.Next() {
-		var network *net.IPNet
+	t.lock()
+	defer t.unlock()
 
-		dser.clear()
-		network, err = networks.Network(dser)
+	for _, n := range networks {
+		ip, prefixLen := networkIP(n)
+		err := t.insertIPLocked(ip, prefixLen, recordTypeData, inserter.ReplaceWith(value), nil)
 		if err != nil {
-			return nil, err
+			return err
 		}
+	}
+	return nil
+}
+
+// insertAllBatchSize is the number of networks inserted by InsertAll each
+// time it acquires the tree's lock.
+const insertAllBatchSize = 1024
 
-		err = tree.Insert(network, dser.rv)
+// InsertAll inserts every network and value returned by next until next
+// returns false. This allows large numbers of networks to be streamed into
+// the tree, e.g., from a channel or a file, without first collecting them.
+// When Options.ThreadSafe is set, the lock is acquired once per batch of
+// networks rather than for each network. As the lock is held while next is
+// called, next must not call methods on the tree.
+//
+// If an insert fails, no further networks are read from next and the error
+// is returned. If Options.Progress is set, the number of networks inserted
+// is reported in the ProgressInsert stage.
+//
+// This is not safe to call from multiple threads unless Options.ThreadSafe
+// is set.
+func (t *Tree) InsertAll(next func() (*net.IPNet, mmdbtype.DataType, bool)) error {
+	return t.InsertAllCtx(context.Background(), next)
+}
+
+// InsertAllCtx is the same as InsertAll except that the context is checked
+// before each batch of networks is read from next, and its error is returned
+// if it is done. The networks inserted before then remain in the tree.
+//
+// This is not safe to call from multiple threads unless Options.ThreadSafe
+// is set.
+func (t *Tree) InsertAllCtx(ctx context.Context, next func() (*net.IPNet, mmdbtype.DataType, bool)) error {
+	progress := t.newProgressReporter(ctx, ProgressInsert, 0)
+	for {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		done, err := t.insertBatch(next, progress)
 		if err != nil {
-			return nil, err
+			return err
+		}
+		if done {
+			progress.finish()
+			return nil
 		}
 	}
-	if err := networks.Err(); err != nil {
-		return nil, err
-	}
-	return tree, nil
 }
 
-// Insert a data value into the tree.
-//
-// This is not safe to call from multiple threads.
-func (t *Tree) Insert(network *net.IPNet, value mmdbtype.DataType) error {
-	return t.InsertFunc(network, inserter.ReplaceWith(value))
+// Record is a network and its value.
+type Record struct {
+	Network *net.IPNet
+	Value   mmdbtype.DataType
 }
 
-// InsertFunc will insert the output of the function passed to it. The argument
-// passed to the function is the existing value in the record. The function
-// should return the mmdbtype.DataType to be inserted. In both cases, a nil value means
-// an empty record.
+// InsertSorted inserts the networks in the batch in order, as with Insert.
+// When the networks are sorted by address, each insert starts from the
+// deepest node that it shares with the previous network rather than from
+// the root, which makes inserting large numbers of networks considerably
+// faster. Unsorted batches are inserted correctly, but more slowly.
 //
-// You must never modify the argument passed to the function as the value may
-// be shared with other records. If you want a copy of the mmdbtype.DataType to modify,
-// call the Copy method on it, which will make a deep copy. This isn't done
-// automatically before calling the function as not all functions will require
-// the record to be copied and there is a non-trivial performance impact.
+// If an insert fails, the networks after it are not inserted and the error
+// is returned.
 //
-// The function will be called multiple times per insert when the network
-// has multiple preexisting records associated with it.
+// This is not safe to call from multiple threads unless Options.ThreadSafe
+// is set.
+func (t *Tree) InsertSorted(batch []Record) error {
+	t.lock()
+	defer t.unlock()
+
+	// cursor holds the nodes on the path of the previous network, indexed
+	// by their depth. No nodes are removed while inserting, so they remain
+	// in the tree.
+	cursor := []*node{t.root}
+	var prev net.IP
+	for _, r := range batch {
+		ip, prefixLen := networkIP(r.Network)
+		iRec, err := t.newInsertRecord(ip, prefixLen, recordTypeData, inserter.ReplaceWith(r.Value), nil)
+		if err != nil {
+			return err
+		}
+
+		// The insert starts from the deepest node on the paths of both
+		// networks that is above the new network.
+		depth := 0
+		if prev != nil {
+			depth = commonPrefixLen(prev, iRec.ip)
+			if depth > iRec.prefixLen-1 {
+				depth = iRec.prefixLen - 1
+			}
+			if depth > len(cursor)-1 {
+				depth = len(cursor) - 1
+			}
+			if depth < 0 {
+				depth = 0
+			}
+		}
+		cursor = cursor[:depth+1]
+
+		if err := t.insertFrom(cursor[depth], depth, iRec); err != nil {
+			return err
+		}
+
+		n := cursor[depth]
+		for ; depth < iRec.prefixLen-1; depth++ {
+			child := n.children[bitAt(iRec.ip, depth)]
+			if child.recordType != recordTypeNode && child.recordType != recordTypeFixedNode {
+				break
+			}
+			n = child.node
+			cursor = append(cursor, n)
+		}
+		prev = iRec.ip
+	}
+	return nil
+}
+
+// InsertError is the error for a network in a batch that could not be
+// inserted by InsertBatch.
+type InsertError struct {
+	Network *net.IPNet
+	Err     error
+}
+
+func (e *InsertError) Error() string {
+	return fmt.Sprintf("error inserting %s: %v", e.Network, e.Err)
+}
+
+// Unwrap returns the cause of the error.
+func (e *InsertError) Unwrap() error {
+	return e.Err
+}
+
+// BatchError is returned by InsertBatch when some of the networks in a batch
+// could not be inserted.
+type BatchError struct {
+	// Errors are the errors for each network that could not be inserted, in
+	// the order of the batch.
+	Errors []*InsertError
+}
+
+func (e *BatchError) Error() string {
+	if len(e.Errors) == 1 {
+		return e.Errors[0].Error()
+	}
+	return fmt.Sprintf("%d inserts failed; the first: %v", len(e.Errors), e.Errors[0])
+}
+
+// InsertBatch inserts the networks in the batch in order, as with Insert,
+// but continues with the rest of the batch when an insert fails, e.g., so
+// that a few invalid rows in a feed do not stop the build. If any inserts
+// fail, a *BatchError with the error for each of them is returned after the
+// whole batch has been inserted.
 //
-// This is not safe to call from multiple threads.
-func (t *Tree) InsertFunc(
-	network *net.IPNet,
-	inserter func(value mmdbtype.DataType) (mmdbtype.DataType, error),
-) error {
-	return t.insert(network, recordTypeData, inserter, nil)
+// As with Insert, a failed insert of a network that spans several records
+// may have set some of them.
+//
+// This is not safe to call from multiple threads unless Options.ThreadSafe
+// is set.
+func (t *Tree) InsertBatch(batch []Record) error {
+	t.lock()
+	defer t.unlock()
+
+	var errs []*InsertError
+	for _, r := range batch {
+		ip, prefixLen := networkIP(r.Network)
+		err := t.insertIPLocked(ip, prefixLen, recordTypeData, inserter.ReplaceWith(r.Value), nil)
+		if err != nil {
+			errs = append(errs, &InsertError{Network: r.Network, Err: err})
+		}
+	}
+	if errs != nil {
+		return &BatchError{Errors: errs}
+	}
+	return nil
+}
+
+// commonPrefixLen returns the number of leading bits that a and b, which
+// must be the same length, have in common.
+func commonPrefixLen(a, b net.IP) int {
+	for i := range a {
+		if x := a[i] ^ b[i]; x != 0 {
+			return i*8 + bits.LeadingZeros8(x)
+		}
+	}
+	return len(a) * 8
+}
+
+func (t *Tree) insertBatch(
+	next func() (*net.IPNet, mmdbtype.DataType, bool),
+	progress *progressReporter,
+) (bool, error) {
+	t.lock()
+	defer t.unlock()
+
+	for i := 0; i < insertAllBatchSize; i++ {
+		network, value, ok := next()
+		if !ok {
+			return true, nil
+		}
+		ip, prefixLen := networkIP(network)
+		err := t.insertIPLocked(ip, prefixLen, recordTypeData, inserter.ReplaceWith(value), nil)
+		if err != nil {
+			return true, err
+		}
+		progress.add(1)
+	}
+	return false, nil
 }
 
 func (t *Tree) insert(
@@ -245,42 +834,141 @@ func (t *Tree) insert(
 	inserter func(value mmdbtype.DataType) (mmdbtype.DataType, error),
 	node *node,
 ) error {
-	// We set this to 0 so that the tree must be finalized again.
-	t.nodeCount = 0
-
-	prefixLen, _ := network.Mask.Size()
+	ip, prefixLen := networkIP(network)
+	return t.insertIP(ip, prefixLen, recordType, inserter, node)
+}
 
+// networkIP returns the IP and prefix length of the network. IPv4 networks
+// always have a 4 byte IP.
+func networkIP(network *net.IPNet) (net.IP, int) {
+	prefixLen, bits := network.Mask.Size()
 	ip := network.IP
-	if t.treeDepth == 128 && len(ip) == 4 {
-		ip = ipV4ToV6(ip)
-		prefixLen += 96
+	if bits == 32 {
+		// The IP may be a 16 byte IPv4 address, e.g., from net.ParseIP.
+		if ipv4 := ip.To4(); ipv4 != nil {
+			ip = ipv4
+		}
 	}
+	return ip, prefixLen
+}
 
-	return t.root.insert(
-		insertRecord{
-			ip:           ip,
-			prefixLen:    prefixLen,
-			recordType:   recordType,
-			inserter:     inserter,
-			insertedNode: node,
+func (t *Tree) insertIP(
+	ip net.IP,
+	prefixLen int,
+	recordType recordType,
+	inserter func(value mmdbtype.DataType) (mmdbtype.DataType, error),
+	node *node,
+) error {
+	t.lock()
+	defer t.unlock()
 
-			dataMap: t.dataMap,
-		},
-		0,
-	)
+	return t.insertIPLocked(ip, prefixLen, recordType, inserter, node)
 }
 
-func (t *Tree) insertStringNetwork(
-	network string,
+// insertIPLocked is the same as insertIP except that the caller must hold
+// the lock.
+func (t *Tree) insertIPLocked(
+	ip net.IP,
+	prefixLen int,
 	recordType recordType,
 	inserter func(value mmdbtype.DataType) (mmdbtype.DataType, error),
 	node *node,
 ) error {
-	_, ipnet, err := net.ParseCIDR(network)
+	iRec, err := t.newInsertRecord(ip, prefixLen, recordType, inserter, node)
 	if err != nil {
-		return errors.Wrapf(err, "error parsing network (%s)", network)
+		return err
 	}
-	return t.insert(ipnet, recordType, inserter, node)
+	return t.insertFrom(t.root, 0, iRec)
+}
+
+// newInsertRecord returns the insertRecord for inserting the network. IPv4
+// networks are mapped into the IPv4 subtree of IPv6 trees.
+func (t *Tree) newInsertRecord(
+	ip net.IP,
+	prefixLen int,
+	recordType recordType,
+	inserter func(value mmdbtype.DataType) (mmdbtype.DataType, error),
+	node *node,
+) (insertRecord, error) {
+	// We set this to 0 so that the tree must be finalized again.
+	t.nodeCount = 0
+
+	inputIP, inputPrefixLen := ip, prefixLen
+	if t.treeDepth == 128 && len(ip) == 4 {
+		ip = ipV4ToV6(ip)
+		prefixLen += 96
+	}
+
+	if len(ip)*8 != t.treeDepth {
+		return insertRecord{}, errors.Errorf("cannot insert %s/%d into an IPv%d tree", ip, prefixLen, t.ipVersion)
+	}
+
+	var onInsert func(net.IP, int, mmdbtype.DataType, mmdbtype.DataType)
+	var path net.IP
+	if t.onInsert != nil && recordType == recordTypeData {
+		onInsert = func(ip net.IP, prefixLen int, old, new mmdbtype.DataType) {
+			t.onInsert(t.network(ip, prefixLen), old, new)
+		}
+		path = make(net.IP, len(ip))
+		copy(path, ip)
+	}
+
+	return insertRecord{
+		ip:             ip,
+		prefixLen:      prefixLen,
+		inputIP:        inputIP,
+		inputPrefixLen: inputPrefixLen,
+		recordType:     recordType,
+		inserter:       inserter,
+		insertedNode:   node,
+
+		dataMap:      t.dataMap,
+		nodes:        t.allocator,
+		provenance:   t.provenance,
+		skipReserved: t.skipReservedInserts,
+		onInsert:     onInsert,
+		path:         path,
+		metrics:      t.metrics,
+		maxNodes:     t.maxNodes,
+	}, nil
+}
+
+// insertFrom inserts the record starting at n, which must be the node at
+// depth on the path to the network. The nodes above n must already be marked
+// as changed.
+func (t *Tree) insertFrom(n *node, depth int, iRec insertRecord) error {
+	err := n.insert(iRec, depth)
+	if errors.Is(err, errInsertSkipped) {
+		t.skippedInserts++
+		logf(t.logger, "%v", err)
+		return nil
+	}
+	if err == nil && iRec.recordType == recordTypeData {
+		addMetric(t.metrics, CounterInserts, 1)
+	}
+	return err
+}
+
+// SkippedInserts returns the number of inserts into reserved or aliased
+// networks that were skipped because Options.OnReservedInsert is
+// ReservedInsertSkip.
+func (t *Tree) SkippedInserts() int {
+	t.rlock()
+	defer t.runlock()
+
+	return t.skippedInserts
+}
+
+// AliasSpec is an aliased network in an IPv6 tree. A lookup of an address in
+// Network continues in Target using the bits of the address that follow
+// Network's prefix, e.g., looking up ::ffff:1.2.3.4 with an alias of
+// ::ffff:0:0/96 to ::/96 returns the data for ::1.2.3.4, i.e., 1.2.3.4.
+type AliasSpec struct {
+	// Network is the aliased network. Data cannot be inserted into it.
+	Network *net.IPNet
+	// Target is the network that lookups in Network are redirected to. An
+	// IPv4 network refers to the IPv4 subtree, ::/96.
+	Target *net.IPNet
 }
 
 var ipv4AliasNetworks = []string{
@@ -289,38 +977,83 @@ var ipv4AliasNetworks = []string{
 	"2002::/16",
 }
 
-func (t *Tree) insertIPv4Aliases() error {
-	_, ipv4Root, err := net.ParseCIDR("::/96")
-	if err != nil {
-		return errors.Wrap(err, "error parsing IPv4 root")
+// DefaultAliases returns the aliases used in IPv6 trees by default. These
+// alias the IPv4-mapped network, ::ffff:0:0/96, Teredo, 2001::/32, and 6to4,
+// 2002::/16, to the IPv4 subtree, ::/96.
+func DefaultAliases() []AliasSpec {
+	_, ipv4Root, _ := net.ParseCIDR("::/96")
+	aliases := make([]AliasSpec, 0, len(ipv4AliasNetworks))
+	for _, network := range ipv4AliasNetworks {
+		_, ipnet, _ := net.ParseCIDR(network)
+		aliases = append(aliases, AliasSpec{Network: ipnet, Target: ipv4Root})
 	}
+	return aliases
+}
 
-	ipv4RootNode := &node{}
-
-	// Make ::/96, the IPv4 root, a fixed node.
-	err = t.insert(ipv4Root, recordTypeFixedNode, nil, ipv4RootNode)
-	if err != nil {
-		return err
+func (t *Tree) insertAliases(aliases []AliasSpec) error {
+	// The networks must not overlap as the alias and fixed node records
+	// would otherwise be copied into or replaced by each other. Aliases may
+	// share a target.
+	var inserted []*net.IPNet
+	checkOverlap := func(network *net.IPNet) error {
+		ip, prefixLen, err := t.treeNetwork(network)
+		if err != nil {
+			return err
+		}
+		for _, other := range inserted {
+			otherIP, otherPrefixLen, _ := t.treeNetwork(other)
+			minPrefixLen := prefixLen
+			if otherPrefixLen < minPrefixLen {
+				minPrefixLen = otherPrefixLen
+			}
+			mask := net.CIDRMask(minPrefixLen, t.treeDepth)
+			if ip.Mask(mask).Equal(otherIP.Mask(mask)) {
+				return errors.Errorf("alias network %s overlaps %s", network, other)
+			}
+		}
+		inserted = append(inserted, network)
+		return nil
 	}
 
-	for _, network := range ipv4AliasNetworks {
-		err := t.insertStringNetwork(network, recordTypeAlias, nil, ipv4RootNode)
+	targets := map[string]*node{}
+	for _, alias := range aliases {
+		if alias.Network == nil || alias.Target == nil {
+			return errors.New("aliases must have a network and a target")
+		}
+
+		ip, prefixLen, err := t.treeNetwork(alias.Target)
 		if err != nil {
 			return err
 		}
+		// This is used rather than the target's string so that an IPv4
+		// target and its network in the IPv4 subtree are the same.
+		targetKey := t.network(ip, prefixLen).String()
+
+		target, ok := targets[targetKey]
+		if !ok {
+			if err := checkOverlap(alias.Target); err != nil {
+				return err
+			}
+			target = t.allocator.new()
+			if err := t.insert(alias.Target, recordTypeFixedNode, nil, target); err != nil {
+				return err
+			}
+			targets[targetKey] = target
+		}
+
+		if err := checkOverlap(alias.Network); err != nil {
+			return err
+		}
+		if err := t.insert(alias.Network, recordTypeAlias, nil, target); err != nil {
+			return err
+		}
 	}
 	return nil
 }
 
-func (t *Tree) insertReservedNetworks() error {
-	// the reserved networks are in reserved.go
-	networks := reservedNetworksIPv4
-	if t.ipVersion == 6 {
-		networks = append(networks, reservedNetworksIPv6...)
-	}
-
+func (t *Tree) insertReservedNetworks(networks []*net.IPNet) error {
 	for _, network := range networks {
-		err := t.insertStringNetwork(network, recordTypeReserved, nil, nil)
+		err := t.insert(network, recordTypeReserved, nil, nil)
 		if err != nil {
 			return err
 		}
@@ -329,11 +1062,32 @@ func (t *Tree) insertReservedNetworks() error {
 }
 
 // Get the value for the given IP address from the tree. If the nil interface
-// is returned, that means the tree does not have a value for the IP.
+// is returned, that means the tree does not have a value for the IP. In an
+// IPv4 tree, IPv6 addresses other than IPv4-mapped addresses are not in the
+// tree and a nil network is returned for them.
 func (t *Tree) Get(ip net.IP) (*net.IPNet, mmdbtype.DataType) {
+	network, r := t.getRecord(ip)
+
+	var value mmdbtype.DataType
+	if r.recordType == recordTypeData {
+		value = r.value.data
+	}
+	return network, value
+}
+
+// getRecord returns the network and record for the IP. The network is nil
+// and the record is empty if the IP cannot be in the tree.
+func (t *Tree) getRecord(ip net.IP) (*net.IPNet, record) {
 	lookupIP := ip
 
-	if t.treeDepth == 128 {
+	if t.treeDepth == 32 {
+		ipv4 := ip.To4()
+		if ipv4 == nil {
+			return nil, record{}
+		}
+		ip = ipv4
+		lookupIP = ipv4
+	} else {
 		// We use To4() here as Go will parse an IPv4 address to a 16 byte
 		// IPv6-mapped IPv4 address, e.g.:
 		//
@@ -346,7 +1100,7 @@ func (t *Tree) Get(ip net.IP) (*net.IPNet, mmdbtype.DataType) {
 		}
 	}
 
-	prefixLen, r := t.root.get(lookupIP, 0)
+	prefixLen, r := t.get(lookupIP)
 
 	// This is so that if you look up an IPv4 address in a database that has
 	// an IPv4 subtree, you will get back an IPv4 network. This matches what
@@ -357,53 +1111,302 @@ func (t *Tree) Get(ip net.IP) (*net.IPNet, mmdbtype.DataType) {
 
 	mask := net.CIDRMask(prefixLen, t.treeDepth)
 
-	var value mmdbtype.DataType
-	if r.recordType == recordTypeData {
-		value = r.value.data
+	return &net.IPNet{
+		IP:   ip.Mask(mask),
+		Mask: mask,
+	}, r
+}
+
+// get returns the prefix length of the record for the IP, which must already
+// be in the tree's representation, and the record.
+func (t *Tree) get(ip net.IP) (int, record) {
+	t.rlock()
+	defer t.runlock()
+
+	return t.root.get(ip, 0)
+}
+
+// Lookup looks up the IP address in the tree and decodes its value into
+// result, which must be a non-nil pointer, in the same way as
+// github.com/oschwald/maxminddb-golang decodes values read from a database,
+// e.g., into a struct with maxminddb tags. See mmdbtype.Unmarshal for the
+// supported types. The network containing the IP is returned. If the tree
+// does not have a value for the IP, result is left unchanged.
+//
+// This allows code that reads the database to be used with a tree before it
+// has been written.
+func (t *Tree) Lookup(ip net.IP, result interface{}) (*net.IPNet, error) {
+	network, value := t.Get(ip)
+	if err := mmdbtype.Unmarshal(value, result); err != nil {
+		return network, errors.WithMessagef(err, "error decoding the value for %s", ip)
+	}
+	return network, nil
+}
+
+// GetExact returns the value for network if the tree has a record for
+// exactly that network. Unlike Get, nil is returned if network is part of a
+// larger network with data or if it has been split by the insertion of a
+// smaller network with different data.
+//
+// The records are those of the search tree, so they do not always match the
+// inserted networks. Inserting 1.1.1.0/24 into 1.1.0.0/16 splits the latter
+// into records for 1.1.0.0/24, 1.1.2.0/23, ..., 1.1.128.0/17, each of which
+// has the value of 1.1.0.0/16. Finalizing the tree, which happens when it is
+// written or walked, merges adjacent networks with the same data.
+//
+// nil is also returned for an IPv6 network in an IPv4 tree and for aliased
+// networks.
+func (t *Tree) GetExact(network *net.IPNet) mmdbtype.DataType {
+	ip, prefixLen, err := t.treeNetwork(network)
+	if err != nil {
+		return nil
 	}
 
+	t.rlock()
+	defer t.runlock()
+
+	r, depth := t.prefixRecord(ip, prefixLen)
+	if r.recordType != recordTypeData || depth != prefixLen {
+		return nil
+	}
+	return r.value.data
+}
+
+// Walk calls fn for every network in the tree that has data, in address
+// order. The tree is finalized first so that the networks match those that
+// would be written to the database, e.g., adjacent networks with the same
+// value are combined.
+//
+// Aliased networks are not visited. In an IPv6 tree, networks in the IPv4
+// subtree, ::/96, are passed to fn as IPv4 networks.
+//
+// If fn returns an error, the walk stops and the error is returned. fn must
+// not modify the tree or the value passed to it.
+//
+// This is not safe to call from multiple threads unless Options.ThreadSafe
+// is set.
+func (t *Tree) Walk(fn func(network *net.IPNet, value mmdbtype.DataType) error) error {
+	t.lock()
+	defer t.unlock()
+
+	if t.nodeCount == 0 {
+		t.finalize()
+	}
+
+	ip := make(net.IP, t.treeDepth/8)
+	return t.root.walk(ip, 0, func(ip net.IP, prefixLen int, r *record) error {
+		return fn(t.network(ip, prefixLen), r.value.data)
+	})
+}
+
+// FindNetworks returns every network with data in the tree whose value
+// matches the predicate, in address order. The networks are as returned by
+// Walk. As many networks often share a value, match is called once for each
+// distinct value rather than for each network. match must not modify the
+// value passed to it.
+//
+// This is not safe to call from multiple threads unless Options.ThreadSafe
+// is set.
+func (t *Tree) FindNetworks(match func(value mmdbtype.DataType) bool) []*net.IPNet {
+	t.lock()
+	defer t.unlock()
+
+	if t.nodeCount == 0 {
+		t.finalize()
+	}
+
+	matches := map[dataMapKey]bool{}
+	var networks []*net.IPNet
+	ip := make(net.IP, t.treeDepth/8)
+	// The callback never returns an error.
+	_ = t.root.walk(ip, 0, func(ip net.IP, prefixLen int, r *record) error {
+		ok, seen := matches[r.value.key]
+		if !seen {
+			ok = match(r.value.data)
+			matches[r.value.key] = ok
+		}
+		if ok {
+			networks = append(networks, t.network(ip, prefixLen))
+		}
+		return nil
+	})
+	return networks
+}
+
+// FindValues returns every distinct value stored in the tree that matches
+// the predicate, e.g., to find malformed values in a large build without
+// visiting each network. match is called once for each distinct value, and
+// values are returned in an unspecified but consistent order. Use
+// FindNetworks to find the networks with the values. match must not modify
+// the value passed to it.
+//
+// This is not safe to call from multiple threads unless Options.ThreadSafe
+// is set.
+func (t *Tree) FindValues(match func(value mmdbtype.DataType) bool) []mmdbtype.DataType {
+	t.rlock()
+	defer t.runlock()
+
+	// Values with the same data but different provenance are stored
+	// separately, so the keys are deduplicated.
+	keys := make([]string, 0, len(t.dataMap.data))
+	values := make(map[string]mmdbtype.DataType, len(t.dataMap.data))
+	for _, v := range t.dataMap.data {
+		if _, ok := values[string(v.key)]; ok {
+			continue
+		}
+		values[string(v.key)] = v.data
+		keys = append(keys, string(v.key))
+	}
+	sort.Strings(keys)
+
+	var matches []mmdbtype.DataType
+	for _, key := range keys {
+		if match(values[key]) {
+			matches = append(matches, values[key])
+		}
+	}
+	return matches
+}
+
+// network returns a new *net.IPNet for the IP and prefix length, which are
+// relative to the tree's depth. Networks in the IPv4 subtree of an IPv6 tree
+// are returned as IPv4 networks.
+func (t *Tree) network(ip net.IP, prefixLen int) *net.IPNet {
+	if t.treeDepth == 128 && prefixLen >= 96 && bytes.Equal(ip[:12], v4Prefix) {
+		ip = ip[12:]
+		prefixLen -= 96
+	}
+
+	bitLen := len(ip) * 8
+	mask := net.CIDRMask(prefixLen, bitLen)
 	return &net.IPNet{
 		IP:   ip.Mask(mask),
 		Mask: mask,
-	}, value
+	}
+}
+
+// newDerived returns a new, empty tree with the same options and build
+// epoch as t.
+func (t *Tree) newDerived() (*Tree, error) {
+	opts := t.options
+	opts.BuildEpoch = t.buildEpoch
+	return New(opts)
+}
+
+// treeLock is the lock of a thread-safe tree.
+type treeLock struct {
+	sync.RWMutex
+	// order is unique to each lock and increases with each lock created,
+	// so that functions that lock two trees may lock them in a consistent
+	// order.
+	order uint64
+}
+
+// lockOrder is the order of the last lock created.
+var lockOrder uint64
+
+func newTreeLock() *treeLock {
+	return &treeLock{order: atomic.AddUint64(&lockOrder, 1)}
+}
+
+func (t *Tree) lock() {
+	if t.mu != nil {
+		t.mu.Lock()
+	}
+}
+
+func (t *Tree) unlock() {
+	if t.mu != nil {
+		t.mu.Unlock()
+	}
+}
+
+func (t *Tree) rlock() {
+	if t.mu != nil {
+		t.mu.RLock()
+	}
+}
+
+func (t *Tree) runlock() {
+	if t.mu != nil {
+		t.mu.RUnlock()
+	}
+}
+
+// FinalizeCtx prunes and numbers the nodes of the tree if it has changed
+// since it was last finalized, as WriteTo and the other methods that need a
+// finalized tree otherwise do first. This allows the finalization of a large
+// tree to be aborted. The context is checked periodically and its error is
+// returned if it is done. The tree is left in a consistent state, and the
+// nodes that were not finalized yet are finalized the next time.
+//
+// This is not safe to call from multiple threads unless Options.ThreadSafe
+// is set.
+func (t *Tree) FinalizeCtx(ctx context.Context) error {
+	t.lock()
+	defer t.unlock()
+
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	if t.nodeCount != 0 {
+		return nil
+	}
+	return t.finalizeCtx(ctx)
 }
 
 // finalize prepares the tree for writing. It is not threadsafe.
 func (t *Tree) finalize() {
-	_, t.nodeCount = t.root.finalize(0)
+	// The context is never done, so there is no error.
+	_ = t.finalizeCtx(context.Background())
+}
+
+// finalizeCtx is the same as finalize except that it stops and returns the
+// error of the context if it is done. The tree is only marked as finalized
+// if it completes.
+func (t *Tree) finalizeCtx(ctx context.Context) error {
+	defer observeSince(t.metrics, TimerFinalize, time.Now())
+
+	progress := t.newProgressReporter(ctx, ProgressFinalize, 0)
+	_, nodeCount := t.root.finalize(0, t.allocator, t.dataMap, progress)
+	if err := progress.canceled(); err != nil {
+		return err
+	}
+	t.nodeCount = nodeCount
+	progress.finish()
+	return nil
 }
 
 // WriteTo writes the tree to the provided Writer.
 func (t *Tree) WriteTo(w io.Writer) (int64, error) {
-	if t.nodeCount == 0 {
-		t.finalize()
+	return t.WriteToCtx(context.Background(), w)
+}
+
+// WriteToCtx is the same as WriteTo except that the context is checked
+// periodically while the tree is finalized and written, and its error is
+// returned if it is done, e.g., so that a build may be aborted when the
+// request for it is canceled. Some of the database may have been written to
+// w by then.
+//
+// This is not safe to call from multiple threads unless Options.ThreadSafe
+// is set.
+func (t *Tree) WriteToCtx(ctx context.Context, w io.Writer) (int64, error) {
+	t.lock()
+	defer t.unlock()
+	defer observeSince(t.metrics, TimerWrite, time.Now())
+
+	dataWriter, nodes, recordSize, err := t.prepareWrite(ctx)
+	if err != nil {
+		return 0, err
 	}
 
 	buf := bufio.NewWriter(w)
 
-	// We create this here so that we don't have to allocate millions of these. This
-	// may no longer make sense now that we are using a bufio.Writer anyway, which has
-	// WriteByte, but we should probably do some testing.
-	recordBuf := make([]byte, 2*t.recordSize/8)
-
-	usePointers := true
-	dataWriter := newDataWriter(t.dataMap, usePointers)
-
-	nodeCount, numBytes, err := t.writeNode(buf, t.root, dataWriter, recordBuf)
+	numBytes, err := t.writeNodes(ctx, buf, nodes, dataWriter, recordSize)
 	if err != nil {
 		_ = buf.Flush()
 		return numBytes, err
 	}
-	if nodeCount != t.nodeCount {
-		_ = buf.Flush()
-		// This should only happen if there is a programming bug
-		// in this library.
-		return numBytes, errors.Errorf(
-			"number of nodes written (%d) doesn't match number expected (%d)",
-			nodeCount,
-			t.nodeCount,
-		)
-	}
 
 	nb, err := buf.Write(dataSectionSeparator)
 	numBytes += int64(nb)
@@ -427,7 +1430,7 @@ func (t *Tree) WriteTo(w io.Writer) (int64, error) {
 	}
 
 	metadataWriter := newDataWriter(dataWriter.dataMap, !t.disableMetadataPointers)
-	_, err = t.writeMetadata(metadataWriter)
+	_, err = t.writeMetadata(metadataWriter, recordSize)
 	if err != nil {
 		_ = buf.Flush()
 		return numBytes, errors.Wrap(err, "error writing metadata")
@@ -448,44 +1451,367 @@ func (t *Tree) WriteTo(w io.Writer) (int64, error) {
 	return numBytes, err
 }
 
-func (t *Tree) writeNode(
+// prepareWrite finalizes the tree if necessary and encodes its data
+// section. It returns the data section, the nodes in the order they are
+// written, and the record size to write them with. The caller must hold the
+// lock.
+func (t *Tree) prepareWrite(ctx context.Context) (*dataWriter, []*node, int, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, nil, 0, err
+	}
+	if t.nodeCount == 0 {
+		if err := t.finalizeCtx(ctx); err != nil {
+			return nil, nil, 0, err
+		}
+	}
+
+	usePointers := true
+	dataWriter := newDataWriter(t.dataMap, usePointers)
+
+	// We write the data section before the search tree so that we know its
+	// size, and thus the largest record value, before writing any nodes.
+	// This also means that the nodes only read from the dataWriter, which
+	// allows them to be encoded concurrently.
+	progress := t.newProgressReporter(ctx, ProgressWriteData, t.nodeCount)
+	if err := t.writeData(t.root, dataWriter, progress); err != nil {
+		return nil, nil, 0, err
+	}
+	progress.finish()
+
+	recordSize, err := t.resolveRecordSize(dataWriter.Len())
+	if err != nil {
+		return nil, nil, 0, err
+	}
+
+	if t.strict {
+		if err := t.checkSpec(recordSize); err != nil {
+			return nil, nil, 0, err
+		}
+	}
+
+	nodes := t.nodes(t.root, make([]*node, 0, t.nodeCount))
+	if len(nodes) != t.nodeCount {
+		// This should only happen if there is a programming bug
+		// in this library.
+		return nil, nil, 0, errors.Errorf(
+			"number of nodes to write (%d) doesn't match number expected (%d)",
+			len(nodes),
+			t.nodeCount,
+		)
+	}
+	return dataWriter, nodes, recordSize, nil
+}
+
+// WriteToFile writes the database to the file at path. The database is
+// written to a temporary file in the same directory, synced, and then
+// renamed to path, so readers of path never see a partially written
+// database. If path already exists, it is replaced. The new file has mode
+// 0644.
+//
+// This is not safe to call from multiple threads unless Options.ThreadSafe
+// is set.
+func (t *Tree) WriteToFile(path string) (int64, error) {
+	dir, base := filepath.Split(path)
+	if dir == "" {
+		dir = "."
+	}
+	f, err := ioutil.TempFile(dir, "."+base+".tmp")
+	if err != nil {
+		return 0, errors.Wrap(err, "error creating temporary file")
+	}
+	tmpPath := f.Name()
+
+	numBytes, err := t.writeToTempFile(f)
+	if err != nil {
+		_ = os.Remove(tmpPath)
+		return numBytes, err
+	}
+
+	if err := os.Rename(tmpPath, path); err != nil {
+		_ = os.Remove(tmpPath)
+		return numBytes, errors.Wrap(err, "error renaming temporary file")
+	}
+	return numBytes, nil
+}
+
+// writeToTempFile writes the database to f, syncs it, and closes it.
+func (t *Tree) writeToTempFile(f *os.File) (int64, error) {
+	numBytes, err := t.WriteTo(f)
+	if err != nil {
+		_ = f.Close()
+		return numBytes, err
+	}
+	if err := f.Chmod(0o644); err != nil {
+		_ = f.Close()
+		return numBytes, errors.Wrap(err, "error setting file mode")
+	}
+	if err := f.Sync(); err != nil {
+		_ = f.Close()
+		return numBytes, errors.Wrap(err, "error syncing temporary file")
+	}
+	return numBytes, errors.Wrap(f.Close(), "error closing temporary file")
+}
+
+// nodeBatchSize is the number of nodes encoded at a time by writeNodes.
+const nodeBatchSize = 1 << 16
+
+// minNodesPerWorker is the smallest number of nodes that are encoded in a
+// separate goroutine. Below this, the overhead of the goroutine exceeds the
+// time it takes to encode the nodes.
+const minNodesPerWorker = 1 << 12
+
+// writeNodes writes the search tree. The nodes must be in the order of their
+// node numbers. The nodes are encoded in batches, and the nodes in each batch
+// are split between multiple goroutines. As each node is encoded into its
+// own position in the batch's buffer, the output does not depend on how the
+// work is split up. The context is checked after each batch.
+func (t *Tree) writeNodes(
+	ctx context.Context,
 	w io.Writer,
-	n *node,
+	nodes []*node,
 	dataWriter *dataWriter,
-	recordBuf []byte,
-) (int, int64, error) {
-	err := t.copyNode(recordBuf, n, dataWriter)
-	if err != nil {
-		return 0, 0, err
+	recordSize int,
+) (int64, error) {
+	nodeBytes := recordSize / 4
+	batchSize := nodeBatchSize
+	if len(nodes) < batchSize {
+		batchSize = len(nodes)
 	}
+	batch := make([]byte, batchSize*nodeBytes)
 
+	progress := t.newProgressReporter(ctx, ProgressWriteNodes, len(nodes))
 	numBytes := int64(0)
-	nb, err := w.Write(recordBuf)
-	numBytes += int64(nb)
-	nodesWritten := 1
-	if err != nil {
-		return nodesWritten, numBytes, errors.Wrap(err, "error writing node")
+	for len(nodes) > 0 {
+		n := batchSize
+		if len(nodes) < n {
+			n = len(nodes)
+		}
+
+		buf := batch[:n*nodeBytes]
+		if err := t.copyNodes(buf, nodes[:n], dataWriter, recordSize); err != nil {
+			return numBytes, err
+		}
+
+		nb, err := w.Write(buf)
+		numBytes += int64(nb)
+		if err != nil {
+			return numBytes, errors.Wrap(err, "error writing node")
+		}
+		nodes = nodes[n:]
+		progress.add(n)
+		if err := progress.canceled(); err != nil {
+			return numBytes, err
+		}
 	}
+	progress.finish()
+	return numBytes, nil
+}
+
+// copyNodes encodes the nodes into buf, which must be exactly large enough
+// to hold them.
+func (t *Tree) copyNodes(buf []byte, nodes []*node, dataWriter *dataWriter, recordSize int) error {
+	nodeBytes := recordSize / 4
 
+	workers := runtime.GOMAXPROCS(0)
+	if maxWorkers := len(nodes) / minNodesPerWorker; workers > maxWorkers {
+		workers = maxWorkers
+	}
+	if workers <= 1 {
+		for i, n := range nodes {
+			if err := t.copyNode(buf[i*nodeBytes:], n, dataWriter, recordSize); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+
+	chunkSize := (len(nodes) + workers - 1) / workers
+	errs := make([]error, workers)
+	var wg sync.WaitGroup
+	for worker := 0; worker < workers; worker++ {
+		start := worker * chunkSize
+		end := start + chunkSize
+		if end > len(nodes) {
+			end = len(nodes)
+		}
+
+		wg.Add(1)
+		go func(worker, start, end int) {
+			defer wg.Done()
+			for i := start; i < end; i++ {
+				err := t.copyNode(buf[i*nodeBytes:], nodes[i], dataWriter, recordSize)
+				if err != nil {
+					errs[worker] = err
+					return
+				}
+			}
+		}(worker, start, end)
+	}
+	wg.Wait()
+
+	// We return the error for the first node that failed so that the error
+	// is the same regardless of the number of goroutines.
+	for _, err := range errs {
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// nodes appends the nodes in the subtree to the slice in the order of their
+// node numbers and sets the node number of each. The tree must be finalized.
+func (t *Tree) nodes(n *node, nodes []*node) []*node {
+	n.nodeNum = len(nodes)
+	nodes = append(nodes, n)
 	for i := 0; i < 2; i++ {
 		child := n.children[i]
 		if child.recordType != recordTypeNode && child.recordType != recordTypeFixedNode {
 			continue
 		}
-		addedNodes, addedBytes, err := t.writeNode(
-			w,
-			n.children[i].node,
-			dataWriter,
-			recordBuf,
+		nodes = t.nodes(child.node, nodes)
+	}
+	return nodes
+}
+
+// writeData writes the value of each data record in the subtree to the
+// dataWriter. The values are written in node order so that the data section
+// is the same as if it had been written while writing the nodes.
+func (t *Tree) writeData(n *node, dataWriter *dataWriter, progress *progressReporter) error {
+	progress.add(1)
+	if err := progress.canceled(); err != nil {
+		return err
+	}
+
+	for i := 0; i < 2; i++ {
+		r := n.children[i]
+		if r.recordType != recordTypeData {
+			continue
+		}
+		if _, err := dataWriter.maybeWrite(r.value); err != nil {
+			return err
+		}
+	}
+
+	for i := 0; i < 2; i++ {
+		child := n.children[i]
+		if child.recordType != recordTypeNode && child.recordType != recordTypeFixedNode {
+			continue
+		}
+		if err := t.writeData(child.node, dataWriter, progress); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// EstimateSize returns the size in bytes of the database that WriteTo would
+// write for the tree in its current state, so that unexpected growth may be
+// caught before the database is written. The tree is finalized and its data
+// section is encoded to determine its size, but the search tree is not
+// encoded, which makes this considerably faster than WriteTo for large trees.
+//
+// This is not safe to call from multiple threads unless Options.ThreadSafe
+// is set.
+func (t *Tree) EstimateSize() (int64, error) {
+	t.lock()
+	defer t.unlock()
+
+	if t.nodeCount == 0 {
+		t.finalize()
+	}
+
+	dataWriter := newDataWriter(t.dataMap, true)
+	if err := t.writeData(t.root, dataWriter, nil); err != nil {
+		return 0, err
+	}
+
+	recordSize, err := t.resolveRecordSize(dataWriter.Len())
+	if err != nil {
+		return 0, err
+	}
+
+	metadataWriter := newDataWriter(dataWriter.dataMap, !t.disableMetadataPointers)
+	if _, err := t.writeMetadata(metadataWriter, recordSize); err != nil {
+		return 0, errors.Wrap(err, "error writing metadata")
+	}
+
+	size := int64(t.nodeCount) * int64(recordSize) / 4
+	size += int64(len(dataSectionSeparator) + dataWriter.Len())
+	size += int64(len(metadataStartMarker) + metadataWriter.Len())
+	return size, nil
+}
+
+var recordSizes = []int{24, 28, 32}
+
+// RecordSizeError is returned when writing a tree whose search tree and
+// data section cannot be addressed with the record size. Nothing is written
+// in this case.
+type RecordSizeError struct {
+	// RecordSize is the configured record size. It is 0 if the database is
+	// too large for any record size.
+	RecordSize      int
+	NodeCount       int
+	DataSectionSize int
+}
+
+// Is returns true for ErrRecordSizeOverflow.
+func (e *RecordSizeError) Is(target error) bool {
+	return target == ErrRecordSizeOverflow
+}
+
+func (e *RecordSizeError) Error() string {
+	if e.RecordSize == 0 {
+		return fmt.Sprintf(
+			"the database is too large to write with any record size: %d nodes and a %d byte data section",
+			e.NodeCount,
+			e.DataSectionSize,
 		)
-		nodesWritten += addedNodes
-		numBytes += addedBytes
-		if err != nil {
-			return nodesWritten, numBytes, err
+	}
+	return fmt.Sprintf(
+		"the database is too large to write with a record size of %d: %d nodes and a %d byte data section; "+
+			"try increasing RecordSize or setting GrowRecordSize",
+		e.RecordSize,
+		e.NodeCount,
+		e.DataSectionSize,
+	)
+}
+
+// resolveRecordSize returns the record size to use when writing the tree.
+// If a record size was not configured, the smallest record size that can
+// address every node and the whole data section is returned.
+func (t *Tree) resolveRecordSize(dataSectionSize int) (int, error) {
+	// The largest possible record value is a pointer to the last byte of
+	// the data section.
+	maxValue := t.nodeCount + len(dataSectionSeparator) + dataSectionSize
+
+	if t.recordSize != 0 && !t.growRecordSize {
+		if maxValue > 1<<t.recordSize {
+			return 0, &RecordSizeError{
+				RecordSize:      t.recordSize,
+				NodeCount:       t.nodeCount,
+				DataSectionSize: dataSectionSize,
+			}
 		}
+		return t.recordSize, nil
 	}
 
-	return nodesWritten, numBytes, nil
+	for _, recordSize := range recordSizes {
+		if recordSize >= t.recordSize && maxValue <= 1<<recordSize {
+			if t.recordSize != 0 && recordSize != t.recordSize {
+				logf(
+					t.logger,
+					"grew the record size from %d to %d to address %d nodes and %d bytes of data",
+					t.recordSize,
+					recordSize,
+					t.nodeCount,
+					dataSectionSize,
+				)
+			}
+			return recordSize, nil
+		}
+	}
+	return 0, &RecordSizeError{NodeCount: t.nodeCount, DataSectionSize: dataSectionSize}
 }
 
 func (t *Tree) recordValue(
@@ -494,7 +1820,7 @@ func (t *Tree) recordValue(
 ) (int, error) {
 	switch r.recordType {
 	case recordTypeData:
-		offset, err := dataWriter.maybeWrite(r.value)
+		offset, err := dataWriter.offset(r.value)
 		return t.nodeCount + len(dataSectionSeparator) + offset, err
 	case recordTypeEmpty, recordTypeReserved:
 		return t.nodeCount, nil
@@ -503,7 +1829,7 @@ func (t *Tree) recordValue(
 	}
 }
 
-func (t *Tree) copyNode(buf []byte, n *node, dataWriter *dataWriter) error {
+func (t *Tree) copyNode(buf []byte, n *node, dataWriter *dataWriter, recordSize int) error {
 	left, err := t.recordValue(n.children[0], dataWriter)
 	if err != nil {
 		return err
@@ -513,18 +1839,18 @@ func (t *Tree) copyNode(buf []byte, n *node, dataWriter *dataWriter) error {
 		return err
 	}
 
-	maxRecord := 1 << t.recordSize
+	maxRecord := 1 << recordSize
 	if left >= maxRecord || right >= maxRecord {
 		return errors.Errorf(
 			"exceeded record capacity by attempting to write (%d, %d) to node with %d bit record size; "+
 				"try increasing RecordSize or reducing the size of the database",
 			left,
 			right,
-			t.recordSize,
+			recordSize,
 		)
 	}
 
-	switch t.recordSize {
+	switch recordSize {
 	case 24:
 		buf[0] = byte((left >> 16) & 0xFF)
 		buf[1] = byte((left >> 8) & 0xFF)
@@ -550,7 +1876,7 @@ func (t *Tree) copyNode(buf []byte, n *node, dataWriter *dataWriter) error {
 		buf[6] = byte((right >> 8) & 0xFF)
 		buf[7] = byte(right & 0xFF)
 	default:
-		return errors.Errorf("unsupported record size of %d", t.recordSize)
+		return errors.Errorf("unsupported record size of %d", recordSize)
 	}
 	return nil
 }
@@ -561,7 +1887,12 @@ func ipV4ToV6(ip net.IP) net.IP {
 	return append(v4Prefix, ip...)
 }
 
-func (t *Tree) writeMetadata(dw *dataWriter) (int64, error) {
+func (t *Tree) writeMetadata(dw *dataWriter, recordSize int) (int64, error) {
+	return t.metadata(recordSize).WriteTo(dw)
+}
+
+// metadata returns the metadata map for the tree.
+func (t *Tree) metadata(recordSize int) mmdbtype.Map {
 	description := mmdbtype.Map{}
 	for k, v := range t.description {
 		description[mmdbtype.String(k)] = mmdbtype.String(v)
@@ -571,7 +1902,7 @@ func (t *Tree) writeMetadata(dw *dataWriter) (int64, error) {
 	for _, v := range t.languages {
 		languages = append(languages, mmdbtype.String(v))
 	}
-	metadata := mmdbtype.Map{
+	return mmdbtype.Map{
 		"binary_format_major_version": mmdbtype.Uint16(2),
 		"binary_format_minor_version": mmdbtype.Uint16(0),
 		"build_epoch":                 mmdbtype.Uint64(t.buildEpoch),
@@ -580,7 +1911,6 @@ func (t *Tree) writeMetadata(dw *dataWriter) (int64, error) {
 		"ip_version":                  mmdbtype.Uint16(t.ipVersion),
 		"languages":                   languages,
 		"node_count":                  mmdbtype.Uint32(t.nodeCount),
-		"record_size":                 mmdbtype.Uint16(t.recordSize),
+		"record_size":                 mmdbtype.Uint16(recordSize),
 	}
-	return metadata.WriteTo(dw)
 }
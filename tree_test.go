@@ -2,17 +2,24 @@ package mmdbwriter
 
 import (
 	"bytes"
+	"context"
 	"fmt"
 	"io/ioutil"
 	"math/big"
+	"math/rand"
 	"net"
 	"os"
+	"path/filepath"
+	"runtime"
+	"sort"
+	"sync"
 	"testing"
 	"time"
 
 	"github.com/maxmind/mmdbwriter/inserter"
 	"github.com/maxmind/mmdbwriter/mmdbtype"
 	"github.com/oschwald/maxminddb-golang"
+	"github.com/pkg/errors"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
 )
@@ -286,11 +293,11 @@ func TestTreeInsertAndGet(t *testing.T) {
 			insertErrors: []testInsertError{
 				{
 					network:          "10.0.0.0/8",
-					expectedErrorMsg: "attempt to insert ::a00:0/104, which is in a reserved network",
+					expectedErrorMsg: "attempt to insert 10.0.0.0/8 (::a00:0/104 in the tree), which is in a reserved network",
 				},
 				{
 					network:          "10.0.0.1/32",
-					expectedErrorMsg: "attempt to insert ::a00:1/128, which is in a reserved network",
+					expectedErrorMsg: "attempt to insert 10.0.0.1/32 (::a00:1/128 in the tree), which is in a reserved network",
 				},
 				{
 					network:          "2002:100::/24",
@@ -534,7 +541,1318 @@ func TestInsertFunc_RemovalAndLaterInsert(t *testing.T) {
 	assert.Nil(t, recValue)
 }
 
+func TestInsertWith(t *testing.T) {
+	tree, err := New(Options{})
+	require.NoError(t, err)
+
+	network := mustParseNetwork(t, "1.1.1.0/24")
+	require.NoError(t, tree.InsertWith(network, mmdbtype.Map{"a": mmdbtype.Uint32(1)}, nil))
+
+	// An application-defined generator that adds the value to "a".
+	count := func(value mmdbtype.DataType) inserter.Func {
+		return func(existing mmdbtype.DataType) (mmdbtype.DataType, error) {
+			m := existing.(mmdbtype.Map).Copy().(mmdbtype.Map)
+			m["a"] = m["a"].(mmdbtype.Uint32) + value.(mmdbtype.Uint32)
+			return m, nil
+		}
+	}
+	require.NoError(t, tree.InsertWith(network, mmdbtype.Uint32(2), count))
+
+	_, value := tree.Get(net.ParseIP("1.1.1.1"))
+	assert.Equal(t, mmdbtype.Map{"a": mmdbtype.Uint32(3)}, value)
+
+	require.NoError(t, tree.InsertWith(network, mmdbtype.Map{"b": mmdbtype.Bool(true)}, inserter.TopLevelMergeWith))
+	_, value = tree.Get(net.ParseIP("1.1.1.1"))
+	assert.Equal(t, mmdbtype.Map{"a": mmdbtype.Uint32(3), "b": mmdbtype.Bool(true)}, value)
+}
+
+func TestOnInsert(t *testing.T) {
+	type event struct {
+		network  string
+		old, new mmdbtype.DataType
+	}
+	var events []event
+	tree, err := New(Options{
+		OnInsert: func(network *net.IPNet, old, new mmdbtype.DataType) {
+			events = append(events, event{network: network.String(), old: old, new: new})
+		},
+	})
+	require.NoError(t, err)
+
+	require.NoError(t, tree.Insert(mustParseNetwork(t, "1.1.1.0/24"), mmdbtype.String("a")))
+	require.NoError(t, tree.Insert(mustParseNetwork(t, "1.1.1.128/25"), mmdbtype.String("b")))
+	require.NoError(t, tree.Insert(mustParseNetwork(t, "1.1.0.0/23"), mmdbtype.String("c")))
+	require.NoError(t, tree.Remove(mustParseNetwork(t, "2003::/16")))
+
+	assert.Equal(
+		t,
+		[]event{
+			{network: "1.1.1.0/24", new: mmdbtype.String("a")},
+			{network: "1.1.1.128/25", old: mmdbtype.String("a"), new: mmdbtype.String("b")},
+			{network: "1.1.0.0/24", new: mmdbtype.String("c")},
+			{network: "1.1.1.0/25", old: mmdbtype.String("a"), new: mmdbtype.String("c")},
+			{network: "1.1.1.128/25", old: mmdbtype.String("b"), new: mmdbtype.String("c")},
+			{network: "2003::/16"},
+		},
+		events,
+	)
+}
+
+type testMetrics struct {
+	counters map[Counter]int
+	timers   map[Timer]int
+}
+
+func (m *testMetrics) Add(counter Counter, delta int) {
+	m.counters[counter] += delta
+}
+
+func (m *testMetrics) Observe(timer Timer, d time.Duration) {
+	m.timers[timer]++
+}
+
+func TestMetrics(t *testing.T) {
+	metrics := &testMetrics{counters: map[Counter]int{}, timers: map[Timer]int{}}
+	tree, err := New(Options{
+		DatabaseType: "mmdbwriter-test",
+		Metrics:      metrics,
+	})
+	require.NoError(t, err)
+
+	value := mmdbtype.String("a")
+	require.NoError(t, tree.Insert(mustParseNetwork(t, "1.1.1.0/24"), value))
+	nodesCreated := metrics.counters[CounterNodesCreated]
+	assert.Positive(t, nodesCreated)
+
+	// This splits the existing record and merges into its second half.
+	require.NoError(t, tree.Insert(mustParseNetwork(t, "1.1.1.128/25"), value))
+	assert.Equal(t, nodesCreated+1, metrics.counters[CounterNodesCreated])
+
+	require.NoError(t, tree.Insert(mustParseNetwork(t, "2.2.2.0/24"), value))
+
+	assert.Equal(t, 3, metrics.counters[CounterInserts])
+	assert.Equal(t, 1, metrics.counters[CounterMerges])
+	assert.Equal(t, 2, metrics.counters[CounterDedupHits])
+
+	_, err = tree.WriteTo(&bytes.Buffer{})
+	require.NoError(t, err)
+
+	assert.Equal(t, map[Timer]int{TimerFinalize: 1, TimerWrite: 1}, metrics.timers)
+}
+
+func TestIncrementalFinalize(t *testing.T) {
+	steps := []func(tree *Tree) error{
+		func(tree *Tree) error {
+			for network, value := range map[string]string{
+				"1.1.0.0/16": "a",
+				"2.2.2.0/24": "b",
+				"2.2.3.0/24": "c",
+			} {
+				if err := tree.Insert(mustParseNetwork(t, network), mmdbtype.String(value)); err != nil {
+					return err
+				}
+			}
+			return nil
+		},
+		func(tree *Tree) error {
+			// This makes 2.2.2.0/23 mergeable.
+			if err := tree.Insert(mustParseNetwork(t, "2.2.3.0/24"), mmdbtype.String("b")); err != nil {
+				return err
+			}
+			return tree.Insert(mustParseNetwork(t, "3.3.3.0/24"), mmdbtype.String("d"))
+		},
+		func(tree *Tree) error {
+			// This makes 1.1.0.0/16 mergeable with 1.0.0.0/16.
+			return tree.Insert(mustParseNetwork(t, "1.0.0.0/16"), mmdbtype.String("a"))
+		},
+		func(tree *Tree) error {
+			return tree.TransformAll(func(_ *net.IPNet, _ mmdbtype.DataType) (mmdbtype.DataType, error) {
+				return mmdbtype.String("e"), nil
+			})
+		},
+	}
+
+	write := func(tree *Tree) []byte {
+		buf := &bytes.Buffer{}
+		_, err := tree.WriteTo(buf)
+		require.NoError(t, err)
+		return buf.Bytes()
+	}
+
+	opts := Options{BuildEpoch: 1, DatabaseType: "mmdbwriter-test"}
+	tree, err := New(opts)
+	require.NoError(t, err)
+	for i, step := range steps {
+		require.NoError(t, step(tree))
+		incremental := write(tree)
+		assert.Equal(t, tree.nodeCount, tree.root.size)
+
+		expected, err := New(opts)
+		require.NoError(t, err)
+		for _, step := range steps[:i+1] {
+			require.NoError(t, step(expected))
+		}
+		assert.Equal(t, write(expected), incremental, "step %d", i)
+	}
+}
+
+func TestInsertSorted(t *testing.T) {
+	r := rand.New(rand.NewSource(1))
+	var batch []Record
+	for i := 0; i < 2000; i++ {
+		var ip net.IP
+		var prefixLen int
+		if i%2 == 0 {
+			ip = net.IPv4(byte(1+r.Intn(9)), byte(r.Intn(256)), byte(r.Intn(256)), 0).To4()
+			prefixLen = 8 + r.Intn(25)
+		} else {
+			ip = make(net.IP, 16)
+			ip[0] = 0x20
+			ip[1] = byte(0x03 + r.Intn(4))
+			r.Read(ip[2:])
+			prefixLen = 16 + r.Intn(113)
+		}
+		mask := net.CIDRMask(prefixLen, len(ip)*8)
+		batch = append(batch, Record{
+			Network: &net.IPNet{IP: ip.Mask(mask), Mask: mask},
+			Value:   mmdbtype.Uint32(r.Intn(10)),
+		})
+	}
+	batch = append(batch, Record{Network: mustParseNetwork(t, "::/0"), Value: mmdbtype.String("all")})
+	r.Shuffle(len(batch), func(i, j int) { batch[i], batch[j] = batch[j], batch[i] })
+
+	sorted := append([]Record{}, batch...)
+	sort.SliceStable(sorted, func(i, j int) bool {
+		a, b := sorted[i].Network.IP.To16(), sorted[j].Network.IP.To16()
+		if len(sorted[i].Network.IP) == 4 {
+			a = ipV4ToV6(sorted[i].Network.IP)
+		}
+		if len(sorted[j].Network.IP) == 4 {
+			b = ipV4ToV6(sorted[j].Network.IP)
+		}
+		return bytes.Compare(a, b) < 0
+	})
+
+	write := func(tree *Tree) []byte {
+		buf := &bytes.Buffer{}
+		_, err := tree.WriteTo(buf)
+		require.NoError(t, err)
+		return buf.Bytes()
+	}
+
+	for name, batch := range map[string][]Record{"sorted": sorted, "unsorted": batch} {
+		t.Run(name, func(t *testing.T) {
+			opts := Options{BuildEpoch: 1, DatabaseType: "mmdbwriter-test"}
+			expected, err := New(opts)
+			require.NoError(t, err)
+			for _, r := range batch {
+				require.NoError(t, expected.Insert(r.Network, r.Value))
+			}
+
+			tree, err := New(opts)
+			require.NoError(t, err)
+			require.NoError(t, tree.InsertSorted(batch))
+			assert.Equal(t, write(expected), write(tree))
+		})
+	}
+
+	tree, err := New(Options{})
+	require.NoError(t, err)
+	assert.EqualError(
+		t,
+		tree.InsertSorted([]Record{
+			{Network: mustParseNetwork(t, "1.1.1.0/24"), Value: mmdbtype.String("a")},
+			{Network: mustParseNetwork(t, "10.0.0.0/8"), Value: mmdbtype.String("b")},
+			{Network: mustParseNetwork(t, "11.0.0.0/8"), Value: mmdbtype.String("c")},
+		}),
+		"attempt to insert 10.0.0.0/8 (::a00:0/104 in the tree), which is in a reserved network",
+	)
+	_, value := tree.Get(net.ParseIP("1.1.1.1"))
+	assert.Equal(t, mmdbtype.String("a"), value)
+	_, value = tree.Get(net.ParseIP("11.1.1.1"))
+	assert.Nil(t, value)
+}
+
+func TestRemove(t *testing.T) {
+	tree, err := New(Options{})
+	require.NoError(t, err)
+
+	value := mmdbtype.String("value")
+	for _, network := range []string{"1.1.0.0/16", "2.2.2.0/24", "2.2.3.0/24"} {
+		_, n, err := net.ParseCIDR(network)
+		require.NoError(t, err)
+		require.NoError(t, tree.Insert(n, value))
+	}
+
+	for _, network := range []string{"1.1.1.0/24", "2.2.0.0/16"} {
+		_, n, err := net.ParseCIDR(network)
+		require.NoError(t, err)
+		require.NoError(t, tree.Remove(n))
+	}
+
+	tree.finalize()
+
+	for _, get := range []testGet{
+		{ip: "1.1.0.1", expectedNetwork: "1.1.0.0/24", expectedGetValue: value},
+		{ip: "1.1.1.1", expectedNetwork: "1.1.1.0/24"},
+		{ip: "1.1.255.1", expectedNetwork: "1.1.128.0/17", expectedGetValue: value},
+		{ip: "2.2.2.1", expectedNetwork: "2.0.0.0/7"},
+		{ip: "2.2.3.1", expectedNetwork: "2.0.0.0/7"},
+	} {
+		network, v := tree.Get(net.ParseIP(get.ip))
+		assert.Equal(t, get.expectedNetwork, network.String(), "network for %s", get.ip)
+		assert.Equal(t, get.expectedGetValue, v, "value for %s", get.ip)
+	}
+}
+
+func TestInsertNil(t *testing.T) {
+	tree, err := New(Options{
+		ValidateValues: true,
+		Schema:         Schema{"a": {Type: mmdbtype.Uint32(0), Required: true}},
+	})
+	require.NoError(t, err)
+
+	value := mmdbtype.Map{"a": mmdbtype.Uint32(1)}
+	require.NoError(t, tree.Insert(mustParseNetwork(t, "1.1.0.0/16"), value))
+	require.NoError(t, tree.Insert(mustParseNetwork(t, "2.2.0.0/16"), value))
+
+	// A nil value deletes the data rather than being validated.
+	require.NoError(t, tree.Insert(mustParseNetwork(t, "1.1.1.0/24"), nil))
+	merge := inserter.RemoveIfNil(inserter.TopLevelMergeWith)
+	require.NoError(t, tree.InsertWith(mustParseNetwork(t, "2.2.0.0/16"), nil, merge))
+	require.NoError(t, tree.InsertWith(
+		mustParseNetwork(t, "1.1.2.0/24"),
+		mmdbtype.Map{"a": mmdbtype.Uint32(2)},
+		merge,
+	))
+
+	tree.finalize()
+
+	for _, get := range []testGet{
+		{ip: "1.1.0.1", expectedNetwork: "1.1.0.0/24", expectedGetValue: value},
+		{ip: "1.1.1.1", expectedNetwork: "1.1.1.0/24"},
+		{ip: "1.1.2.1", expectedNetwork: "1.1.2.0/24", expectedGetValue: mmdbtype.Map{"a": mmdbtype.Uint32(2)}},
+		{ip: "2.2.2.1", expectedNetwork: "2.0.0.0/7"},
+	} {
+		network, v := tree.Get(net.ParseIP(get.ip))
+		assert.Equal(t, get.expectedNetwork, network.String(), "network for %s", get.ip)
+		assert.Equal(t, get.expectedGetValue, v, "value for %s", get.ip)
+	}
+}
+
+func TestFillEmpty(t *testing.T) {
+	var inserted []string
+	tree, err := New(Options{
+		OnInsert: func(network *net.IPNet, _, _ mmdbtype.DataType) {
+			inserted = append(inserted, network.String())
+		},
+	})
+	require.NoError(t, err)
+
+	value := mmdbtype.String("value")
+	require.NoError(t, tree.Insert(mustParseNetwork(t, "1.1.1.0/24"), value))
+	require.NoError(t, tree.Insert(mustParseNetwork(t, "1.1.128.0/17"), value))
+	inserted = nil
+
+	unknown := mmdbtype.String("unknown")
+	require.NoError(t, tree.FillEmpty(mustParseNetwork(t, "1.1.0.0/16"), unknown))
+	// The reserved network within the network is skipped.
+	require.NoError(t, tree.FillEmpty(mustParseNetwork(t, "8.0.0.0/6"), unknown))
+
+	assert.NotEmpty(t, inserted)
+	for _, network := range inserted {
+		assert.NotEqual(t, "1.1.1.0/24", network, "records with data are not touched")
+		assert.NotEqual(t, "1.1.128.0/17", network, "records with data are not touched")
+	}
+
+	for _, get := range []testGet{
+		{ip: "1.1.0.1", expectedNetwork: "1.1.0.0/24", expectedGetValue: unknown},
+		{ip: "1.1.1.1", expectedNetwork: "1.1.1.0/24", expectedGetValue: value},
+		{ip: "1.1.2.1", expectedNetwork: "1.1.2.0/23", expectedGetValue: unknown},
+		{ip: "1.1.255.1", expectedNetwork: "1.1.128.0/17", expectedGetValue: value},
+		{ip: "1.2.0.1", expectedNetwork: "1.2.0.0/15"},
+		{ip: "9.0.0.1", expectedNetwork: "8.0.0.0/7", expectedGetValue: unknown},
+		{ip: "10.0.0.1", expectedNetwork: "10.0.0.0/8"},
+	} {
+		network, v := tree.Get(net.ParseIP(get.ip))
+		assert.Equal(t, get.expectedNetwork, network.String(), "network for %s", get.ip)
+		assert.Equal(t, get.expectedGetValue, v, "value for %s", get.ip)
+	}
+}
+
+func TestInsertExcept(t *testing.T) {
+	tree, err := New(Options{})
+	require.NoError(t, err)
+
+	assigned := mmdbtype.String("assigned")
+	require.NoError(t, tree.Insert(mustParseNetwork(t, "1.2.3.0/24"), assigned))
+
+	value := mmdbtype.String("value")
+	require.NoError(t, tree.InsertExcept(
+		mustParseNetwork(t, "1.2.0.0/16"),
+		value,
+		[]*net.IPNet{mustParseNetwork(t, "1.2.3.0/24"), mustParseNetwork(t, "1.2.128.0/17")},
+	))
+
+	tree.finalize()
+
+	for _, get := range []testGet{
+		{ip: "1.2.0.1", expectedNetwork: "1.2.0.0/23", expectedGetValue: value},
+		{ip: "1.2.2.1", expectedNetwork: "1.2.2.0/24", expectedGetValue: value},
+		{ip: "1.2.3.1", expectedNetwork: "1.2.3.0/24", expectedGetValue: assigned},
+		{ip: "1.2.127.1", expectedNetwork: "1.2.64.0/18", expectedGetValue: value},
+		{ip: "1.2.128.1", expectedNetwork: "1.2.128.0/17"},
+	} {
+		network, v := tree.Get(net.ParseIP(get.ip))
+		assert.Equal(t, get.expectedNetwork, network.String(), "network for %s", get.ip)
+		assert.Equal(t, get.expectedGetValue, v, "value for %s", get.ip)
+	}
+
+	err = tree.InsertExcept(
+		mustParseNetwork(t, "1.2.0.0/16"),
+		value,
+		[]*net.IPNet{mustParseNetwork(t, "2001:db8::/32")},
+	)
+	assert.EqualError(t, err, "cannot exclude 2001:db8::/32 from 1.2.0.0/16 as they are not the same IP version")
+}
+
+func TestLookup(t *testing.T) {
+	tree, err := New(Options{})
+	require.NoError(t, err)
+	require.NoError(t, tree.Insert(
+		mustParseNetwork(t, "1.1.1.0/24"),
+		mmdbtype.Map{
+			"country": mmdbtype.Map{"iso_code": mmdbtype.String("AU")},
+			"asn":     mmdbtype.Uint32(13335),
+		},
+	))
+
+	var record struct {
+		Country struct {
+			ISOCode string `maxminddb:"iso_code"`
+		} `maxminddb:"country"`
+		ASN uint `maxminddb:"asn"`
+	}
+	network, err := tree.Lookup(net.ParseIP("1.1.1.1"), &record)
+	require.NoError(t, err)
+	assert.Equal(t, "1.1.1.0/24", network.String())
+	assert.Equal(t, "AU", record.Country.ISOCode)
+	assert.Equal(t, uint(13335), record.ASN)
+
+	var v interface{}
+	network, err = tree.Lookup(net.ParseIP("2.2.2.2"), &v)
+	require.NoError(t, err)
+	assert.Equal(t, "2.0.0.0/7", network.String())
+	assert.Nil(t, v)
+
+	var s string
+	_, err = tree.Lookup(net.ParseIP("1.1.1.1"), &s)
+	assert.Error(t, err)
+}
+
+func TestGetExact(t *testing.T) {
+	tree, err := New(Options{})
+	require.NoError(t, err)
+	for _, insert := range []testInsert{
+		{network: "1.1.0.0/16", value: mmdbtype.String("1.1.0.0/16")},
+		{network: "1.1.1.0/24", value: mmdbtype.String("1.1.1.0/24")},
+		{network: "2003::/16", value: mmdbtype.String("2003::/16")},
+	} {
+		require.NoError(t, tree.Insert(mustParseNetwork(t, insert.network), insert.value))
+	}
+
+	tests := map[string]mmdbtype.DataType{
+		"1.1.1.0/24": mmdbtype.String("1.1.1.0/24"),
+		"2003::/16":  mmdbtype.String("2003::/16"),
+		// The rest of 1.1.0.0/16 is split into records for the networks
+		// around 1.1.1.0/24.
+		"1.1.0.0/24":    mmdbtype.String("1.1.0.0/16"),
+		"1.1.2.0/24":    nil,
+		"1.1.0.0/16":    nil,
+		"1.1.1.0/25":    nil,
+		"2003::/17":     nil,
+		"::ffff:0:0/96": nil,
+	}
+	for network, expected := range tests {
+		assert.Equal(t, expected, tree.GetExact(mustParseNetwork(t, network)), network)
+	}
+}
+
+func TestWalk(t *testing.T) {
+	tree, err := New(Options{})
+	require.NoError(t, err)
+
+	inserts := []testInsert{
+		{network: "2003::/16", value: mmdbtype.String("c")},
+		{network: "1.1.1.0/24", value: mmdbtype.String("a")},
+		{network: "1.1.2.0/24", value: mmdbtype.String("b")},
+		{network: "1.1.3.0/24", value: mmdbtype.String("b")},
+	}
+	for _, insert := range inserts {
+		_, network, err := net.ParseCIDR(insert.network)
+		require.NoError(t, err)
+		require.NoError(t, tree.Insert(network, insert.value))
+	}
+
+	var networks []string
+	var values []mmdbtype.DataType
+	err = tree.Walk(func(network *net.IPNet, value mmdbtype.DataType) error {
+		networks = append(networks, network.String())
+		values = append(values, value)
+		return nil
+	})
+	require.NoError(t, err)
+
+	assert.Equal(t, []string{"1.1.1.0/24", "1.1.2.0/23", "2003::/16"}, networks)
+	assert.Equal(
+		t,
+		[]mmdbtype.DataType{mmdbtype.String("a"), mmdbtype.String("b"), mmdbtype.String("c")},
+		values,
+	)
+
+	expectedErr := errors.New("stop")
+	calls := 0
+	err = tree.Walk(func(*net.IPNet, mmdbtype.DataType) error {
+		calls++
+		return expectedErr
+	})
+	assert.Equal(t, expectedErr, err)
+	assert.Equal(t, 1, calls)
+}
+
+func TestFindNetworks(t *testing.T) {
+	tree, err := New(Options{})
+	require.NoError(t, err)
+	for _, insert := range []testInsert{
+		{network: "1.1.0.0/16", value: mmdbtype.Map{"country": mmdbtype.String("DE")}},
+		{network: "1.2.0.0/16", value: mmdbtype.Map{"country": mmdbtype.String("FR")}},
+		{network: "1.3.0.0/16", value: mmdbtype.Map{"country": mmdbtype.String("DE")}},
+		{network: "2003::/16", value: mmdbtype.Map{"country": mmdbtype.String("DE")}},
+	} {
+		_, network, err := net.ParseCIDR(insert.network)
+		require.NoError(t, err)
+		require.NoError(t, tree.Insert(network, insert.value))
+	}
+
+	calls := 0
+	networks := tree.FindNetworks(func(value mmdbtype.DataType) bool {
+		calls++
+		return value.(mmdbtype.Map)["country"] == mmdbtype.String("DE")
+	})
+
+	var actual []string
+	for _, network := range networks {
+		actual = append(actual, network.String())
+	}
+	assert.Equal(t, []string{"1.1.0.0/16", "1.3.0.0/16", "2003::/16"}, actual)
+	assert.Equal(t, 2, calls, "predicate is called once per distinct value")
+}
+
+func TestFindValues(t *testing.T) {
+	tree, err := New(Options{TrackProvenance: true})
+	require.NoError(t, err)
+	tree.SetSource("a")
+	for _, insert := range []testInsert{
+		{network: "1.1.0.0/16", value: mmdbtype.Map{"asn": mmdbtype.Uint32(0)}},
+		{network: "1.2.0.0/16", value: mmdbtype.Map{"asn": mmdbtype.Uint32(13335)}},
+		{network: "1.3.0.0/16", value: mmdbtype.Map{"asn": mmdbtype.Uint32(13335)}},
+	} {
+		_, network, err := net.ParseCIDR(insert.network)
+		require.NoError(t, err)
+		require.NoError(t, tree.Insert(network, insert.value))
+	}
+	// The same value from a different source is stored separately, but it
+	// is only returned once.
+	tree.SetSource("b")
+	_, network, err := net.ParseCIDR("2003::/16")
+	require.NoError(t, err)
+	require.NoError(t, tree.Insert(network, mmdbtype.Map{"asn": mmdbtype.Uint32(0)}))
+
+	calls := 0
+	values := tree.FindValues(func(value mmdbtype.DataType) bool {
+		calls++
+		return value.(mmdbtype.Map)["asn"] == mmdbtype.Uint32(0)
+	})
+	assert.Equal(t, []mmdbtype.DataType{mmdbtype.Map{"asn": mmdbtype.Uint32(0)}}, values)
+	assert.Equal(t, 2, calls, "predicate is called once per distinct value")
+
+	assert.Len(t, tree.FindValues(func(mmdbtype.DataType) bool { return true }), 2)
+}
+
+func TestFindValuesAfterOverwrite(t *testing.T) {
+	tree, err := New(Options{})
+	require.NoError(t, err)
+
+	a := mmdbtype.String("a")
+	require.NoError(t, tree.Insert(mustParseNetwork(t, "1.1.0.0/16"), a))
+	require.NoError(t, tree.Insert(mustParseNetwork(t, "1.1.1.0/24"), mmdbtype.String("b")))
+	require.NoError(t, tree.Insert(mustParseNetwork(t, "1.1.1.0/24"), a))
+	// The records for 1.1.0.0/16 are merged when the tree is written.
+	_, err = tree.WriteTo(ioutil.Discard)
+	require.NoError(t, err)
+
+	c := mmdbtype.String("c")
+	require.NoError(t, tree.Insert(mustParseNetwork(t, "1.1.0.0/16"), c))
+	assert.Equal(
+		t,
+		[]mmdbtype.DataType{c},
+		tree.FindValues(func(mmdbtype.DataType) bool { return true }),
+		"values overwritten after being merged are not returned",
+	)
+}
+
+func TestMergeTree(t *testing.T) {
+	countryTree, err := New(Options{})
+	require.NoError(t, err)
+	_, network, err := net.ParseCIDR("1.1.0.0/16")
+	require.NoError(t, err)
+	require.NoError(t, countryTree.Insert(network, mmdbtype.Map{"country": mmdbtype.String("AU")}))
+
+	asnTree, err := New(Options{})
+	require.NoError(t, err)
+	_, network, err = net.ParseCIDR("1.1.1.0/24")
+	require.NoError(t, err)
+	require.NoError(t, asnTree.Insert(network, mmdbtype.Map{"asn": mmdbtype.Uint32(13335)}))
+
+	require.NoError(t, countryTree.MergeTree(asnTree, inserter.TopLevelMergeWith))
+
+	for _, get := range []testGet{
+		{
+			ip:               "1.1.0.1",
+			expectedNetwork:  "1.1.0.0/24",
+			expectedGetValue: mmdbtype.Map{"country": mmdbtype.String("AU")},
+		},
+		{
+			ip:              "1.1.1.1",
+			expectedNetwork: "1.1.1.0/24",
+			expectedGetValue: mmdbtype.Map{
+				"asn":     mmdbtype.Uint32(13335),
+				"country": mmdbtype.String("AU"),
+			},
+		},
+	} {
+		network, v := countryTree.Get(net.ParseIP(get.ip))
+		assert.Equal(t, get.expectedNetwork, network.String(), "network for %s", get.ip)
+		assert.Equal(t, get.expectedGetValue, v, "value for %s", get.ip)
+	}
+
+	require.NoError(t, countryTree.MergeTree(asnTree, nil))
+
+	_, v := countryTree.Get(net.ParseIP("1.1.1.1"))
+	assert.Equal(t, mmdbtype.Map{"asn": mmdbtype.Uint32(13335)}, v, "nil strategy replaces")
+}
+
+func TestMergeTreeWithItself(t *testing.T) {
+	tree, err := New(Options{ThreadSafe: true})
+	require.NoError(t, err)
+	require.NoError(t, tree.Insert(mustParseNetwork(t, "1.1.0.0/16"), mmdbtype.Slice{mmdbtype.Uint32(1)}))
+
+	done := make(chan error, 1)
+	go func() {
+		done <- tree.MergeTree(tree, inserter.AppendUniqueWith)
+	}()
+	select {
+	case err := <-done:
+		require.NoError(t, err)
+	case <-time.After(10 * time.Second):
+		require.FailNow(t, "MergeTree with the tree itself deadlocked")
+	}
+
+	_, value := tree.Get(net.ParseIP("1.1.1.1"))
+	assert.Equal(t, mmdbtype.Slice{mmdbtype.Uint32(1)}, value)
+}
+
+func TestIntersectAndSubtractTree(t *testing.T) {
+	newTree := func(inserts []testInsert) *Tree {
+		tree, err := New(Options{})
+		require.NoError(t, err)
+		for _, insert := range inserts {
+			_, network, err := net.ParseCIDR(insert.network)
+			require.NoError(t, err)
+			require.NoError(t, tree.Insert(network, insert.value))
+		}
+		return tree
+	}
+	newDataTree := func() *Tree {
+		return newTree([]testInsert{
+			{network: "1.1.0.0/16", value: mmdbtype.String("1.1/16")},
+			{network: "1.2.0.0/16", value: mmdbtype.String("1.2/16")},
+			{network: "2003::/16", value: mmdbtype.String("2003/16")},
+		})
+	}
+	list := newTree([]testInsert{
+		{network: "1.1.1.0/24", value: mmdbtype.Bool(true)},
+		{network: "1.2.0.0/15", value: mmdbtype.Bool(true)},
+	})
+
+	intersection := newDataTree()
+	require.NoError(t, intersection.IntersectTree(list))
+
+	subtraction := newDataTree()
+	require.NoError(t, subtraction.SubtractTree(list))
+
+	tests := []struct {
+		ip           string
+		intersection mmdbtype.DataType
+		subtraction  mmdbtype.DataType
+	}{
+		{ip: "1.1.0.1", subtraction: mmdbtype.String("1.1/16")},
+		{ip: "1.1.1.1", intersection: mmdbtype.String("1.1/16")},
+		{ip: "1.2.0.1", intersection: mmdbtype.String("1.2/16")},
+		{ip: "1.3.0.1"},
+		{ip: "2003::1", subtraction: mmdbtype.String("2003/16")},
+	}
+	for _, test := range tests {
+		_, v := intersection.Get(net.ParseIP(test.ip))
+		assert.Equal(t, test.intersection, v, "intersection value for %s", test.ip)
+
+		_, v = subtraction.Get(net.ParseIP(test.ip))
+		assert.Equal(t, test.subtraction, v, "subtraction value for %s", test.ip)
+	}
+
+	tree := newDataTree()
+	require.NoError(t, tree.SubtractTree(tree))
+	require.NoError(t, tree.Walk(func(network *net.IPNet, _ mmdbtype.DataType) error {
+		return errors.Errorf("unexpected network %s", network)
+	}))
+
+	v4, err := New(Options{IPVersion: 4})
+	require.NoError(t, err)
+	assert.EqualError(t, tree.IntersectTree(v4), "cannot intersect an IPv6 tree with an IPv4 tree")
+}
+
+func TestAliases(t *testing.T) {
+	aliases := []AliasSpec{
+		// NAT64
+		{Network: mustParseNetwork(t, "64:ff9b::/96"), Target: mustParseNetwork(t, "0.0.0.0/0")},
+	}
+	for _, alias := range DefaultAliases() {
+		if alias.Network.String() != "2002::/16" {
+			aliases = append(aliases, alias)
+		}
+	}
+
+	tree, err := New(Options{
+		Aliases:      aliases,
+		DatabaseType: "mmdbwriter-test",
+		Description:  map[string]string{"en": "Test database"},
+	})
+	require.NoError(t, err)
+	require.NoError(t, tree.Insert(mustParseNetwork(t, "1.1.1.0/24"), mmdbtype.String("1.1.1.0/24")))
+	require.NoError(t, tree.Insert(mustParseNetwork(t, "2002::/16"), mmdbtype.String("2002::/16")))
+	assert.EqualError(
+		t,
+		tree.Insert(mustParseNetwork(t, "64:ff9b::/120"), mmdbtype.String("nat64")),
+		"attempt to insert 64:ff9b::/120, which is in an aliased network",
+	)
+
+	buf := &bytes.Buffer{}
+	_, err = tree.WriteTo(buf)
+	require.NoError(t, err)
+
+	reader, err := maxminddb.FromBytes(buf.Bytes())
+	require.NoError(t, err)
+	require.NoError(t, reader.Verify())
+
+	for ip, expected := range map[string]interface{}{
+		"1.1.1.1":             "1.1.1.0/24",
+		"64:ff9b::101:101":    "1.1.1.0/24",
+		"::ffff:1.1.1.1":      "1.1.1.0/24",
+		"2001:0:101:101::":    "1.1.1.0/24",
+		"2002:101:101::":      "2002::/16",
+		"64:ff9b::1:101:101":  nil,
+		"2001:db9::101:101:1": nil,
+	} {
+		var v interface{}
+		require.NoError(t, reader.Lookup(net.ParseIP(ip), &v))
+		assert.Equal(t, expected, v, ip)
+	}
+
+	tree, err = New(Options{Aliases: []AliasSpec{}})
+	require.NoError(t, err)
+	assert.NoError(t, tree.Insert(mustParseNetwork(t, "::ffff:0:0/96"), mmdbtype.String("not aliased")))
+
+	_, err = New(Options{
+		Aliases: []AliasSpec{
+			{Network: mustParseNetwork(t, "::ffff:0:0/96"), Target: mustParseNetwork(t, "::/96")},
+			{Network: mustParseNetwork(t, "::/80"), Target: mustParseNetwork(t, "::/96")},
+		},
+	})
+	assert.EqualError(t, err, "alias network ::/80 overlaps ::/96")
+
+	_, err = New(Options{IPVersion: 4, Aliases: DefaultAliases()})
+	assert.EqualError(t, err, "aliases are only supported in IPv6 trees")
+}
+
+func TestIPv4Tree(t *testing.T) {
+	tree, err := New(Options{
+		IPVersion:    4,
+		DatabaseType: "mmdbwriter-test",
+		Description:  map[string]string{"en": "Test database"},
+	})
+	require.NoError(t, err)
+
+	require.NoError(t, tree.Insert(mustParseNetwork(t, "1.1.1.0/24"), mmdbtype.String("1.1.1.0/24")))
+	// A 16 byte IPv4 address, as returned by net.ParseIP.
+	require.NoError(t, tree.Insert(
+		&net.IPNet{IP: net.ParseIP("2.2.2.0"), Mask: net.CIDRMask(24, 32)},
+		mmdbtype.String("2.2.2.0/24"),
+	))
+	assert.EqualError(
+		t,
+		tree.Insert(mustParseNetwork(t, "2003::/16"), mmdbtype.String("v6")),
+		"cannot insert 2003::/16 into an IPv4 tree",
+	)
+
+	for ip, expected := range map[string]mmdbtype.DataType{
+		"1.1.1.1":        mmdbtype.String("1.1.1.0/24"),
+		"::ffff:2.2.2.2": mmdbtype.String("2.2.2.0/24"),
+		"3.3.3.3":        nil,
+	} {
+		network, value := tree.Get(net.ParseIP(ip))
+		assert.Equal(t, expected, value, ip)
+		if expected != nil {
+			assert.Equal(t, expected, mmdbtype.String(network.String()), ip)
+		}
+	}
+
+	network, value := tree.Get(net.ParseIP("2003::1"))
+	assert.Nil(t, network)
+	assert.Nil(t, value)
+
+	buf := &bytes.Buffer{}
+	_, err = tree.WriteTo(buf)
+	require.NoError(t, err)
+
+	reader, err := maxminddb.FromBytes(buf.Bytes())
+	require.NoError(t, err)
+	require.NoError(t, reader.Verify())
+	assert.Equal(t, uint(4), reader.Metadata.IPVersion)
+
+	var v interface{}
+	require.NoError(t, reader.Lookup(net.ParseIP("2.2.2.2"), &v))
+	assert.Equal(t, "2.2.2.0/24", v)
+}
+
+func TestReservedNetworks(t *testing.T) {
+	var reserved []*net.IPNet
+	for _, network := range DefaultReservedNetworks(6) {
+		if network.String() != "100.64.0.0/10" {
+			reserved = append(reserved, network)
+		}
+	}
+	reserved = append(reserved, mustParseNetwork(t, "2003::/16"))
+
+	tree, err := New(Options{ReservedNetworks: reserved})
+	require.NoError(t, err)
+
+	assert.NoError(t, tree.Insert(mustParseNetwork(t, "100.64.0.0/10"), mmdbtype.String("cgnat")))
+	assert.EqualError(
+		t,
+		tree.Insert(mustParseNetwork(t, "10.0.0.0/8"), mmdbtype.String("private")),
+		"attempt to insert 10.0.0.0/8 (::a00:0/104 in the tree), which is in a reserved network",
+	)
+	assert.EqualError(
+		t,
+		tree.Insert(mustParseNetwork(t, "2003::/32"), mmdbtype.String("custom")),
+		"attempt to insert 2003::/32, which is in a reserved network",
+	)
+
+	tree, err = New(Options{ReservedNetworks: []*net.IPNet{}})
+	require.NoError(t, err)
+	assert.NoError(t, tree.Insert(mustParseNetwork(t, "10.0.0.0/8"), mmdbtype.String("private")))
+
+	assert.Len(t, DefaultReservedNetworks(4), len(reservedNetworksIPv4))
+}
+
+func TestOnReservedInsert(t *testing.T) {
+	tree, err := New(Options{OnReservedInsert: ReservedInsertSkip})
+	require.NoError(t, err)
+
+	for _, network := range []string{"10.0.0.0/8", "1.1.1.0/24", "10.1.0.0/16", "::ffff:1.1.1.0/120"} {
+		require.NoError(t, tree.Insert(mustParseNetwork(t, network), mmdbtype.String(network)))
+	}
+	assert.Equal(t, 3, tree.SkippedInserts())
+
+	_, value := tree.Get(net.ParseIP("1.1.1.1"))
+	assert.Equal(t, mmdbtype.String("1.1.1.0/24"), value)
+
+	_, err = New(Options{OnReservedInsert: ReservedInsertAction(5)})
+	assert.EqualError(t, err, "unsupported OnReservedInsert: 5")
+}
+
+func TestValidateValues(t *testing.T) {
+	tree, err := New(Options{ValidateValues: true})
+	require.NoError(t, err)
+
+	negative := mmdbtype.Uint128(*big.NewInt(-1))
+	assert.EqualError(
+		t,
+		tree.Insert(mustParseNetwork(t, "1.1.1.0/24"), mmdbtype.Map{"a": &negative}),
+		"invalid value at a: the Uint128 -1 is negative",
+	)
+	assert.NoError(t, tree.Insert(mustParseNetwork(t, "1.1.1.0/24"), mmdbtype.Map{"a": mmdbtype.Uint16(1)}))
+}
+
+func TestEstimateSize(t *testing.T) {
+	tree, err := New(Options{})
+	require.NoError(t, err)
+
+	for i := 0; i < 1000; i++ {
+		ip := net.IPv4(1, byte(i>>8), byte(i), 0).To4()
+		network := &net.IPNet{IP: ip, Mask: net.CIDRMask(24, 32)}
+		value := mmdbtype.Map{
+			"country": mmdbtype.Map{"iso_code": mmdbtype.String("AU")},
+			"i":       mmdbtype.Uint32(i % 100),
+		}
+		require.NoError(t, tree.Insert(network, value))
+	}
+
+	size, err := tree.EstimateSize()
+	require.NoError(t, err)
+
+	buf := &bytes.Buffer{}
+	_, err = tree.WriteTo(buf)
+	require.NoError(t, err)
+	assert.Equal(t, int64(buf.Len()), size)
+}
+
+func TestAutomaticRecordSize(t *testing.T) {
+	tree, err := New(Options{
+		DatabaseType: "mmdbwriter-test",
+		Description:  map[string]string{"en": "Test database"},
+	})
+	require.NoError(t, err)
+
+	_, network, err := net.ParseCIDR("1.1.1.0/24")
+	require.NoError(t, err)
+	require.NoError(t, tree.Insert(network, mmdbtype.String("value")))
+
+	buf := &bytes.Buffer{}
+	_, err = tree.WriteTo(buf)
+	require.NoError(t, err)
+
+	reader, err := maxminddb.FromBytes(buf.Bytes())
+	require.NoError(t, err)
+	assert.Equal(t, uint(24), reader.Metadata.RecordSize)
+	assert.NoError(t, reader.Verify())
+
+	tests := []struct {
+		nodeCount          int
+		dataSectionSize    int
+		expectedRecordSize int
+		expectedErr        string
+	}{
+		{nodeCount: 1, dataSectionSize: 1, expectedRecordSize: 24},
+		{nodeCount: 1<<24 - 16, dataSectionSize: 0, expectedRecordSize: 24},
+		{nodeCount: 1<<24 - 16, dataSectionSize: 1, expectedRecordSize: 28},
+		{nodeCount: 1 << 20, dataSectionSize: 1 << 28, expectedRecordSize: 32},
+		{
+			nodeCount:       1 << 31,
+			dataSectionSize: 1 << 31,
+			expectedErr: "the database is too large to write with any record size: " +
+				"2147483648 nodes and a 2147483648 byte data section",
+		},
+	}
+	for _, test := range tests {
+		tree.nodeCount = test.nodeCount
+		recordSize, err := tree.resolveRecordSize(test.dataSectionSize)
+		if test.expectedErr != "" {
+			assert.EqualError(t, err, test.expectedErr)
+			continue
+		}
+		require.NoError(t, err)
+		assert.Equal(t, test.expectedRecordSize, recordSize)
+	}
+
+	_, err = New(Options{RecordSize: 20})
+	assert.EqualError(t, err, "unsupported RecordSize: 20")
+}
+
+type testLogger struct {
+	messages []string
+}
+
+func (l *testLogger) Printf(format string, v ...interface{}) {
+	l.messages = append(l.messages, fmt.Sprintf(format, v...))
+}
+
+func TestLogger(t *testing.T) {
+	logger := &testLogger{}
+	tree, err := New(Options{
+		OnReservedInsert: ReservedInsertSkip,
+		RecordSize:       24,
+		GrowRecordSize:   true,
+		Logger:           logger,
+	})
+	require.NoError(t, err)
+
+	require.NoError(t, tree.Insert(mustParseNetwork(t, "10.1.0.0/16"), mmdbtype.String("private")))
+	require.NoError(t, tree.Insert(mustParseNetwork(t, "2002:101:100::/40"), mmdbtype.String("aliased")))
+
+	tree.nodeCount = 1 << 24
+	_, err = tree.resolveRecordSize(1)
+	require.NoError(t, err)
+
+	assert.Equal(
+		t,
+		[]string{
+			"attempt to insert 10.1.0.0/16 (::a01:0/112 in the tree), which is in a reserved network: insert skipped",
+			"attempt to insert 2002:101:100::/40, which is in an aliased network: insert skipped",
+			"grew the record size from 24 to 28 to address 16777216 nodes and 1 bytes of data",
+		},
+		logger.messages,
+	)
+}
+
+func TestRecordSizeError(t *testing.T) {
+	tree, err := New(Options{RecordSize: 24})
+	require.NoError(t, err)
+	tree.nodeCount = 1 << 24
+
+	_, err = tree.resolveRecordSize(1)
+	var sizeErr *RecordSizeError
+	require.True(t, errors.As(err, &sizeErr))
+	assert.Equal(t, &RecordSizeError{RecordSize: 24, NodeCount: 1 << 24, DataSectionSize: 1}, sizeErr)
+	assert.EqualError(
+		t,
+		err,
+		"the database is too large to write with a record size of 24: 16777216 nodes and a 1 byte data section; "+
+			"try increasing RecordSize or setting GrowRecordSize",
+	)
+
+	tree, err = New(Options{RecordSize: 28, GrowRecordSize: true})
+	require.NoError(t, err)
+	tree.nodeCount = 1
+	recordSize, err := tree.resolveRecordSize(1)
+	require.NoError(t, err)
+	assert.Equal(t, 28, recordSize, "the configured record size is the minimum")
+
+	tree.nodeCount = 1 << 28
+	recordSize, err = tree.resolveRecordSize(1)
+	require.NoError(t, err)
+	assert.Equal(t, 32, recordSize)
+}
+
+func TestInsertRange(t *testing.T) {
+	tree, err := New(Options{})
+	require.NoError(t, err)
+
+	value := mmdbtype.String("value")
+	require.NoError(t, tree.InsertRange(net.ParseIP("1.2.3.7"), net.ParseIP("1.2.9.255"), value))
+
+	for ip, expectedNetwork := range map[string]string{
+		"1.2.3.6":   "1.2.3.6/32",
+		"1.2.3.7":   "1.2.3.7/32",
+		"1.2.3.200": "1.2.3.128/25",
+		"1.2.9.255": "1.2.8.0/23",
+	} {
+		network, v := tree.Get(net.ParseIP(ip))
+		assert.Equal(t, expectedNetwork, network.String(), "network for %s", ip)
+		if ip == "1.2.3.6" {
+			assert.Nil(t, v, "value for %s", ip)
+		} else {
+			assert.Equal(t, value, v, "value for %s", ip)
+		}
+	}
+}
+
+func TestInsertCount(t *testing.T) {
+	tree, err := New(Options{})
+	require.NoError(t, err)
+
+	value := mmdbtype.String("value")
+	require.NoError(t, tree.InsertCount(net.ParseIP("1.2.3.0"), 768, value))
+
+	network, v := tree.Get(net.ParseIP("1.2.5.255"))
+	assert.Equal(t, "1.2.4.0/23", network.String())
+	assert.Equal(t, value, v)
+
+	network, v = tree.Get(net.ParseIP("1.2.6.0"))
+	assert.Equal(t, "1.2.6.0/23", network.String())
+	assert.Nil(t, v)
+
+	assert.EqualError(
+		t,
+		tree.InsertCount(net.ParseIP("255.255.255.255"), 2, value),
+		"2 addresses starting at 255.255.255.255 extend past the last address",
+	)
+}
+
+func TestInsertAll(t *testing.T) {
+	tree, err := New(Options{ThreadSafe: true})
+	require.NoError(t, err)
+
+	records := make(chan testInsert)
+	go func() {
+		defer close(records)
+		for i := 0; i < 3*insertAllBatchSize; i++ {
+			records <- testInsert{
+				network: fmt.Sprintf("1.%d.%d.0/24", i>>8, i&0xFF),
+				value:   mmdbtype.Uint32(i),
+			}
+		}
+	}()
+
+	err = tree.InsertAll(func() (*net.IPNet, mmdbtype.DataType, bool) {
+		r, ok := <-records
+		if !ok {
+			return nil, nil, false
+		}
+		_, network, err := net.ParseCIDR(r.network)
+		require.NoError(t, err)
+		return network, r.value, true
+	})
+	require.NoError(t, err)
+
+	network, value := tree.Get(net.ParseIP("1.11.255.1"))
+	assert.Equal(t, "1.11.255.0/24", network.String())
+	assert.Equal(t, mmdbtype.Uint32(3*insertAllBatchSize-1), value)
+
+	_, network, err = net.ParseCIDR("10.0.0.0/8")
+	require.NoError(t, err)
+	calls := 0
+	err = tree.InsertAll(func() (*net.IPNet, mmdbtype.DataType, bool) {
+		calls++
+		return network, mmdbtype.Bool(true), true
+	})
+	assert.EqualError(t, err, "attempt to insert 10.0.0.0/8 (::a00:0/104 in the tree), which is in a reserved network")
+	assert.Equal(t, 1, calls)
+}
+
+func TestMaxNodes(t *testing.T) {
+	tree, err := New(Options{IPVersion: 4, IncludeReservedNetworks: true, MaxNodes: 8})
+	require.NoError(t, err)
+
+	// The root and the nodes for the first 7 bits of the network.
+	require.NoError(t, tree.Insert(mustParseNetwork(t, "1.0.0.0/8"), mmdbtype.Uint32(1)))
+	require.NoError(t, tree.Insert(mustParseNetwork(t, "1.0.0.0/8"), mmdbtype.Uint32(2)))
+
+	err = tree.Insert(mustParseNetwork(t, "2.0.0.0/8"), mmdbtype.Uint32(3))
+	assert.EqualError(t, err, "attempt to insert 2.0.0.0/8 would exceed the limit of 8 nodes")
+	assert.True(t, errors.Is(err, ErrNodeLimitExceeded))
+
+	_, value := tree.Get(net.ParseIP("1.1.1.1"))
+	assert.Equal(t, mmdbtype.Uint32(2), value)
+	_, value = tree.Get(net.ParseIP("2.2.2.2"))
+	assert.Nil(t, value)
+
+	// The nodes pruned by finalizing the tree no longer count.
+	require.NoError(t, tree.Remove(mustParseNetwork(t, "1.0.0.0/8")))
+	_, err = tree.WriteTo(ioutil.Discard)
+	require.NoError(t, err)
+	assert.NoError(t, tree.Insert(mustParseNetwork(t, "2.0.0.0/8"), mmdbtype.Uint32(3)))
+
+	_, err = New(Options{MaxNodes: -1})
+	assert.EqualError(t, err, "unsupported MaxNodes: -1")
+}
+
+func TestInsertAll16ByteIPv4(t *testing.T) {
+	inserted, err := New(Options{})
+	require.NoError(t, err)
+	streamed, err := New(Options{})
+	require.NoError(t, err)
+
+	ip := net.ParseIP("1.2.3.0").To16()
+	network := &net.IPNet{IP: ip, Mask: net.CIDRMask(24, 32)}
+	value := mmdbtype.String("value")
+	require.NoError(t, inserted.Insert(network, value))
+	sent := false
+	require.NoError(t, streamed.InsertAll(func() (*net.IPNet, mmdbtype.DataType, bool) {
+		if sent {
+			return nil, nil, false
+		}
+		sent = true
+		return network, value, true
+	}))
+
+	for _, tree := range []*Tree{inserted, streamed} {
+		n, v := tree.Get(net.ParseIP("1.2.3.4"))
+		assert.Equal(t, "1.2.3.0/24", n.String())
+		assert.Equal(t, value, v)
+	}
+}
+
+func TestInsertAllCtx(t *testing.T) {
+	tree, err := New(Options{})
+	require.NoError(t, err)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	calls := 0
+	err = tree.InsertAllCtx(ctx, func() (*net.IPNet, mmdbtype.DataType, bool) {
+		calls++
+		if calls == insertAllBatchSize {
+			cancel()
+		}
+		ip := net.IPv4(1, byte(calls>>8), byte(calls), 0).To4()
+		return &net.IPNet{IP: ip, Mask: net.CIDRMask(24, 32)}, mmdbtype.Uint32(calls), true
+	})
+	assert.Equal(t, context.Canceled, err)
+	assert.Equal(t, insertAllBatchSize, calls, "no networks are read after the batch")
+
+	_, value := tree.Get(net.ParseIP("1.4.0.1"))
+	assert.Equal(t, mmdbtype.Uint32(insertAllBatchSize), value)
+}
+
+func TestInsertBatch(t *testing.T) {
+	tree, err := New(Options{})
+	require.NoError(t, err)
+
+	reserved := mustParseNetwork(t, "10.0.0.0/8")
+	documentation := mustParseNetwork(t, "2001:db8::/32")
+	err = tree.InsertBatch([]Record{
+		{Network: mustParseNetwork(t, "1.1.1.0/24"), Value: mmdbtype.Uint32(1)},
+		{Network: reserved, Value: mmdbtype.Uint32(2)},
+		{Network: mustParseNetwork(t, "2.2.2.0/24"), Value: mmdbtype.Uint32(3)},
+		{Network: documentation, Value: mmdbtype.Uint32(4)},
+	})
+	require.Error(t, err)
+	assert.EqualError(
+		t,
+		err,
+		"2 inserts failed; the first: error inserting 10.0.0.0/8: "+
+			"attempt to insert 10.0.0.0/8 (::a00:0/104 in the tree), which is in a reserved network",
+	)
+
+	var batchErr *BatchError
+	require.True(t, errors.As(err, &batchErr))
+	require.Len(t, batchErr.Errors, 2)
+	assert.Equal(t, reserved, batchErr.Errors[0].Network)
+	assert.True(t, errors.Is(batchErr.Errors[0], ErrReservedNetwork))
+	assert.Equal(t, documentation, batchErr.Errors[1].Network)
+
+	// The networks after the failed inserts are still inserted.
+	_, value := tree.Get(net.ParseIP("1.1.1.1"))
+	assert.Equal(t, mmdbtype.Uint32(1), value)
+	_, value = tree.Get(net.ParseIP("2.2.2.2"))
+	assert.Equal(t, mmdbtype.Uint32(3), value)
+
+	assert.NoError(t, tree.InsertBatch([]Record{
+		{Network: mustParseNetwork(t, "3.3.3.0/24"), Value: mmdbtype.Uint32(5)},
+	}))
+}
+
+func TestThreadSafe(t *testing.T) {
+	tree, err := New(Options{ThreadSafe: true})
+	require.NoError(t, err)
+
+	var wg sync.WaitGroup
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			for j := 0; j < 256; j++ {
+				ip := net.IPv4(byte(i+1), byte(j), 0, 0).To4()
+				network := &net.IPNet{IP: ip, Mask: net.CIDRMask(16, 32)}
+				assert.NoError(t, tree.Insert(network, mmdbtype.Uint32(i)))
+				_, v := tree.Get(ip)
+				assert.Equal(t, mmdbtype.Uint32(i), v)
+			}
+		}(i)
+	}
+	wg.Wait()
+
+	count := 0
+	require.NoError(t, tree.Walk(func(*net.IPNet, mmdbtype.DataType) error {
+		count++
+		return nil
+	}))
+	// Each goroutine's /16s are combined into a single /8.
+	assert.Equal(t, 8, count)
+}
+
+func TestPrunedNodesAreReused(t *testing.T) {
+	tree, err := New(Options{})
+	require.NoError(t, err)
+
+	for _, network := range []string{"1.1.1.0/24", "1.1.1.0/25", "1.1.1.128/25"} {
+		require.NoError(t, tree.Insert(mustParseNetwork(t, network), mmdbtype.String("value")))
+	}
+	// The node for 1.1.1.0/24 is pruned as both of its records have the
+	// same data.
+	tree.finalize()
+	require.Len(t, tree.allocator.free, 1)
+
+	require.NoError(t, tree.Insert(mustParseNetwork(t, "1.1.2.0/25"), mmdbtype.String("other")))
+	assert.Empty(t, tree.allocator.free)
+
+	_, value := tree.Get(net.ParseIP("1.1.1.200"))
+	assert.Equal(t, mmdbtype.String("value"), value)
+}
+
+func TestWriteToIsDeterministic(t *testing.T) {
+	tree, err := New(Options{
+		BuildEpoch:   1,
+		DatabaseType: "mmdbwriter-test",
+		Description:  map[string]string{"en": "Test database"},
+	})
+	require.NoError(t, err)
+
+	// Enough networks that the nodes are encoded by multiple goroutines.
+	for i := 0; i < 1<<15; i++ {
+		ip := net.IPv4(1, byte(i>>8), byte(i), 0).To4()
+		network := &net.IPNet{IP: ip, Mask: net.CIDRMask(24, 32)}
+		require.NoError(t, tree.Insert(network, mmdbtype.Map{"i": mmdbtype.Uint32(i % 1000)}))
+	}
+
+	write := func(procs int) []byte {
+		defer runtime.GOMAXPROCS(runtime.GOMAXPROCS(procs))
+		buf := &bytes.Buffer{}
+		_, err := tree.WriteTo(buf)
+		require.NoError(t, err)
+		return buf.Bytes()
+	}
+
+	serial := write(1)
+	parallel := write(8)
+	assert.Equal(t, serial, parallel)
+
+	reader, err := maxminddb.FromBytes(parallel)
+	require.NoError(t, err)
+	require.NoError(t, reader.Verify())
+
+	var v map[string]interface{}
+	require.NoError(t, reader.Lookup(net.ParseIP("1.1.2.3"), &v))
+	assert.Equal(t, map[string]interface{}{"i": uint64(258)}, v)
+}
+
 func s2ip(v string) *interface{} {
 	i := interface{}(v)
 	return &i
 }
+
+func TestWriteToFile(t *testing.T) {
+	dir, err := ioutil.TempDir("", "mmdbwriter")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	tree, err := New(Options{
+		DatabaseType: "mmdbwriter-test",
+		Description:  map[string]string{"en": "Test database"},
+	})
+	require.NoError(t, err)
+	require.NoError(t, tree.Insert(mustParseNetwork(t, "1.1.1.0/24"), mmdbtype.String("a")))
+
+	path := filepath.Join(dir, "test.mmdb")
+	require.NoError(t, ioutil.WriteFile(path, []byte("old"), 0o600))
+
+	numBytes, err := tree.WriteToFile(path)
+	require.NoError(t, err)
+
+	b, err := ioutil.ReadFile(path) //nolint:gosec // the path is from TempDir
+	require.NoError(t, err)
+	assert.Equal(t, int64(len(b)), numBytes)
+
+	reader, err := maxminddb.FromBytes(b)
+	require.NoError(t, err)
+	require.NoError(t, reader.Verify())
+
+	files, err := ioutil.ReadDir(dir)
+	require.NoError(t, err)
+	require.Len(t, files, 1, "the temporary file was renamed")
+	assert.Equal(t, os.FileMode(0o644), files[0].Mode().Perm())
+
+	_, err = tree.WriteToFile(filepath.Join(dir, "missing", "test.mmdb"))
+	assert.Error(t, err)
+}
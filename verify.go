@@ -0,0 +1,91 @@
+package mmdbwriter
+
+import (
+	"net"
+
+	"github.com/oschwald/maxminddb-golang"
+	"github.com/pkg/errors"
+)
+
+// VerifyAgainst checks that the database read by reader matches the tree,
+// e.g., after writing the tree and opening the result with
+// maxminddb.FromBytes or maxminddb.Open. It checks that the database has the
+// tree's IP version, database type, and node count, that every network with
+// data in the database has the same data in the tree, and that the tree has
+// no other networks with data. Aliased networks are not checked.
+//
+// The tree is finalized first. It should not be modified between writing
+// it and calling VerifyAgainst.
+//
+// This is not safe to call from multiple threads unless Options.ThreadSafe
+// is set.
+func (t *Tree) VerifyAgainst(reader *maxminddb.Reader) error {
+	t.lock()
+	defer t.unlock()
+
+	if t.nodeCount == 0 {
+		t.finalize()
+	}
+
+	m := reader.Metadata
+	if int(m.IPVersion) != t.ipVersion {
+		return errors.Errorf("the database is for IPv%d but the tree is for IPv%d", m.IPVersion, t.ipVersion)
+	}
+	if m.DatabaseType != t.databaseType {
+		return errors.Errorf(
+			"the database type is %q but the tree's type is %q",
+			m.DatabaseType,
+			t.databaseType,
+		)
+	}
+	if int(m.NodeCount) != t.nodeCount {
+		return errors.Errorf("the database has %d nodes but the tree has %d", m.NodeCount, t.nodeCount)
+	}
+
+	dser := newDeserializer()
+	count := 0
+	networks := reader.Networks(maxminddb.SkipAliasedNetworks)
+	for networks.Next() {
+		dser.clear()
+		network, err := networks.Network(dser)
+		if err != nil {
+			return errors.Wrap(err, "error reading network from the database")
+		}
+
+		ip, prefixLen, err := t.treeNetwork(network)
+		if err != nil {
+			return err
+		}
+		r, depth := t.prefixRecord(ip, prefixLen)
+		if r.recordType == recordTypeAlias {
+			continue
+		}
+		if r.recordType != recordTypeData || depth != prefixLen {
+			return errors.Errorf("the database has data for %s but the tree does not have that network", network)
+		}
+
+		key, err := t.dataMap.keyWriter.key(dser.rv)
+		if err != nil {
+			return err
+		}
+		if dataMapKey(key) != r.value.key {
+			return errors.Errorf("the data for %s in the database differs from the tree", network)
+		}
+		count++
+	}
+	if err := networks.Err(); err != nil {
+		return errors.Wrap(err, "error iterating over the database's networks")
+	}
+
+	treeCount := 0
+	ip := make(net.IP, t.treeDepth/8)
+	// The callback never returns an error.
+	_ = t.root.walk(ip, 0, func(net.IP, int, *record) error {
+		treeCount++
+		return nil
+	})
+	if count != treeCount {
+		return errors.Errorf("the database has %d networks with data but the tree has %d", count, treeCount)
+	}
+	return nil
+}
@@ -0,0 +1,59 @@
+package mmdbwriter
+
+import (
+	"bytes"
+	"net"
+	"testing"
+
+	"github.com/maxmind/mmdbwriter/mmdbtype"
+	"github.com/oschwald/maxminddb-golang"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestVerifyAgainst(t *testing.T) {
+	tree, err := New(Options{
+		DatabaseType: "mmdbwriter-test",
+		Description:  map[string]string{"en": "Test database"},
+	})
+	require.NoError(t, err)
+	require.NoError(t, tree.Insert(mustParseNetwork(t, "1.1.0.0/16"), mmdbtype.String("a")))
+	require.NoError(t, tree.Insert(
+		mustParseNetwork(t, "1.1.1.0/24"),
+		mmdbtype.Map{"b": mmdbtype.Slice{mmdbtype.Uint64(1), mmdbtype.Float64(1.5)}},
+	))
+	require.NoError(t, tree.Insert(mustParseNetwork(t, "2003::/16"), mmdbtype.Bool(true)))
+
+	buf := &bytes.Buffer{}
+	_, err = tree.WriteTo(buf)
+	require.NoError(t, err)
+	reader, err := maxminddb.FromBytes(buf.Bytes())
+	require.NoError(t, err)
+
+	require.NoError(t, tree.VerifyAgainst(reader))
+
+	changed := tree.Clone()
+	require.NoError(t, changed.TransformAll(func(_ *net.IPNet, v mmdbtype.DataType) (mmdbtype.DataType, error) {
+		if v == mmdbtype.Bool(true) {
+			return mmdbtype.Bool(false), nil
+		}
+		return v, nil
+	}))
+	assert.EqualError(t, changed.VerifyAgainst(reader), "the data for 2003::/16 in the database differs from the tree")
+
+	removed := tree.Clone()
+	require.NoError(t, removed.Remove(mustParseNetwork(t, "1.1.1.0/24")))
+	assert.EqualError(
+		t,
+		removed.VerifyAgainst(reader),
+		"the database has data for 1.1.1.0/24 but the tree does not have that network",
+	)
+
+	other, err := New(Options{DatabaseType: "other"})
+	require.NoError(t, err)
+	assert.EqualError(
+		t,
+		other.VerifyAgainst(reader),
+		`the database type is "mmdbwriter-test" but the tree's type is "other"`,
+	)
+}
@@ -0,0 +1,87 @@
+package mmdbwriter
+
+import (
+	"context"
+	"io"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// WriteToAt writes the tree to the provided WriterAt, e.g., an *os.File,
+// starting at offset 0. The database is identical to the one written by
+// WriteTo, but the search tree and the data and metadata sections are
+// written concurrently at their offsets, which reduces the time it takes to
+// write large databases to storage that handles concurrent writes well. It
+// returns the number of bytes written.
+//
+// Progress for the search tree is reported on the calling goroutine. If
+// both sections fail to be written, the error for the search tree is
+// returned.
+//
+// This is not safe to call from multiple threads unless Options.ThreadSafe
+// is set.
+func (t *Tree) WriteToAt(w io.WriterAt) (int64, error) {
+	t.lock()
+	defer t.unlock()
+	defer observeSince(t.metrics, TimerWrite, time.Now())
+
+	dataWriter, nodes, recordSize, err := t.prepareWrite(context.Background())
+	if err != nil {
+		return 0, err
+	}
+
+	// The metadata is encoded before any writes start as the data section
+	// must not change while the nodes are being encoded.
+	metadataWriter := newDataWriter(dataWriter.dataMap, !t.disableMetadataPointers)
+	if _, err := t.writeMetadata(metadataWriter, recordSize); err != nil {
+		return 0, errors.Wrap(err, "error writing metadata")
+	}
+
+	type result struct {
+		numBytes int64
+		err      error
+	}
+	done := make(chan result, 1)
+	go func() {
+		ow := &offsetWriter{w: w, offset: int64(len(nodes)) * int64(recordSize) / 4}
+		var res result
+		for _, section := range []struct {
+			b    []byte
+			name string
+		}{
+			{dataSectionSeparator, "data section separator"},
+			{dataWriter.Bytes(), "data section"},
+			{metadataStartMarker, "metadata start marker"},
+			{metadataWriter.Bytes(), "metadata"},
+		} {
+			nb, err := ow.Write(section.b)
+			res.numBytes += int64(nb)
+			if err != nil {
+				res.err = errors.Wrapf(err, "error writing %s", section.name)
+				break
+			}
+		}
+		done <- res
+	}()
+
+	numBytes, err := t.writeNodes(context.Background(), &offsetWriter{w: w}, nodes, dataWriter, recordSize)
+	res := <-done
+	numBytes += res.numBytes
+	if err != nil {
+		return numBytes, err
+	}
+	return numBytes, res.err
+}
+
+// offsetWriter writes sequentially to a WriterAt starting at offset.
+type offsetWriter struct {
+	w      io.WriterAt
+	offset int64
+}
+
+func (ow *offsetWriter) Write(b []byte) (int, error) {
+	n, err := ow.w.WriteAt(b, ow.offset)
+	ow.offset += int64(n)
+	return n, err
+}
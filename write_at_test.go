@@ -0,0 +1,49 @@
+package mmdbwriter
+
+import (
+	"bytes"
+	"io/ioutil"
+	"net"
+	"os"
+	"testing"
+
+	"github.com/maxmind/mmdbwriter/mmdbtype"
+	"github.com/oschwald/maxminddb-golang"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestWriteToAt(t *testing.T) {
+	tree, err := New(Options{
+		BuildEpoch:   1,
+		DatabaseType: "mmdbwriter-test",
+		Description:  map[string]string{"en": "Test database"},
+	})
+	require.NoError(t, err)
+	for i := 0; i < 1<<12; i++ {
+		ip := net.IPv4(1, byte(i>>8), byte(i), 0).To4()
+		network := &net.IPNet{IP: ip, Mask: net.CIDRMask(24, 32)}
+		require.NoError(t, tree.Insert(network, mmdbtype.Map{"i": mmdbtype.Uint32(i % 100)}))
+	}
+
+	buf := &bytes.Buffer{}
+	_, err = tree.WriteTo(buf)
+	require.NoError(t, err)
+
+	f, err := ioutil.TempFile("", "mmdbwriter")
+	require.NoError(t, err)
+	defer os.Remove(f.Name())
+	defer f.Close()
+
+	numBytes, err := tree.WriteToAt(f)
+	require.NoError(t, err)
+	assert.Equal(t, int64(buf.Len()), numBytes)
+
+	b, err := ioutil.ReadFile(f.Name())
+	require.NoError(t, err)
+	assert.Equal(t, buf.Bytes(), b)
+
+	reader, err := maxminddb.FromBytes(b)
+	require.NoError(t, err)
+	require.NoError(t, reader.Verify())
+}